@@ -0,0 +1,423 @@
+// Package asm builds packages from assembly source, which is written in the
+// form that disasm lists them in.
+//
+// A file starts with directives that declare the package's tables:
+//
+//	import "io"
+//	extern "io:print"
+//	method main
+//	method helper private
+//	class pkg fields=0
+//	class yes fields=0 kind=true
+//	impl pkg main pkg.main
+//	impl pkg print extern "io:print"
+//	impl yes fail handler yes.fail
+//
+// These are followed by instructions, one to a line, and labels, which end
+// with a colon. Registers and fields are numbers, classes are named by their
+// class directive, globals are written g0, g1... or as an import path,
+// constants are quoted strings or floats, and code addresses are labels.
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/commands/compile/text"
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/assembly"
+	"github.com/bobappleyard/cezanne/format/disasm"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
+
+var (
+	ErrUnknown   = errors.New("unknown directive or instruction")
+	ErrOperands  = errors.New("bad operands")
+	ErrUndefined = errors.New("undefined")
+	ErrRedefined = errors.New("defined more than once")
+)
+
+var kinds = map[string]format.CoreKind{
+	"int":    format.IntKind,
+	"true":   format.TrueKind,
+	"false":  format.FalseKind,
+	"array":  format.ArrayKind,
+	"string": format.StringKind,
+	"float":  format.FloatKind,
+	"bigint": format.BigIntKind,
+
+	"continuation": format.ContinuationKind,
+	"map":          format.MapKind,
+	"set":          format.SetKind,
+}
+
+var opcodes = map[string]byte{}
+
+func init() {
+	for op := 0; op < 256; op++ {
+		if o, ok := disasm.Lookup(byte(op)); ok {
+			opcodes[o.Name] = byte(op)
+		}
+	}
+}
+
+// Assemble builds a package from assembly source. Problems are reported as a
+// diag.List, with positions in the named file.
+func Assemble(syms *symtab.Symtab, name string, src []byte) (*format.Package, error) {
+	a := &assembler{
+		syms:    syms,
+		b:       assembly.New(syms),
+		file:    name,
+		lines:   lineStarts(src),
+		end:     len(src),
+		classes: map[string]*assembly.Class{},
+		labels:  map[string]*label{},
+	}
+
+	toks, offsets, err := tokenize(src)
+	if err != nil {
+		return nil, a.syntaxError(err, offsets)
+	}
+	f, err := text.Parse[token, file](parseRules{}, toks)
+	if err != nil {
+		return nil, a.syntaxError(err, offsets)
+	}
+
+	for _, l := range f.lines {
+		a.line(l)
+	}
+	a.checkLabels()
+	if err := a.diags.Err(); err != nil {
+		return nil, err
+	}
+	return a.b.Package(), nil
+}
+
+type assembler struct {
+	syms  *symtab.Symtab
+	b     *assembly.Writer
+	diags diag.List
+
+	file  string
+	lines []int
+	end   int
+
+	classes map[string]*assembly.Class
+	labels  map[string]*label
+}
+
+type label struct {
+	loc     *assembly.Location
+	defined bool
+
+	// where the label was first used, for reporting it if it is never defined
+	used int
+}
+
+func lineStarts(src []byte) []int {
+	lines := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+func (a *assembler) pos(offset int) diag.Pos {
+	line := sort.Search(len(a.lines), func(j int) bool { return a.lines[j] > offset })
+	return diag.Pos{
+		File: a.file,
+		Line: line,
+		Col:  offset - a.lines[line-1] + 1,
+	}
+}
+
+func (a *assembler) syntaxError(err error, offsets []int) error {
+	span := diag.Span{Start: diag.Pos{File: a.file}}
+
+	var input *text.UnexpectedInput
+	var tok *text.UnexpectedToken
+	switch {
+	case errors.As(err, &input):
+		span = diag.At(a.pos(input.Pos))
+		err = errors.New("unexpected character")
+	case errors.As(err, &tok):
+		span = diag.At(a.pos(offsets[tok.Index]))
+		err = errors.New("unexpected token")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		span = diag.At(a.pos(a.end))
+		err = errors.New("unexpected end of file")
+	}
+
+	var l diag.List
+	l.Errorf(span, "%s", err)
+	return l
+}
+
+func (a *assembler) errorf(pos int, name string, err error) {
+	a.diags.Add(diag.Diagnostic{
+		Severity: diag.Error,
+		Span:     diag.At(a.pos(pos)),
+		Message:  fmt.Sprintf("%s: %s", name, err),
+		Err:      err,
+	})
+}
+
+func (a *assembler) line(l line) {
+	if l.label {
+		a.defineLabel(l)
+		return
+	}
+	var ok bool
+	switch l.name {
+	case "import":
+		ok = a.importDirective(l.operands)
+	case "extern":
+		ok = a.externDirective(l.operands)
+	case "method":
+		ok = a.methodDirective(l.operands)
+	case "class":
+		ok = a.classDirective(l)
+	case "impl":
+		ok = a.implDirective(l)
+	default:
+		op, known := opcodes[l.name]
+		if !known {
+			a.errorf(l.pos, l.name, ErrUnknown)
+			return
+		}
+		ok = a.instruction(op, l)
+	}
+	if !ok {
+		a.errorf(l.pos, l.name, ErrOperands)
+	}
+}
+
+func (a *assembler) importDirective(ops []operand) bool {
+	if len(ops) != 1 || !isString(ops[0]) {
+		return false
+	}
+	a.b.Import(ops[0].text)
+	return true
+}
+
+func (a *assembler) externDirective(ops []operand) bool {
+	if len(ops) != 1 || !isString(ops[0]) {
+		return false
+	}
+	a.b.External(a.syms.SymbolID(ops[0].text))
+	return true
+}
+
+func (a *assembler) methodDirective(ops []operand) bool {
+	if len(ops) == 0 || len(ops) > 2 || !isName(ops[0]) {
+		return false
+	}
+	m := a.b.Method(a.syms.SymbolID(ops[0].text))
+	if len(ops) == 1 {
+		return true
+	}
+	switch {
+	case isWord(ops[1], "public"):
+		m.SetVisibility(format.Public)
+	case isWord(ops[1], "private"):
+		m.SetVisibility(format.Private)
+	default:
+		return false
+	}
+	return true
+}
+
+func (a *assembler) classDirective(l line) bool {
+	ops := l.operands
+	if len(ops) == 0 || !isName(ops[0]) {
+		return false
+	}
+	name := ops[0].text
+	if _, ok := a.classes[name]; ok {
+		a.errorf(ops[0].pos, name, ErrRedefined)
+		return true
+	}
+	c := a.b.Class(0)
+	a.classes[name] = c
+	for _, op := range ops[1:] {
+		switch {
+		case op.key == "fields" && op.kind == intOperand && op.val >= 0:
+			c.SetFields(op.val)
+		case op.key == "kind" && op.kind == nameOperand && kinds[op.text] != 0:
+			c.SetKind(kinds[op.text])
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (a *assembler) implDirective(l line) bool {
+	ops := l.operands
+	if len(ops) < 3 || !isName(ops[0]) || !isName(ops[1]) {
+		return false
+	}
+	class, ok := a.class(ops[0])
+	if !ok {
+		return true
+	}
+	method := a.b.Method(a.syms.SymbolID(ops[1].text))
+	switch {
+	case len(ops) == 3 && isName(ops[2]):
+		a.b.ImplementMethodAt(class, method, a.label(ops[2]))
+	case len(ops) == 4 && isWord(ops[2], "handler") && isName(ops[3]):
+		a.b.ImplementHandlerAt(class, method, a.label(ops[3]))
+	case len(ops) == 4 && isWord(ops[2], "extern") && isString(ops[3]):
+		a.b.ImplementExternalMethod(class, method, a.syms.SymbolID(ops[3].text))
+	default:
+		return false
+	}
+	return true
+}
+
+func (a *assembler) instruction(op byte, l line) bool {
+	desc, _ := disasm.Lookup(op)
+	ops := l.operands
+	if len(ops) != len(desc.Operands) {
+		return false
+	}
+	for i, k := range desc.Operands {
+		if !a.operandFits(k, ops[i]) {
+			return false
+		}
+	}
+
+	switch op {
+	case format.LoadOp:
+		a.b.Load(ops[0].val)
+	case format.StoreOp:
+		a.b.Store(ops[0].val)
+	case format.NaturalOp:
+		if ops[0].kind == nameOperand {
+			a.b.Natural(a.label(ops[0]))
+		} else {
+			a.b.Natural(a.b.Fixed(ops[0].val))
+		}
+	case format.GlobalLoadOp:
+		a.b.GlobalLoad(a.global(ops[0]))
+	case format.GlobalStoreOp:
+		a.b.GlobalStore(a.global(ops[0]))
+	case format.CreateOp:
+		if class, ok := a.class(ops[0]); ok {
+			a.b.Create(class, ops[1].val)
+		}
+	case format.FieldOp:
+		a.b.Field(ops[0].val)
+	case format.RetOp:
+		a.b.Return()
+	case format.CallOp:
+		a.b.Call(a.b.Method(a.syms.SymbolID(ops[0].text)), ops[1].val)
+	case format.ArrayOp:
+		a.b.Array(ops[0].val, ops[1].val)
+	case format.ConstantOp:
+		if ops[0].kind == floatOperand {
+			a.b.Constant(a.b.Float(ops[0].fval))
+		} else {
+			a.b.Constant(a.b.String(ops[0].text))
+		}
+	}
+	return true
+}
+
+func (a *assembler) operandFits(k disasm.OperandKind, op operand) bool {
+	switch k {
+	case disasm.Register:
+		return op.key == "" && op.kind == intOperand && op.val >= 0 && op.val < 256
+	case disasm.Base:
+		return op.key == "base" && op.kind == intOperand && op.val >= 0 && op.val < 256
+	case disasm.Int:
+		return op.key == "" && op.kind != strOperand
+	case disasm.Global:
+		_, ok := globalID(op)
+		return isString(op) || ok
+	case disasm.Class, disasm.Method:
+		return isName(op)
+	case disasm.Constant:
+		return isString(op) || op.key == "" && op.kind == floatOperand
+	}
+	return false
+}
+
+// Globals are numbered, as in g0. Quoted globals are imports.
+func globalID(op operand) (int, bool) {
+	if !isName(op) || !strings.HasPrefix(op.text, "g") {
+		return 0, false
+	}
+	id, err := strconv.Atoi(op.text[1:])
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func (a *assembler) global(op operand) *assembly.Global {
+	if isString(op) {
+		return a.b.Import(op.text)
+	}
+	id, _ := globalID(op)
+	return a.b.Global(id)
+}
+
+func (a *assembler) class(op operand) (*assembly.Class, bool) {
+	c, ok := a.classes[op.text]
+	if !ok {
+		a.errorf(op.pos, op.text, ErrUndefined)
+	}
+	return c, ok
+}
+
+func (a *assembler) label(op operand) *assembly.Location {
+	l, ok := a.labels[op.text]
+	if !ok {
+		l = &label{loc: a.b.Location(), used: op.pos}
+		a.labels[op.text] = l
+	}
+	return l.loc
+}
+
+func (a *assembler) defineLabel(l line) {
+	lab, ok := a.labels[l.name]
+	if !ok {
+		lab = &label{loc: a.b.Location()}
+		a.labels[l.name] = lab
+	}
+	if lab.defined {
+		a.errorf(l.pos, l.name, ErrRedefined)
+		return
+	}
+	lab.defined = true
+	lab.loc.Define()
+}
+
+func (a *assembler) checkLabels() {
+	for name, l := range a.labels {
+		if !l.defined {
+			a.errorf(l.used, name, ErrUndefined)
+		}
+	}
+	a.diags.Sort()
+}
+
+func isName(op operand) bool {
+	return op.key == "" && op.kind == nameOperand
+}
+
+func isString(op operand) bool {
+	return op.key == "" && op.kind == strOperand
+}
+
+func isWord(op operand, word string) bool {
+	return isName(op) && op.text == word
+}
@@ -0,0 +1,157 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/assembly"
+	"github.com/bobappleyard/cezanne/format/disasm"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+const listing = `import "io"
+extern "test:lte"
+method match
+method fold private
+method lte
+class c0 fields=0
+class c1 fields=2 kind=true
+class c2 fields=0 kind=float
+class c3 fields=3 kind=continuation
+impl c1 match c1.match
+impl c0 lte extern "test:lte"
+impl c1 fold handler c1.fold
+
+	CREATE c0 base=0 // 0
+	RETURN           // 6
+c1.match:
+	NATURAL L27      // 7
+	STORE 3          // 12
+	GLOBAL_LOAD "io" // 14
+	LOAD 2           // 19
+	CALL fold base=7 // 21
+L27:
+c1.fold:
+	GLOBAL_STORE g1  // 27
+	FIELD 1          // 32
+	ARRAY 2 base=5   // 37
+	CONSTANT "hi\n"  // 43
+	CONSTANT 2.0     // 48
+	CONSTANT -1e+21  // 53
+	NATURAL -1       // 58
+	RETURN           // 63
+`
+
+func TestRoundTrip(t *testing.T) {
+	var syms symtab.Symtab
+	p, err := Assemble(&syms, "test.czs", []byte(listing))
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, disasm.Package(&buf, p))
+	assert.Equal(t, buf.String(), listing)
+}
+
+func TestMatchesWriter(t *testing.T) {
+	var syms symtab.Symtab
+	b := assembly.New(&syms)
+
+	k := b.Location()
+	pkg := b.Class(0)
+	b.Create(pkg, 0)
+	b.Return()
+
+	trueClass := b.Class(0)
+	trueClass.SetKind(format.TrueKind)
+	b.ImplementMethod(trueClass, b.Method(syms.SymbolID("match")))
+	b.Natural(k)
+	b.Load(2)
+	b.Call(b.Method(syms.SymbolID("true")), 0)
+	k.Define()
+	b.ImplementExternalMethod(pkg, b.Method(syms.SymbolID("lte")), syms.SymbolID("test:lte"))
+
+	// methods are numbered in the order that they are first mentioned
+	p, err := Assemble(&syms, "test.czs", []byte(`
+method match
+method true
+class pkg
+class yes kind=true
+impl yes match yes.match
+impl pkg lte extern "test:lte"
+
+	CREATE pkg base=0
+	RETURN
+yes.match:
+	NATURAL done
+	LOAD 2
+	CALL true base=0
+done:
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, p, b.Package())
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		src  string
+		err  error
+		msg  string
+	}{
+		{
+			name: "Syntax",
+			src:  "LOAD 1\nRETURN =\n",
+			msg:  "test.czs:2:8: error: unexpected token",
+		},
+		{
+			name: "UnknownInstruction",
+			src:  "\tJUMP L1\n",
+			err:  ErrUnknown,
+			msg:  "test.czs:1:2: error: JUMP: unknown directive or instruction",
+		},
+		{
+			name: "Operands",
+			src:  "LOAD 300\nCALL f 2\nclass c kind=double\n",
+			err:  ErrOperands,
+			msg: "test.czs:1:1: error: LOAD: bad operands\n" +
+				"test.czs:2:1: error: CALL: bad operands\n" +
+				"test.czs:3:1: error: class: bad operands",
+		},
+		{
+			name: "UndefinedClass",
+			src:  "CREATE c0 base=0\nimpl c1 f L1\nL1:\n",
+			err:  ErrUndefined,
+			msg: "test.czs:1:8: error: c0: undefined\n" +
+				"test.czs:2:6: error: c1: undefined",
+		},
+		{
+			name: "UndefinedLabel",
+			src:  "NATURAL L1\nRETURN\n",
+			err:  ErrUndefined,
+			msg:  "test.czs:1:9: error: L1: undefined",
+		},
+		{
+			name: "Redefined",
+			src:  "class c\nclass c\nL1:\nL1:\n",
+			err:  ErrRedefined,
+			msg: "test.czs:2:7: error: c: defined more than once\n" +
+				"test.czs:4:1: error: L1: defined more than once",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var syms symtab.Symtab
+			p, err := Assemble(&syms, "test.czs", []byte(test.src))
+			assert.Nil(t, p)
+			var l diag.List
+			assert.True(t, errors.As(err, &l))
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err))
+			}
+			assert.Equal(t, err.Error(), test.msg)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package asm
+
+import (
+	"errors"
+	"os"
+
+	"github.com/bobappleyard/cezanne/commands"
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format/storage"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
+
+type Options struct {
+	Output string `option:"o"`
+}
+
+var (
+	ErrFailed  = errors.New("assembly failed")
+	ErrOneFile = errors.New("expected one source file")
+)
+
+func init() {
+	commands.Register("asm", Asm)
+}
+
+// Asm builds a package from an assembly source file. Problems are written to
+// standard error, and if there are any no package is written.
+func Asm(options Options, files []string) error {
+	if len(files) != 1 {
+		return ErrOneFile
+	}
+	src, err := os.ReadFile(files[0])
+	if err != nil {
+		return err
+	}
+
+	var syms symtab.Symtab
+	pkg, err := Assemble(&syms, files[0], src)
+	if err != nil {
+		var diags diag.List
+		diags.AddError(err)
+		if err := diags.WriteText(os.Stderr); err != nil {
+			return err
+		}
+		return ErrFailed
+	}
+
+	output, err := os.Create(options.Output)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	_, err = storage.Write(output, *pkg)
+	return err
+}
@@ -0,0 +1,36 @@
+package asm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/storage"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestAsm(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "pkg.czs")
+	out := filepath.Join(dir, "pkg")
+	err := os.WriteFile(src, []byte("import \"io\"\n\tGLOBAL_LOAD \"io\"\n\tRETURN\n"), 0o644)
+	assert.Nil(t, err)
+
+	assert.Nil(t, Asm(Options{Output: out}, []string{src}))
+
+	f, err := os.Open(out)
+	assert.Nil(t, err)
+	defer f.Close()
+	var p format.Package
+	_, err = storage.Read(f, &p)
+	assert.Nil(t, err)
+	assert.Equal(t, p.Imports, []string{"io"})
+	assert.Equal(t, p.Code, []byte{format.GlobalLoadOp, 0, 0, 0, 0, format.RetOp})
+}
+
+func TestAsmOneFile(t *testing.T) {
+	err := Asm(Options{}, nil)
+	assert.True(t, errors.Is(err, ErrOneFile))
+}
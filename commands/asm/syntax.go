@@ -0,0 +1,202 @@
+package asm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/commands/compile/text"
+	"github.com/bobappleyard/cezanne/util/must"
+)
+
+// Assembly source is a sequence of lines. Each line is a label, or a
+// directive or instruction followed by its operands. The parser only finds
+// the lines; what they mean is decided when they are assembled.
+
+type token interface {
+	tok()
+}
+
+type comment struct{}
+type whitespace struct{ text string }
+type newline struct{}
+type ident struct {
+	name string
+	pos  int
+}
+type strLit struct {
+	text string
+	pos  int
+}
+type intLit struct {
+	val int
+	pos int
+}
+type floatLit struct {
+	val float64
+	pos int
+}
+type equals struct{}
+type colon struct{}
+
+func (comment) tok()    {}
+func (whitespace) tok() {}
+func (newline) tok()    {}
+func (ident) tok()      {}
+func (strLit) tok()     {}
+func (intLit) tok()     {}
+func (floatLit) tok()   {}
+func (equals) tok()     {}
+func (colon) tok()      {}
+
+var lexicon = must.Be(text.NewLexer(
+	text.Regex(`//[^\n]*`, func(start int, text string) token {
+		return comment{}
+	}),
+	text.Regex(`\s+`, func(start int, text string) token {
+		return whitespace{text}
+	}),
+	// names may contain dots, so that labels can be named after the class and
+	// method that they implement
+	text.Regex(`[\c_][\w_.]*`, func(start int, text string) token {
+		return ident{name: text, pos: start}
+	}),
+	text.Regex(`"([^"]|\\.)*"`, func(start int, text string) token {
+		inner, _ := strconv.Unquote(text)
+		return strLit{text: inner, pos: start}
+	}),
+	text.Regex(`-?\d+`, func(start int, text string) token {
+		x, _ := strconv.Atoi(text)
+		return intLit{val: x, pos: start}
+	}),
+	text.Regex(`-?\d+(\.\d+([eE](\+|-)?\d+)?|[eE](\+|-)?\d+)`, func(start int, text string) token {
+		x, _ := strconv.ParseFloat(text, 64)
+		return floatLit{val: x, pos: start}
+	}),
+	text.Regex(`=`, func(start int, text string) token {
+		return equals{}
+	}),
+	text.Regex(`:`, func(start int, text string) token {
+		return colon{}
+	}),
+))
+
+// tokenize also gives the offset in src of each token. Every line, including
+// the last, ends with a newline token.
+func tokenize(src []byte) ([]token, []int, error) {
+	toks := lexicon.Stream(src)
+	var res []token
+	var offsets []int
+	for toks.Next() {
+		switch t := toks.This().(type) {
+		case comment:
+			continue
+		case whitespace:
+			if !strings.Contains(t.text, "\n") {
+				continue
+			}
+			res = append(res, newline{})
+		default:
+			res = append(res, t)
+		}
+		offsets = append(offsets, toks.Pos())
+	}
+	if toks.Err() != nil {
+		return nil, nil, toks.Err()
+	}
+	if len(res) != 0 {
+		if _, ok := res[len(res)-1].(newline); !ok {
+			res = append(res, newline{})
+			offsets = append(offsets, len(src))
+		}
+	}
+	return res, offsets, nil
+}
+
+type parseRules struct{}
+
+type file struct {
+	lines []line
+}
+
+type line struct {
+	pos      int
+	name     string
+	label    bool
+	operands []operand
+}
+
+type operandKind int
+
+const (
+	_ operandKind = iota
+	nameOperand
+	strOperand
+	intOperand
+	floatOperand
+)
+
+// An operand may be given a key, as in base=2.
+type operand struct {
+	pos  int
+	key  string
+	kind operandKind
+	text string
+	val  int
+	fval float64
+}
+
+type operandList struct {
+	operands []operand
+}
+
+func (parseRules) ParseEmptyFile() file {
+	return file{}
+}
+
+func (parseRules) ParseBlankLine(f file, nl newline) file {
+	return f
+}
+
+func (parseRules) ParseLine(f file, l line) file {
+	return file{lines: append(f.lines, l)}
+}
+
+func (parseRules) ParseStatement(name ident, ops operandList, nl newline) line {
+	return line{pos: name.pos, name: name.name, operands: ops.operands}
+}
+
+func (parseRules) ParseLabel(name ident, c colon, nl newline) line {
+	return line{pos: name.pos, name: name.name, label: true}
+}
+
+func (parseRules) ParseNoOperands() operandList {
+	return operandList{}
+}
+
+func (parseRules) ParseOperand(ops operandList, x operand) operandList {
+	return operandList{operands: append(ops.operands, x)}
+}
+
+func (parseRules) ParseName(x ident) operand {
+	return operand{pos: x.pos, kind: nameOperand, text: x.name}
+}
+
+func (parseRules) ParseString(x strLit) operand {
+	return operand{pos: x.pos, kind: strOperand, text: x.text}
+}
+
+func (parseRules) ParseInt(x intLit) operand {
+	return operand{pos: x.pos, kind: intOperand, val: x.val}
+}
+
+func (parseRules) ParseFloat(x floatLit) operand {
+	return operand{pos: x.pos, kind: floatOperand, fval: x.val}
+}
+
+func (parseRules) ParseKeyedName(key ident, e equals, x ident) operand {
+	return operand{pos: key.pos, key: key.name, kind: nameOperand, text: x.name}
+}
+
+func (parseRules) ParseKeyedInt(key ident, e equals, x intLit) operand {
+	return operand{pos: key.pos, key: key.name, kind: intOperand, val: x.val}
+}
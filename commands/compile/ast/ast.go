@@ -1,6 +1,9 @@
 package ast
 
-import "github.com/bobappleyard/cezanne/format/symtab"
+import (
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
 
 type Package struct {
 	Name    symtab.Symbol
@@ -10,10 +13,14 @@ type Package struct {
 }
 
 type Import struct {
+	Pos  Pos
 	Name symtab.Symbol
 	Path string
 }
 
+// Pos is a position in a source file.
+type Pos = diag.Pos
+
 type Var struct {
 	Name  symtab.Symbol
 	Value Expr
@@ -27,11 +34,16 @@ type Int struct {
 	Value int
 }
 
+type Float struct {
+	Value float64
+}
+
 type String struct {
 	Value string
 }
 
 type Ref struct {
+	Pos  Pos
 	Name symtab.Symbol
 }
 
@@ -40,37 +52,85 @@ type Create struct {
 }
 
 type Method struct {
+	Pos  Pos
 	Name symtab.Symbol
 	Args []symtab.Symbol
 	Body Expr
+
+	// Where each argument is declared. It is either nil or has an entry for
+	// each argument, with the zero Pos for arguments that are not in the
+	// source.
+	ArgPos []Pos
+
+	// Optional type annotations. ArgTypes is either nil or has an entry for
+	// each argument, with nil marking an argument that was not annotated.
+	// TypeParams names the method's own type parameters.
+	TypeParams []symtab.Symbol
+	ArgTypes   []TypeExpr
+	Result     TypeExpr
+	Effect     TypeExpr
 }
 
 type Let struct {
+	Pos   Pos
 	Name  symtab.Symbol
+	Type  TypeExpr
 	Value Expr
 	In    Expr
 }
 
 type Invoke struct {
+	Pos    Pos
 	Object Expr
 	Name   symtab.Symbol
 	Args   []Expr
 }
 
 type Handle struct {
+	Pos  Pos
 	In   Expr
 	With []Method
 }
 
 type Trigger struct {
+	Pos  Pos
 	Name symtab.Symbol
 	Args []Expr
 }
 
+// Array is an array literal.
+type Array struct {
+	Pos   Pos
+	Items []Expr
+}
+
 func (Int) expr()     {}
+func (Float) expr()   {}
 func (String) expr()  {}
 func (Ref) expr()     {}
 func (Create) expr()  {}
+func (Let) expr()     {}
 func (Invoke) expr()  {}
 func (Handle) expr()  {}
 func (Trigger) expr() {}
+func (Array) expr()   {}
+
+// TypeExpr is a type as written in the source, before it has been resolved
+// against the declared types.
+type TypeExpr interface {
+	typeExpr()
+}
+
+type NamedType struct {
+	Name symtab.Symbol
+	Args []TypeExpr
+}
+
+type FuncType struct {
+	Args   []TypeExpr
+	Result TypeExpr
+	Effect TypeExpr
+}
+
+func (NamedType) typeExpr() {}
+func (FuncType) typeExpr()  {}
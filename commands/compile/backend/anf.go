@@ -1,11 +1,15 @@
 package backend
 
-import "github.com/bobappleyard/cezanne/format/symtab"
+import (
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
 
 type pkg struct {
 }
 
 type method struct {
+	pos        ast.Pos
 	name       symtab.Symbol
 	argc, varc int
 	steps      []step
@@ -22,6 +26,11 @@ type stringStep struct {
 	into variable
 }
 
+type floatStep struct {
+	val  float64
+	into variable
+}
+
 type intStep struct {
 	val  int
 	into variable
@@ -56,6 +65,14 @@ type createStep struct {
 	into    variable
 	methods []method
 	fields  []variable
+
+	// the methods are effect handlers
+	handler bool
+}
+
+type arrayStep struct {
+	into  variable
+	items []variable
 }
 
 type returnStep struct {
@@ -63,6 +80,7 @@ type returnStep struct {
 }
 
 type callStep struct {
+	pos    ast.Pos
 	into   variable
 	object variable
 	method symtab.Symbol
@@ -71,12 +89,14 @@ type callStep struct {
 
 func (stringStep) step()      {}
 func (intStep) step()         {}
+func (floatStep) step()       {}
 func (localStep) step()       {}
 func (fieldStep) step()       {}
 func (globalStep) step()      {}
 func (importStep) step()      {}
 func (returnStep) step()      {}
 func (createStep) step()      {}
+func (arrayStep) step()       {}
 func (callStep) step()        {}
 func (globalStoreStep) step() {}
 
@@ -3,6 +3,7 @@ package backend
 import (
 	"github.com/bobappleyard/cezanne/commands/compile/ast"
 	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/assembly"
 	"github.com/bobappleyard/cezanne/format/symtab"
 )
 
@@ -11,7 +12,7 @@ func BuildPackage(syms *symtab.Symtab, pkg ast.Package) (*format.Package, error)
 	pkgObject := interpretExpr(globalScope(syms, pkg), &root, buildRoot(pkg))
 	root.steps = append(root.steps, returnStep{val: pkgObject})
 
-	asm := assembler{syms: syms}
+	asm := assembler{syms: syms, dest: *assembly.New(syms)}
 	asm.writePackage(root)
 
 	return asm.dest.Package(), nil
@@ -2,16 +2,18 @@ package backend
 
 import (
 	"fmt"
+	"os"
 	"testing"
 
+	"github.com/bobappleyard/cezanne/commands/asm"
 	"github.com/bobappleyard/cezanne/commands/compile/ast"
 	"github.com/bobappleyard/cezanne/commands/link"
 	"github.com/bobappleyard/cezanne/format"
-	"github.com/bobappleyard/cezanne/format/assembly"
 	"github.com/bobappleyard/cezanne/format/symtab"
 	"github.com/bobappleyard/cezanne/runtime/api"
 	"github.com/bobappleyard/cezanne/runtime/env"
 	"github.com/bobappleyard/cezanne/util/assert"
+	"github.com/bobappleyard/cezanne/util/must"
 )
 
 type testLinkerEnv map[string]*format.Package
@@ -130,7 +132,7 @@ func TestBuildPackage(t *testing.T) {
 		p.Return(p.Process().Int(a * b))
 	})
 
-	e.Run(&syms, prog)
+	assert.Nil(t, e.Run(&syms, prog))
 	t.Log(logged)
 
 	assert.Equal(t, logged, []api.Object{{Class: prog.CoreKinds[format.IntKind], Data: 24}})
@@ -138,39 +140,9 @@ func TestBuildPackage(t *testing.T) {
 }
 
 func testPkg(syms *symtab.Symtab) *format.Package {
-	var b assembly.Writer
-
-	pkgClass := b.Class(0)
-
-	b.Create(pkgClass, 0)
-	b.Return()
-
-	trueClass := b.Class(0)
-	b.ImplementMethod(trueClass, b.Method(syms.SymbolID("match")))
-	b.Load(2)
-	b.Call(b.Method(syms.SymbolID("true")), 0)
-
-	b.ImplementMethod(pkgClass, b.Method(syms.SymbolID("true")))
-	b.Create(trueClass, 0)
-	b.Return()
-
-	falseClass := b.Class(0)
-	b.ImplementMethod(falseClass, b.Method(syms.SymbolID("match")))
-	b.Load(2)
-	b.Call(b.Method(syms.SymbolID("false")), 0)
-
-	b.ImplementMethod(pkgClass, b.Method(syms.SymbolID("false")))
-	b.Create(falseClass, 0)
-	b.Return()
-
-	b.ImplementExternalMethod(pkgClass, b.Method(syms.SymbolID("lte")), syms.SymbolID("test:lte"))
-	b.ImplementExternalMethod(pkgClass, b.Method(syms.SymbolID("sub")), syms.SymbolID("test:sub"))
-	b.ImplementExternalMethod(pkgClass, b.Method(syms.SymbolID("mul")), syms.SymbolID("test:mul"))
-	b.ImplementExternalMethod(pkgClass, b.Method(syms.SymbolID("print")), syms.SymbolID("test:print"))
-
-	p := b.Package()
-	p.Classes[1].Kind = format.TrueKind
-	p.Classes[2].Kind = format.FalseKind
-
-	return p
+	src, err := os.ReadFile("testdata/test.czs")
+	if err != nil {
+		panic(err)
+	}
+	return must.Be(asm.Assemble(syms, "testdata/test.czs", src))
 }
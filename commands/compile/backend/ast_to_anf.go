@@ -59,6 +59,20 @@ func (s scope) enter(bound, free []symtab.Symbol) scope {
 	}
 }
 
+func (s scope) bind(name symtab.Symbol, v variable) scope {
+	vars := make(map[symtab.Symbol]binding, len(s.vars)+1)
+	for n, b := range s.vars {
+		vars[n] = b
+	}
+	vars[name] = binding{kind: localBinding, offset: int(v)}
+	return scope{
+		syms:    s.syms,
+		this:    s.this,
+		imports: s.imports,
+		vars:    vars,
+	}
+}
+
 func interpretExpr(s scope, dest *method, src ast.Expr) variable {
 	switch src := src.(type) {
 	case ast.Int:
@@ -69,6 +83,14 @@ func interpretExpr(s scope, dest *method, src ast.Expr) variable {
 		})
 		return v
 
+	case ast.Float:
+		v := dest.nextVar()
+		dest.steps = append(dest.steps, floatStep{
+			val:  src.Value,
+			into: v,
+		})
+		return v
+
 	case ast.String:
 		v := dest.nextVar()
 		dest.steps = append(dest.steps, stringStep{
@@ -80,31 +102,54 @@ func interpretExpr(s scope, dest *method, src ast.Expr) variable {
 	case ast.Ref:
 		return interpretLookup(s, dest, src.Name)
 
+	case ast.Let:
+		v := interpretExpr(s, dest, src.Value)
+		return interpretExpr(s.bind(src.Name, v), dest, src.In)
+
 	case ast.Create:
-		methods, freeVars := interpretClass(s, src.Methods)
-		fields := slices.Map(freeVars, func(v symtab.Symbol) variable {
-			return interpretLookup(s, dest, v)
+		return interpretCreate(s, dest, src.Methods, false)
+
+	case ast.Array:
+		items := slices.Map(src.Items, func(x ast.Expr) variable {
+			return interpretExpr(s, dest, x)
 		})
 		v := dest.nextVar()
-		dest.steps = append(dest.steps, createStep{
-			into:    v,
-			fields:  fields,
-			methods: methods,
+		dest.steps = append(dest.steps, arrayStep{
+			into:  v,
+			items: items,
 		})
 		return v
 
+	case ast.Handle:
+		handlers := interpretCreate(s, dest, src.With, true)
+		body := interpretCreate(s, dest, []ast.Method{{
+			Name: s.syms.SymbolID("call"),
+			Body: src.In,
+		}}, false)
+		return interpretRuntimeCall(s, dest, "handle", handlers, body)
+
+	case ast.Trigger:
+		name := dest.nextVar()
+		dest.steps = append(dest.steps, stringStep{
+			val:  s.syms.SymbolName(src.Name),
+			into: name,
+		})
+		args := interpretExpr(s, dest, ast.Array{Items: src.Args})
+		return interpretRuntimeCall(s, dest, "trigger", name, args)
+
 	case ast.Invoke:
 		params := slices.Map(src.Args, func(arg ast.Expr) variable {
 			return interpretExpr(s, dest, arg)
 		})
 
 		if isGlobalMethodCall(s, src) {
-			return interpretGlobalMethodCall(s, dest, src.Object.(ast.Ref), params)
+			return interpretGlobalMethodCall(s, dest, src, params)
 		}
 
 		object := interpretExpr(s, dest, src.Object)
 		v := dest.nextVar()
 		dest.steps = append(dest.steps, callStep{
+			pos:    src.Pos,
 			object: object,
 			method: src.Name,
 			params: params,
@@ -117,12 +162,48 @@ func interpretExpr(s scope, dest *method, src ast.Expr) variable {
 	}
 }
 
+func interpretCreate(s scope, dest *method, src []ast.Method, handler bool) variable {
+	methods, freeVars := interpretClass(s, src)
+	fields := slices.Map(freeVars, func(v symtab.Symbol) variable {
+		return interpretLookup(s, dest, v)
+	})
+	v := dest.nextVar()
+	dest.steps = append(dest.steps, createStep{
+		into:    v,
+		fields:  fields,
+		methods: methods,
+		handler: handler,
+	})
+	return v
+}
+
+// Effects are handled and triggered by methods of the runtime package.
+const runtimePath = "runtime"
+
+func interpretRuntimeCall(s scope, dest *method, name string, params ...variable) variable {
+	u := dest.nextVar()
+	dest.steps = append(dest.steps, importStep{
+		from: runtimePath,
+		into: u,
+	})
+
+	v := dest.nextVar()
+	dest.steps = append(dest.steps, callStep{
+		object: u,
+		method: s.syms.SymbolID(name),
+		params: params,
+		into:   v,
+	})
+	return v
+}
+
 func isGlobalMethodCall(s scope, src ast.Invoke) bool {
 	o, ok := src.Object.(ast.Ref)
 	return ok && src.Name == s.syms.SymbolID("call") && s.lookup(o.Name).kind == globalMethodBinding
 }
 
-func interpretGlobalMethodCall(s scope, dest *method, src ast.Ref, params []variable) variable {
+func interpretGlobalMethodCall(s scope, dest *method, call ast.Invoke, params []variable) variable {
+	src := call.Object.(ast.Ref)
 	u := dest.nextVar()
 	dest.steps = append(dest.steps, importStep{
 		from: ".",
@@ -131,6 +212,7 @@ func interpretGlobalMethodCall(s scope, dest *method, src ast.Ref, params []vari
 
 	v := dest.nextVar()
 	dest.steps = append(dest.steps, callStep{
+		pos:    call.Pos,
 		object: u,
 		method: src.Name,
 		params: params,
@@ -182,6 +264,7 @@ func interpretClass(s scope, methods []ast.Method) ([]method, []symtab.Symbol) {
 
 	blocks := slices.Map(methods, func(m ast.Method) method {
 		res := method{
+			pos:  m.Pos,
 			name: m.Name,
 			argc: len(m.Args),
 			varc: len(m.Args) + 1,
@@ -204,7 +287,7 @@ func objectFreeVars(s scope, methods []ast.Method) []symtab.Symbol {
 
 func exprFreeVars(s scope, x ast.Expr) []symtab.Symbol {
 	switch x := x.(type) {
-	case ast.Int, ast.String:
+	case ast.Int, ast.Float, ast.String:
 		return nil
 
 	case ast.Ref:
@@ -213,6 +296,10 @@ func exprFreeVars(s scope, x ast.Expr) []symtab.Symbol {
 		}
 		return []symtab.Symbol{x.Name}
 
+	case ast.Let:
+		freeVars := exprFreeVars(s, x.Value)
+		return append(freeVars, exprFreeVars(s.bind(x.Name, 0), x.In)...)
+
 	case ast.Create:
 		freeVars := objectFreeVars(s, x.Methods)
 		var res []symtab.Symbol
@@ -232,6 +319,21 @@ func exprFreeVars(s scope, x ast.Expr) []symtab.Symbol {
 		freeVars = append(freeVars, exprFreeVars(s, x.Object)...)
 		return freeVars
 
+	case ast.Array:
+		var freeVars []symtab.Symbol
+		for _, x := range x.Items {
+			freeVars = append(freeVars, exprFreeVars(s, x)...)
+		}
+		return freeVars
+
+	case ast.Handle:
+		freeVars := exprFreeVars(s, ast.Create{Methods: x.With})
+		body := ast.Create{Methods: []ast.Method{{Body: x.In}}}
+		return append(freeVars, exprFreeVars(s, body)...)
+
+	case ast.Trigger:
+		return exprFreeVars(s, ast.Array{Items: x.Args})
+
 	default:
 		panic(fmt.Sprintf("unsupported syntax: %T", x))
 	}
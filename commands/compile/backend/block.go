@@ -3,6 +3,7 @@ package backend
 import (
 	"fmt"
 
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
 	"github.com/bobappleyard/cezanne/format/assembly"
 	"github.com/bobappleyard/cezanne/format/symtab"
 )
@@ -20,13 +21,9 @@ type pendingWork interface {
 }
 
 type implementMethod struct {
-	class  *assembly.Class
-	method method
-}
-
-type placeString struct {
-	start, end *assembly.Location
-	value      string
+	class   *assembly.Class
+	method  method
+	handler bool
 }
 
 func (w *assembler) writePackage(root method) {
@@ -39,19 +36,19 @@ func (w *assembler) writePackage(root method) {
 }
 
 func (w *implementMethod) doWork(a *assembler) {
-	a.dest.ImplementMethod(w.class, a.dest.Method(w.method.name))
-	a.writeBlock(w.method)
-}
-
-func (w *placeString) doWork(a *assembler) {
-	w.start.Define()
-	for _, b := range []byte(w.value) {
-		a.dest.WriteByte(int(b))
+	m := a.dest.Method(w.method.name)
+	if w.handler {
+		entry := a.dest.Location()
+		a.dest.ImplementHandlerAt(w.class, m, entry)
+		entry.Define()
+	} else {
+		a.dest.ImplementMethod(w.class, m)
 	}
-	w.end.Define()
+	a.writeBlock(w.method)
 }
 
 func (w *assembler) writeBlock(src method) {
+	w.sourcePos(src.pos)
 	for p, s := range src.steps {
 		switch s := s.(type) {
 
@@ -59,24 +56,13 @@ func (w *assembler) writeBlock(src method) {
 			w.dest.Natural(w.dest.Fixed(s.val))
 			w.dest.Store(int(s.into) + baseRegister)
 
+		case floatStep:
+			w.dest.Constant(w.dest.Float(s.val))
+			w.dest.Store(int(s.into) + baseRegister)
+
 		case stringStep:
-			start := w.dest.Location()
-			end := w.dest.Location()
-			k := w.dest.Location()
-
-			w.dest.Natural(w.dest.Fixed(src.varc + baseRegister))
-			w.dest.Store(src.varc + baseRegister)
-			w.dest.Natural(k)
-			w.dest.Store(src.varc + baseRegister + 1)
-			w.dest.Natural(start)
-			w.dest.Store(src.varc + baseRegister + 2)
-			w.dest.Natural(end)
-			w.dest.Store(src.varc + baseRegister + 3)
-			w.dest.GlobalLoad(w.dest.Import("runtime"))
-			w.dest.Call(w.dest.Method(w.syms.SymbolID("string_constant")), src.varc+baseRegister)
-			k.Define()
+			w.dest.Constant(w.dest.String(s.val))
 			w.dest.Store(int(s.into) + baseRegister)
-			w.pending = append(w.pending, &placeString{start, end, s.val})
 
 		case localStep:
 			w.dest.Load(int(s.from) + baseRegister)
@@ -102,11 +88,19 @@ func (w *assembler) writeBlock(src method) {
 			}
 			c := w.dest.Class(len(s.fields))
 			for _, m := range s.methods {
-				w.pending = append(w.pending, &implementMethod{c, m})
+				w.pending = append(w.pending, &implementMethod{c, m, s.handler})
 			}
 			w.dest.Create(c, src.varc+baseRegister)
 			w.dest.Store(int(s.into) + baseRegister)
 
+		case arrayStep:
+			for i, x := range s.items {
+				w.dest.Load(int(x) + baseRegister)
+				w.dest.Store(src.varc + i + baseRegister)
+			}
+			w.dest.Array(len(s.items), src.varc+baseRegister)
+			w.dest.Store(int(s.into) + baseRegister)
+
 		case returnStep:
 			w.dest.Load(int(s.val) + baseRegister)
 			w.dest.Return()
@@ -116,6 +110,7 @@ func (w *assembler) writeBlock(src method) {
 			w.dest.GlobalStore(w.dest.Global(s.into))
 
 		case callStep:
+			w.sourcePos(s.pos)
 			if isTailCall(src.steps[p+1:], s.into) {
 				w.dest.Load(int(s.object) + baseRegister)
 				w.dest.Store(src.varc + baseRegister)
@@ -129,7 +124,9 @@ func (w *assembler) writeBlock(src method) {
 					w.dest.Store(i + baseRegister)
 				}
 
+				// the method finds the object after its arguments
 				w.dest.Load(src.varc + baseRegister)
+				w.dest.Store(len(s.params) + baseRegister)
 				w.dest.Call(w.dest.Method(s.method), 0)
 				// we do this to skip the final return instruction
 				return
@@ -148,6 +145,7 @@ func (w *assembler) writeBlock(src method) {
 					w.dest.Store(src.varc + i + baseRegister*2)
 				}
 				w.dest.Load(int(s.object) + baseRegister)
+				w.dest.Store(src.varc + len(s.params) + baseRegister*2)
 				w.dest.Call(w.dest.Method(s.method), src.varc+baseRegister)
 
 				// continuation
@@ -162,6 +160,15 @@ func (w *assembler) writeBlock(src method) {
 	}
 }
 
+// Code from an unknown position is left with the position of the code before
+// it.
+func (w *assembler) sourcePos(pos ast.Pos) {
+	if pos.Line == 0 {
+		return
+	}
+	w.dest.SourcePos(pos.File, pos.Line, pos.Col)
+}
+
 func isTailCall(steps []step, id variable) bool {
 	if len(steps) != 1 {
 		return false
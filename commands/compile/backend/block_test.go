@@ -28,21 +28,7 @@ func TestTailCall(t *testing.T) {
 	w.writePackage(b)
 
 	expect := new(assembly.Writer)
-	start := expect.Location()
-	end := expect.Location()
-	k := expect.Location()
-
-	expect.Natural(expect.Fixed(5))
-	expect.Store(5)
-	expect.Natural(k)
-	expect.Store(6)
-	expect.Natural(start)
-	expect.Store(7)
-	expect.Natural(end)
-	expect.Store(8)
-	expect.GlobalLoad(expect.Import("runtime"))
-	expect.Call(expect.Method(syms.SymbolID("string_constant")), 5)
-	k.Define()
+	expect.Constant(expect.String("abc"))
 	expect.Store(2)
 	expect.Natural(expect.Fixed(1))
 	expect.Store(3)
@@ -53,12 +39,44 @@ func TestTailCall(t *testing.T) {
 	expect.Load(6)
 	expect.Store(2)
 	expect.Load(5)
+	expect.Store(3)
 	expect.Call(expect.Method(syms.SymbolID("add")), 0)
-	start.Define()
-	expect.WriteByte('a')
-	expect.WriteByte('b')
-	expect.WriteByte('c')
-	end.Define()
+
+	assert.Equal(t, &w.dest, expect)
+}
+
+func TestArray(t *testing.T) {
+	var syms symtab.Symtab
+
+	b := method{
+		varc: 3,
+		steps: []step{
+			intStep{val: 1, into: 0},
+			intStep{val: 2, into: 1},
+			arrayStep{items: []variable{1, 0}, into: 2},
+			returnStep{val: 2},
+		},
+	}
+
+	w := &assembler{
+		syms: &syms,
+	}
+
+	w.writePackage(b)
+
+	expect := new(assembly.Writer)
+	expect.Natural(expect.Fixed(1))
+	expect.Store(2)
+	expect.Natural(expect.Fixed(2))
+	expect.Store(3)
+	expect.Load(3)
+	expect.Store(5)
+	expect.Load(2)
+	expect.Store(6)
+	expect.Array(2, 5)
+	expect.Store(4)
+	expect.Load(4)
+	expect.Return()
 
 	assert.Equal(t, &w.dest, expect)
 }
@@ -1,42 +1,66 @@
 package compile
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/bobappleyard/cezanne/commands"
 	"github.com/bobappleyard/cezanne/commands/compile/ast"
 	"github.com/bobappleyard/cezanne/commands/compile/backend"
 	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/commands/compile/resolve"
+	"github.com/bobappleyard/cezanne/commands/compile/types"
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/format/storage"
 	"github.com/bobappleyard/cezanne/format/symtab"
 )
 
 type Options struct {
 	Output string `option:"o"`
+
+	// Directory containing compiled packages, named by import path, whose
+	// types imports are checked against.
+	Packages string `option:"p"`
+
+	// How to report problems: "text" (the default) or "json".
+	Format string `option:"format"`
 }
 
+var (
+	ErrFailed        = errors.New("compilation failed")
+	ErrUnknownFormat = errors.New("unknown diagnostic format")
+)
+
 func init() {
 	commands.Register("compile", Compile)
 }
 
+// Compile builds a package from source files. Problems are written to
+// standard error, or as JSON to standard output, and if there are any errors
+// no package is written.
 func Compile(options Options, files []string) error {
-	var sourceModel ast.Package
-	var syms symtab.Symtab
+	var diags diag.List
+	objectModel := compilePackage(options, files, &diags)
 
-	for _, f := range files {
-		data, err := os.ReadFile(f)
-		if err != nil {
-			return err
+	if len(diags) != 0 {
+		diags.Sort()
+		var err error
+		switch options.Format {
+		case "", "text":
+			err = diags.WriteText(os.Stderr)
+		case "json":
+			err = diags.WriteJSON(os.Stdout)
+		default:
+			err = fmt.Errorf("%s: %w", options.Format, ErrUnknownFormat)
 		}
-		err = parser.ParseFile(&syms, &sourceModel, data)
 		if err != nil {
 			return err
 		}
 	}
-
-	objectModel, err := backend.BuildPackage(&syms, sourceModel)
-	if err != nil {
-		return err
+	if diags.HasErrors() {
+		return ErrFailed
 	}
 
 	output, err := os.Create(options.Output)
@@ -45,6 +69,67 @@ func Compile(options Options, files []string) error {
 	}
 	defer output.Close()
 
-	_, err = storage.Write(output, objectModel)
+	_, err = storage.Write(output, *objectModel)
 	return err
 }
+
+// Each phase reports as many problems as it can find, but later phases only
+// run if earlier ones succeeded.
+func compilePackage(options Options, files []string, diags *diag.List) *format.Package {
+	var sourceModel ast.Package
+	var syms symtab.Symtab
+
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			diags.AddError(err)
+			continue
+		}
+		diags.AddError(parser.ParseNamedFile(&syms, &sourceModel, f, data))
+	}
+	if diags.HasErrors() {
+		return nil
+	}
+
+	// Types are still checked when names are missing, but the resolver has
+	// already reported those.
+	names := resolve.Package(&syms, sourceModel)
+	diags.AddError(names)
+
+	env := types.NewEnv(&syms)
+	diags.AddError(ImportTypes(env, &syms, options.Packages, sourceModel.Imports))
+	exports, err := env.CheckPackage(sourceModel)
+	if names != nil {
+		err = knownVariables(err)
+	}
+	diags.AddError(err)
+	if diags.HasErrors() {
+		return nil
+	}
+
+	objectModel, err := backend.BuildPackage(&syms, sourceModel)
+	if err != nil {
+		diags.AddError(err)
+		return nil
+	}
+	objectModel.Types = env.EncodePackage(exports)
+
+	return objectModel
+}
+
+func knownVariables(err error) error {
+	var errs types.Errors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	var res types.Errors
+	for _, e := range errs {
+		if !errors.Is(e, types.ErrUnknownVariable) {
+			res = append(res, e)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
@@ -1,12 +1,19 @@
 package compile
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/bobappleyard/cezanne/commands/compile/ast"
 	"github.com/bobappleyard/cezanne/commands/compile/backend"
 	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/commands/compile/resolve"
+	"github.com/bobappleyard/cezanne/commands/diag"
 	linker "github.com/bobappleyard/cezanne/commands/link"
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/format/assembly"
@@ -84,11 +91,39 @@ func TestCompiler(t *testing.T) {
 		p.Return(p.Process().String(string(prog.Code[start:end])))
 	})
 
-	e.Run(&syms, prog)
+	assert.Nil(t, e.Run(&syms, prog))
 
 	assert.Equal(t, logged, []string{"hello"})
 }
 
+func TestCompileLines(t *testing.T) {
+	var pt ast.Package
+	var syms symtab.Symtab
+
+	err := parser.ParseNamedFile(&syms, &pt, "main.cz", []byte(`import test
+
+func main() {
+	test.print("hello")
+}
+`))
+	assert.Nil(t, err)
+
+	pkg, err := backend.BuildPackage(&syms, pt)
+	assert.Nil(t, err)
+
+	var call int
+	for _, r := range pkg.Relocations {
+		if r.Kind == format.MethodRel && pkg.Methods[r.ID].Name == syms.SymbolID("print") {
+			call = int(r.Pos)
+		}
+	}
+	assert.True(t, call != 0)
+
+	pos, ok := pkg.Lines.Lookup(call)
+	assert.True(t, ok)
+	assert.Equal(t, pos.String(), "main.cz:4:7")
+}
+
 func testPkg(syms *symtab.Symtab) *format.Package {
 	b := assembly.New(syms)
 
@@ -139,3 +174,78 @@ func runtimePkg(syms *symtab.Symtab) *format.Package {
 
 	return p
 }
+
+func TestCompileDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.cz")
+	err := os.WriteFile(main, []byte(`func main() {
+	prnt("hello")
+	prnt(x)
+}
+`), 0o644)
+	assert.Nil(t, err)
+	broken := filepath.Join(dir, "broken.cz")
+	err = os.WriteFile(broken, []byte("func broken() { ( }\n"), 0o644)
+	assert.Nil(t, err)
+
+	var diags diag.List
+	pkg := compilePackage(Options{}, []string{main}, &diags)
+	assert.Nil(t, pkg)
+	assert.True(t, errors.Is(diags, resolve.ErrUndefined))
+	assert.Equal(t, diags.Error(), strings.Join([]string{
+		main + ":2:2: error: prnt: undefined",
+		main + ":3:2: error: prnt: undefined",
+		main + ":3:7: error: x: undefined",
+	}, "\n"))
+
+	// undefined names do not hide type errors
+	both := filepath.Join(dir, "both.cz")
+	err = os.WriteFile(both, []byte(`func main() {
+	let n: Int = "one"
+	prnt(n)
+}
+`), 0o644)
+	assert.Nil(t, err)
+	diags = nil
+	pkg = compilePackage(Options{}, []string{both}, &diags)
+	assert.Nil(t, pkg)
+	assert.Equal(t, diags.Error(), strings.Join([]string{
+		both + ":3:2: error: prnt: undefined",
+		both + ":2:6: error: main: n: expected Int, got String: wrong constructor",
+	}, "\n"))
+
+	// the runtime package's types are known, as they are to the language
+	// server
+	rt := filepath.Join(dir, "rt.cz")
+	err = os.WriteFile(rt, []byte(`import runtime
+
+func main() {
+	let s: Int = runtime.set()
+	s
+}
+`), 0o644)
+	assert.Nil(t, err)
+	diags = nil
+	pkg = compilePackage(Options{}, []string{rt}, &diags)
+	assert.Nil(t, pkg)
+	assert.Equal(t, diags.Error(), rt+":4:6: error: main: s: expected Int, got Set[a]: wrong constructor")
+
+	// syntax errors in one file do not hide those in another
+	diags = nil
+	pkg = compilePackage(Options{}, []string{broken, broken}, &diags)
+	assert.Nil(t, pkg)
+	assert.Equal(t, len(diags), 2)
+
+	var out bytes.Buffer
+	diags[:1].WriteJSON(&out)
+	assert.Equal(t, out.String(), `[
+  {
+    "severity": "error",
+    "file": "`+broken+`",
+    "line": 1,
+    "column": 17,
+    "message": "unexpected token"
+  }
+]
+`)
+}
@@ -0,0 +1,94 @@
+package compile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bobappleyard/cezanne/commands"
+	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/commands/diag"
+)
+
+type FmtOptions struct {
+	// List the files that are not formatted, rather than rewriting them.
+	Check bool `option:"check"`
+}
+
+var ErrNotFormatted = errors.New("not formatted")
+
+func init() {
+	commands.Register("fmt", Fmt)
+}
+
+// Fmt rewrites source files in the canonical layout. With no files it formats
+// standard input to standard output.
+func Fmt(options FmtOptions, files []string) error {
+	if len(files) == 0 {
+		return fmtStream(options, os.Stdin, os.Stdout)
+	}
+	return fmtFiles(options, files, os.Stdout)
+}
+
+func fmtStream(options FmtOptions, r io.Reader, w io.Writer) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	out, err := parser.Format("<stdin>", src)
+	if err != nil {
+		return err
+	}
+	if options.Check {
+		if !bytes.Equal(src, out) {
+			return fmt.Errorf("<stdin>: %w", ErrNotFormatted)
+		}
+		return nil
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// In check mode the names of files that would change are written to w. Files
+// that cannot be formatted are reported and left alone.
+func fmtFiles(options FmtOptions, files []string, w io.Writer) error {
+	var diags diag.List
+	unformatted := 0
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			diags.AddError(err)
+			continue
+		}
+		out, err := parser.Format(f, src)
+		if err != nil {
+			diags.AddError(err)
+			continue
+		}
+		if bytes.Equal(src, out) {
+			continue
+		}
+		if options.Check {
+			unformatted++
+			fmt.Fprintln(w, f)
+			continue
+		}
+		if err := os.WriteFile(f, out, 0666); err != nil {
+			diags.AddError(err)
+		}
+	}
+
+	if len(diags) != 0 {
+		diags.Sort()
+		if err := diags.WriteText(os.Stderr); err != nil {
+			return err
+		}
+		return ErrFailed
+	}
+	if unformatted != 0 {
+		return fmt.Errorf("%d files: %w", unformatted, ErrNotFormatted)
+	}
+	return nil
+}
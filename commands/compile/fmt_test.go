@@ -0,0 +1,51 @@
+package compile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestFmtFiles(t *testing.T) {
+	dir := t.TempDir()
+	messy := filepath.Join(dir, "messy.cz")
+	tidy := filepath.Join(dir, "tidy.cz")
+	assert.Nil(t, os.WriteFile(messy, []byte("func main( ) {main()}"), 0666))
+	assert.Nil(t, os.WriteFile(tidy, []byte("func main() { main() }\n"), 0666))
+
+	var out bytes.Buffer
+	err := fmtFiles(FmtOptions{Check: true}, []string{messy, tidy}, &out)
+	assert.True(t, errors.Is(err, ErrNotFormatted))
+	assert.Equal(t, out.String(), messy+"\n")
+
+	src, err := os.ReadFile(messy)
+	assert.Nil(t, err)
+	assert.Equal(t, string(src), "func main( ) {main()}")
+
+	out.Reset()
+	err = fmtFiles(FmtOptions{}, []string{messy, tidy}, &out)
+	assert.Nil(t, err)
+	assert.Equal(t, out.String(), "")
+
+	src, err = os.ReadFile(messy)
+	assert.Nil(t, err)
+	assert.Equal(t, string(src), "func main() { main() }\n")
+
+	err = fmtFiles(FmtOptions{Check: true}, []string{messy, tidy}, &out)
+	assert.Nil(t, err)
+}
+
+func TestFmtStream(t *testing.T) {
+	var out bytes.Buffer
+	err := fmtStream(FmtOptions{}, strings.NewReader("import io\n\n\n\nfunc main() {io.print(1)}"), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, out.String(), "import io\n\nfunc main() { io.print(1) }\n")
+
+	err = fmtStream(FmtOptions{Check: true}, strings.NewReader("func main() {}"), &out)
+	assert.True(t, errors.Is(err, ErrNotFormatted))
+}
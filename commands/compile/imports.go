@@ -0,0 +1,53 @@
+package compile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/types"
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/storage"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/core"
+)
+
+// Imports whose packages have not been compiled, or that were compiled without
+// type information, are left for the checker to infer. The runtime package
+// comes with the toolchain, so its types are always known. The compiler and the
+// language server both use this, so that they agree about the types of imports.
+func ImportTypes(env *types.Env, syms *symtab.Symtab, dir string, imports []ast.Import) error {
+	for _, imp := range imports {
+		if imp.Path == core.Path {
+			env.ImportPackage(&types.Package{Exports: types.Runtime}, syms.SymbolName(imp.Name))
+			continue
+		}
+		if dir == "" {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, imp.Path))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var pkg format.Package
+		_, err = storage.Read(f, &pkg)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if len(pkg.Types.Types) == 0 {
+			continue
+		}
+		p, err := env.DecodePackage(pkg.Types)
+		if err != nil {
+			return fmt.Errorf("%s: %w", imp.Path, err)
+		}
+		env.ImportPackage(p, syms.SymbolName(imp.Name))
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/commands/compile/text"
+)
+
+// lexeme is a token together with the text it was read from. Unlike the
+// tokens given to the parser, lexemes include comments and whitespace, so a
+// file can be printed again without losing anything.
+type lexeme struct {
+	tok  token
+	text string
+}
+
+func lexemes(src []byte) ([]lexeme, error) {
+	toks := lexicon.Stream(src)
+	var res []lexeme
+	for toks.Next() {
+		res = append(res, lexeme{
+			tok:  mapKeywords(toks.This()),
+			text: string(src[toks.Pos():toks.End()]),
+		})
+	}
+	if toks.Err() != nil {
+		return nil, toks.Err()
+	}
+	return res, nil
+}
+
+// Format prints a source file in the canonical layout. Blocks are indented
+// by tabs, and are written on one line unless they are object literals or
+// handlers, or they already span several lines. Spacing within lines is
+// normalised, at most one blank line is kept between lines, and comments are
+// kept where they are. Files with syntax errors are not formatted.
+func Format(name string, src []byte) ([]byte, error) {
+	i := interpreter{file: name, lines: lineStarts(src), end: len(src)}
+
+	toks, offsets, err := tokenize(src)
+	if err != nil {
+		return nil, i.syntaxError(err, offsets)
+	}
+	if _, err := text.Parse[token, file](parseRules{}, toks); err != nil {
+		return nil, i.syntaxError(err, offsets)
+	}
+
+	ls, err := lexemes(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &printer{expand: expandedBlocks(ls)}
+	for _, l := range ls {
+		p.lexeme(l)
+	}
+	if p.out.Len() != 0 {
+		p.out.WriteByte('\n')
+	}
+	return p.out.Bytes(), nil
+}
+
+// expandedBlocks decides which blocks are written over several lines, giving
+// the index of the lexeme that opens each one. Any block that contains an
+// expanded block is expanded too, so that the result is stable when it is
+// formatted again.
+func expandedBlocks(ls []lexeme) map[int]bool {
+	type open struct {
+		at      int
+		block   bool
+		object  bool
+		content bool
+		expand  bool
+	}
+	res := map[int]bool{}
+	var stack []open
+	var handles []int
+	var prev token
+
+	for i, l := range ls {
+		top := len(stack) - 1
+		switch t := l.tok.(type) {
+		case whitespace:
+			if top >= 0 && stack[top].block && strings.Contains(t.text, "\n") {
+				stack[top].expand = true
+			}
+			continue
+
+		case comment:
+			if top >= 0 {
+				stack[top].content = true
+				stack[top].expand = true
+			}
+			continue
+
+		case groupClose, listClose, blockClose:
+			if top < 0 {
+				continue
+			}
+			o := stack[top]
+			stack = stack[:top]
+			expand := o.expand || o.object && o.content
+			if o.block {
+				res[o.at] = expand
+			}
+			if top > 0 {
+				stack[top-1].expand = stack[top-1].expand || expand
+			}
+			prev = l.tok
+			continue
+		}
+
+		if top >= 0 {
+			stack[top].content = true
+		}
+		switch l.tok.(type) {
+		case handleKeyword:
+			handles = append(handles, len(stack))
+
+		case groupOpen, listOpen:
+			stack = append(stack, open{at: i})
+
+		case blockOpen:
+			// the block after a handle expression holds its handlers
+			_, object := prev.(objectKeyword)
+			if n := len(handles); !object && n != 0 && handles[n-1] == len(stack) {
+				object = true
+				handles = handles[:n-1]
+			}
+			stack = append(stack, open{at: i, block: true, object: object})
+		}
+		prev = l.tok
+	}
+	return res
+}
+
+type printer struct {
+	expand map[int]bool
+	out    bytes.Buffer
+
+	// the brackets that are open, with whether each is an expanded block
+	stack []bracket
+	depth int
+
+	// the last token written on the current line, or nil at the start of a
+	// line
+	prev token
+
+	// whether the source has stayed on the same line since the last token
+	sameLine bool
+
+	// the number of line breaks to write before the next token, and the most
+	// that may be written
+	breaks, maxBreaks int
+
+	at int
+}
+
+type bracket struct {
+	block, expanded bool
+}
+
+// Line breaks only matter directly inside blocks and at the top level of a
+// file. Within brackets they are spacing like any other.
+func (p *printer) breaksMatter() bool {
+	return len(p.stack) == 0 || p.stack[len(p.stack)-1].block
+}
+
+func (p *printer) lexeme(l lexeme) {
+	defer func() { p.at++ }()
+
+	switch t := l.tok.(type) {
+	case whitespace:
+		if strings.Contains(t.text, "\n") {
+			p.sameLine = false
+		}
+		if p.breaksMatter() {
+			p.lineBreaks(strings.Count(t.text, "\n"))
+		}
+
+	case comment:
+		// comments that follow a token on the same line stay there
+		if p.sameLine && p.out.Len() != 0 {
+			p.out.WriteByte(' ')
+		} else {
+			p.startLine(l.tok)
+		}
+		p.out.WriteString(strings.TrimRight(l.text, " \t\r"))
+		p.prev = nil
+		p.sameLine = false
+		p.lineBreaks(1)
+
+	case blockOpen:
+		p.token(l)
+		expanded := p.expand[p.at]
+		p.stack = append(p.stack, bracket{block: true, expanded: expanded})
+		if expanded {
+			p.depth++
+			p.breaks, p.maxBreaks = 1, 1
+		}
+
+	case groupOpen, listOpen:
+		p.token(l)
+		p.stack = append(p.stack, bracket{})
+
+	case blockClose, groupClose, listClose:
+		var b bracket
+		if n := len(p.stack); n != 0 {
+			b = p.stack[n-1]
+			p.stack = p.stack[:n-1]
+		}
+		if b.expanded {
+			p.depth--
+			p.breaks, p.maxBreaks = 1, 1
+		}
+		p.token(l)
+
+	default:
+		p.token(l)
+	}
+}
+
+func (p *printer) lineBreaks(n int) {
+	if n == 0 {
+		return
+	}
+	if p.maxBreaks == 0 {
+		p.maxBreaks = 2
+	}
+	if n > p.breaks {
+		p.breaks = n
+	}
+	if p.breaks > p.maxBreaks {
+		p.breaks = p.maxBreaks
+	}
+}
+
+// startLine writes any pending line breaks followed by the indentation for
+// the new line, which is to start with a token. Breaks before the first token
+// of the file are dropped.
+func (p *printer) startLine(next token) {
+	if p.out.Len() != 0 {
+		for i := 0; i < p.breaks; i++ {
+			p.out.WriteByte('\n')
+		}
+	}
+	p.breaks, p.maxBreaks = 0, 0
+	indent := p.depth
+	if _, close := next.(blockClose); !close && !p.breaksMatter() {
+		// a continuation line within brackets, rather than the end of a
+		// block that started on an earlier line
+		indent++
+	}
+	for i := 0; i < indent; i++ {
+		p.out.WriteByte('\t')
+	}
+	p.prev = nil
+}
+
+func (p *printer) token(l lexeme) {
+	if p.prev == nil || p.breaks != 0 {
+		p.startLine(l.tok)
+	} else if spaced(p.prev, l.tok) {
+		p.out.WriteByte(' ')
+	}
+	p.out.WriteString(l.text)
+	p.prev = l.tok
+	p.sameLine = true
+}
+
+// spaced decides whether a space separates two tokens on the same line.
+func spaced(prev, next token) bool {
+	switch prev.(type) {
+	case groupOpen, listOpen, dot:
+		return false
+	}
+	switch next.(type) {
+	case comma, colon, dot, groupClose, listClose:
+		return false
+	case groupOpen:
+		switch prev.(type) {
+		case ident, groupClose, listClose, funcKeyword:
+			return false
+		}
+	case listOpen:
+		_, ok := prev.(ident)
+		return !ok
+	case blockClose:
+		_, ok := prev.(blockOpen)
+		return !ok
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		name, in, out string
+	}{
+		{
+			name: "Empty",
+			in:   "\n\n",
+			out:  "",
+		},
+		{
+			name: "Spacing",
+			in:   "import io\nfunc  f( x :Int ,y )  :Int{io.print ( x,y )}",
+			out:  "import io\nfunc f(x: Int, y): Int { io.print(x, y) }\n",
+		},
+		{
+			name: "Indentation",
+			in: `func main() {
+    let x = 1
+        x
+}
+`,
+			out: "func main() {\n\tlet x = 1\n\tx\n}\n",
+		},
+		{
+			name: "BlankLines",
+			in:   "\n\nfunc f() {\n\n\n\tf()\n\n\n\tf()\n\n}\n\n\n\nfunc g() {}\n\n",
+			out:  "func f() {\n\tf()\n\n\tf()\n}\n\nfunc g() {}\n",
+		},
+		{
+			name: "ObjectMethods",
+			in:   "func f(n) { object { get() { n }\n  set(m) { f(m) } } }",
+			out:  "func f(n) {\n\tobject {\n\t\tget() { n }\n\t\tset(m) { f(m) }\n\t}\n}\n",
+		},
+		{
+			name: "EmptyObject",
+			in:   "func f() { object {} }",
+			out:  "func f() { object {} }\n",
+		},
+		{
+			name: "Handler",
+			in:   "func f(g) { handle g() { log(k, x) { k.resume(x) } } }",
+			out:  "func f(g) {\n\thandle g() {\n\t\tlog(k, x) { k.resume(x) }\n\t}\n}\n",
+		},
+		{
+			name: "Types",
+			in:   "func f [T] ( x : List [T], g : func ( T ) : T in E ) : T in E { g(x) }",
+			out:  "func f[T](x: List[T], g: func(T): T in E): T in E { g(x) }\n",
+		},
+		{
+			name: "Comments",
+			in: `// leading
+import io   // trailing
+func main() {
+// inside
+	io.print("a")   // after
+	// last
+}
+`,
+			out: "// leading\nimport io // trailing\nfunc main() {\n\t// inside\n\tio.print(\"a\") // after\n\t// last\n}\n",
+		},
+		{
+			name: "BlockInArguments",
+			in:   "func f(n) {\n\tn.match(object {\n\t\ttrue() { 1 }\n\t\tfalse() { 2 }\n\t})\n}\n",
+			out:  "func f(n) {\n\tn.match(object {\n\t\ttrue() { 1 }\n\t\tfalse() { 2 }\n\t})\n}\n",
+		},
+		{
+			name: "CommentInArguments",
+			in:   "func main() {\n\tf(1, // one\n2)\n}\n",
+			out:  "func main() {\n\tf(1, // one\n\t\t2)\n}\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := Format("test.cz", []byte(test.in))
+			assert.Nil(t, err)
+			assert.Equal(t, string(out), test.out)
+
+			again, err := Format("test.cz", out)
+			assert.Nil(t, err)
+			assert.Equal(t, string(again), test.out)
+		})
+	}
+}
+
+// The programs in the repository's test data are already formatted, so
+// formatting them changes nothing, however many times it is done.
+func TestFormatSources(t *testing.T) {
+	for _, name := range []string{
+		"../../../runtime/core/testdata/helpers.cz",
+		"../../../commands/debug/testdata/main.cz",
+	} {
+		t.Run(filepath.Base(filepath.Dir(filepath.Dir(name))), func(t *testing.T) {
+			src, err := os.ReadFile(name)
+			assert.Nil(t, err)
+
+			out, err := Format(name, src)
+			assert.Nil(t, err)
+			assert.Equal(t, string(out), string(src))
+
+			again, err := Format(name, out)
+			assert.Nil(t, err)
+			assert.Equal(t, string(again), string(out))
+		})
+	}
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	_, err := Format("test.cz", []byte("func main() {\n\tf(\n}\n"))
+	var l diag.List
+	assert.True(t, errors.As(err, &l))
+	assert.Equal(t, l[0].Span.Start.File, "test.cz")
+	assert.Equal(t, l[0].Span.Start.Line, 3)
+}
@@ -1,66 +1,221 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 
 	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/text"
+	"github.com/bobappleyard/cezanne/commands/diag"
 	"github.com/bobappleyard/cezanne/format/symtab"
 	"github.com/bobappleyard/cezanne/util/slices"
 )
 
 type interpreter struct {
-	syms *symtab.Symtab
+	syms  *symtab.Symtab
+	file  string
+	lines []int
+	end   int
 }
 
-func (i *interpreter) interpretFunc(d funcDecl) ast.Method {
-	return ast.Method{
-		Name: i.syms.SymbolID(d.name),
-		Args: slices.Map(d.args, i.syms.SymbolID),
-		Body: i.interpretExpr(d.body[0]),
+// lineStarts finds the offset of the start of each line in src.
+func lineStarts(src []byte) []int {
+	lines := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
 	}
+	return lines
 }
 
-func (i *interpreter) interpretMethod(d method) ast.Method {
-	return ast.Method{
-		Name: i.syms.SymbolID(d.name),
-		Args: slices.Map(d.args, i.syms.SymbolID),
-		Body: i.interpretExpr(d.body[0]),
+func (i *interpreter) pos(offset int) ast.Pos {
+	line := sort.Search(len(i.lines), func(j int) bool { return i.lines[j] > offset })
+	return ast.Pos{
+		File: i.file,
+		Line: line,
+		Col:  offset - i.lines[line-1] + 1,
 	}
 }
 
+// syntaxError places errors from the lexer and parser in the source.
+func (i *interpreter) syntaxError(err error, offsets []int) diag.List {
+	var l diag.List
+	span := diag.Span{Start: diag.Pos{File: i.file}}
+
+	var input *text.UnexpectedInput
+	var tok *text.UnexpectedToken
+	switch {
+	case errors.As(err, &input):
+		span = diag.At(i.pos(input.Pos))
+		err = errors.New("unexpected character")
+	case errors.As(err, &tok):
+		span = diag.At(i.pos(offsets[tok.Index]))
+		err = errors.New("unexpected token")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		span = diag.At(i.pos(i.end))
+		err = errors.New("unexpected end of file")
+	}
+
+	l.Errorf(span, "%s", err)
+	return l
+}
+
+func (i *interpreter) interpretFunc(d funcDecl) ast.Method {
+	return i.interpretSignature(ast.Method{
+		Pos:    i.pos(d.pos),
+		Name:   i.syms.SymbolID(d.name),
+		Args:   slices.Map(d.args, i.paramName),
+		ArgPos: slices.Map(d.args, i.paramPos),
+		Body:   i.interpretBody(d.body),
+	}, d.generics, d.args, d.result)
+}
+
+func (i *interpreter) interpretMethod(d method) ast.Method {
+	return i.interpretSignature(ast.Method{
+		Pos:    i.pos(d.pos),
+		Name:   i.syms.SymbolID(d.name),
+		Args:   slices.Map(d.args, i.paramName),
+		ArgPos: slices.Map(d.args, i.paramPos),
+		Body:   i.interpretBody(d.body),
+	}, d.generics, d.args, d.result)
+}
+
 func (i *interpreter) interpretHandler(d method) ast.Method {
-	return ast.Method{
-		Name: i.syms.SymbolID(d.name),
-		Args: slices.Map(append([]string{"context"}, d.args...), i.syms.SymbolID),
-		Body: i.interpretExpr(d.body[0]),
+	args := append([]param{{name: "context", pos: -1}}, d.args...)
+	return i.interpretSignature(ast.Method{
+		Pos:    i.pos(d.pos),
+		Name:   i.syms.SymbolID(d.name),
+		Args:   slices.Map(args, i.paramName),
+		ArgPos: slices.Map(args, i.paramPos),
+		Body:   i.interpretBody(d.body),
+	}, d.generics, args, d.result)
+}
+
+func (i *interpreter) paramName(p param) symtab.Symbol {
+	return i.syms.SymbolID(p.name)
+}
+
+// The context argument of a handler is not in the source, so it has no
+// position.
+func (i *interpreter) paramPos(p param) ast.Pos {
+	if p.pos < 0 {
+		return ast.Pos{}
 	}
+	return i.pos(p.pos)
+}
+
+func (i *interpreter) interpretSignature(m ast.Method, generics []string, args []param, res resultSpec) ast.Method {
+	if len(generics) != 0 {
+		m.TypeParams = slices.Map(generics, i.syms.SymbolID)
+	}
+	for _, a := range args {
+		if a.t != nil {
+			m.ArgTypes = slices.Map(args, func(p param) ast.TypeExpr {
+				return i.interpretType(p.t)
+			})
+			break
+		}
+	}
+	m.Result = i.interpretType(res.t)
+	m.Effect = i.interpretType(res.effect)
+	return m
+}
+
+// A body is a sequence of statements, where each let binding scopes over the
+// statements that follow it. Expressions that are not in the final position
+// are evaluated for their effects and their values discarded.
+func (i *interpreter) interpretBody(body []stmt) ast.Expr {
+	var res ast.Expr
+	for j := len(body) - 1; j >= 0; j-- {
+		switch s := body[j].(type) {
+		case exprStmt:
+			if res == nil {
+				res = i.interpretExpr(s.expr)
+				continue
+			}
+			res = ast.Let{
+				Name:  i.syms.SymbolID("_"),
+				Value: i.interpretExpr(s.expr),
+				In:    res,
+			}
+		case letStmt:
+			name := i.syms.SymbolID(s.name)
+			if res == nil {
+				res = ast.Ref{Pos: i.pos(s.pos), Name: name}
+			}
+			res = ast.Let{
+				Pos:   i.pos(s.pos),
+				Name:  name,
+				Type:  i.interpretType(s.t),
+				Value: i.interpretExpr(s.value),
+				In:    res,
+			}
+		}
+	}
+	return res
+}
+
+func (i *interpreter) interpretType(t typeExpr) ast.TypeExpr {
+	switch t := t.(type) {
+	case nil:
+		return nil
+	case namedTypeExpr:
+		return ast.NamedType{
+			Name: i.syms.SymbolID(t.name),
+			Args: slices.Map(t.args, i.interpretType),
+		}
+	case funcTypeExpr:
+		return ast.FuncType{
+			Args:   slices.Map(t.args, i.interpretType),
+			Result: i.interpretType(t.result.t),
+			Effect: i.interpretType(t.result.effect),
+		}
+	}
+	panic(fmt.Sprintf("unrecognized %#v", t))
 }
 
 func (i *interpreter) interpretExpr(e expr) ast.Expr {
 	switch e := e.(type) {
 	case intVal:
 		return ast.Int{Value: e.Value}
+	case floatVal:
+		return ast.Float{Value: e.Value}
 	case strVal:
 		return ast.String{Value: e.Value}
 	case varRef:
-		return ast.Ref{Name: i.syms.SymbolID(e.Name)}
+		return ast.Ref{Pos: i.pos(e.pos), Name: i.syms.SymbolID(e.Name)}
 	case createObject:
 		return ast.Create{
 			Methods: slices.Map(e.Methods, i.interpretMethod),
 		}
 	case invokeMethod:
+		var pos ast.Pos
+		if e.pos >= 0 {
+			pos = i.pos(e.pos)
+		}
 		return ast.Invoke{
+			Pos:    pos,
 			Object: i.interpretExpr(e.Object),
 			Name:   i.syms.SymbolID(e.Name),
 			Args:   slices.Map(e.Args, i.interpretExpr),
 		}
+	case arrayLit:
+		return ast.Array{
+			Pos:   i.pos(e.pos),
+			Items: slices.Map(e.Items, i.interpretExpr),
+		}
 	case handleEffects:
 		return ast.Handle{
+			Pos:  i.pos(e.pos),
 			In:   i.interpretExpr(e.In),
 			With: slices.Map(e.With, i.interpretHandler),
 		}
 	case triggerEffect:
 		return ast.Trigger{
+			Pos:  i.pos(e.pos),
 			Name: i.syms.SymbolID(e.Name),
 			Args: slices.Map(e.Args, i.interpretExpr),
 		}
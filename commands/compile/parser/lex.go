@@ -15,23 +15,33 @@ type token interface {
 type comment struct{ text string }
 type whitespace struct{ text string }
 type newline struct{}
-type ident struct{ name string }
+type ident struct {
+	name string
+	pos  int
+}
 type strLit struct{ text string }
 type intLit struct{ val int }
+type floatLit struct{ val float64 }
 type op struct{ of string }
+type assign struct{}
+type colon struct{}
 type comma struct{}
 type dot struct{}
 type groupOpen struct{}
 type groupClose struct{}
 type blockOpen struct{}
 type blockClose struct{}
+type listOpen struct{ pos int }
+type listClose struct{}
 type importKeyword struct{}
 type funcKeyword struct{}
 type objectKeyword struct{}
 type effectKeyword struct{}
 type varKeyword struct{}
 type triggerKeyword struct{}
-type handleKeyword struct{}
+type handleKeyword struct{ pos int }
+type letKeyword struct{}
+type inKeyword struct{}
 
 func (comment) tok()        {}
 func (whitespace) tok()     {}
@@ -39,13 +49,18 @@ func (newline) tok()        {}
 func (ident) tok()          {}
 func (strLit) tok()         {}
 func (intLit) tok()         {}
+func (floatLit) tok()       {}
 func (op) tok()             {}
+func (assign) tok()         {}
+func (colon) tok()          {}
 func (comma) tok()          {}
 func (dot) tok()            {}
 func (groupOpen) tok()      {}
 func (groupClose) tok()     {}
 func (blockOpen) tok()      {}
 func (blockClose) tok()     {}
+func (listOpen) tok()       {}
+func (listClose) tok()      {}
 func (importKeyword) tok()  {}
 func (funcKeyword) tok()    {}
 func (objectKeyword) tok()  {}
@@ -53,6 +68,8 @@ func (effectKeyword) tok()  {}
 func (varKeyword) tok()     {}
 func (triggerKeyword) tok() {}
 func (handleKeyword) tok()  {}
+func (letKeyword) tok()     {}
+func (inKeyword) tok()      {}
 
 var lexicon = must.Be(text.NewLexer(
 	text.Regex(`//[^\n]*`, func(start int, text string) token {
@@ -62,7 +79,7 @@ var lexicon = must.Be(text.NewLexer(
 		return whitespace{text}
 	}),
 	text.Regex(`\c\w*`, func(start int, text string) token {
-		return ident{text}
+		return ident{name: text, pos: start}
 	}),
 	text.Regex(`"([^"]|\\.)*"`, func(start int, text string) token {
 		inner, _ := strconv.Unquote(text)
@@ -72,9 +89,21 @@ var lexicon = must.Be(text.NewLexer(
 		x, _ := strconv.Atoi(text)
 		return intLit{x}
 	}),
+	// a float needs digits after the point, so that 1.add(2) is a method call
+	text.Regex(`\d+(\.\d+([eE](\+|-)?\d+)?|[eE](\+|-)?\d+)`, func(start int, text string) token {
+		x, _ := strconv.ParseFloat(text, 64)
+		return floatLit{x}
+	}),
+	// must come before op so that a lone = is an assignment
+	text.Regex(`=`, func(start int, text string) token {
+		return assign{}
+	}),
 	text.Regex(`-|[+*/><=]+`, func(start int, text string) token {
 		return op{text}
 	}),
+	text.Regex(`:`, func(start int, text string) token {
+		return colon{}
+	}),
 	text.Regex(`,`, func(start int, text string) token {
 		return comma{}
 	}),
@@ -93,6 +122,12 @@ var lexicon = must.Be(text.NewLexer(
 	text.Regex(`\}`, func(start int, text string) token {
 		return blockClose{}
 	}),
+	text.Regex(`\[`, func(start int, text string) token {
+		return listOpen{start}
+	}),
+	text.Regex(`\]`, func(start int, text string) token {
+		return listClose{}
+	}),
 ))
 
 // This implements stream.Iter
@@ -110,9 +145,13 @@ func mapKeywords(t token) token {
 		case "trigger":
 			return triggerKeyword{}
 		case "handle":
-			return handleKeyword{}
+			return handleKeyword{tok.pos}
 		case "object":
 			return objectKeyword{}
+		case "let":
+			return letKeyword{}
+		case "in":
+			return inKeyword{}
 		}
 	}
 	return t
@@ -128,11 +167,11 @@ func isNewline(scope *[]bool, t token) bool {
 
 func isIgnored(scope *[]bool, t token) bool {
 	switch t.(type) {
-	case groupOpen:
+	case groupOpen, listOpen:
 		*scope = append(*scope, false)
 	case blockOpen:
 		*scope = append(*scope, true)
-	case groupClose, blockClose:
+	case groupClose, blockClose, listClose:
 		if len(*scope) > 0 {
 			*scope = (*scope)[:len(*scope)-1]
 		}
@@ -142,22 +181,27 @@ func isIgnored(scope *[]bool, t token) bool {
 	return false
 }
 
-func tokenize(src []byte) ([]token, error) {
-	toks, err := lexicon.Tokenize(src)
-	if err != nil {
-		return nil, err
-	}
+// tokenize also gives the offset in src of each token.
+func tokenize(src []byte) ([]token, []int, error) {
+	toks := lexicon.Stream(src)
 	var res []token
+	var offsets []int
 	var scope []bool
-	for _, t := range toks {
+	for toks.Next() {
+		t := toks.This()
 		if isNewline(&scope, t) {
 			res = append(res, newline{})
+			offsets = append(offsets, toks.Pos())
 			continue
 		}
 		if isIgnored(&scope, t) {
 			continue
 		}
 		res = append(res, mapKeywords(t))
+		offsets = append(offsets, toks.Pos())
+	}
+	if toks.Err() != nil {
+		return nil, nil, toks.Err()
 	}
-	return res, nil
+	return res, offsets, nil
 }
@@ -5,29 +5,42 @@ import (
 
 	"github.com/bobappleyard/cezanne/commands/compile/ast"
 	"github.com/bobappleyard/cezanne/commands/compile/text"
+	"github.com/bobappleyard/cezanne/commands/diag"
 	"github.com/bobappleyard/cezanne/format/symtab"
 	"github.com/bobappleyard/cezanne/util/slices"
 )
 
 func ParseFile(syms *symtab.Symtab, m *ast.Package, src []byte) error {
-	toks, err := tokenize(src)
+	return ParseNamedFile(syms, m, "", src)
+}
+
+// ParseNamedFile is like ParseFile, but the positions it records refer to a
+// file with the given name. Syntax errors are reported as diagnostics.
+func ParseNamedFile(syms *symtab.Symtab, m *ast.Package, name string, src []byte) error {
+	i := interpreter{syms: syms, file: name, lines: lineStarts(src), end: len(src)}
+
+	toks, offsets, err := tokenize(src)
 	if err != nil {
-		return err
+		return i.syntaxError(err, offsets)
 	}
 
-	st, err := text.Parse[token, file](parseRules{}, toks)
+	st, err := parseFile(toks, offsets, &i)
 	if err != nil {
 		return err
 	}
 
-	m.Imports = slices.Map(st.imports, func(x importSpec) ast.Import {
+	// each file has its own imports, but they all go into the same package
+	if m.Imports == nil {
+		m.Imports = []ast.Import{}
+	}
+	m.Imports = append(m.Imports, slices.Map(st.imports, func(x importSpec) ast.Import {
 		return ast.Import{
+			Pos:  i.pos(x.pos),
 			Name: syms.SymbolID(x.Name),
 			Path: x.Path,
 		}
-	})
+	})...)
 
-	i := interpreter{syms: syms}
 	for _, d := range st.decls {
 		switch d := d.(type) {
 		case funcDecl:
@@ -40,6 +53,50 @@ func ParseFile(syms *symtab.Symtab, m *ast.Package, src []byte) error {
 	return nil
 }
 
+// parseFile parses the tokens of a file. After a syntax error it carries on
+// from the next function declared at the start of a line, so that each file
+// reports as many problems as it can.
+func parseFile(toks []token, offsets []int, i *interpreter) (file, error) {
+	var errs diag.List
+	var res file
+	for {
+		st, err := text.Parse[token, file](parseRules{}, toks)
+		if err == nil {
+			res.imports = append(res.imports, st.imports...)
+			res.decls = append(res.decls, st.decls...)
+			break
+		}
+		errs = append(errs, i.syntaxError(err, offsets)...)
+		var tok *text.UnexpectedToken
+		if !errors.As(err, &tok) {
+			break
+		}
+		next := nextFunc(toks, tok.Index+1)
+		if next == -1 {
+			break
+		}
+		toks, offsets = toks[next:], offsets[next:]
+	}
+	if errs.HasErrors() {
+		return file{}, errs
+	}
+	return res, nil
+}
+
+// nextFunc finds the first function declared at the start of a line, from the
+// token at index from onwards, or -1 if there isn't one.
+func nextFunc(toks []token, from int) int {
+	for j := from; j < len(toks); j++ {
+		if _, ok := toks[j].(funcKeyword); !ok {
+			continue
+		}
+		if _, ok := toks[j-1].(newline); ok {
+			return j
+		}
+	}
+	return -1
+}
+
 type parseRules struct{}
 
 type file struct {
@@ -49,6 +106,7 @@ type file struct {
 
 type importSpec struct {
 	Name, Path string
+	pos        int
 }
 
 type decl interface {
@@ -56,9 +114,12 @@ type decl interface {
 }
 
 type funcDecl struct {
-	name string
-	args []string
-	body []expr
+	pos      int
+	name     string
+	generics []string
+	args     []param
+	result   resultSpec
+	body     []stmt
 }
 
 type varDecl struct {
@@ -77,12 +138,17 @@ type intVal struct {
 	Value int
 }
 
+type floatVal struct {
+	Value float64
+}
+
 type strVal struct {
 	Value string
 }
 
 type varRef struct {
 	Name string
+	pos  int
 }
 
 type createObject struct {
@@ -90,32 +156,95 @@ type createObject struct {
 }
 
 type method struct {
-	name string
-	args []string
-	body []expr
+	pos      int
+	name     string
+	generics []string
+	args     []param
+	result   resultSpec
+	body     []stmt
 }
 
 type invokeMethod struct {
+	pos    int
 	Object expr
 	Name   string
 	Args   []expr
 }
 
+type arrayLit struct {
+	Items []expr
+	pos   int
+}
+
 type handleEffects struct {
 	In   expr
 	With []method
+	pos  int
 }
 
 type triggerEffect struct {
 	Name string
 	Args []expr
+	pos  int
+}
+
+type stmt interface {
+	stmt()
+}
+
+type exprStmt struct {
+	expr expr
+}
+
+type letStmt struct {
+	pos   int
+	name  string
+	t     typeExpr
+	value expr
+}
+
+func (exprStmt) stmt() {}
+func (letStmt) stmt()  {}
+
+type typeExpr interface {
+	typeExpr()
+}
+
+type namedTypeExpr struct {
+	name string
+	args []typeExpr
+}
+
+type funcTypeExpr struct {
+	args   []typeExpr
+	result resultSpec
+}
+
+func (namedTypeExpr) typeExpr() {}
+func (funcTypeExpr) typeExpr()  {}
+
+type param struct {
+	name string
+	t    typeExpr
+	pos  int
+}
+
+type typeAnnotation struct {
+	t typeExpr
+}
+
+type resultSpec struct {
+	t      typeExpr
+	effect typeExpr
 }
 
 func (intVal) expr()        {}
+func (floatVal) expr()      {}
 func (strVal) expr()        {}
 func (varRef) expr()        {}
 func (createObject) expr()  {}
 func (invokeMethod) expr()  {}
+func (arrayLit) expr()      {}
 func (handleEffects) expr() {}
 func (triggerEffect) expr() {}
 
@@ -135,18 +264,21 @@ func (parseRules) ParseImport(f file, m importKeyword, path ident) (file, error)
 	if len(f.decls) != 0 {
 		return file{}, errors.New("imports must come at the top of the file")
 	}
-	return file{imports: append(f.imports, importSpec{Name: path.name, Path: path.name}), decls: f.decls}, nil
+	return file{imports: append(f.imports, importSpec{Name: path.name, Path: path.name, pos: path.pos}), decls: f.decls}, nil
 }
 
 func (parseRules) ParseFunc(
-	m funcKeyword, name ident,
-	gro groupOpen, args argList, grc groupClose,
+	m funcKeyword, name ident, gs genericParams,
+	gro groupOpen, args argList, grc groupClose, res resultSpec,
 	bo blockOpen, body exprList, bc blockClose,
 ) funcDecl {
 	return funcDecl{
-		name: name.name,
-		args: args.args,
-		body: body.exprs,
+		pos:      name.pos,
+		name:     name.name,
+		generics: gs.names,
+		args:     args.args,
+		result:   res,
+		body:     body.exprs,
 	}
 }
 
@@ -166,14 +298,17 @@ func (parseRules) ParseObject(kw objectKeyword,
 }
 
 func (parseRules) ParseMethod(
-	name ident,
-	gro groupOpen, args argList, grc groupClose,
+	name ident, gs genericParams,
+	gro groupOpen, args argList, grc groupClose, res resultSpec,
 	bo blockOpen, body exprList, bc blockClose,
 ) method {
 	return method{
-		name: name.name,
-		args: args.args,
-		body: body.exprs,
+		pos:      name.pos,
+		name:     name.name,
+		generics: gs.names,
+		args:     args.args,
+		result:   res,
+		body:     body.exprs,
 	}
 }
 
@@ -181,12 +316,20 @@ func (parseRules) ParseInt(x intLit) intVal {
 	return intVal{x.val}
 }
 
+func (parseRules) ParseFloat(x floatLit) floatVal {
+	return floatVal{x.val}
+}
+
 func (parseRules) ParseStr(x strLit) strVal {
 	return strVal{x.text}
 }
 
+func (parseRules) ParseArray(lo listOpen, items paramList, lc listClose) arrayLit {
+	return arrayLit{Items: items.args, pos: lo.pos}
+}
+
 func (parseRules) ParseVarRef(x ident) varRef {
-	return varRef{x.name}
+	return varRef{Name: x.name, pos: x.pos}
 }
 
 func (parseRules) ParseMethodCall(
@@ -194,6 +337,7 @@ func (parseRules) ParseMethodCall(
 	gro groupOpen, params paramList, grc groupClose,
 ) invokeMethod {
 	return invokeMethod{
+		pos:    name.pos,
 		Object: obj,
 		Name:   name.name,
 		Args:   params.args,
@@ -205,12 +349,25 @@ func (parseRules) ParseFunctionCall(
 	gro groupOpen, params paramList, grc groupClose,
 ) invokeMethod {
 	return invokeMethod{
+		pos:    exprPos(obj),
 		Object: obj,
 		Name:   "call",
 		Args:   params.args,
 	}
 }
 
+// exprPos gives the offset of an expression in the source, or -1 if it is not
+// known.
+func exprPos(e expr) int {
+	switch e := e.(type) {
+	case varRef:
+		return e.pos
+	case invokeMethod:
+		return e.pos
+	}
+	return -1
+}
+
 func (parseRules) ParseTrigger(
 	m triggerKeyword, effname ident,
 	gro groupOpen, params paramList, grc groupClose,
@@ -218,6 +375,7 @@ func (parseRules) ParseTrigger(
 	return triggerEffect{
 		Name: effname.name,
 		Args: params.args,
+		pos:  effname.pos,
 	}
 }
 
@@ -228,11 +386,12 @@ func (parseRules) ParseHandle(
 	return handleEffects{
 		In:   e,
 		With: handlers.methods,
+		pos:  m.pos,
 	}
 }
 
 type argList struct {
-	args []string
+	args []param
 }
 
 type paramList struct {
@@ -240,7 +399,7 @@ type paramList struct {
 }
 
 type exprList struct {
-	exprs []expr
+	exprs []stmt
 }
 
 type methodList struct {
@@ -251,26 +410,138 @@ func (parseRules) ParseNoArgs() argList {
 	return argList{}
 }
 
-func (parseRules) ParseOneArg(arg ident) argList {
-	return argList{args: []string{arg.name}}
+func (parseRules) ParseOneArg(arg ident, t typeAnnotation) argList {
+	return argList{args: []param{{name: arg.name, t: t.t, pos: arg.pos}}}
 }
 
-func (parseRules) ParseManyArgs(prev argList, sep comma, arg ident) argList {
-	return argList{args: append(prev.args, arg.name)}
+func (parseRules) ParseManyArgs(prev argList, sep comma, arg ident, t typeAnnotation) argList {
+	return argList{args: append(prev.args, param{name: arg.name, t: t.t, pos: arg.pos})}
+}
+
+type genericParams struct {
+	names []string
+}
+
+func (parseRules) ParseNoGenerics() genericParams {
+	return genericParams{}
+}
+
+func (parseRules) ParseGenerics(lo listOpen, names identList, lc listClose) genericParams {
+	return genericParams{names: names.names}
+}
+
+type identList struct {
+	names []string
+}
+
+func (parseRules) ParseOneIdent(name ident) identList {
+	return identList{names: []string{name.name}}
+}
+
+func (parseRules) ParseManyIdents(prev identList, sep comma, name ident) identList {
+	return identList{names: append(prev.names, name.name)}
+}
+
+func (parseRules) ParseNoAnnotation() typeAnnotation {
+	return typeAnnotation{}
+}
+
+func (parseRules) ParseAnnotation(c colon, t typeExpr) typeAnnotation {
+	return typeAnnotation{t: t}
+}
+
+func (parseRules) ParseNoResult() resultSpec {
+	return resultSpec{}
+}
+
+func (parseRules) ParseResult(c colon, t typeExpr) resultSpec {
+	return resultSpec{t: t}
+}
+
+func (parseRules) ParseResultEffect(c colon, t typeExpr, kw inKeyword, eff typeExpr) resultSpec {
+	return resultSpec{t: t, effect: eff}
+}
+
+func (parseRules) ParseEffectOnly(kw inKeyword, eff typeExpr) resultSpec {
+	return resultSpec{effect: eff}
+}
+
+func (parseRules) ParseNamedType(name ident) namedTypeExpr {
+	return namedTypeExpr{name: name.name}
+}
+
+func (parseRules) ParseGenericType(name ident, lo listOpen, args typeList, lc listClose) namedTypeExpr {
+	return namedTypeExpr{name: name.name, args: args.types}
+}
+
+func (parseRules) ParseFuncType(
+	kw funcKeyword,
+	gro groupOpen, args typeParamList, grc groupClose, res resultSpec,
+) funcTypeExpr {
+	return funcTypeExpr{args: args.types, result: res}
+}
+
+type typeList struct {
+	types []typeExpr
+}
+
+func (parseRules) ParseOneType(t typeExpr) typeList {
+	return typeList{types: []typeExpr{t}}
+}
+
+func (parseRules) ParseManyTypes(prev typeList, sep comma, t typeExpr) typeList {
+	return typeList{types: append(prev.types, t)}
+}
+
+// Parameters of function types may be named, but only the types are kept.
+type typeParamList struct {
+	types []typeExpr
+}
+
+func (parseRules) ParseNoTypeParams() typeParamList {
+	return typeParamList{}
+}
+
+func (parseRules) ParseOneTypeParam(t typeParam) typeParamList {
+	return typeParamList{types: []typeExpr{t.t}}
+}
+
+func (parseRules) ParseManyTypeParams(prev typeParamList, sep comma, t typeParam) typeParamList {
+	return typeParamList{types: append(prev.types, t.t)}
+}
+
+type typeParam struct {
+	t typeExpr
+}
+
+func (parseRules) ParseTypeParam(t typeExpr) typeParam {
+	return typeParam{t: t}
+}
+
+func (parseRules) ParseNamedTypeParam(name ident, c colon, t typeExpr) typeParam {
+	return typeParam{t: t}
 }
 
 func (parseRules) ParseEmptyBody() exprList {
 	return exprList{}
 }
 
-func (parseRules) ParseBodySingleExpr(e expr) exprList {
-	return exprList{exprs: []expr{e}}
+func (parseRules) ParseBodySingleExpr(e stmt) exprList {
+	return exprList{exprs: []stmt{e}}
 }
 
-func (parseRules) ParseBodyLeadingNewline(exprs exprList, nl newline, e expr) exprList {
+func (parseRules) ParseBodyLeadingNewline(exprs exprList, nl newline, e stmt) exprList {
 	return exprList{exprs: append(exprs.exprs, e)}
 }
 
+func (parseRules) ParseExprStmt(e expr) exprStmt {
+	return exprStmt{expr: e}
+}
+
+func (parseRules) ParseLet(kw letKeyword, name ident, t typeAnnotation, a assign, value expr) letStmt {
+	return letStmt{pos: name.pos, name: name.name, t: t.t, value: value}
+}
+
 func (parseRules) ParseBodyTrailingNewline(exprs exprList, nl newline) exprList {
 	return exprs
 }
@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/diag"
 	"github.com/bobappleyard/cezanne/format/symtab"
 	"github.com/bobappleyard/cezanne/util/assert"
 )
@@ -30,6 +33,24 @@ func TestParseFile(t *testing.T) {
 				Vars: []ast.Var{},
 			},
 		},
+		{
+			name: "FloatLit",
+			in:   `func main() {2.5e3.add(1.5)}`,
+			out: ast.Package{
+				Name:    symtab.Symbol{},
+				Imports: []ast.Import{},
+				Funcs: []ast.Method{{
+					Name: syms.SymbolID("main"),
+					Args: []symtab.Symbol{},
+					Body: ast.Invoke{
+						Object: ast.Float{Value: 2500},
+						Name:   syms.SymbolID("add"),
+						Args:   []ast.Expr{ast.Float{Value: 1.5}},
+					},
+				}},
+				Vars: []ast.Var{},
+			},
+		},
 		{
 			name: "StrLit",
 			in:   `func main() {"hello"}`,
@@ -141,6 +162,28 @@ func TestParseFile(t *testing.T) {
 				Vars: []ast.Var{},
 			},
 		},
+		{
+			name: "ArrayLit",
+			in:   `func main() {[1, [], "a"].get(0)}`,
+			out: ast.Package{
+				Name:    symtab.Symbol{},
+				Imports: []ast.Import{},
+				Funcs: []ast.Method{{
+					Name: syms.SymbolID("main"),
+					Args: []symtab.Symbol{},
+					Body: ast.Invoke{
+						Object: ast.Array{Items: []ast.Expr{
+							ast.Int{Value: 1},
+							ast.Array{Items: []ast.Expr{}},
+							ast.String{Value: "a"},
+						}},
+						Name: syms.SymbolID("get"),
+						Args: []ast.Expr{ast.Int{Value: 0}},
+					},
+				}},
+				Vars: []ast.Var{},
+			},
+		},
 		{
 			name: "MultilineParams",
 			in: `
@@ -171,7 +214,7 @@ func TestParseFile(t *testing.T) {
 			var m ast.Package
 			err := ParseFile(&syms, &m, []byte(test.in))
 			assert.Nil(t, err)
-			assert.Equal(t, m, test.out)
+			assert.Equal(t, withoutPositions(m), test.out)
 		})
 	}
 
@@ -210,6 +253,181 @@ func TestFullParse(t *testing.T) {
 			},
 		}},
 		Vars: []ast.Var{},
-	}, m)
+	}, withoutPositions(m))
+
+}
+
+func TestParseAnnotations(t *testing.T) {
+	var syms symtab.Symtab
+
+	var m ast.Package
+	err := ParseFile(&syms, &m, []byte(`
+	func apply(f: func(x: Int): String in IO, x: Int): String in IO {
+		let y: String = f(x)
+		y
+	}
+	`))
+	assert.Nil(t, err)
+
+	Int := ast.NamedType{Name: syms.SymbolID("Int"), Args: []ast.TypeExpr{}}
+	String := ast.NamedType{Name: syms.SymbolID("String"), Args: []ast.TypeExpr{}}
+	IO := ast.NamedType{Name: syms.SymbolID("IO"), Args: []ast.TypeExpr{}}
+
+	assert.Equal(t, withoutPositions(m).Funcs, []ast.Method{{
+		Name: syms.SymbolID("apply"),
+		Args: []symtab.Symbol{syms.SymbolID("f"), syms.SymbolID("x")},
+		Body: ast.Let{
+			Name: syms.SymbolID("y"),
+			Type: String,
+			Value: ast.Invoke{
+				Object: ast.Ref{Name: syms.SymbolID("f")},
+				Name:   syms.SymbolID("call"),
+				Args:   []ast.Expr{ast.Ref{Name: syms.SymbolID("x")}},
+			},
+			In: ast.Ref{Name: syms.SymbolID("y")},
+		},
+		ArgTypes: []ast.TypeExpr{
+			ast.FuncType{
+				Args:   []ast.TypeExpr{Int},
+				Result: String,
+				Effect: IO,
+			},
+			Int,
+		},
+		Result: String,
+		Effect: IO,
+	}})
+}
+
+func TestParseGenericAnnotation(t *testing.T) {
+	var syms symtab.Symtab
+
+	var m ast.Package
+	err := ParseFile(&syms, &m, []byte(`
+	func first(xs: List[Int]) {
+		xs.head()
+	}
+	`))
+	assert.Nil(t, err)
+
+	assert.Equal(t, m.Funcs[0].ArgTypes, []ast.TypeExpr{ast.NamedType{
+		Name: syms.SymbolID("List"),
+		Args: []ast.TypeExpr{ast.NamedType{Name: syms.SymbolID("Int"), Args: []ast.TypeExpr{}}},
+	}})
+}
+
+func TestParsePositions(t *testing.T) {
+	var syms symtab.Symtab
+
+	var m ast.Package
+	err := ParseNamedFile(&syms, &m, "main.cz", []byte(`import io
+
+func main() {
+	let x = 1
+	io.print(x)
+}
+`))
+	assert.Nil(t, err)
+
+	assert.Equal(t, m.Imports[0].Pos, ast.Pos{File: "main.cz", Line: 1, Col: 8})
+	assert.Equal(t, m.Funcs[0].Pos, ast.Pos{File: "main.cz", Line: 3, Col: 6})
+
+	let := m.Funcs[0].Body.(ast.Let)
+	assert.Equal(t, let.Pos, ast.Pos{File: "main.cz", Line: 4, Col: 6})
+
+	call := let.In.(ast.Invoke)
+	assert.Equal(t, call.Object.(ast.Ref).Pos, ast.Pos{File: "main.cz", Line: 5, Col: 2})
+	assert.Equal(t, call.Args[0].(ast.Ref).Pos, ast.Pos{File: "main.cz", Line: 5, Col: 11})
+	assert.Equal(t, call.Args[0].(ast.Ref).Pos.String(), "main.cz:5:11")
+}
+
+// The tests above are about the shape of the tree, so they ignore positions.
+func withoutPositions(m ast.Package) ast.Package {
+	v := reflect.New(reflect.TypeOf(m)).Elem()
+	v.Set(reflect.ValueOf(m))
+	clearPositions(v)
+	return v.Interface().(ast.Package)
+}
+
+func clearPositions(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(ast.Pos{}) {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				clearPositions(v.Field(i))
+			}
+		}
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		if v.Type() == reflect.TypeOf([]ast.Pos{}) {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(s, v)
+		for i := 0; i < s.Len(); i++ {
+			clearPositions(s.Index(i))
+		}
+		v.Set(s)
 
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		inner := reflect.New(v.Elem().Type()).Elem()
+		inner.Set(v.Elem())
+		clearPositions(inner)
+		v.Set(inner)
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "UnexpectedToken",
+			in:   "func main() {\n\tx.(1)\n}\n",
+			out:  "main.cz:2:4: error: unexpected token",
+		},
+		{
+			name: "EveryFunction",
+			in:   "func f() {\n\tx.(1)\n}\n\nfunc g() { 1 }\n\nfunc h() {\n\t(y\n}\n",
+			out:  "main.cz:2:4: error: unexpected token\nmain.cz:8:2: error: unexpected token",
+		},
+		{
+			name: "UnexpectedCharacter",
+			in:   "func main() {\n\tx ? y\n}\n",
+			out:  "main.cz:2:4: error: unexpected character",
+		},
+		{
+			name: "UnexpectedEOF",
+			in:   "func main() {\n\tx\n",
+			out:  "main.cz:3:1: error: unexpected end of file",
+		},
+		{
+			name: "LateImport",
+			in:   "func main() { 1 }\nimport io\n",
+			out:  "main.cz: error: imports must come at the top of the file",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var syms symtab.Symtab
+			var m ast.Package
+			err := ParseNamedFile(&syms, &m, "main.cz", []byte(test.in))
+
+			var l diag.List
+			assert.True(t, errors.As(err, &l))
+			assert.Equal(t, err.Error(), test.out)
+		})
+	}
 }
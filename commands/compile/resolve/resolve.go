@@ -0,0 +1,201 @@
+package resolve
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
+
+var (
+	ErrUndefined      = errors.New("undefined")
+	ErrShadowedImport = errors.New("shadows an import")
+	ErrDuplicateName  = errors.New("declared more than once")
+)
+
+// Error is a problem with a name at some position in the source.
+type Error struct {
+	Pos  ast.Pos
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Pos, e.Name, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors are all of the problems found in a package, in source order.
+type Errors []*Error
+
+func (es Errors) Diagnostics() []diag.Diagnostic {
+	res := make([]diag.Diagnostic, len(es))
+	for i, e := range es {
+		res[i] = diag.Diagnostic{
+			Severity: diag.Error,
+			Span:     diag.At(e.Pos),
+			Message:  fmt.Sprintf("%s: %s", e.Name, e.Err),
+			Err:      e,
+		}
+	}
+	return res
+}
+
+func (es Errors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Package checks that every name used in the package refers to something
+// that is in scope, that no name hides an import, and that functions and the
+// methods of each object have distinct names. If there are any problems the
+// result is an Errors.
+func Package(syms *symtab.Symtab, pkg ast.Package) error {
+	r := &resolver{
+		syms:    syms,
+		imports: map[symtab.Symbol]bool{},
+		globals: map[symtab.Symbol]bool{},
+	}
+	for _, imp := range pkg.Imports {
+		if r.imports[imp.Name] {
+			r.error(imp.Pos, imp.Name, ErrDuplicateName)
+		}
+		r.imports[imp.Name] = true
+		r.globals[imp.Name] = true
+	}
+	r.methods(nil, pkg.Funcs, true)
+	if len(r.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(r.errs, func(i, j int) bool {
+		return r.errs[i].Pos.Before(r.errs[j].Pos)
+	})
+	return r.errs
+}
+
+type resolver struct {
+	syms    *symtab.Symtab
+	imports map[symtab.Symbol]bool
+	globals map[symtab.Symbol]bool
+	errs    Errors
+}
+
+// Local variables, innermost first.
+type scope struct {
+	name  symtab.Symbol
+	outer *scope
+}
+
+func (s *scope) bind(name symtab.Symbol) *scope {
+	return &scope{name: name, outer: s}
+}
+
+func (s *scope) has(name symtab.Symbol) bool {
+	for ; s != nil; s = s.outer {
+		if s.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *resolver) error(pos ast.Pos, name symtab.Symbol, err error) {
+	r.errs = append(r.errs, &Error{
+		Pos:  pos,
+		Name: r.syms.SymbolName(name),
+		Err:  err,
+	})
+}
+
+func (r *resolver) declare(pos ast.Pos, name symtab.Symbol) {
+	if r.imports[name] {
+		r.error(pos, name, ErrShadowedImport)
+	}
+}
+
+// Top-level functions are in scope throughout the package, so they are all
+// declared before any of their bodies are resolved.
+func (r *resolver) methods(s *scope, ms []ast.Method, global bool) {
+	seen := map[symtab.Symbol]bool{}
+	for _, m := range ms {
+		if seen[m.Name] {
+			r.error(m.Pos, m.Name, ErrDuplicateName)
+		}
+		seen[m.Name] = true
+		if global {
+			r.declare(m.Pos, m.Name)
+			r.globals[m.Name] = true
+		}
+	}
+	for _, m := range ms {
+		r.method(s, m)
+	}
+}
+
+// Arguments are placed where they are declared, or at their method if that is
+// not known.
+func (r *resolver) method(s *scope, m ast.Method) {
+	for i, a := range m.Args {
+		pos := m.Pos
+		if i < len(m.ArgPos) && m.ArgPos[i].Line != 0 {
+			pos = m.ArgPos[i]
+		}
+		r.declare(pos, a)
+		s = s.bind(a)
+	}
+	s = s.bind(r.syms.SymbolID("this"))
+	r.expr(s, m.Body)
+}
+
+func (r *resolver) expr(s *scope, x ast.Expr) {
+	switch x := x.(type) {
+	// nil is an empty body
+	case nil, ast.Int, ast.Float, ast.String:
+
+	case ast.Ref:
+		if !s.has(x.Name) && !r.globals[x.Name] {
+			r.error(x.Pos, x.Name, ErrUndefined)
+		}
+
+	case ast.Let:
+		r.expr(s, x.Value)
+		r.declare(x.Pos, x.Name)
+		r.expr(s.bind(x.Name), x.In)
+
+	case ast.Create:
+		r.methods(s, x.Methods, false)
+
+	case ast.Invoke:
+		r.expr(s, x.Object)
+		for _, a := range x.Args {
+			r.expr(s, a)
+		}
+
+	case ast.Handle:
+		r.expr(s, x.In)
+		r.methods(s, x.With, false)
+
+	case ast.Trigger:
+		for _, a := range x.Args {
+			r.expr(s, a)
+		}
+
+	case ast.Array:
+		for _, a := range x.Items {
+			r.expr(s, a)
+		}
+
+	default:
+		panic(fmt.Sprintf("unsupported syntax: %T", x))
+	}
+}
@@ -0,0 +1,147 @@
+package resolve
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestPackage(t *testing.T) {
+	type problem struct {
+		line, col int
+		name      string
+		err       error
+	}
+
+	for _, test := range []struct {
+		name string
+		in   string
+		errs []problem
+	}{
+		{
+			name: "Valid",
+			in: `import io
+
+func main() {
+	let x = count(1)
+	io.print(x.next())
+}
+
+func count(n) {
+	object {
+		next() { count(n) }
+		value() { this.next() }
+	}
+}
+`,
+		},
+		{
+			name: "Undefined",
+			in: `func main() {
+	pritn(x)
+}
+`,
+			errs: []problem{
+				{2, 2, "pritn", ErrUndefined},
+				{2, 8, "x", ErrUndefined},
+			},
+		},
+		{
+			name: "LetScope",
+			in: `func main() {
+	object {
+		get() { let y = 1 }
+		put() { y }
+	}
+}
+`,
+			errs: []problem{
+				{4, 11, "y", ErrUndefined},
+			},
+		},
+		{
+			name: "ShadowedImport",
+			in: `import io
+
+func main(io) {
+	let io = 1
+	io
+}
+
+func io() { 1 }
+`,
+			errs: []problem{
+				{3, 11, "io", ErrShadowedImport},
+				{4, 6, "io", ErrShadowedImport},
+				{8, 6, "io", ErrShadowedImport},
+			},
+		},
+		{
+			name: "ShadowingParameter",
+			in: `import io
+
+func main() {
+	object {
+		apply(x, io) { x }
+	}
+}
+
+func f() {
+}
+`,
+			errs: []problem{
+				{5, 12, "io", ErrShadowedImport},
+			},
+		},
+		{
+			name: "DuplicateNames",
+			in: `func main() {
+	object {
+		get() { 1 }
+		get() { 2 }
+	}
+}
+
+func main() { 3 }
+`,
+			errs: []problem{
+				{4, 3, "get", ErrDuplicateName},
+				{8, 6, "main", ErrDuplicateName},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var syms symtab.Symtab
+			var pkg ast.Package
+			err := parser.ParseNamedFile(&syms, &pkg, "test.cz", []byte(test.in))
+			assert.Nil(t, err)
+
+			err = Package(&syms, pkg)
+			if test.errs == nil {
+				assert.Nil(t, err)
+				return
+			}
+
+			var errs Errors
+			assert.True(t, errors.As(err, &errs))
+			var got []problem
+			for _, e := range errs {
+				assert.Equal(t, e.Pos.File, "test.cz")
+				got = append(got, problem{e.Pos.Line, e.Pos.Col, e.Name, e.Err})
+			}
+			assert.Equal(t, got, test.errs)
+		})
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := Errors{
+		{Pos: ast.Pos{File: "a.cz", Line: 1, Col: 2}, Name: "x", Err: ErrUndefined},
+		{Pos: ast.Pos{File: "a.cz", Line: 3, Col: 4}, Name: "y", Err: ErrDuplicateName},
+	}
+	assert.Equal(t, err.Error(), "a.cz:1:2: x: undefined\na.cz:3:4: y: declared more than once")
+}
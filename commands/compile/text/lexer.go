@@ -1,6 +1,7 @@
 package text
 
 import (
+	"fmt"
 	"unicode/utf8"
 )
 
@@ -47,11 +48,21 @@ type Stream[T any] struct {
 	prog       *Lexer[T]
 	src        []byte
 	srcPos     int
+	tokPos     int
 	this, next []bool
 	tok        T
 	err        error
 }
 
+// UnexpectedInput is returned when the text at Pos does not start any token.
+type UnexpectedInput struct {
+	Pos int
+}
+
+func (e *UnexpectedInput) Error() string {
+	return fmt.Sprintf("unexpected input at offset %d", e.Pos)
+}
+
 func (p *Lexer[T]) State() LexerState {
 	p.maxState++
 	return p.maxState
@@ -108,12 +119,7 @@ func (p *Lexer[T]) Final(given LexerState, then func(start int, text string) T)
 }
 
 func (p *Lexer[T]) Tokenize(src []byte) ([]T, error) {
-	proc := &Stream[T]{
-		prog: p,
-		src:  src,
-		this: make([]bool, p.maxState+1),
-		next: make([]bool, p.maxState+1),
-	}
+	proc := p.Stream(src)
 	var res []T
 	for proc.Next() {
 		res = append(res, proc.This())
@@ -124,6 +130,16 @@ func (p *Lexer[T]) Tokenize(src []byte) ([]T, error) {
 	return res, nil
 }
 
+// Stream reads tokens from src one at a time.
+func (p *Lexer[T]) Stream(src []byte) *Stream[T] {
+	return &Stream[T]{
+		prog: p,
+		src:  src,
+		this: make([]bool, p.maxState+1),
+		next: make([]bool, p.maxState+1),
+	}
+}
+
 func (l *Stream[T]) Err() error {
 	return l.err
 }
@@ -139,6 +155,16 @@ func (l *Stream[T]) This() T {
 	return l.tok
 }
 
+// Pos gives the offset in the source of the start of the current token.
+func (l *Stream[T]) Pos() int {
+	return l.tokPos
+}
+
+// End gives the offset in the source just after the current token.
+func (l *Stream[T]) End() int {
+	return l.srcPos
+}
+
 func (l *Stream[T]) exec() bool {
 	pos := l.srcPos
 	start := pos
@@ -163,10 +189,14 @@ func (l *Stream[T]) exec() bool {
 	}
 
 	if final == -1 {
+		if start < len(l.src) {
+			l.err = &UnexpectedInput{Pos: start}
+		}
 		return false
 	}
 
 	l.tok = l.prog.finalStates[final].Then(start, string(l.src[start:end]))
+	l.tokPos = start
 	l.srcPos = end
 
 	return true
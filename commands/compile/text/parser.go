@@ -13,6 +13,7 @@ import (
 
 type UnexpectedToken struct {
 	Token any
+	Index int
 }
 
 func (e *UnexpectedToken) Error() string {
@@ -280,7 +281,8 @@ func (p *parser) matches(root *symbol) error {
 				continue
 			}
 			return &UnexpectedToken{
-				p.seen[i],
+				Token: p.seen[i],
+				Index: i,
 			}
 		}
 	}
@@ -0,0 +1,241 @@
+package types
+
+import "sync"
+
+// Constructors for the types of values that the language provides directly.
+var (
+	IntType    = &Constructor{Name: "Int"}
+	FloatType  = &Constructor{Name: "Float"}
+	StringType = &Constructor{Name: "String"}
+	BoolType   = &Constructor{Name: "Bool"}
+	ArrayType  = &Constructor{Name: "Array", Args: []Type{NewVar()}}
+	MapType    = &Constructor{Name: "Map", Args: []Type{NewVar(), NewVar()}}
+	SetType    = &Constructor{Name: "Set", Args: []Type{NewVar()}}
+)
+
+// Runtime is the type of the runtime package, which makes empty maps and sets.
+var Runtime Type
+
+func init() {
+	// match[U, E](v: {false(): U in E, true(): U in E}): U in E
+	u, eff := NewVar(), NewVar()
+	visitor := &Anonymous{
+		Methods: Shape{
+			{Name: "false", In: Tuple(), Out: u, Eff: eff},
+			{Name: "true", In: Tuple(), Out: u, Eff: eff},
+		},
+		Scope: []Type{u, eff},
+	}
+	BoolType.Methods = Shape{
+		{Name: "match", In: Tuple(visitor), Out: u, Eff: eff},
+	}
+	BoolType.Methods = append(BoolType.Methods, protocol(&Named{Cons: BoolType})...)
+	sortShape(BoolType.Methods)
+
+	// the methods of the runtime package, which have no effects of their own
+	for _, op := range []string{"add", "sub", "mul"} {
+		IntType.Methods = append(IntType.Methods, builtin(op, IntType, IntType))
+	}
+	// apart from dividing by zero, which triggers divisionByZero(n: Int)
+	for _, op := range []string{"div", "mod"} {
+		m := builtin(op, IntType, IntType)
+		m.Eff = raises("divisionByZero", &Named{Cons: IntType})
+		IntType.Methods = append(IntType.Methods, m)
+	}
+	for _, op := range []string{"eq", "lt", "lte", "gt", "gte"} {
+		IntType.Methods = append(IntType.Methods, builtin(op, BoolType, IntType))
+	}
+	IntType.Methods = append(IntType.Methods,
+		builtin("neg", IntType),
+		builtin("toFloat", FloatType),
+		builtin("toString", StringType),
+	)
+	IntType.Methods = append(IntType.Methods, protocol(&Named{Cons: IntType})...)
+	sortShape(IntType.Methods)
+
+	for _, op := range []string{"add", "sub", "mul", "div"} {
+		FloatType.Methods = append(FloatType.Methods, builtin(op, FloatType, FloatType))
+	}
+	for _, op := range []string{"eq", "lt", "lte", "gt", "gte"} {
+		FloatType.Methods = append(FloatType.Methods, builtin(op, BoolType, FloatType))
+	}
+	// NaN and the infinities trigger outOfRange(x: Float) when converted
+	truncate := builtin("toInt", IntType)
+	truncate.Eff = raises("outOfRange", &Named{Cons: FloatType})
+	FloatType.Methods = append(FloatType.Methods,
+		builtin("neg", FloatType),
+		truncate,
+		builtin("toString", StringType),
+	)
+	FloatType.Methods = append(FloatType.Methods, protocol(&Named{Cons: FloatType})...)
+	sortShape(FloatType.Methods)
+
+	// positions outside of a string trigger outOfBounds(i: Int), and
+	// converting text that does not hold a number triggers
+	// invalidNumber(s: String)
+	slice := builtin("slice", StringType, IntType, IntType)
+	slice.Eff = raises("outOfBounds", &Named{Cons: IntType})
+	toInt, toFloat := builtin("toInt", IntType), builtin("toFloat", FloatType)
+	toInt.Eff = raises("invalidNumber", &Named{Cons: StringType})
+	toFloat.Eff = raises("invalidNumber", &Named{Cons: StringType})
+	StringType.Methods = Shape{
+		builtin("length", IntType),
+		builtin("concat", StringType, StringType),
+		slice,
+		builtin("index", IntType, StringType),
+		builtin("compare", IntType, StringType),
+		builtin("eq", BoolType, StringType),
+		toInt,
+		toFloat,
+		{
+			Name: "split",
+			In:   Tuple(&Named{Cons: StringType}),
+			Out:  &Named{Cons: ArrayType, Args: []Type{&Named{Cons: StringType}}},
+			Eff:  NewVar(),
+		},
+	}
+	StringType.Methods = append(StringType.Methods, protocol(&Named{Cons: StringType})...)
+	sortShape(StringType.Methods)
+
+	// indexes outside of an array trigger outOfBounds(i: Int)
+	elem := ArrayType.Args[0]
+	array := &Named{Cons: ArrayType, Args: []Type{elem}}
+	index := &Named{Cons: IntType}
+	bounds := func() Type {
+		return raises("outOfBounds", index)
+	}
+
+	// map[U, E](f: func(T): U in E): Array[U] in E
+	mapped, mapEff := NewVar(), NewVar()
+	mapper := &Anonymous{
+		Methods: Shape{{Name: "call", In: Tuple(elem), Out: mapped, Eff: mapEff}},
+		Scope:   []Type{elem, mapped, mapEff},
+	}
+
+	// fold[U, E](init: U, f: func(U, T): U in E): U in E
+	acc, foldEff := NewVar(), NewVar()
+	folder := &Anonymous{
+		Methods: Shape{{Name: "call", In: Tuple(acc, elem), Out: acc, Eff: foldEff}},
+		Scope:   []Type{elem, acc, foldEff},
+	}
+
+	ArrayType.Methods = Shape{
+		{Name: "get", In: Tuple(index), Out: elem, Eff: bounds()},
+		{Name: "set", In: Tuple(index, elem), Out: array, Eff: bounds()},
+		{Name: "slice", In: Tuple(index, index), Out: array, Eff: bounds()},
+		{Name: "push", In: Tuple(elem), Out: array, Eff: NewVar()},
+		{Name: "map", In: Tuple(mapper), Out: &Named{Cons: ArrayType, Args: []Type{mapped}}, Eff: mapEff},
+		{Name: "fold", In: Tuple(acc, folder), Out: acc, Eff: foldEff},
+		builtin("length", IntType),
+	}
+	ArrayType.Methods = append(ArrayType.Methods, protocol(array)...)
+	sortShape(ArrayType.Methods)
+
+	initCollections()
+}
+
+// Keys of maps and items of sets are compared by the equals and hash methods,
+// which the built-in types all have.
+func protocol(self Type) Shape {
+	return Shape{
+		{Name: "equals", In: Tuple(self), Out: &Named{Cons: BoolType}, Eff: NewVar()},
+		{Name: "hash", In: Tuple(), Out: &Named{Cons: IntType}, Eff: NewVar()},
+	}
+}
+
+func initCollections() {
+	boolean, size := &Named{Cons: BoolType}, &Named{Cons: IntType}
+
+	// looking up a key that is not there triggers missingKey(k: K)
+	key, value := MapType.Args[0], MapType.Args[1]
+	m := &Named{Cons: MapType, Args: []Type{key, value}}
+	missing := NewVar()
+	missing.constraint = Shape{{Name: "missingKey", In: Tuple(key), Out: NewVar(), Eff: NewVar()}}
+
+	// fold[U, E](init: U, f: func(U, K, V): U in E): U in E
+	acc, foldEff := NewVar(), NewVar()
+	folder := &Anonymous{
+		Methods: Shape{{Name: "call", In: Tuple(acc, key, value), Out: acc, Eff: foldEff}},
+		Scope:   []Type{key, value, acc, foldEff},
+	}
+
+	MapType.Methods = Shape{
+		{Name: "get", In: Tuple(key), Out: value, Eff: missing},
+		{Name: "has", In: Tuple(key), Out: boolean, Eff: NewVar()},
+		{Name: "put", In: Tuple(key, value), Out: m, Eff: NewVar()},
+		{Name: "remove", In: Tuple(key), Out: m, Eff: NewVar()},
+		{Name: "size", In: Tuple(), Out: size, Eff: NewVar()},
+		{Name: "keys", In: Tuple(), Out: &Named{Cons: ArrayType, Args: []Type{key}}, Eff: NewVar()},
+		{Name: "values", In: Tuple(), Out: &Named{Cons: ArrayType, Args: []Type{value}}, Eff: NewVar()},
+		{Name: "fold", In: Tuple(acc, folder), Out: acc, Eff: foldEff},
+	}
+	sortShape(MapType.Methods)
+
+	// fold[U, E](init: U, f: func(U, T): U in E): U in E
+	item := SetType.Args[0]
+	s := &Named{Cons: SetType, Args: []Type{item}}
+	setAcc, setFoldEff := NewVar(), NewVar()
+	setFolder := &Anonymous{
+		Methods: Shape{{Name: "call", In: Tuple(setAcc, item), Out: setAcc, Eff: setFoldEff}},
+		Scope:   []Type{item, setAcc, setFoldEff},
+	}
+
+	SetType.Methods = Shape{
+		{Name: "has", In: Tuple(item), Out: boolean, Eff: NewVar()},
+		{Name: "add", In: Tuple(item), Out: s, Eff: NewVar()},
+		{Name: "remove", In: Tuple(item), Out: s, Eff: NewVar()},
+		{Name: "size", In: Tuple(), Out: size, Eff: NewVar()},
+		{Name: "items", In: Tuple(), Out: &Named{Cons: ArrayType, Args: []Type{item}}, Eff: NewVar()},
+		{Name: "fold", In: Tuple(setAcc, setFolder), Out: setAcc, Eff: setFoldEff},
+	}
+	sortShape(SetType.Methods)
+
+	// map[K, V](): Map[K, V] and set[T](): Set[T]
+	runtime := Shape{
+		{Name: "map", In: Tuple(), Out: &Named{Cons: MapType, Args: []Type{NewVar(), NewVar()}}, Eff: NewVar()},
+		{Name: "set", In: Tuple(), Out: &Named{Cons: SetType, Args: []Type{NewVar()}}, Eff: NewVar()},
+	}
+	sortShape(runtime)
+	Runtime = &Anonymous{Methods: runtime}
+}
+
+// raises gives an effect that includes the operation name, which the runtime
+// triggers with arguments of the given types.
+func raises(name string, in ...Type) Type {
+	eff := NewVar()
+	eff.constraint = Shape{{Name: name, In: Tuple(in...), Out: NewVar(), Eff: NewVar()}}
+	return eff
+}
+
+func builtin(name string, out *Constructor, in ...*Constructor) Method {
+	args := make([]Type, len(in))
+	for i, c := range in {
+		args[i] = &Named{Cons: c}
+	}
+	return Method{Name: name, In: Tuple(args...), Out: &Named{Cons: out}, Eff: NewVar()}
+}
+
+var (
+	tupleLock sync.Mutex
+	tuples    []*Constructor
+)
+
+// Tuple gives the type of an argument list. Tuples of the same length share a
+// constructor, so they unify element-wise.
+func Tuple(ts ...Type) Type {
+	return &Named{Cons: tupleCons(len(ts)), Args: ts}
+}
+
+func tupleCons(n int) *Constructor {
+	tupleLock.Lock()
+	defer tupleLock.Unlock()
+
+	for len(tuples) <= n {
+		args := make([]Type, len(tuples))
+		for i := range args {
+			args[i] = NewVar()
+		}
+		tuples = append(tuples, &Constructor{Name: "Tuple", Args: args})
+	}
+	return tuples[n]
+}
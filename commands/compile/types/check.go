@@ -0,0 +1,823 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
+
+var (
+	ErrUnknownVariable = errors.New("unknown variable")
+	ErrUnknownType     = errors.New("unknown type")
+)
+
+// Error is a type error in a top-level function. Pos is where the expression
+// that failed to check appears, and Notes point at the declarations involved.
+type Error struct {
+	Pos   ast.Pos
+	Err   error
+	Notes []diag.Annotation
+}
+
+func (e *Error) Error() string {
+	if pos := e.Pos.String(); pos != "" {
+		return fmt.Sprintf("%s: %s", pos, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Diagnostics() []diag.Diagnostic {
+	return []diag.Diagnostic{{
+		Severity: diag.Error,
+		Span:     diag.At(e.Pos),
+		Message:  e.Err.Error(),
+		Notes:    e.Notes,
+		Err:      e,
+	}}
+}
+
+// Errors are all of the type errors found in a package, in source order.
+type Errors []*Error
+
+func (es Errors) Diagnostics() []diag.Diagnostic {
+	var res []diag.Diagnostic
+	for _, e := range es {
+		res = append(res, e.Diagnostics()...)
+	}
+	return res
+}
+
+// Is reports whether any of the errors are target.
+func (es Errors) Is(target error) bool {
+	for _, e := range es {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (es Errors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// located marks an error with the position of the innermost expression that
+// failed to check.
+type located struct {
+	pos   ast.Pos
+	notes []diag.Annotation
+	err   error
+}
+
+func (e *located) Error() string {
+	return e.err.Error()
+}
+
+func (e *located) Unwrap() error {
+	return e.err
+}
+
+type checker struct {
+	env  *Env
+	subs *Subs
+	vars map[symtab.Symbol]*Scheme
+
+	// type parameters that are in scope
+	tvars map[symtab.Symbol]Type
+
+	// the effect of the method currently being checked
+	eff Type
+
+	// the types of names, by where they appear in the source
+	positions map[ast.Pos]Type
+
+	// where the names in scope were declared, when that is known
+	decls map[symtab.Symbol]ast.Pos
+}
+
+// A method's signature, along with the variables standing for its type
+// parameters.
+type signature struct {
+	Method
+	generics []Type
+	tvars    map[symtab.Symbol]Type
+}
+
+func (c *checker) name(s symtab.Symbol) string {
+	return c.env.syms.SymbolName(s)
+}
+
+func (c *checker) with() *checker {
+	return &checker{
+		env:       c.env,
+		subs:      c.subs,
+		vars:      c.vars,
+		tvars:     c.tvars,
+		eff:       c.eff,
+		positions: c.positions,
+		decls:     c.decls,
+	}
+}
+
+func (c *checker) record(pos ast.Pos, t Type) {
+	if c.positions == nil || pos.Line == 0 {
+		return
+	}
+	c.positions[pos] = t
+}
+
+func (c *checker) bind(name symtab.Symbol, s *Scheme) *checker {
+	vars := make(map[symtab.Symbol]*Scheme, len(c.vars)+1)
+	for n, s := range c.vars {
+		vars[n] = s
+	}
+	vars[name] = s
+	res := c.with()
+	res.vars = vars
+	if _, ok := c.decls[name]; ok {
+		res.declare(name, ast.Pos{})
+	}
+	return res
+}
+
+// declare records where a name was declared, or forgets it if pos is zero.
+func (c *checker) declare(name symtab.Symbol, pos ast.Pos) {
+	decls := make(map[symtab.Symbol]ast.Pos, len(c.decls)+1)
+	for n, p := range c.decls {
+		decls[n] = p
+	}
+	if pos.Line == 0 {
+		delete(decls, name)
+	} else {
+		decls[name] = pos
+	}
+	c.decls = decls
+}
+
+// at places err at pos, unless an expression inside the one at pos has
+// already claimed it.
+func (c *checker) at(pos ast.Pos, err error) error {
+	var l *located
+	if pos.Line == 0 || errors.As(err, &l) {
+		return err
+	}
+	return &located{pos: pos, err: err}
+}
+
+// note explains where the name that x refers to was declared.
+func (c *checker) note(x ast.Expr, err error) error {
+	var l *located
+	ref, ok := x.(ast.Ref)
+	if !ok || !errors.As(err, &l) {
+		return err
+	}
+	if pos, ok := c.decls[ref.Name]; ok {
+		l.notes = append(l.notes, diag.Annotation{
+			Span:    diag.At(pos),
+			Message: fmt.Sprintf("%s is declared here", c.name(ref.Name)),
+		})
+	}
+	return err
+}
+
+// The variables in scope, which cannot be generalised.
+func (c *checker) envTypes() []Type {
+	ts := []Type{c.eff}
+	for _, s := range c.vars {
+		free := freeVars(c.subs, []Type{s.Type})
+	next:
+		for _, v := range free {
+			for _, u := range s.Vars {
+				if u == v {
+					continue next
+				}
+			}
+			ts = append(ts, v)
+		}
+	}
+	for _, t := range c.tvars {
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// Top-level functions are checked in groups of mutually recursive functions.
+// Within a group functions are monomorphic, and once the group is checked its
+// functions are generalised for use by later groups.
+//
+// The result is the type of the package object as importers see it. A
+// function that fails to check does not stop the others from being checked,
+// and all of the errors are reported together.
+func (c *checker) checkFuncs(funcs []ast.Method) (*Anonymous, error) {
+	self := NewVar()
+	this := c.env.syms.SymbolID("this")
+	sigs := make([]signature, len(funcs))
+	var generic []*Metavar
+	var errs Errors
+	for _, f := range funcs {
+		c.declare(f.Name, f.Pos)
+	}
+	for _, group := range funcGroups(funcs) {
+		for _, i := range group {
+			f := funcs[i]
+			sig, err := c.signature(f)
+			if err != nil {
+				errs = append(errs, c.funcError(f, err))
+				sig = c.unknownSignature(f)
+			}
+			sigs[i] = sig
+			c.vars[f.Name] = Mono(function(sig))
+		}
+		inner := c.bind(this, Mono(self))
+		for _, i := range group {
+			f := funcs[i]
+			if err := inner.checkMethod(f, sigs[i]); err != nil {
+				errs = append(errs, c.funcError(f, err))
+			}
+		}
+		for _, i := range group {
+			f := funcs[i]
+			delete(c.vars, f.Name)
+		}
+		env := c.envTypes()
+		for _, i := range group {
+			f := funcs[i]
+			s := Generalize(c.subs, function(sigs[i]), env)
+			c.vars[f.Name] = s
+			generic = append(generic, s.Vars...)
+		}
+	}
+	if len(errs) != 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Pos.Before(errs[j].Pos)
+		})
+		return nil, errs
+	}
+	if err := self.Unify(c.subs, object(sigs)); err != nil {
+		return nil, err
+	}
+
+	// Within the package, functions referring to it through this are
+	// monomorphic, but importers can use them at any type they were
+	// generalised to.
+	exports := object(sigs).Apply(c.subs).(*Anonymous)
+	var scope []Type
+next:
+	for _, v := range exports.Scope {
+		for _, g := range generic {
+			if v == Type(g) {
+				continue next
+			}
+		}
+		scope = append(scope, v)
+	}
+	exports.Scope = scope
+	return exports, nil
+}
+
+func (c *checker) funcError(f ast.Method, err error) *Error {
+	res := &Error{
+		Pos: f.Pos,
+		Err: fmt.Errorf("%s: %w", c.name(f.Name), err),
+	}
+	var l *located
+	if errors.As(err, &l) {
+		res.Pos = l.pos
+		res.Notes = l.notes
+	}
+	return res
+}
+
+// A function whose annotations could not be resolved is checked as though it
+// had none, so that its body and the functions using it are still checked.
+func (c *checker) unknownSignature(f ast.Method) signature {
+	args := make([]Type, len(f.Args))
+	for i := range args {
+		args[i] = NewVar()
+	}
+	return signature{Method: Method{
+		Name: c.name(f.Name),
+		In:   Tuple(args...),
+		Out:  NewVar(),
+		Eff:  NewVar(),
+	}}
+}
+
+func (c *checker) infer(x ast.Expr) (Type, error) {
+	switch x := x.(type) {
+	case ast.Int:
+		return &Named{Cons: IntType}, nil
+
+	case ast.Float:
+		return &Named{Cons: FloatType}, nil
+
+	case ast.String:
+		return &Named{Cons: StringType}, nil
+
+	case ast.Ref:
+		s, ok := c.vars[x.Name]
+		if !ok {
+			return nil, c.at(x.Pos, fmt.Errorf("%s: %w", c.name(x.Name), ErrUnknownVariable))
+		}
+		t := s.Instantiate(c.subs)
+		c.record(x.Pos, t)
+		return t, nil
+
+	case ast.Let:
+		return c.inferLet(x)
+
+	case ast.Create:
+		return c.inferObject(x.Methods)
+
+	case ast.Invoke:
+		return c.inferInvoke(x)
+
+	case ast.Handle:
+		return c.inferHandle(x)
+
+	case ast.Trigger:
+		return c.inferTrigger(x)
+
+	case ast.Array:
+		return c.inferArray(x)
+	}
+	panic(fmt.Sprintf("unsupported syntax: %T", x))
+}
+
+func (c *checker) inferLet(x ast.Let) (Type, error) {
+	t, err := c.infer(x.Value)
+	if err != nil {
+		return nil, err
+	}
+	if x.Type != nil {
+		ann, err := c.resolveType(x.Type)
+		if err != nil {
+			return nil, c.at(x.Pos, fmt.Errorf("%s: %w", c.name(x.Name), err))
+		}
+		if err := c.unify(ann, t); err != nil {
+			return nil, c.at(x.Pos, fmt.Errorf("%s: %w", c.name(x.Name), err))
+		}
+		t = ann
+	}
+	c.record(x.Pos, t)
+	inner := c.bind(x.Name, c.generalize(x.Value, t))
+	inner.declare(x.Name, x.Pos)
+	return inner.infer(x.In)
+}
+
+// Only values are generalised, as the result of a method call may depend on
+// effects that happen when it is evaluated.
+func (c *checker) generalize(x ast.Expr, t Type) *Scheme {
+	switch x.(type) {
+	case ast.Create, ast.Ref, ast.Int, ast.Float, ast.String:
+		return Generalize(c.subs, t, c.envTypes())
+	}
+	return Mono(t)
+}
+
+func (c *checker) inferObject(methods []ast.Method) (Type, error) {
+	self := NewVar()
+	inner := c.bind(c.env.syms.SymbolID("this"), Mono(self))
+	sigs := make([]signature, len(methods))
+	for i, m := range methods {
+		sig, err := c.signature(m)
+		if err != nil {
+			return nil, c.at(m.Pos, fmt.Errorf("%s: %w", c.name(m.Name), err))
+		}
+		sigs[i] = sig
+	}
+	for i, m := range methods {
+		if err := inner.checkMethod(m, sigs[i]); err != nil {
+			return nil, c.at(m.Pos, fmt.Errorf("%s: %w", c.name(m.Name), err))
+		}
+	}
+	if err := self.Unify(c.subs, object(sigs)); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+func (c *checker) inferInvoke(x ast.Invoke) (Type, error) {
+	obj, err := c.infer(x.Object)
+	if err != nil {
+		return nil, err
+	}
+	args, err := c.inferAll(x.Args)
+	if err != nil {
+		return nil, err
+	}
+	out := NewVar()
+	err = c.supports(obj, Shape{{
+		Name: c.name(x.Name),
+		In:   Tuple(args...),
+		Out:  out,
+		Eff:  c.eff,
+	}})
+	if err != nil {
+		err = c.at(x.Pos, fmt.Errorf("%s: %w", c.name(x.Name), err))
+		return nil, c.note(x.Object, err)
+	}
+	return out, nil
+}
+
+// The body of a handle expression has its own effect. Operations the handlers
+// provide are checked against the handlers, and any others are passed on to
+// the enclosing effect.
+func (c *checker) inferHandle(x ast.Handle) (Type, error) {
+	inner := c.with()
+	inner.eff = NewVar()
+	res, err := inner.infer(x.In)
+	if err != nil {
+		return nil, err
+	}
+
+	handlers := make([]Method, len(x.With))
+	positions := make(map[string]ast.Pos, len(x.With))
+	for i, h := range x.With {
+		sig, err := c.signature(h)
+		if err != nil {
+			return nil, c.at(h.Pos, fmt.Errorf("%s: %w", c.name(h.Name), err))
+		}
+		if err := c.unify(sig.Out, res); err != nil {
+			return nil, c.at(h.Pos, fmt.Errorf("%s: %w", c.name(h.Name), err))
+		}
+		if err := c.checkMethod(h, sig); err != nil {
+			return nil, c.at(h.Pos, fmt.Errorf("%s: %w", c.name(h.Name), err))
+		}
+		// the context argument is supplied by the runtime
+		args := sig.In.(*Named).Args
+		handlers[i] = Method{
+			Name: sig.Name,
+			In:   Tuple(args[1:]...),
+			Out:  NewVar(),
+			Eff:  NewVar(),
+		}
+		positions[sig.Name] = h.Pos
+	}
+	sortShape(handlers)
+
+	ops := Shape{}
+	if v, ok := c.subs.Resolve(inner.eff).(*Metavar); ok {
+		ops = v.constraint
+	}
+	// operations that are passed on are placed at the handle expression
+	for _, op := range ops {
+		pos := x.Pos
+		h, err := Shape(handlers).Get(op.Name)
+		if err != nil {
+			err = c.eff.Supports(c.subs, Shape{op})
+		} else if err = op.Unify(c.subs, h); err != nil {
+			pos = positions[op.Name]
+			err = fmt.Errorf("%s: %w", op.Name, err)
+		}
+		if err != nil {
+			return nil, c.at(pos, err)
+		}
+	}
+
+	return res, nil
+}
+
+func (c *checker) inferTrigger(x ast.Trigger) (Type, error) {
+	args, err := c.inferAll(x.Args)
+	if err != nil {
+		return nil, err
+	}
+	out := NewVar()
+	err = c.eff.Supports(c.subs, Shape{{
+		Name: c.name(x.Name),
+		In:   Tuple(args...),
+		Out:  out,
+		Eff:  NewVar(),
+	}})
+	if err != nil {
+		return nil, c.at(x.Pos, fmt.Errorf("%s: %w", c.name(x.Name), err))
+	}
+	return out, nil
+}
+
+// The items of an array all have the same type.
+func (c *checker) inferArray(x ast.Array) (Type, error) {
+	items, err := c.inferAll(x.Items)
+	if err != nil {
+		return nil, err
+	}
+	elem := Type(NewVar())
+	for i, t := range items {
+		if err := c.unify(elem, t); err != nil {
+			return nil, c.note(x.Items[i], c.at(x.Pos, err))
+		}
+	}
+	return &Named{Cons: ArrayType, Args: []Type{elem}}, nil
+}
+
+func (c *checker) inferAll(xs []ast.Expr) ([]Type, error) {
+	ts := make([]Type, len(xs))
+	for i, x := range xs {
+		t, err := c.infer(x)
+		if err != nil {
+			return nil, err
+		}
+		ts[i] = t
+	}
+	return ts, nil
+}
+
+// The signature of a method uses its annotations where they are present, and
+// fresh variables where they are not.
+func (c *checker) signature(m ast.Method) (signature, error) {
+	var generics []Type
+	if len(m.TypeParams) != 0 {
+		tvars := make(map[symtab.Symbol]Type, len(c.tvars)+len(m.TypeParams))
+		for n, t := range c.tvars {
+			tvars[n] = t
+		}
+		for _, p := range m.TypeParams {
+			v := NewRigidVar()
+			tvars[p] = v
+			generics = append(generics, v)
+		}
+		c = c.with()
+		c.tvars = tvars
+	}
+
+	args := make([]Type, len(m.Args))
+	for i := range m.Args {
+		var ann ast.TypeExpr
+		if m.ArgTypes != nil {
+			ann = m.ArgTypes[i]
+		}
+		t, err := c.resolveType(ann)
+		if err != nil {
+			return signature{}, fmt.Errorf("%s: %w", c.name(m.Args[i]), err)
+		}
+		args[i] = t
+	}
+	out, err := c.resolveType(m.Result)
+	if err != nil {
+		return signature{}, err
+	}
+	eff, err := c.resolveType(m.Effect)
+	if err != nil {
+		return signature{}, err
+	}
+	return signature{
+		Method: Method{
+			Name: c.name(m.Name),
+			In:   Tuple(args...),
+			Out:  out,
+			Eff:  eff,
+		},
+		generics: generics,
+		tvars:    c.tvars,
+	}, nil
+}
+
+func (c *checker) checkMethod(m ast.Method, sig signature) error {
+	c.record(m.Pos, function(sig))
+	inner := c.with()
+	inner.tvars = sig.tvars
+	inner.eff = sig.Eff
+	for i, a := range sig.In.(*Named).Args {
+		inner = inner.bind(m.Args[i], Mono(a))
+	}
+	t, err := inner.infer(m.Body)
+	if err != nil {
+		return err
+	}
+	return c.unify(sig.Out, t)
+}
+
+func (c *checker) unify(expected, got Type) error {
+	if err := expected.Unify(c.subs, got); err != nil {
+		p := NewPrinter(c.subs)
+		return fmt.Errorf("expected %s, got %s: %w", p.Type(expected), p.Type(got), err)
+	}
+	return nil
+}
+
+func (c *checker) supports(t Type, s Shape) error {
+	if err := t.Supports(c.subs, s); err != nil {
+		p := NewPrinter(c.subs)
+		return fmt.Errorf("%s does not support %s: %w", p.Type(t), p.Shape(s), err)
+	}
+	return nil
+}
+
+func (c *checker) resolveType(t ast.TypeExpr) (Type, error) {
+	switch t := t.(type) {
+	case nil:
+		return NewVar(), nil
+
+	case ast.NamedType:
+		if v, ok := c.tvars[t.Name]; ok && len(t.Args) == 0 {
+			return v, nil
+		}
+		cons, ok := c.env.cons[qname{sym: c.name(t.Name)}]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", c.name(t.Name), ErrUnknownType)
+		}
+		if len(t.Args) == 0 && len(cons.Args) == 0 {
+			return &Named{Cons: cons}, nil
+		}
+		args := make([]Type, len(t.Args))
+		for i, a := range t.Args {
+			at, err := c.resolveType(a)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = at
+		}
+		res, err := cons.WithArgs(c.subs, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.name(t.Name), err)
+		}
+		return res, nil
+
+	case ast.FuncType:
+		args := make([]Type, len(t.Args))
+		for i, a := range t.Args {
+			at, err := c.resolveType(a)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = at
+		}
+		out, err := c.resolveType(t.Result)
+		if err != nil {
+			return nil, err
+		}
+		eff, err := c.resolveType(t.Effect)
+		if err != nil {
+			return nil, err
+		}
+		return function(signature{Method: Method{
+			In:  Tuple(args...),
+			Out: out,
+			Eff: eff,
+		}}), nil
+	}
+	panic(fmt.Sprintf("unsupported type syntax: %T", t))
+}
+
+// Functions are objects with a single call method.
+func function(sig signature) Type {
+	sig.Name = "call"
+	return object([]signature{sig})
+}
+
+// Objects created in the source are monomorphic in the variables appearing in
+// their methods, apart from the methods' own type parameters.
+func object(sigs []signature) *Anonymous {
+	shape := make(Shape, len(sigs))
+	var generics []Type
+	for i, s := range sigs {
+		shape[i] = s.Method
+		generics = append(generics, s.generics...)
+	}
+	sortShape(shape)
+	var scope []Type
+	for _, m := range shape {
+		scope = metavars(scope, m.In)
+		scope = metavars(scope, m.Out)
+		scope = metavars(scope, m.Eff)
+	}
+	var res []Type
+next:
+	for _, v := range scope {
+		for _, g := range generics {
+			if v == g {
+				continue next
+			}
+		}
+		res = append(res, v)
+	}
+	return &Anonymous{Methods: shape, Scope: res}
+}
+
+func sortShape(s Shape) {
+	sort.SliceStable(s, func(i, j int) bool { return s[i].Name < s[j].Name })
+}
+
+// funcGroups splits the functions into strongly connected components of the
+// reference graph, in an order where each group only refers to itself and the
+// groups that come before it.
+func funcGroups(funcs []ast.Method) [][]int {
+	index := map[symtab.Symbol]int{}
+	for i, f := range funcs {
+		index[f.Name] = i
+	}
+	edges := make([][]int, len(funcs))
+	for i, f := range funcs {
+		for _, r := range refs(nil, f.Body) {
+			if j, ok := index[r]; ok {
+				edges[i] = append(edges[i], j)
+			}
+		}
+	}
+
+	// Tarjan's algorithm
+	g := &grouper{
+		edges: edges,
+		order: make([]int, len(funcs)),
+		low:   make([]int, len(funcs)),
+		on:    make([]bool, len(funcs)),
+	}
+	for i := range funcs {
+		if g.order[i] == 0 {
+			g.visit(i)
+		}
+	}
+	return g.groups
+}
+
+type grouper struct {
+	edges      [][]int
+	order, low []int
+	on         []bool
+	stack      []int
+	next       int
+	groups     [][]int
+}
+
+func (g *grouper) visit(i int) {
+	g.next++
+	g.order[i] = g.next
+	g.low[i] = g.next
+	g.stack = append(g.stack, i)
+	g.on[i] = true
+
+	for _, j := range g.edges[i] {
+		if g.order[j] == 0 {
+			g.visit(j)
+			if g.low[j] < g.low[i] {
+				g.low[i] = g.low[j]
+			}
+		} else if g.on[j] && g.order[j] < g.low[i] {
+			g.low[i] = g.order[j]
+		}
+	}
+
+	if g.low[i] != g.order[i] {
+		return
+	}
+	var group []int
+	for {
+		j := g.stack[len(g.stack)-1]
+		g.stack = g.stack[:len(g.stack)-1]
+		g.on[j] = false
+		group = append(group, j)
+		if j == i {
+			break
+		}
+	}
+	sort.Ints(group)
+	g.groups = append(g.groups, group)
+}
+
+// refs finds the names referred to in an expression.
+func refs(acc []symtab.Symbol, x ast.Expr) []symtab.Symbol {
+	switch x := x.(type) {
+	case ast.Ref:
+		acc = append(acc, x.Name)
+	case ast.Let:
+		acc = refs(acc, x.Value)
+		acc = refs(acc, x.In)
+	case ast.Create:
+		for _, m := range x.Methods {
+			acc = refs(acc, m.Body)
+		}
+	case ast.Invoke:
+		acc = refs(acc, x.Object)
+		for _, a := range x.Args {
+			acc = refs(acc, a)
+		}
+	case ast.Handle:
+		acc = refs(acc, x.In)
+		for _, m := range x.With {
+			acc = refs(acc, m.Body)
+		}
+	case ast.Trigger:
+		for _, a := range x.Args {
+			acc = refs(acc, a)
+		}
+	case ast.Array:
+		for _, a := range x.Items {
+			acc = refs(acc, a)
+		}
+	}
+	return acc
+}
@@ -0,0 +1,580 @@
+package types
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+	"github.com/bobappleyard/cezanne/util/slices"
+)
+
+func TestCheckPackage(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		err  error
+	}{
+		{
+			name: "Unannotated",
+			in: `
+			import test
+
+			func main() {
+				test.print(id(1))
+			}
+
+			func id(x) { x }
+			`,
+		},
+		{
+			name: "Annotated",
+			in: `
+			func main(): Int {
+				let x: Int = 1
+				id(x)
+			}
+
+			func id(x: Int): Int { x }
+			`,
+		},
+		{
+			name: "WrongArgument",
+			in: `
+			func main() {
+				id("hello")
+			}
+
+			func id(x: Int): Int { x }
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "WrongResult",
+			in: `
+			func main(): String {
+				1
+			}
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "BuiltinMethods",
+			in: `
+			func main(): String {
+				let n: Int = "1,2".split(",").get(1).toInt()
+				let f: Float = n.toFloat().mul(2.5)
+				n.add(f.toInt()).toString().concat("!")
+			}
+			`,
+		},
+		{
+			name: "NoBuiltinMethod",
+			in: `
+			func main() {
+				"a".add(1)
+			}
+			`,
+			err: ErrNoMethod,
+		},
+		{
+			name: "WrongLet",
+			in: `
+			func main() {
+				let x: String = 1
+				x
+			}
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "FunctionArgument",
+			in: `
+			func main() {
+				apply(object { call(x) { x } })
+			}
+
+			func apply(f: func(x: Int): Int): Int {
+				f(1)
+			}
+			`,
+		},
+		{
+			name: "WrongFunctionArgument",
+			in: `
+			func main() {
+				apply(object { call(x) { "hello" } })
+			}
+
+			func apply(f: func(x: Int): Int): Int {
+				f(1)
+			}
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "MissingMethod",
+			in: `
+			func main() {
+				object { f() { 1 } }.g()
+			}
+			`,
+			err: ErrNoMethod,
+		},
+		{
+			name: "ObjectSelfReference",
+			in: `
+			func main() {
+				object {
+					f() { this }
+					g() { this.f().g() }
+				}
+			}
+			`,
+		},
+		{
+			name: "BoolMatch",
+			in: `
+			func choose(b: Bool): Int {
+				b.match(object {
+					true() { 1 }
+					false() { 2 }
+				})
+			}
+			`,
+		},
+		{
+			name: "UnknownType",
+			in: `
+			func main(x: Foo) { x }
+			`,
+			err: ErrUnknownType,
+		},
+		{
+			name: "UnknownVariable",
+			in: `
+			func main() { x }
+			`,
+			err: ErrUnknownVariable,
+		},
+		{
+			name: "PolymorphicFunction",
+			in: `
+			func main() {
+				let x: Int = id(1)
+				let y: String = id("hello")
+				y
+			}
+
+			func id(x) { x }
+			`,
+		},
+		{
+			name: "MonomorphicWithinGroup",
+			in: `
+			func f(x) {
+				let a: Int = g(1)
+				let b: String = g("hello")
+				x
+			}
+
+			func g(x) { f(x) }
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "PolymorphicLet",
+			in: `
+			func main() {
+				let id = object { call(x) { x } }
+				let x: Int = id(1)
+				let y: String = id("hello")
+				y
+			}
+			`,
+		},
+		{
+			name: "MonomorphicArgument",
+			in: `
+			func main(id) {
+				let x: Int = id(1)
+				let y: String = id("hello")
+				y
+			}
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "GenericMethod",
+			in: `
+			func main() {
+				let box = object {
+					map[U](f: func(x: Int): U): U { f(1) }
+				}
+				let x: Int = box.map(object { call(x) { x } })
+				let y: String = box.map(object { call(x) { "hello" } })
+				y
+			}
+			`,
+		},
+		{
+			name: "GenericFunction",
+			in: `
+			func main(): String {
+				apply(object { call(x) { x } }, "hello")
+			}
+
+			func apply[T, U](f: func(x: T): U, x: T): U {
+				let y: T = x
+				f(y)
+			}
+			`,
+		},
+		{
+			name: "WrongGenericFunction",
+			in: `
+			func apply[T, U](f: func(x: T): U, x: T): U {
+				let y: U = x
+				f(y)
+			}
+			`,
+			err: ErrRigidVar,
+		},
+		{
+			name: "HandledEffect",
+			in: `
+			func main() {
+				handle trigger Write(2) {
+					Write(x) { x }
+				}
+			}
+			`,
+		},
+		{
+			name: "Array",
+			in: `
+			func main() {
+				let xs = [1, 2]
+				let x: Int = handle xs.push(3).get(4) {
+					outOfBounds(i) { i.neg() }
+				}
+				let ys: Array[String] = xs.map(object { call(x) { x.toString() } })
+				ys
+			}
+			`,
+		},
+		{
+			name: "MixedArray",
+			in: `
+			func main() { [1, "two"] }
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "OutOfBoundsResult",
+			in: `
+			func main() {
+				let x: Int = handle [1].get(2) {
+					outOfBounds(i) { "none" }
+				}
+				x
+			}
+			`,
+			err: ErrWrongCons,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var syms symtab.Symtab
+			var pkg ast.Package
+
+			err := parser.ParseFile(&syms, &pkg, []byte(test.in))
+			assert.Nil(t, err)
+
+			_, err = NewEnv(&syms).CheckPackage(pkg)
+			if test.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			if !errors.Is(err, test.err) {
+				t.Errorf("got %v, expecting %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestCollections(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		err  error
+	}{
+		{
+			name: "Map",
+			in: `
+			func main() {
+				let m = runtime.map().put("a", 1)
+				let n: Int = handle m.get("b") {
+					missingKey(k) { k.length() }
+				}
+				let ks: Array[String] = m.keys()
+				m.fold(0, object { call(acc, k, v) { acc.add(v) } })
+			}
+			`,
+		},
+		{
+			name: "MapValues",
+			in: `
+			func main() {
+				runtime.map().put("a", 1).put("b", "c")
+			}
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "Set",
+			in: `
+			func main() {
+				let s: Set[Array[Int]] = runtime.set().add([1, 2])
+				let b: Bool = s.has([2, 1])
+				s.items().length()
+			}
+			`,
+		},
+		{
+			name: "Protocol",
+			in: `
+			func main() {
+				let b: Bool = 1.equals(2).equals(1.0.equals(2.0))
+				"a".hash().add([1].hash())
+			}
+			`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var syms symtab.Symtab
+			var pkg ast.Package
+
+			err := parser.ParseFile(&syms, &pkg, []byte("import runtime\n"+test.in))
+			assert.Nil(t, err)
+
+			env := NewEnv(&syms)
+			env.ImportPackage(&Package{Exports: Runtime}, "runtime")
+			_, err = env.CheckPackage(pkg)
+			if test.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			if !errors.Is(err, test.err) {
+				t.Errorf("got %v, expecting %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	var syms symtab.Symtab
+
+	ty, err := NewEnv(&syms).TypeOf(ast.Let{
+		Name:  syms.SymbolID("x"),
+		Value: ast.Int{Value: 1},
+		In:    ast.Ref{Name: syms.SymbolID("x")},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ty, Type(&Named{Cons: IntType}))
+}
+
+func TestTypeOfRecursiveObject(t *testing.T) {
+	var syms symtab.Symtab
+
+	ty, err := NewEnv(&syms).TypeOf(ast.Create{Methods: []ast.Method{{
+		Name: syms.SymbolID("next"),
+		Body: ast.Ref{Name: syms.SymbolID("this")},
+	}}})
+	assert.Nil(t, err)
+	assert.Equal(t, ty.String(), "rec a. {next(): a}")
+}
+
+func TestUnknownImport(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		err  error
+	}{
+		{
+			name: "SameType",
+			in: `
+			func main() {
+				let a: Int = lib
+				let b: Int = lib
+				a
+			}
+			`,
+		},
+		{
+			name: "DifferentTypes",
+			in: `
+			func main() {
+				let a: Int = lib
+				let b: String = lib
+				a
+			}
+			`,
+			err: ErrWrongCons,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var syms symtab.Symtab
+			var pkg ast.Package
+
+			err := parser.ParseFile(&syms, &pkg, []byte("import lib\n"+test.in))
+			assert.Nil(t, err)
+
+			_, err = NewEnv(&syms).CheckPackage(pkg)
+			if test.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			if !errors.Is(err, test.err) {
+				t.Errorf("got %v, expecting %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	var syms symtab.Symtab
+	var pkg ast.Package
+
+	err := parser.ParseNamedFile(&syms, &pkg, "main.cz", []byte(`func main() {
+	id("hello")
+}
+
+func id(x: Int): Int { x }
+`))
+	assert.Nil(t, err)
+
+	_, err = NewEnv(&syms).CheckPackage(pkg)
+	var errs Errors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, len(errs), 1)
+	assert.Equal(t, errs[0].Pos, ast.Pos{File: "main.cz", Line: 2, Col: 2})
+	assert.Equal(t, errs[0].Notes, []diag.Annotation{{
+		Span:    diag.At(ast.Pos{File: "main.cz", Line: 5, Col: 6}),
+		Message: "id is declared here",
+	}})
+	assert.True(t, strings.HasPrefix(err.Error(), "main.cz:2:2: main: "))
+}
+
+func TestEveryError(t *testing.T) {
+	var syms symtab.Symtab
+	var pkg ast.Package
+
+	err := parser.ParseNamedFile(&syms, &pkg, "main.cz", []byte(`func main() {
+	let x: String = 1
+	x
+}
+
+func f(n: Foo) { n }
+
+func g() {
+	1.add(y)
+}
+`))
+	assert.Nil(t, err)
+
+	_, err = NewEnv(&syms).CheckPackage(pkg)
+	var l diag.List
+	l.AddError(err)
+	assert.Equal(t, l.Error(), strings.Join([]string{
+		"main.cz:2:6: error: main: x: expected String, got Int: wrong constructor",
+		"main.cz:6:6: error: f: n: Foo: unknown type",
+		"main.cz:9:8: error: g: y: unknown variable",
+	}, "\n"))
+}
+
+// Errors inside array literals and handle expressions are placed at them, or at
+// the handler that does not match.
+func TestHandleAndArrayErrorPositions(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "MixedArray",
+			in:   "func main() {\n\t[1, \"two\"]\n}\n",
+			out:  "main.cz:2:2: error: main: expected Int, got String: wrong constructor",
+		},
+		{
+			name: "Handler",
+			in:   "func main() {\n\thandle trigger Write(2) {\n\t\tWrite(x: String) { 1 }\n\t}\n}\n",
+			out:  "main.cz:3:3: error: main: Write: wrong constructor",
+		},
+		{
+			name: "PassedOn",
+			in:   "func f[E](): Int in E {\n\thandle trigger Write(2) {\n\t\tRead() { 1 }\n\t}\n}\n",
+			out:  "main.cz:2:2: error: f: Write: type parameter does not match",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var syms symtab.Symtab
+			var pkg ast.Package
+
+			err := parser.ParseNamedFile(&syms, &pkg, "main.cz", []byte(test.in))
+			assert.Nil(t, err)
+
+			_, err = NewEnv(&syms).CheckPackage(pkg)
+			var l diag.List
+			l.AddError(err)
+			assert.Equal(t, l.Error(), test.out)
+		})
+	}
+}
+
+func TestPositions(t *testing.T) {
+	var syms symtab.Symtab
+	var pkg ast.Package
+
+	err := parser.ParseNamedFile(&syms, &pkg, "main.cz", []byte(`func main() {
+	let x = inc(1)
+	x
+}
+
+func inc(n: Int): Int { n }
+`))
+	assert.Nil(t, err)
+
+	p, err := NewEnv(&syms).CheckPackage(pkg)
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		line, col int
+		typ       string
+	}{
+		{2, 6, "Int"},
+		{2, 10, "{call(Int): Int}"},
+		{3, 2, "Int"},
+		{6, 6, "{call(Int): Int}"},
+	} {
+		ty, ok := p.Positions[ast.Pos{File: "main.cz", Line: test.line, Col: test.col}]
+		assert.True(t, ok)
+		assert.Equal(t, NewPrinter(nil).Type(ty), test.typ)
+	}
+}
+
+func TestMethodsOf(t *testing.T) {
+	var syms symtab.Symtab
+
+	ty, err := NewEnv(&syms).TypeOf(ast.Create{Methods: []ast.Method{{
+		Name: syms.SymbolID("next"),
+		Body: ast.Ref{Name: syms.SymbolID("this")},
+	}}})
+	assert.Nil(t, err)
+	assert.Equal(t, MethodsOf(ty).String(), "{next(): a}")
+	names := slices.Map(MethodsOf(&Named{Cons: BoolType}), func(m Method) string { return m.Name })
+	assert.Equal(t, names, []string{"equals", "hash", "match"})
+}
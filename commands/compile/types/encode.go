@@ -0,0 +1,249 @@
+package types
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/bobappleyard/cezanne/format"
+)
+
+var ErrInvalidTypeInfo = errors.New("invalid type information")
+
+// EncodePackage converts the types that a package exports into a form that
+// can be stored alongside its code.
+func (e *Env) EncodePackage(p *Package) format.TypeInfo {
+	enc := &encoder{
+		subs:  p.subs,
+		types: map[Type]int32{},
+		cons:  map[*Constructor]int32{},
+	}
+	enc.info.Exports = enc.typ(p.Exports)
+
+	names := make([]string, 0, len(p.Types))
+	for n := range p.Types {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		enc.constructor(p.Types[n])
+	}
+
+	return enc.info
+}
+
+// DecodePackage recovers the types that a package exports from the form
+// produced by EncodePackage. The constructors that the language provides, and
+// those that have been declared in e, are shared with the decoded types rather
+// than recreated.
+func (e *Env) DecodePackage(info format.TypeInfo) (*Package, error) {
+	dec := &decoder{
+		info:  info,
+		types: make([]Type, len(info.Types)),
+		cons:  make([]*Constructor, len(info.Constructors)),
+	}
+	p := &Package{Types: map[string]*Constructor{}}
+
+	// Types can refer to each other in any order, so they are all created
+	// before any of them are filled in.
+	for i, t := range info.Types {
+		switch t.Kind {
+		case format.VarType:
+			dec.types[i] = NewVar()
+		case format.NamedType:
+			dec.types[i] = &Named{}
+		case format.ObjectType:
+			dec.types[i] = &Anonymous{}
+		case format.RecursiveType:
+			dec.types[i] = &Recursive{}
+		default:
+			return nil, ErrInvalidTypeInfo
+		}
+	}
+
+	var fresh []int
+	for i, c := range info.Constructors {
+		if c.Name == tupleCons(0).Name {
+			dec.cons[i] = tupleCons(len(c.Args))
+			continue
+		}
+		if known, ok := e.cons[qname{sym: c.Name}]; ok {
+			dec.cons[i] = known
+			continue
+		}
+		dec.cons[i] = &Constructor{Name: c.Name}
+		p.Types[c.Name] = dec.cons[i]
+		fresh = append(fresh, i)
+	}
+
+	for _, i := range fresh {
+		c := info.Constructors[i]
+		dec.cons[i].Args = dec.list(c.Args)
+		dec.cons[i].Methods = dec.shape(c.Methods)
+	}
+
+	for i, t := range info.Types {
+		switch u := dec.types[i].(type) {
+		case *Metavar:
+			u.constraint = dec.shape(t.Methods)
+
+		case *Named:
+			if t.Cons < 0 || int(t.Cons) >= len(dec.cons) {
+				return nil, ErrInvalidTypeInfo
+			}
+			u.Cons = dec.cons[t.Cons]
+			u.Args = dec.list(t.Args)
+
+		case *Anonymous:
+			u.Scope = dec.list(t.Args)
+			u.Methods = dec.shape(t.Methods)
+
+		case *Recursive:
+			args := dec.list(t.Args)
+			if len(args) != 2 {
+				return nil, ErrInvalidTypeInfo
+			}
+			v, ok := args[0].(*Metavar)
+			if !ok {
+				return nil, ErrInvalidTypeInfo
+			}
+			u.Var = v
+			u.Body = args[1]
+		}
+	}
+
+	p.Exports = dec.ref(info.Exports)
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return p, nil
+}
+
+type encoder struct {
+	subs  *Subs
+	info  format.TypeInfo
+	types map[Type]int32
+	cons  map[*Constructor]int32
+}
+
+// Types are given an index before their parts are encoded, so types that
+// refer back to themselves come out as cycles in the table.
+func (e *encoder) typ(t Type) int32 {
+	if id, ok := e.types[t]; ok {
+		return id
+	}
+	id := int32(len(e.info.Types))
+	e.types[t] = id
+	e.info.Types = append(e.info.Types, format.Type{})
+
+	var res format.Type
+	switch t := t.(type) {
+	case *Metavar:
+		// the constraint is not applied along with the variable
+		constraint := t.constraint
+		if e.subs != nil {
+			constraint = constraint.Apply(e.subs)
+		}
+		res = format.Type{
+			Kind:    format.VarType,
+			Methods: e.shape(constraint),
+		}
+
+	case *Named:
+		res = format.Type{
+			Kind: format.NamedType,
+			Cons: e.constructor(t.Cons),
+			Args: e.list(t.Args),
+		}
+
+	case *Anonymous:
+		res = format.Type{
+			Kind:    format.ObjectType,
+			Args:    e.list(t.Scope),
+			Methods: e.shape(t.Methods),
+		}
+
+	case *Recursive:
+		res = format.Type{
+			Kind: format.RecursiveType,
+			Args: []int32{e.typ(t.Var), e.typ(t.Body)},
+		}
+	}
+	e.info.Types[id] = res
+
+	return id
+}
+
+func (e *encoder) constructor(c *Constructor) int32 {
+	if id, ok := e.cons[c]; ok {
+		return id
+	}
+	id := int32(len(e.info.Constructors))
+	e.cons[c] = id
+	e.info.Constructors = append(e.info.Constructors, format.TypeConstructor{})
+
+	e.info.Constructors[id] = format.TypeConstructor{
+		Name:    c.Name,
+		Args:    e.list(c.Args),
+		Methods: e.shape(c.Methods),
+	}
+
+	return id
+}
+
+func (e *encoder) list(ts []Type) []int32 {
+	res := make([]int32, len(ts))
+	for i, t := range ts {
+		res[i] = e.typ(t)
+	}
+	return res
+}
+
+func (e *encoder) shape(s Shape) []format.TypeMethod {
+	res := make([]format.TypeMethod, len(s))
+	for i, m := range s {
+		res[i] = format.TypeMethod{
+			Name: m.Name,
+			In:   e.typ(m.In),
+			Out:  e.typ(m.Out),
+			Eff:  e.typ(m.Eff),
+		}
+	}
+	return res
+}
+
+type decoder struct {
+	info  format.TypeInfo
+	types []Type
+	cons  []*Constructor
+	err   error
+}
+
+func (d *decoder) ref(id int32) Type {
+	if id < 0 || int(id) >= len(d.types) {
+		d.err = ErrInvalidTypeInfo
+		return NewVar()
+	}
+	return d.types[id]
+}
+
+func (d *decoder) list(ids []int32) []Type {
+	res := make([]Type, len(ids))
+	for i, id := range ids {
+		res[i] = d.ref(id)
+	}
+	return res
+}
+
+func (d *decoder) shape(ms []format.TypeMethod) Shape {
+	res := make(Shape, len(ms))
+	for i, m := range ms {
+		res[i] = Method{
+			Name: m.Name,
+			In:   d.ref(m.In),
+			Out:  d.ref(m.Out),
+			Eff:  d.ref(m.Eff),
+		}
+	}
+	sortShape(res)
+	return res
+}
@@ -0,0 +1,165 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/storage"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+type bufferAt struct {
+	buf []byte
+}
+
+// WriteAt implements io.WriterAt
+func (w *bufferAt) WriteAt(p []byte, off int64) (int, error) {
+	n := int(off) + len(p)
+	if n > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, n-len(w.buf))...)
+	}
+	copy(w.buf[off:], p)
+	return len(p), nil
+}
+
+func TestEncodePackage(t *testing.T) {
+	var syms symtab.Symtab
+
+	lib := checkSource(t, &syms, `
+	func id(x) { x }
+
+	func twice(f, x) { f(f(x)) }
+
+	func count(n: Int) {
+		object {
+			value() { n }
+			next() { count(n) }
+		}
+	}
+	`)
+
+	var buf bufferAt
+	_, err := storage.Write(&buf, NewEnv(&syms).EncodePackage(lib))
+	assert.Nil(t, err)
+
+	var info format.TypeInfo
+	_, err = storage.Read(bytes.NewReader(buf.buf), &info)
+	assert.Nil(t, err)
+
+	decoded, err := NewEnv(&syms).DecodePackage(info)
+	assert.Nil(t, err)
+	assert.Equal(t, decoded.Exports.String(), lib.Exports.String())
+	assert.Equal(t, decoded.Exports.String(),
+		"{count(Int): rec a. {next(): a, value(): Int}, id(b): b, twice(c, d): d}")
+}
+
+func TestImportDecodedPackage(t *testing.T) {
+	var syms symtab.Symtab
+
+	lib := checkSource(t, &syms, `
+	func id(x) { x }
+
+	func twice(f, x) { f(f(x)) }
+
+	func add(x: Int, y: Int): Int { x }
+	`)
+	info := NewEnv(&syms).EncodePackage(lib)
+
+	for _, test := range []struct {
+		name string
+		in   string
+		err  error
+	}{
+		{
+			name: "Polymorphic",
+			in: `
+			import lib
+
+			func main() {
+				lib.add(lib.id(1), 2)
+				lib.id("hello")
+			}
+			`,
+		},
+		{
+			name: "WrongArgument",
+			in: `
+			import lib
+
+			func main() {
+				lib.add("hello", 2)
+			}
+			`,
+			err: ErrWrongCons,
+		},
+		{
+			name: "Constrained",
+			in: `
+			import lib
+
+			func main() {
+				lib.twice(1, 2)
+			}
+			`,
+			err: ErrNoMethod,
+		},
+		{
+			name: "MissingMethod",
+			in: `
+			import lib
+
+			func main() {
+				lib.sub(1, 2)
+			}
+			`,
+			err: ErrNoMethod,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var pkg ast.Package
+			err := parser.ParseFile(&syms, &pkg, []byte(test.in))
+			assert.Nil(t, err)
+
+			env := NewEnv(&syms)
+			decoded, err := env.DecodePackage(info)
+			assert.Nil(t, err)
+			env.ImportPackage(decoded, "lib")
+
+			_, err = env.CheckPackage(pkg)
+			if test.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			if !errors.Is(err, test.err) {
+				t.Errorf("got %v, expecting %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	var syms symtab.Symtab
+
+	_, err := NewEnv(&syms).DecodePackage(format.TypeInfo{})
+	assert.True(t, errors.Is(err, ErrInvalidTypeInfo))
+
+	_, err = NewEnv(&syms).DecodePackage(format.TypeInfo{
+		Types: []format.Type{{Kind: format.NamedType, Cons: 3}},
+	})
+	assert.True(t, errors.Is(err, ErrInvalidTypeInfo))
+}
+
+func checkSource(t *testing.T, syms *symtab.Symtab, src string) *Package {
+	var pkg ast.Package
+	err := parser.ParseFile(syms, &pkg, []byte(src))
+	assert.Nil(t, err)
+
+	p, err := NewEnv(syms).CheckPackage(pkg)
+	assert.Nil(t, err)
+	return p
+}
@@ -2,12 +2,15 @@ package types
 
 import (
 	"errors"
+	"fmt"
 	"sync/atomic"
 
 	"github.com/bobappleyard/cezanne/util/slices"
 )
 
 type Type interface {
+	fmt.Stringer
+
 	Apply(e *Subs) Type
 	Copy(seen map[Type]Type) Type
 
@@ -20,12 +23,15 @@ var (
 	ErrWrongCons     = errors.New("wrong constructor")
 	ErrWrongArgCount = errors.New("wrong number of type args")
 	ErrWrongMethods  = errors.New("wrong methods")
+	ErrInfiniteType  = errors.New("infinite type")
+	ErrRigidVar      = errors.New("type parameter does not match")
 )
 
 // Metavar enables polymorphism. It represents a currently-unknown type.
 type Metavar struct {
 	id         uint64
 	constraint Shape
+	rigid      bool
 }
 
 var nextVar uint64
@@ -36,8 +42,37 @@ func NewVar() *Metavar {
 	}
 }
 
+// NewRigidVar creates a variable that stands for a declared type parameter.
+// It may only be unified with itself, or with variables that are not rigid.
+// Copies of it are not rigid.
+func NewRigidVar() *Metavar {
+	v := NewVar()
+	v.rigid = true
+	return v
+}
+
+// Applying a variable that is bound to a type that refers back to the
+// variable produces a Recursive type.
 func (t *Metavar) Apply(e *Subs) Type {
-	return e.Resolve(t)
+	u := e.Resolve(t)
+	if u == t {
+		return t
+	}
+	if r, ok := e.applying[u]; ok {
+		r.used = true
+		return r.binder
+	}
+	if e.applying == nil {
+		e.applying = map[Type]*recursion{}
+	}
+	r := &recursion{binder: NewVar()}
+	e.applying[u] = r
+	body := u.Apply(e)
+	delete(e.applying, u)
+	if !r.used {
+		return body
+	}
+	return &Recursive{Var: r.binder, Body: body}
 }
 
 func (t *Metavar) Unify(e *Subs, u Type) error {
@@ -49,6 +84,15 @@ func (t *Metavar) Unify(e *Subs, u Type) error {
 	if t == u {
 		return nil
 	}
+	if t.rigid {
+		if v, ok := u.(*Metavar); ok && !v.rigid {
+			return v.Unify(e, t)
+		}
+		return ErrRigidVar
+	}
+	if e.occurs(t, u) {
+		return ErrInfiniteType
+	}
 
 	e.VarMeans(t, u)
 	if err := u.Supports(e, t.constraint); err != nil {
@@ -66,6 +110,9 @@ func (t *Metavar) Supports(e *Subs, s Shape) error {
 	if t != rv {
 		return rv.Supports(e, s)
 	}
+	if t.rigid {
+		return fmt.Errorf("%s: %w", s[0].Name, ErrRigidVar)
+	}
 	ss, err := t.constraint.Merge(e, s)
 	if err != nil {
 		return err
@@ -81,6 +128,7 @@ func (t *Metavar) Copy(seen map[Type]Type) Type {
 		return t
 	}
 	u := NewVar()
+	seen[t] = u
 	u.constraint = t.constraint.Copy(seen)
 
 	return u
@@ -132,6 +180,9 @@ func (t *Named) Unify(e *Subs, u Type) error {
 	case *Metavar:
 		return u.Unify(e, t)
 
+	case *Recursive:
+		return u.Unify(e, t)
+
 	case *Named:
 		if u.Cons != t.Cons {
 			return ErrWrongCons
@@ -157,7 +208,11 @@ func (t *Named) Supports(e *Subs, ms Shape) error {
 		if err != nil {
 			return err
 		}
-		seen := createSeen(t.Args)
+		// the constructor's methods are written in terms of its own arguments
+		seen := map[Type]Type{}
+		for i, a := range t.Cons.Args {
+			seen[a] = t.Args[i]
+		}
 		if err := m.Unify(e, n.Copy(seen)); err != nil {
 			return err
 		}
@@ -181,34 +236,48 @@ type Anonymous struct {
 }
 
 func (t *Anonymous) Unify(e *Subs, u Type) error {
+	if t == u {
+		return nil
+	}
 	switch u := u.(type) {
 	case *Metavar:
 		return u.Unify(e, t)
 
+	case *Recursive:
+		return u.Unify(e, t)
+
 	case *Anonymous:
-		if len(t.Methods) != len(u.Methods) {
-			return ErrWrongMethods
+		held, mark := e.assume(t, u)
+		if held {
+			return nil
 		}
+		return e.settle(mark, t.unifyMethods(e, u))
 
-		for i, m := range t.Methods {
-			n := u.Methods[i]
+	default:
+		return ErrWrongKind
+	}
+}
 
-			if m.Name != n.Name {
-				return ErrWrongMethods
-			}
+func (t *Anonymous) unifyMethods(e *Subs, u *Anonymous) error {
+	if len(t.Methods) != len(u.Methods) {
+		return ErrWrongMethods
+	}
 
-			seenT := createSeen(t.Scope)
-			seenU := createSeen(u.Scope)
-			if err := m.Copy(seenT).Unify(e, n.Copy(seenU)); err != nil {
-				return err
-			}
-		}
+	for i, m := range t.Methods {
+		n := u.Methods[i]
 
-		return nil
+		if m.Name != n.Name {
+			return ErrWrongMethods
+		}
 
-	default:
-		return ErrWrongKind
+		seenT := createSeen(t.Scope)
+		seenU := createSeen(u.Scope)
+		if err := m.Copy(seenT).Unify(e, n.Copy(seenU)); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 func (t *Anonymous) Supports(e *Subs, s Shape) error {
@@ -235,7 +304,110 @@ func (t *Anonymous) Copy(seen map[Type]Type) Type {
 }
 
 func (t *Anonymous) Apply(e *Subs) Type {
-	panic("unimplemented")
+	// Variables in scope may have been bound to types containing other
+	// variables, and those must stay in scope.
+	var scope []Type
+	for _, s := range t.Scope {
+		scope = metavars(scope, s.Apply(e))
+	}
+	return &Anonymous{
+		Methods: t.Methods.Apply(e),
+		Scope:   scope,
+	}
+}
+
+// metavars adds the variables that appear in t to acc, if they are not already
+// present.
+func metavars(acc []Type, t Type) []Type {
+	switch t := t.(type) {
+	case *Metavar:
+		for _, u := range acc {
+			if u == Type(t) {
+				return acc
+			}
+		}
+		acc = append(acc, t)
+	case *Named:
+		for _, a := range t.Args {
+			acc = metavars(acc, a)
+		}
+	case *Anonymous:
+		for _, a := range t.Scope {
+			acc = metavars(acc, a)
+		}
+		for _, m := range t.Methods {
+			acc = metavars(acc, m.In)
+			acc = metavars(acc, m.Out)
+			acc = metavars(acc, m.Eff)
+		}
+	case *Recursive:
+		for _, u := range metavars(nil, t.Body) {
+			if u != Type(t.Var) {
+				acc = metavars(acc, u)
+			}
+		}
+	}
+	return acc
+}
+
+// Recursive is an equi-recursive type. It is equal to its body, with the
+// variable standing for the recursive type itself.
+type Recursive struct {
+	Var  *Metavar
+	Body Type
+}
+
+// Unfold replaces the recursive variable in the body.
+func (t *Recursive) Unfold() Type {
+	seen := createSeen(metavars(nil, t.Body))
+	seen[t.Var] = t
+	return t.Body.Copy(seen)
+}
+
+func (t *Recursive) Apply(e *Subs) Type {
+	body := t.Body.Apply(e)
+	if body == t.Body {
+		return t
+	}
+	return &Recursive{Var: t.Var, Body: body}
+}
+
+func (t *Recursive) Copy(seen map[Type]Type) Type {
+	if u := seen[t]; u != nil {
+		return u
+	}
+	// keeping the same type where possible lets unification spot cycles
+	same := true
+	for _, v := range metavars(nil, t) {
+		if seen[v] != v {
+			same = false
+			break
+		}
+	}
+	if same {
+		return t
+	}
+	v := NewVar()
+	seen[t.Var] = v
+	return &Recursive{Var: v, Body: t.Body.Copy(seen)}
+}
+
+func (t *Recursive) Unify(e *Subs, u Type) error {
+	if t == u {
+		return nil
+	}
+	if u, ok := u.(*Metavar); ok {
+		return u.Unify(e, t)
+	}
+	held, mark := e.assume(t, u)
+	if held {
+		return nil
+	}
+	return e.settle(mark, t.Unfold().Unify(e, u))
+}
+
+func (t *Recursive) Supports(e *Subs, s Shape) error {
+	return t.Unfold().Supports(e, s)
 }
 
 func createSeen(args []Type) map[Type]Type {
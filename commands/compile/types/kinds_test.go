@@ -93,3 +93,130 @@ func TestNamedGenericFails(t *testing.T) {
 
 	assert.Equal(t, err, ErrWrongCons)
 }
+
+func TestOccursCheck(t *testing.T) {
+	List := &Constructor{Name: "List", Args: []Type{NewVar()}}
+	a := NewVar()
+
+	e := &Subs{}
+	err := a.Unify(e, &Named{Cons: List, Args: []Type{a}})
+	assert.Equal(t, err, ErrInfiniteType)
+
+	err = a.Unify(e, Tuple(NewVar(), a))
+	assert.Equal(t, err, ErrInfiniteType)
+}
+
+func TestRecursiveObject(t *testing.T) {
+	a := NewVar()
+	obj := &Anonymous{
+		Methods: Shape{{Name: "next", In: Tuple(), Out: a, Eff: NewVar()}},
+		Scope:   []Type{a},
+	}
+
+	e := &Subs{}
+	err := a.Unify(e, obj)
+	assert.Nil(t, err)
+
+	r, ok := a.Apply(e).(*Recursive)
+	assert.True(t, ok)
+	assert.Equal(t, r.String(), "rec a. {next(): a}")
+
+	// the unfolded type is equal to the folded one
+	assert.Nil(t, r.Unify(e, r.Unfold()))
+
+	out := NewVar()
+	err = r.Supports(e, Shape{{Name: "next", In: Tuple(), Out: out, Eff: NewVar()}})
+	assert.Nil(t, err)
+	assert.Equal(t, out.Apply(e).String(), "rec a. {next(): a}")
+}
+
+func TestRecursiveUnify(t *testing.T) {
+	Int := &Named{Cons: IntType}
+	stream := func(elem Type) Type {
+		self := NewVar()
+		return &Recursive{
+			Var: self,
+			Body: &Anonymous{
+				Methods: Shape{
+					{Name: "head", In: Tuple(), Out: elem, Eff: NewVar()},
+					{Name: "tail", In: Tuple(), Out: self, Eff: NewVar()},
+				},
+				Scope: []Type{self, elem},
+			},
+		}
+	}
+
+	e := &Subs{}
+	elem := NewVar()
+	err := stream(Int).Unify(e, stream(elem))
+	assert.Nil(t, err)
+	assert.Equal(t, elem.Apply(e), Type(Int))
+
+	err = stream(Int).Unify(e, stream(&Named{Cons: StringType}))
+	assert.Equal(t, err, ErrWrongCons)
+}
+
+// An assumption made while unifying a pair of recursive types does not outlive
+// a unification that fails.
+func TestRecursiveUnifyFails(t *testing.T) {
+	stream := func(elem Type) Type {
+		self := NewVar()
+		return &Recursive{
+			Var: self,
+			Body: &Anonymous{
+				Methods: Shape{
+					{Name: "next", In: Tuple(), Out: self, Eff: NewVar()},
+					{Name: "value", In: Tuple(), Out: elem, Eff: NewVar()},
+				},
+				Scope: []Type{self, elem},
+			},
+		}
+	}
+	ints, strs := stream(&Named{Cons: IntType}), stream(&Named{Cons: StringType})
+
+	e := &Subs{}
+	err := ints.Unify(e, strs)
+	assert.Equal(t, err, ErrWrongCons)
+	assert.Equal(t, len(e.assumed), 0)
+
+	err = ints.Unify(e, strs)
+	assert.Equal(t, err, ErrWrongCons)
+}
+
+func TestSelfReferentialConstructor(t *testing.T) {
+	Int := &Named{Cons: IntType}
+
+	// class ListImpl[T] {
+	//     head(): T
+	//     tail(): ListImpl[T]
+	//     match[U](v: {cons(head: T, tail: ListImpl[T]): U, null(): U}): U
+	// }
+	T := NewVar()
+	ListImpl := &Constructor{Name: "ListImpl", Args: []Type{T}}
+	self := &Named{Cons: ListImpl, Args: []Type{T}}
+	U := NewVar()
+	visitor := &Anonymous{
+		Methods: Shape{
+			{Name: "cons", In: Tuple(T, self), Out: U, Eff: NewVar()},
+			{Name: "null", In: Tuple(), Out: U, Eff: NewVar()},
+		},
+		Scope: []Type{T, U},
+	}
+	ListImpl.Methods = Shape{
+		{Name: "head", In: Tuple(), Out: T, Eff: NewVar()},
+		{Name: "match", In: Tuple(visitor), Out: U, Eff: NewVar()},
+		{Name: "tail", In: Tuple(), Out: self, Eff: NewVar()},
+	}
+
+	e := &Subs{}
+	xs, tail, head := NewVar(), NewVar(), NewVar()
+	assert.Nil(t, xs.Supports(e, Shape{{Name: "tail", In: Tuple(), Out: tail, Eff: NewVar()}}))
+	assert.Nil(t, tail.Supports(e, Shape{{Name: "head", In: Tuple(), Out: head, Eff: NewVar()}}))
+
+	ints, err := ListImpl.WithArgs(e, []Type{Int})
+	assert.Nil(t, err)
+	assert.Nil(t, xs.Unify(e, ints))
+
+	assert.Equal(t, head.Apply(e), Type(Int))
+	assert.Equal(t, tail.Apply(e).String(), "ListImpl[Int]")
+}
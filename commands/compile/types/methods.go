@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+
+	"github.com/bobappleyard/cezanne/util/slices"
 )
 
 type Method struct {
@@ -36,20 +38,15 @@ func (ms Shape) Merge(e *Subs, more Shape) (Shape, error) {
 	copy(res, ms)
 	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
 
-next:
 	for _, m := range more {
 		idx := sort.Search(len(res), func(i int) bool { return res[i].Name >= m.Name })
-		for ; idx < len(res) && res[idx].Name == m.Name; idx++ {
-			n := res[idx]
-			if m.In == n.In {
-				if err := m.Out.Unify(e, n.Out); err != nil {
-					return nil, err
-				}
-				if err := m.Eff.Unify(e, n.Eff); err != nil {
-					return nil, err
-				}
-				continue next
+		// methods are identified by name, so any others with this name must
+		// agree with it
+		if idx < len(res) && res[idx].Name == m.Name {
+			if err := m.Unify(e, res[idx]); err != nil {
+				return nil, err
 			}
+			continue
 		}
 		res = append(res, Method{})
 		copy(res[idx+1:], res[idx:])
@@ -59,6 +56,19 @@ next:
 	return res, nil
 }
 
+func (ms Shape) Apply(e *Subs) Shape {
+	return slices.Map(ms, func(m Method) Method { return m.Apply(e) })
+}
+
+func (m Method) Apply(e *Subs) Method {
+	return Method{
+		Name: m.Name,
+		In:   m.In.Apply(e),
+		Out:  m.Out.Apply(e),
+		Eff:  m.Eff.Apply(e),
+	}
+}
+
 func (m Method) Copy(seen map[Type]Type) Method {
 	return Method{
 		Name: m.Name,
@@ -80,3 +90,19 @@ func (m Method) Unify(e *Subs, n Method) error {
 	}
 	return nil
 }
+
+// MethodsOf gives the methods that values of type t are known to have. For a
+// variable these are the methods it has been required to support.
+func MethodsOf(t Type) Shape {
+	switch t := t.(type) {
+	case *Metavar:
+		return t.constraint
+	case *Named:
+		return t.Cons.Methods
+	case *Anonymous:
+		return t.Methods
+	case *Recursive:
+		return MethodsOf(t.Body)
+	}
+	return nil
+}
@@ -1,13 +1,26 @@
 package types
 
-import "github.com/bobappleyard/cezanne/commands/compile/ast"
+import (
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
 
 type Package struct {
 	Exports Type
 	Types   map[string]*Constructor
+
+	// Positions gives the types of the variables and methods in the source,
+	// keyed by where they are defined or referred to. Methods are given
+	// function types.
+	Positions map[ast.Pos]Type
+
+	// the substitutions made while checking the package, if it was checked
+	// rather than decoded
+	subs *Subs
 }
 
 type Env struct {
+	syms *symtab.Symtab
 	vars map[string]Type
 	cons map[qname]*Constructor
 }
@@ -16,6 +29,22 @@ type qname struct {
 	pkg, sym string
 }
 
+func NewEnv(syms *symtab.Symtab) *Env {
+	e := &Env{
+		syms: syms,
+		vars: map[string]Type{},
+		cons: map[qname]*Constructor{},
+	}
+	e.DeclareType("Int", IntType)
+	e.DeclareType("Float", FloatType)
+	e.DeclareType("String", StringType)
+	e.DeclareType("Bool", BoolType)
+	e.DeclareType("Array", ArrayType)
+	e.DeclareType("Map", MapType)
+	e.DeclareType("Set", SetType)
+	return e
+}
+
 func (e *Env) ImportPackage(p *Package, as string) {
 	e.vars[as] = p.Exports
 	for n, c := range p.Types {
@@ -27,6 +56,51 @@ func (e *Env) DeclareType(name string, cons *Constructor) {
 	e.cons[qname{sym: name}] = cons
 }
 
+// TypeOf infers the type of an expression that only refers to imported
+// packages.
 func (e *Env) TypeOf(x ast.Expr) (Type, error) {
-	return nil, nil
+	c := e.checker()
+	t, err := c.infer(x)
+	if err != nil {
+		return nil, err
+	}
+	return t.Apply(c.subs), nil
+}
+
+// CheckPackage infers types for every function in the package and checks them
+// against any annotations that were provided. Each import that has not been
+// given to ImportPackage is a single monomorphic type variable, so every use
+// of it in the package must agree on its type.
+func (e *Env) CheckPackage(pkg ast.Package) (*Package, error) {
+	c := e.checker()
+	c.positions = map[ast.Pos]Type{}
+	for _, imp := range pkg.Imports {
+		t, ok := e.vars[e.syms.SymbolName(imp.Name)]
+		if !ok {
+			t = NewVar()
+		}
+		c.vars[imp.Name] = Mono(t)
+	}
+	exports, err := c.checkFuncs(pkg.Funcs)
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[ast.Pos]Type, len(c.positions))
+	for pos, t := range c.positions {
+		positions[pos] = t.Apply(c.subs)
+	}
+	return &Package{Exports: exports, Positions: positions, subs: c.subs}, nil
+}
+
+func (e *Env) checker() *checker {
+	c := &checker{
+		env:  e,
+		subs: &Subs{},
+		vars: map[symtab.Symbol]*Scheme{},
+		eff:  NewVar(),
+	}
+	for n, t := range e.vars {
+		c.vars[e.syms.SymbolID(n)] = Mono(t)
+	}
+	return c
 }
@@ -0,0 +1,181 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Printer renders types as text. Variables are named a, b, c... in the order
+// they are first printed, and a printer remembers the names it has given out,
+// so using one printer for all the types in a message keeps them consistent.
+type Printer struct {
+	subs   *Subs
+	names  map[*Metavar]string
+	active map[*Metavar]bool
+	cycles map[*Metavar]bool
+}
+
+// NewPrinter creates a printer that applies the substitutions in e as it
+// prints. e may be nil.
+func NewPrinter(e *Subs) *Printer {
+	return &Printer{
+		subs:   e,
+		names:  map[*Metavar]string{},
+		active: map[*Metavar]bool{},
+		cycles: map[*Metavar]bool{},
+	}
+}
+
+func (p *Printer) Type(t Type) string {
+	var b strings.Builder
+	p.writeType(&b, t)
+	return b.String()
+}
+
+func (p *Printer) Shape(s Shape) string {
+	var b strings.Builder
+	p.writeShape(&b, s)
+	return b.String()
+}
+
+func (p *Printer) name(v *Metavar) string {
+	if n, ok := p.names[v]; ok {
+		return n
+	}
+	i := len(p.names)
+	n := string(rune('a' + i%26))
+	if i >= 26 {
+		n += fmt.Sprint(i / 26)
+	}
+	p.names[v] = n
+	return n
+}
+
+func (p *Printer) writeType(b *strings.Builder, t Type) {
+	switch t := t.(type) {
+	case *Metavar:
+		p.writeVar(b, t)
+	case *Named:
+		p.writeNamed(b, t)
+	case *Anonymous:
+		p.writeShape(b, t.Methods)
+	case *Recursive:
+		b.WriteString("rec ")
+		b.WriteString(p.name(t.Var))
+		b.WriteString(". ")
+		p.writeType(b, t.Body)
+	default:
+		fmt.Fprint(b, t)
+	}
+}
+
+// Bound variables are printed as the type they are bound to. A type that
+// refers to itself is introduced with rec.
+func (p *Printer) writeVar(b *strings.Builder, v *Metavar) {
+	var u Type = v
+	if p.subs != nil {
+		u = p.subs.Resolve(v)
+	}
+	if u == Type(v) || p.active[v] {
+		if p.active[v] {
+			p.cycles[v] = true
+		}
+		b.WriteString(p.name(v))
+		return
+	}
+
+	p.active[v] = true
+	var inner strings.Builder
+	p.writeType(&inner, u)
+	delete(p.active, v)
+
+	if p.cycles[v] {
+		b.WriteString("rec ")
+		b.WriteString(p.name(v))
+		b.WriteString(". ")
+	}
+	b.WriteString(inner.String())
+}
+
+func (p *Printer) writeNamed(b *strings.Builder, t *Named) {
+	if t.Cons == tupleCons(len(t.Args)) {
+		b.WriteString("(")
+		p.writeList(b, t.Args)
+		b.WriteString(")")
+		return
+	}
+	b.WriteString(t.Cons.Name)
+	if len(t.Args) == 0 {
+		return
+	}
+	b.WriteString("[")
+	p.writeList(b, t.Args)
+	b.WriteString("]")
+}
+
+func (p *Printer) writeList(b *strings.Builder, ts []Type) {
+	for i, t := range ts {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		p.writeType(b, t)
+	}
+}
+
+func (p *Printer) writeShape(b *strings.Builder, s Shape) {
+	b.WriteString("{")
+	for i, m := range s {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		p.writeMethod(b, m)
+	}
+	b.WriteString("}")
+}
+
+func (p *Printer) writeMethod(b *strings.Builder, m Method) {
+	b.WriteString(m.Name)
+	if in, ok := p.resolve(m.In).(*Named); ok && in.Cons == tupleCons(len(in.Args)) {
+		p.writeType(b, m.In)
+	} else {
+		b.WriteString("(...")
+		p.writeType(b, m.In)
+		b.WriteString(")")
+	}
+	b.WriteString(": ")
+	p.writeType(b, m.Out)
+
+	// an effect that nothing is known about is left out
+	if v, ok := p.resolve(m.Eff).(*Metavar); ok && len(v.constraint) == 0 {
+		return
+	}
+	b.WriteString(" in ")
+	p.writeType(b, m.Eff)
+}
+
+func (p *Printer) resolve(t Type) Type {
+	if p.subs == nil {
+		return t
+	}
+	return p.subs.Resolve(t)
+}
+
+func (t *Metavar) String() string {
+	return NewPrinter(nil).Type(t)
+}
+
+func (t *Named) String() string {
+	return NewPrinter(nil).Type(t)
+}
+
+func (t *Anonymous) String() string {
+	return NewPrinter(nil).Type(t)
+}
+
+func (t *Recursive) String() string {
+	return NewPrinter(nil).Type(t)
+}
+
+func (s Shape) String() string {
+	return NewPrinter(nil).Shape(s)
+}
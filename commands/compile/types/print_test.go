@@ -0,0 +1,90 @@
+package types
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestPrint(t *testing.T) {
+	Int := &Named{Cons: IntType}
+	List := &Constructor{Name: "List", Args: []Type{NewVar()}}
+	a, b, eff := NewVar(), NewVar(), NewVar()
+	eff.constraint = Shape{{Name: "Write", In: Tuple(Int), Out: Tuple(), Eff: NewVar()}}
+
+	p := NewPrinter(nil)
+
+	assert.Equal(t, p.Type(a), "a")
+	assert.Equal(t, p.Type(&Named{Cons: List, Args: []Type{b}}), "List[b]")
+	assert.Equal(t, p.Type(Tuple(a, Int)), "(a, Int)")
+	assert.Equal(t, p.Type(&Anonymous{Methods: Shape{
+		{Name: "f", In: Tuple(a), Out: b, Eff: NewVar()},
+		{Name: "g", In: Tuple(), Out: Int, Eff: eff},
+	}}), "{f(a): b, g(): Int in c}")
+	assert.Equal(t, p.Type(a), "a")
+
+	assert.Equal(t, Shape{{Name: "f", In: Tuple(b), Out: b, Eff: NewVar()}}.String(), "{f(a): a}")
+}
+
+func TestPrintSubstituted(t *testing.T) {
+	Int := &Named{Cons: IntType}
+	a, b := NewVar(), NewVar()
+
+	e := &Subs{}
+	e.VarMeans(a, Tuple(b, Int))
+
+	assert.Equal(t, NewPrinter(e).Type(a), "(a, Int)")
+	assert.Equal(t, NewPrinter(nil).Type(a), "a")
+}
+
+func TestPrintRecursive(t *testing.T) {
+	self := NewVar()
+	obj := &Anonymous{
+		Methods: Shape{{Name: "next", In: Tuple(), Out: self, Eff: NewVar()}},
+		Scope:   []Type{self},
+	}
+
+	e := &Subs{}
+	e.VarMeans(self, obj)
+
+	assert.Equal(t, NewPrinter(e).Type(self), "rec a. {next(): a}")
+}
+
+func TestAnonymousApply(t *testing.T) {
+	Int := &Named{Cons: IntType}
+	a, b, c := NewVar(), NewVar(), NewVar()
+	obj := &Anonymous{
+		Methods: Shape{{Name: "get", In: Tuple(), Out: a, Eff: c}},
+		Scope:   []Type{a, c},
+	}
+
+	e := &Subs{}
+	e.VarMeans(a, Tuple(b, Int))
+
+	applied := obj.Apply(e).(*Anonymous)
+	assert.Equal(t, applied.Methods[0].Out, Tuple(b, Int))
+	assert.Equal(t, applied.Scope, []Type{b, c})
+}
+
+func TestErrorMessage(t *testing.T) {
+	var syms symtab.Symtab
+	var pkg ast.Package
+
+	err := parser.ParseFile(&syms, &pkg, []byte(`
+	func main() {
+		id("hello")
+	}
+
+	func id(x: Int): Int { x }
+	`))
+	assert.Nil(t, err)
+
+	_, err = NewEnv(&syms).CheckPackage(pkg)
+	assert.True(t, errors.Is(err, ErrWrongCons))
+	assert.True(t, strings.Contains(err.Error(), "{call(Int): Int} does not support {call(String): a}"))
+}
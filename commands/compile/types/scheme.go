@@ -0,0 +1,110 @@
+package types
+
+// Scheme is a type that may be polymorphic in some of its variables. Each use
+// of the scheme gets fresh copies of those variables.
+type Scheme struct {
+	Vars []*Metavar
+	Type Type
+}
+
+// Mono creates a scheme that is not polymorphic at all.
+func Mono(t Type) *Scheme {
+	return &Scheme{Type: t}
+}
+
+// Generalize creates a scheme that is polymorphic in those variables of t that
+// do not appear in env.
+func Generalize(e *Subs, t Type, env []Type) *Scheme {
+	fixed := map[*Metavar]bool{}
+	for _, v := range freeVars(e, env) {
+		fixed[v] = true
+	}
+	t = t.Apply(e)
+	var vars []*Metavar
+	for _, v := range freeVars(e, []Type{t}) {
+		if fixed[v] {
+			continue
+		}
+		vars = append(vars, v)
+	}
+	return &Scheme{Vars: vars, Type: t}
+}
+
+// Instantiate gives a type for one use of the scheme.
+func (s *Scheme) Instantiate(e *Subs) Type {
+	if len(s.Vars) == 0 {
+		return s.Type
+	}
+	generic := map[*Metavar]bool{}
+	for _, v := range s.Vars {
+		generic[v] = true
+	}
+	w := &varWalker{subs: e, seen: map[Type]bool{}}
+	w.walk(s.Type)
+	seen := map[Type]Type{}
+	for _, v := range w.all {
+		if generic[v] {
+			continue
+		}
+		seen[v] = v
+	}
+	return s.Type.Copy(seen)
+}
+
+// freeVars finds the variables that are not bound by e and that can be reached
+// from ts, including through the constraints on other variables.
+func freeVars(e *Subs, ts []Type) []*Metavar {
+	w := &varWalker{subs: e, seen: map[Type]bool{}}
+	for _, t := range ts {
+		w.walk(t)
+	}
+	return w.free
+}
+
+type varWalker struct {
+	subs *Subs
+	seen map[Type]bool
+	all  []*Metavar
+	free []*Metavar
+}
+
+func (w *varWalker) walk(t Type) {
+	if w.seen[t] {
+		return
+	}
+	w.seen[t] = true
+
+	switch t := t.(type) {
+	case *Metavar:
+		w.all = append(w.all, t)
+		if u := w.subs.Resolve(t); u != Type(t) {
+			w.walk(u)
+			return
+		}
+		w.free = append(w.free, t)
+		w.walkShape(t.constraint)
+
+	case *Named:
+		for _, a := range t.Args {
+			w.walk(a)
+		}
+
+	case *Anonymous:
+		// other variables in the methods are already polymorphic
+		for _, s := range t.Scope {
+			w.walk(s)
+		}
+
+	case *Recursive:
+		w.seen[t.Var] = true
+		w.walk(t.Body)
+	}
+}
+
+func (w *varWalker) walkShape(s Shape) {
+	for _, m := range s {
+		w.walk(m.In)
+		w.walk(m.Out)
+		w.walk(m.Eff)
+	}
+}
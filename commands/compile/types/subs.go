@@ -6,6 +6,21 @@ import (
 
 type Subs struct {
 	equalities table[Type]
+
+	// pairs of types that are taken to be equal while unifying recursive types
+	assumed []assumption
+
+	// types bound to variables that are being applied, to detect cycles
+	applying map[Type]*recursion
+}
+
+type assumption struct {
+	left, right Type
+}
+
+type recursion struct {
+	binder *Metavar
+	used   bool
 }
 
 func (e *Subs) Resolve(t Type) Type {
@@ -26,6 +41,49 @@ func (e *Subs) VarMeans(v *Metavar, t Type) {
 	e.equalities = e.equalities.add(v, t)
 }
 
+// Unifying recursive types eventually comes back to a pair of types that are
+// already being unified. Such a pair is assumed to be equal, and assume
+// reports whether that is the case. Otherwise the pair is assumed from now on,
+// and mark is where the assumption was made, to give to settle.
+func (e *Subs) assume(t, u Type) (held bool, mark int) {
+	for _, a := range e.assumed {
+		if (a.left == t && a.right == u) || (a.left == u && a.right == t) {
+			return true, len(e.assumed)
+		}
+	}
+	mark = len(e.assumed)
+	e.assumed = append(e.assumed, assumption{left: t, right: u})
+	return false, mark
+}
+
+// settle keeps the assumptions made since mark if the unification they were
+// made for succeeded, and withdraws them if it failed.
+func (e *Subs) settle(mark int, err error) error {
+	if err != nil {
+		e.assumed = e.assumed[:mark]
+	}
+	return err
+}
+
+// Does v occur in t in a way that would make binding v to t create an
+// infinite type? Occurrences inside object types are allowed, as those are
+// equi-recursive.
+func (e *Subs) occurs(v *Metavar, t Type) bool {
+	switch t := e.Resolve(t).(type) {
+	case *Metavar:
+		return t == v
+	case *Named:
+		for _, a := range t.Args {
+			if e.occurs(v, a) {
+				return true
+			}
+		}
+	case *Recursive:
+		return e.occurs(v, t.Body)
+	}
+	return false
+}
+
 type table[T any] struct {
 	ids  []*Metavar
 	vals []T
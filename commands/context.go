@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownOption = errors.New("unknown option")
+	ErrMissingValue  = errors.New("missing option value")
+)
+
+// NewContext creates a context from command line arguments. Options are
+// written -name value or -name=value, and boolean options may be written
+// -name on their own. Options may appear anywhere before a -- argument, and
+// the remaining arguments are left for the command.
+func NewContext(name string, args []string) Context {
+	return &argContext{name: name, args: args, rest: args}
+}
+
+type argContext struct {
+	name string
+	args []string
+	rest []string
+}
+
+func (c *argContext) Name() string {
+	return c.name
+}
+
+func (c *argContext) Args() []string {
+	return c.rest
+}
+
+// BindOptions sets fields of the struct that options points to, according to
+// their option tags.
+func (c *argContext) BindOptions(options any) error {
+	v := reflect.ValueOf(options).Elem()
+	fields := map[string]reflect.Value{}
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		if name, ok := f.Tag.Lookup("option"); ok {
+			fields[name] = v.FieldByIndex(f.Index)
+		}
+	}
+
+	var rest []string
+	for i := 0; i < len(c.args); i++ {
+		arg := c.args[i]
+		if arg == "--" {
+			rest = append(rest, c.args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			rest = append(rest, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg[1:], "=")
+		f, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("-%s: %w", name, ErrUnknownOption)
+		}
+		if !hasValue && f.Kind() == reflect.Bool {
+			value, hasValue = "true", true
+		}
+		if !hasValue {
+			if i+1 == len(c.args) {
+				return fmt.Errorf("-%s: %w", name, ErrMissingValue)
+			}
+			i++
+			value = c.args[i]
+		}
+		if err := setOption(f, value); err != nil {
+			return fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+
+	c.rest = rest
+	return nil
+}
+
+func setOption(f reflect.Value, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+
+	default:
+		return fmt.Errorf("%s: unsupported option type", f.Type())
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestBindOptions(t *testing.T) {
+	type options struct {
+		Output  string `option:"o"`
+		Verbose bool   `option:"v"`
+		Depth   int    `option:"depth"`
+		Ignored string
+	}
+
+	for _, test := range []struct {
+		name string
+		args []string
+		opts options
+		rest []string
+		err  error
+	}{
+		{
+			name: "NoOptions",
+			args: []string{"a.cz", "b.cz"},
+			rest: []string{"a.cz", "b.cz"},
+		},
+		{
+			name: "Mixed",
+			args: []string{"-o", "out", "a.cz", "-v", "-depth=3", "b.cz"},
+			opts: options{Output: "out", Verbose: true, Depth: 3},
+			rest: []string{"a.cz", "b.cz"},
+		},
+		{
+			name: "EndOfOptions",
+			args: []string{"-v=false", "--", "-o"},
+			rest: []string{"-o"},
+		},
+		{
+			name: "Unknown",
+			args: []string{"-x"},
+			err:  ErrUnknownOption,
+		},
+		{
+			name: "Missing",
+			args: []string{"a.cz", "-o"},
+			err:  ErrMissingValue,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := NewContext("test", test.args)
+			var opts options
+			err := ctx.BindOptions(&opts)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err))
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, opts, test.opts)
+			assert.Equal(t, ctx.Args(), test.rest)
+		})
+	}
+}
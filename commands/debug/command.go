@@ -0,0 +1,57 @@
+package debug
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bobappleyard/cezanne/commands"
+	"github.com/bobappleyard/cezanne/commands/link"
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/core"
+	"github.com/bobappleyard/cezanne/runtime/env"
+)
+
+type Options struct {
+	// Directory containing compiled packages, named by import path.
+	Packages string `option:"p"`
+}
+
+var ErrOneFile = errors.New("expected one package file")
+
+func init() {
+	commands.Register("debug", Debug)
+}
+
+// Debug links a main package with the packages that it imports, and runs the
+// program under the debugger. Commands are read from standard input.
+func Debug(options Options, files []string) error {
+	if len(files) != 1 {
+		return ErrOneFile
+	}
+	var syms symtab.Symtab
+	prog, err := link.Link(&syms, link.Dir{Main: files[0], Path: options.Packages, Symbols: &syms})
+	if err != nil {
+		return err
+	}
+	e := new(env.Env)
+	core.Install(e)
+	return run(e, &syms, prog, os.Stdin, os.Stdout)
+}
+
+func run(e *env.Env, syms *symtab.Symtab, prog *format.Program, in io.Reader, out io.Writer) error {
+	d, err := New(prog, syms, in, out)
+	if err != nil {
+		return err
+	}
+	e.SetStepHook(d.Hook)
+	if err := e.Run(syms, prog); err != nil {
+		return err
+	}
+	if !d.quit {
+		fmt.Fprintln(out, "program finished")
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+// Package debug provides a command that runs programs under an interactive
+// debugger.
+package debug
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/format/verify"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/env"
+)
+
+var (
+	ErrNoLocation   = errors.New("no such method or line")
+	ErrNoBreakpoint = errors.New("no such breakpoint")
+	ErrNoFrame      = errors.New("no such frame")
+	ErrUnknown      = errors.New("unknown command")
+)
+
+// Debugger controls a thread through its step hook. The program stops before
+// its first instruction, and then whenever a breakpoint is reached or a step
+// is complete. While it is stopped, commands are read from the input and their
+// results written to the output.
+type Debugger struct {
+	prog *format.Program
+	syms *symtab.Symtab
+	in   *bufio.Scanner
+	out  io.Writer
+
+	methods []verify.Method
+
+	// the method that each instruction belongs to, and the offsets that start
+	// a new position in the source
+	owner  map[int]*verify.Method
+	starts map[int]bool
+
+	breaks []breakpoint
+	nextID int
+
+	// how the program was last resumed, and the frame that was running then
+	mode     mode
+	from     env.Frame
+	resuming bool
+	quit     bool
+	last     string
+
+	// the receiver of each running frame that has one, by where the frame
+	// starts, and the highest of those frames. The receivers are pinned, so
+	// that collections keep them up to date.
+	receivers   [env.StackSize]api.Object
+	hasReceiver [env.StackSize]bool
+	top         int
+	pinned      bool
+}
+
+type mode int
+
+const (
+	stepInstruction mode = iota
+	stepLine
+	stepOver
+	finish
+	continuing
+)
+
+// breakpoint stops the program before any of the instructions at its offsets.
+type breakpoint struct {
+	id      int
+	where   string
+	offsets []int
+}
+
+func New(prog *format.Program, syms *symtab.Symtab, in io.Reader, out io.Writer) (*Debugger, error) {
+	methods, err := verify.Methods(prog)
+	if err != nil {
+		return nil, err
+	}
+	d := &Debugger{
+		prog:    prog,
+		syms:    syms,
+		in:      bufio.NewScanner(in),
+		out:     out,
+		methods: methods,
+		owner:   map[int]*verify.Method{},
+		starts:  map[int]bool{},
+		nextID:  1,
+	}
+	for i := range d.methods {
+		m := &d.methods[i]
+		for _, pos := range m.Code {
+			d.owner[pos] = m
+		}
+	}
+	for _, e := range prog.Lines.Entries {
+		if e.File >= 0 {
+			d.starts[int(e.Offset)] = true
+		}
+	}
+	return d, nil
+}
+
+// Hook is the thread's step hook. It returns false when the user quits.
+func (d *Debugger) Hook(t *env.Thread) bool {
+	pos := t.CodePos()
+	frame := t.Frame()
+	d.trackReceiver(t, frame)
+	hit := d.breakpointAt(pos)
+	if !d.stop(frame) && hit == nil {
+		return true
+	}
+	if hit != nil {
+		fmt.Fprintf(d.out, "breakpoint %d, ", hit.id)
+	}
+	d.printFrame(t, 0, frame)
+	return d.prompt(t)
+}
+
+// The receiver is only in the value register as a method starts, so it is kept
+// for as long as the frame is running. Frames above the running one have
+// returned or been unwound. The receivers are pinned at the first step, before
+// any method has pinned anything, so they stay pinned for the rest of the run.
+func (d *Debugger) trackReceiver(t *env.Thread, frame env.Frame) {
+	if !d.pinned {
+		t.PinAll(d.receivers[:])
+		d.pinned = true
+	}
+	for ; d.top > frame.Base; d.top-- {
+		d.receivers[d.top] = api.Object{}
+		d.hasReceiver[d.top] = false
+	}
+	if m := d.owner[frame.CodePos]; m != nil && m.Start == frame.CodePos && m.Class >= 0 {
+		d.receivers[frame.Base] = t.Value()
+		d.hasReceiver[frame.Base] = true
+		d.top = frame.Base
+	}
+}
+
+// The instruction that the program was resumed at is run before stopping
+// again.
+func (d *Debugger) stop(frame env.Frame) bool {
+	if d.resuming {
+		d.resuming = false
+		return false
+	}
+	pos := frame.CodePos
+	switch d.mode {
+	case stepInstruction:
+		return true
+	case stepLine:
+		return d.starts[pos] || frame.Base < d.from.Base
+	case stepOver:
+		return frame.Base < d.from.Base || frame.Base == d.from.Base && d.starts[pos]
+	case finish:
+		return frame.Base < d.from.Base
+	}
+	return false
+}
+
+func (d *Debugger) breakpointAt(pos int) *breakpoint {
+	if d.resuming {
+		return nil
+	}
+	for i, b := range d.breaks {
+		for _, at := range b.offsets {
+			if at == pos {
+				return &d.breaks[i]
+			}
+		}
+	}
+	return nil
+}
+
+// prompt reads commands until one of them resumes the program.
+func (d *Debugger) prompt(t *env.Thread) bool {
+	for {
+		fmt.Fprint(d.out, "(cz) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			d.quit = true
+			return false
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		resume, err := d.command(t, fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintf(d.out, "error: %s\n", err)
+			continue
+		}
+		switch resume {
+		case quit:
+			d.quit = true
+			return false
+		case resumed:
+			d.from = t.Frame()
+			d.resuming = true
+			return true
+		}
+	}
+}
+
+type result int
+
+const (
+	stay result = iota
+	resumed
+	quit
+)
+
+func (d *Debugger) command(t *env.Thread, name string, args []string) (result, error) {
+	switch name {
+	case "help", "h":
+		d.help()
+
+	case "break", "b":
+		if len(args) != 1 {
+			return stay, fmt.Errorf("break: expected a method name or file:line")
+		}
+		return stay, d.addBreakpoint(args[0])
+
+	case "delete", "d":
+		if len(args) != 1 {
+			return stay, fmt.Errorf("delete: expected a breakpoint number")
+		}
+		return stay, d.deleteBreakpoint(args[0])
+
+	case "breakpoints":
+		for _, b := range d.breaks {
+			fmt.Fprintf(d.out, "%d: %s\n", b.id, b.where)
+		}
+
+	case "continue", "c":
+		d.mode = continuing
+		return resumed, nil
+
+	case "step", "s":
+		d.mode = stepLine
+		return resumed, nil
+
+	case "next", "n":
+		d.mode = stepOver
+		return resumed, nil
+
+	case "stepi", "si":
+		d.mode = stepInstruction
+		return resumed, nil
+
+	case "finish", "fin":
+		d.mode = finish
+		return resumed, nil
+
+	case "frame", "f":
+		n := 0
+		if len(args) == 1 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil {
+				return stay, fmt.Errorf("%s: %w", args[0], ErrNoFrame)
+			}
+		}
+		return stay, d.showFrame(t, n)
+
+	case "stack", "bt":
+		for i, f := range t.Frames() {
+			d.printFrame(t, i, f)
+		}
+
+	case "handlers":
+		for i, h := range t.Handlers() {
+			fmt.Fprintf(d.out, "%d: %s\n", i, d.describe(t.Process(), h, 1))
+		}
+
+	case "quit", "q":
+		return quit, nil
+
+	default:
+		return stay, fmt.Errorf("%s: %w", name, ErrUnknown)
+	}
+	return stay, nil
+}
+
+func (d *Debugger) help() {
+	fmt.Fprint(d.out, `break METHOD|FILE:LINE  stop when a method is called or a line is reached
+delete N                remove a breakpoint
+breakpoints             list the breakpoints
+continue                run until a breakpoint
+step                    run to the next line, entering calls
+next                    run to the next line in this method
+stepi                   run one instruction
+finish                  run until this method returns
+frame [N]               show the registers of a frame
+stack                   show the call stack
+handlers                show the handler contexts, innermost first
+quit                    stop the program
+`)
+}
+
+// Breakpoints are either on every implementation of a method, or on a line of
+// a file. Files can be given by their base name.
+func (d *Debugger) addBreakpoint(where string) error {
+	var offsets []int
+	if i := strings.LastIndex(where, ":"); i != -1 {
+		line, err := strconv.Atoi(where[i+1:])
+		if err == nil {
+			offsets = d.lineOffsets(where[:i], line)
+		}
+	}
+	if offsets == nil {
+		for _, m := range d.methods {
+			if m.Class >= 0 && (m.Name == where || d.methodName(m.Method) == where) {
+				offsets = append(offsets, m.Start)
+			}
+		}
+	}
+	if offsets == nil {
+		return fmt.Errorf("%s: %w", where, ErrNoLocation)
+	}
+	b := breakpoint{id: d.nextID, where: where, offsets: offsets}
+	d.nextID++
+	d.breaks = append(d.breaks, b)
+	fmt.Fprintf(d.out, "breakpoint %d at %s\n", b.id, where)
+	return nil
+}
+
+func (d *Debugger) lineOffsets(file string, line int) []int {
+	var res []int
+	for _, e := range d.prog.Lines.Entries {
+		if e.File < 0 || int(e.Line) != line {
+			continue
+		}
+		name := d.prog.Lines.Files[e.File]
+		if name == file || filepath.Base(name) == file {
+			res = append(res, int(e.Offset))
+		}
+	}
+	return res
+}
+
+func (d *Debugger) deleteBreakpoint(arg string) error {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", arg, ErrNoBreakpoint)
+	}
+	for i, b := range d.breaks {
+		if b.id == id {
+			d.breaks = append(d.breaks[:i], d.breaks[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%d: %w", id, ErrNoBreakpoint)
+}
+
+func (d *Debugger) methodName(id format.MethodID) string {
+	if int(id) >= len(d.prog.Methods) {
+		return ""
+	}
+	return d.syms.SymbolName(d.prog.Methods[id].Name)
+}
+
+// A frame is described by the method it is in and where it is in the source.
+// Frames other than the running one are waiting for a call to return, so
+// their position is that of the call.
+func (d *Debugger) printFrame(t *env.Thread, n int, f env.Frame) {
+	name := "?"
+	if m := d.owner[f.CodePos]; m != nil {
+		name = m.Name
+	}
+	at := f.CodePos
+	if n != 0 {
+		at--
+	}
+	fmt.Fprintf(d.out, "#%d %s", n, name)
+	if pos, ok := d.prog.Lines.Lookup(at); ok {
+		fmt.Fprintf(d.out, " at %s", pos)
+	}
+	fmt.Fprintf(d.out, ", pc %d\n", f.CodePos)
+}
+
+func (d *Debugger) showFrame(t *env.Thread, n int) error {
+	frames := t.Frames()
+	if n < 0 || n >= len(frames) {
+		return fmt.Errorf("%d: %w", n, ErrNoFrame)
+	}
+	f := frames[n]
+	d.printFrame(t, n, f)
+
+	m := d.owner[f.CodePos]
+	if m == nil {
+		return nil
+	}
+	for i, r := range t.Registers(f, m.FrameSize) {
+		fmt.Fprintf(d.out, "  r%d = %s\n", i, d.describe(t.Process(), r, 1))
+	}
+	if d.hasReceiver[f.Base] && m.Class >= 0 {
+		fmt.Fprintf(d.out, "  this = %s\n", d.describe(t.Process(), d.receivers[f.Base], 2))
+	}
+	return nil
+}
+
+// describe writes an object out, along with its fields down to a given depth.
+// Registers may hold values left over from earlier frames, so anything that
+// cannot be read is reported as such.
+func (d *Debugger) describe(p *env.Process, x api.Object, depth int) (res string) {
+	defer func() {
+		if recover() != nil {
+			res = "<invalid>"
+		}
+	}()
+
+	var fields []api.Object
+	var name string
+	if x.Class < 0 {
+		fields = p.AsArray(x)
+	} else {
+		if int(x.Class) >= len(d.prog.Classes) {
+			return "<invalid>"
+		}
+		c := d.prog.Classes[x.Class]
+		switch c.Kind {
+		case format.IntKind:
+			return strconv.Itoa(p.AsInt(x))
+		case format.BigIntKind:
+			return p.AsBigInt(x).String()
+		case format.FloatKind:
+			return strconv.FormatFloat(p.AsFloat(x), 'g', -1, 64)
+		case format.TrueKind:
+			return "true"
+		case format.FalseKind:
+			return "false"
+		case format.StringKind:
+			return strconv.Quote(p.AsString(x))
+		}
+		name = fmt.Sprintf("c%d", x.Class)
+		if c.Name.ID != 0 {
+			name = d.syms.SymbolName(c.Name)
+		}
+		for i := 0; i < p.FieldCount(x.Class); i++ {
+			fields = append(fields, p.Field(x, i))
+		}
+	}
+
+	open, close := "{", "}"
+	if x.Class < 0 {
+		open, close = "[", "]"
+	}
+	if len(fields) == 0 {
+		return name + open + close
+	}
+	if depth == 0 {
+		return name + open + "..." + close
+	}
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = d.describe(p, f, depth-1)
+	}
+	return name + open + strings.Join(parts, ", ") + close
+}
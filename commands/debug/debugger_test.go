@@ -0,0 +1,164 @@
+package debug
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/asm"
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/backend"
+	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/commands/link"
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/env"
+	"github.com/bobappleyard/cezanne/util/assert"
+	"github.com/bobappleyard/cezanne/util/must"
+)
+
+type testLinkerEnv map[string]*format.Package
+
+// LoadPackage implements link.LinkerEnv
+func (e testLinkerEnv) LoadPackage(path string) (*format.Package, error) {
+	pkg := e[path]
+	if pkg == nil {
+		return nil, fmt.Errorf("%s: %w", path, link.ErrMissingPackage)
+	}
+	return pkg, nil
+}
+
+func testProgram(t *testing.T, syms *symtab.Symtab, name string, src []byte) *format.Program {
+	var pt ast.Package
+	assert.Nil(t, parser.ParseNamedFile(syms, &pt, name, src))
+	pkg, err := backend.BuildPackage(syms, pt)
+	assert.Nil(t, err)
+
+	test := must.Be(os.ReadFile("testdata/test.czs"))
+	prog, err := link.Link(syms, testLinkerEnv{
+		"main": pkg,
+		"test": must.Be(asm.Assemble(syms, "testdata/test.czs", test)),
+	})
+	assert.Nil(t, err)
+	return prog
+}
+
+func debug(t *testing.T, script string) (string, []int) {
+	return debugSource(t, new(env.Env), "testdata/main.cz", must.Be(os.ReadFile("testdata/main.cz")), script)
+}
+
+func debugSource(t *testing.T, e *env.Env, name string, src []byte, script string) (string, []int) {
+	var syms symtab.Symtab
+	prog := testProgram(t, &syms, name, src)
+
+	var printed []int
+	e.AddExternalMethod("test:print", func(p *env.Thread, recv api.Object) {
+		printed = append(printed, p.Process().AsInt(p.Arg(0)))
+		p.Return(p.Process().Int(0))
+	})
+	e.AddExternalMethod("test:mul", func(p *env.Thread, recv api.Object) {
+		a, b := p.Process().AsInt(p.Arg(0)), p.Process().AsInt(p.Arg(1))
+		p.Return(p.Process().Int(a * b))
+	})
+
+	var out bytes.Buffer
+	assert.Nil(t, run(e, &syms, prog, strings.NewReader(script), &out))
+	return out.String(), printed
+}
+
+// The offsets in the output depend on the code that the compiler generates,
+// so they are left out when checking it.
+func contains(t *testing.T, out string, want ...string) {
+	t.Helper()
+	pcs := regexp.MustCompile(`, pc \d+`)
+	out = pcs.ReplaceAllString(out, "")
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Errorf("%q not found in:\n%s", w, out)
+		}
+	}
+}
+
+func TestBreakOnMethod(t *testing.T) {
+	out, printed := debug(t, "break double\ncontinue\nframe\nstack\ncontinue\n")
+	assert.Equal(t, printed, []int{8})
+	contains(t, out,
+		"#0 entry\n(cz) breakpoint 1 at double\n",
+		"breakpoint 1, #0 c8.double at testdata/main.cz:7:6\n",
+		"  r2 = 4\n",
+		"  this = c8{}\n",
+		"(cz) #0 c8.double at testdata/main.cz:7:6\n#1 c8.main at testdata/main.cz:4:13\n",
+		"(cz) program finished\n",
+	)
+}
+
+func TestBreakOnLine(t *testing.T) {
+	out, printed := debug(t, "break main.cz:8\nbreakpoints\ncontinue\nstep\nstep\nquit\n")
+	assert.Nil(t, printed)
+	contains(t, out,
+		"(cz) 1: main.cz:8\n",
+		"breakpoint 1, #0 c8.double at testdata/main.cz:8:7\n",
+		// the call to mul is a tail call, so the next step is back in main
+		"(cz) #0 c8.main at testdata/main.cz:4:13\n",
+		"(cz) #0 c8.main at testdata/main.cz:4:7\n(cz) ",
+	)
+	assert.False(t, strings.Contains(out, "program finished"))
+}
+
+func TestStepping(t *testing.T) {
+	out, _ := debug(t, "break main\ncontinue\nnext\n\nstepi\nfinish\n")
+	contains(t, out,
+		"breakpoint 1, #0 c8.main at testdata/main.cz:3:6\n",
+		"(cz) #0 c8.main at testdata/main.cz:4:13\n",
+		"(cz) #0 c8.main at testdata/main.cz:4:7\n(cz) #0 c8.main at testdata/main.cz:4:7\n",
+		"(cz) program finished\n",
+	)
+}
+
+// Receivers are moved by collections between steps, and are still shown after
+// that.
+// Collections between steps move receivers, which are still shown correctly
+// afterwards.
+func TestReceiverMoved(t *testing.T) {
+	src := `import test
+
+func main() {
+	test.print(pair(3, 4).sum())
+}
+
+func pair(a, b) {
+	object {
+		sum() {
+			let c = test.mul(a, 10)
+			test.mul(object { get() { c } }.get(), b)
+		}
+	}
+}
+`
+	e := new(env.Env)
+	e.SetGCStress(true)
+	out, printed := debugSource(t, e, "main.cz", []byte(src), "break sum\ncontinue\nnext\nnext\nframe\ncontinue\n")
+	assert.Equal(t, printed, []int{120})
+	contains(t, out, "  this = c9{3, 4}\n")
+}
+
+func TestCommandErrors(t *testing.T) {
+	out, _ := debug(t, "bogus\nbreak nowhere\ndelete 1\nframe 9\nbreak\n")
+	contains(t, out,
+		"error: bogus: unknown command\n",
+		"error: nowhere: no such method or line\n",
+		"error: 1: no such breakpoint\n",
+		"error: 9: no such frame\n",
+		"error: break: expected a method name or file:line\n",
+	)
+}
+
+func TestDebugOneFile(t *testing.T) {
+	err := Debug(Options{}, nil)
+	assert.True(t, errors.Is(err, ErrOneFile))
+}
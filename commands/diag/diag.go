@@ -0,0 +1,255 @@
+// Package diag describes problems found in programs, in a form that every
+// phase of the toolchain can report and that can be written out for people or
+// for editors.
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Pos is a position in a source file. Lines and columns count from 1, with
+// columns counted in bytes. The zero Pos is unknown.
+type Pos struct {
+	File      string
+	Line, Col int
+}
+
+// Before reports whether p comes before q, with files ordered by name.
+func (p Pos) Before(q Pos) bool {
+	if p.File != q.File {
+		return p.File < q.File
+	}
+	if p.Line != q.Line {
+		return p.Line < q.Line
+	}
+	return p.Col < q.Col
+}
+
+func (p Pos) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Span is a range of source text. End may be zero when only the start is
+// known.
+type Span struct {
+	Start, End Pos
+}
+
+// At gives a span that only has a start.
+func At(p Pos) Span {
+	return Span{Start: p}
+}
+
+type Severity int
+
+const (
+	_ Severity = iota
+	Error
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Span     Span
+	Message  string
+	Notes    []Annotation
+
+	// The error that the diagnostic describes, if there is one.
+	Err error
+}
+
+// Annotation is extra information about a diagnostic, perhaps pointing at
+// some other part of the source.
+type Annotation struct {
+	Span    Span
+	Message string
+}
+
+func (d Diagnostic) Error() string {
+	return prefix(d.Span, d.Severity) + d.Message
+}
+
+// Diagnoser is implemented by errors that know where in the source they come
+// from.
+type Diagnoser interface {
+	Diagnostics() []Diagnostic
+}
+
+// List collects the diagnostics from every phase of a run. A List with any
+// errors in it is itself an error.
+type List []Diagnostic
+
+func (l *List) Add(d Diagnostic) {
+	*l = append(*l, d)
+}
+
+func (l *List) Errorf(span Span, format string, args ...any) {
+	l.Add(Diagnostic{
+		Severity: Error,
+		Span:     span,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// AddError adds the diagnostics that err describes. An error that is not a
+// Diagnoser becomes a single error with no position.
+func (l *List) AddError(err error) {
+	if err == nil {
+		return
+	}
+	var d Diagnoser
+	if errors.As(err, &d) {
+		*l = append(*l, d.Diagnostics()...)
+		return
+	}
+	l.Add(Diagnostic{
+		Severity: Error,
+		Message:  err.Error(),
+		Err:      err,
+	})
+}
+
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the list if it has any errors, and nil otherwise.
+func (l List) Err() error {
+	if !l.HasErrors() {
+		return nil
+	}
+	return l
+}
+
+// Is reports whether any of the diagnostics describe target.
+func (l List) Is(target error) bool {
+	for _, d := range l {
+		if d.Err != nil && errors.Is(d.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l List) Diagnostics() []Diagnostic {
+	return l
+}
+
+func (l List) Error() string {
+	var b strings.Builder
+	l.WriteText(&b)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Sort puts the diagnostics in source order. Diagnostics without positions
+// come first.
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].Span.Start.Before(l[j].Span.Start)
+	})
+}
+
+// WriteText writes one line per diagnostic and note, in the form
+//
+//	file:line:col: error: message
+func (l List) WriteText(w io.Writer) error {
+	for _, d := range l {
+		if _, err := fmt.Fprintln(w, d.Error()); err != nil {
+			return err
+		}
+		for _, n := range d.Notes {
+			if _, err := fmt.Fprintln(w, prefix(n.Span, Note)+n.Message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func prefix(s Span, sev Severity) string {
+	if pos := s.Start.String(); pos != "" {
+		return fmt.Sprintf("%s: %s: ", pos, sev)
+	}
+	return fmt.Sprintf("%s: ", sev)
+}
+
+type jsonSpan struct {
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+}
+
+type jsonNote struct {
+	jsonSpan
+	Message string `json:"message"`
+}
+
+type jsonDiagnostic struct {
+	Severity string `json:"severity"`
+	jsonSpan
+	Message string     `json:"message"`
+	Notes   []jsonNote `json:"notes,omitempty"`
+}
+
+// WriteJSON writes the diagnostics as a JSON array, for editors and other
+// tools.
+func (l List) WriteJSON(w io.Writer) error {
+	res := make([]jsonDiagnostic, len(l))
+	for i, d := range l {
+		res[i] = jsonDiagnostic{
+			Severity: d.Severity.String(),
+			jsonSpan: spanJSON(d.Span),
+			Message:  d.Message,
+		}
+		for _, n := range d.Notes {
+			res[i].Notes = append(res[i].Notes, jsonNote{
+				jsonSpan: spanJSON(n.Span),
+				Message:  n.Message,
+			})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+func spanJSON(s Span) jsonSpan {
+	return jsonSpan{
+		File:      s.Start.File,
+		Line:      s.Start.Line,
+		Column:    s.Start.Col,
+		EndLine:   s.End.Line,
+		EndColumn: s.End.Col,
+	}
+}
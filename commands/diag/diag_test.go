@@ -0,0 +1,88 @@
+package diag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+type testError struct{}
+
+func (testError) Error() string {
+	return "test error"
+}
+
+func (testError) Diagnostics() []Diagnostic {
+	return []Diagnostic{{
+		Severity: Warning,
+		Span:     At(Pos{File: "a.cz", Line: 3, Col: 1}),
+		Message:  "from a diagnoser",
+	}}
+}
+
+func TestList(t *testing.T) {
+	var l List
+	assert.Nil(t, l.Err())
+
+	errMissing := errors.New("missing")
+	l.AddError(testError{})
+	l.Add(Diagnostic{
+		Severity: Error,
+		Span:     At(Pos{File: "a.cz", Line: 1, Col: 5}),
+		Message:  "bad thing",
+		Notes: []Annotation{{
+			Span:    At(Pos{File: "b.cz", Line: 2, Col: 1}),
+			Message: "declared here",
+		}},
+	})
+	l.AddError(fmt.Errorf("lib: %w", errMissing))
+	l.Sort()
+
+	assert.True(t, l.HasErrors())
+	assert.True(t, errors.Is(l.Err(), errMissing))
+	assert.Equal(t, l.Error(), `error: lib: missing
+a.cz:1:5: error: bad thing
+b.cz:2:1: note: declared here
+a.cz:3:1: warning: from a diagnoser`)
+}
+
+func TestWriteJSON(t *testing.T) {
+	l := List{{
+		Severity: Error,
+		Span: Span{
+			Start: Pos{File: "a.cz", Line: 1, Col: 5},
+			End:   Pos{File: "a.cz", Line: 1, Col: 8},
+		},
+		Message: "bad thing",
+		Notes: []Annotation{{
+			Span:    At(Pos{File: "b.cz", Line: 2, Col: 1}),
+			Message: "declared here",
+		}},
+	}}
+
+	var buf bytes.Buffer
+	assert.Nil(t, l.WriteJSON(&buf))
+	assert.Equal(t, buf.String(), `[
+  {
+    "severity": "error",
+    "file": "a.cz",
+    "line": 1,
+    "column": 5,
+    "endLine": 1,
+    "endColumn": 8,
+    "message": "bad thing",
+    "notes": [
+      {
+        "file": "b.cz",
+        "line": 2,
+        "column": 1,
+        "message": "declared here"
+      }
+    ]
+  }
+]
+`)
+}
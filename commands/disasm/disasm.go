@@ -0,0 +1,53 @@
+// Package disasm provides a command that lists the contents of compiled
+// packages and linked programs.
+package disasm
+
+import (
+	"io"
+	"os"
+
+	"github.com/bobappleyard/cezanne/commands"
+	"github.com/bobappleyard/cezanne/format"
+	listing "github.com/bobappleyard/cezanne/format/disasm"
+	"github.com/bobappleyard/cezanne/format/storage"
+)
+
+type Options struct {
+	// Read the files as linked programs rather than packages.
+	Program bool `option:"program"`
+}
+
+func init() {
+	commands.Register("disasm", Disasm)
+}
+
+// Disasm writes a listing of each file to standard output.
+func Disasm(options Options, files []string) error {
+	for _, f := range files {
+		if err := disasmFile(options, f, os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func disasmFile(options Options, name string, w io.Writer) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if options.Program {
+		var p format.Program
+		if _, err := storage.Read(f, &p); err != nil {
+			return err
+		}
+		return listing.Program(w, &p)
+	}
+	var p format.Package
+	if _, err := storage.Read(f, &p); err != nil {
+		return err
+	}
+	return listing.Package(w, &p)
+}
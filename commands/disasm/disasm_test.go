@@ -0,0 +1,29 @@
+package disasm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/storage"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestDisasmFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "pkg")
+	f, err := os.Create(name)
+	assert.Nil(t, err)
+	_, err = storage.Write(f, format.Package{
+		Imports: []string{"io"},
+		Code:    []byte{format.LoadOp, 2, format.RetOp},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	var buf bytes.Buffer
+	err = disasmFile(Options{}, name, &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, buf.String(), "import \"io\"\n\n\tLOAD 2 // 0\n\tRETURN // 2\n")
+}
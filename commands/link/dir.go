@@ -0,0 +1,84 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/storage"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/core"
+	"golang.org/x/exp/slices"
+)
+
+// Dir loads compiled packages from files in a directory, named by their
+// import path. The main package is read from a file of its own. The runtime
+// package comes with the toolchain, and every program is linked with it.
+//
+// Packages compiled separately have their own symbol tables, so their symbols
+// are moved into Symbols, which the program is linked against.
+type Dir struct {
+	Main    string
+	Path    string
+	Symbols *symtab.Symtab
+}
+
+// LoadPackage implements LinkerEnv
+func (d Dir) LoadPackage(path string) (*format.Package, error) {
+	if path == core.Path {
+		return core.Package(d.Symbols), nil
+	}
+	name := d.Main
+	if path != "main" {
+		name = filepath.Join(d.Path, path)
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", path, ErrMissingPackage)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var p format.Package
+	if _, err := storage.Read(f, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	d.shareSymbols(&p)
+	if path == "main" && !slices.Contains(p.Imports, core.Path) {
+		p.Imports = append(p.Imports, core.Path)
+	}
+	return &p, nil
+}
+
+// Packages without a symbol table of their own already share one.
+func (d Dir) shareSymbols(p *format.Package) {
+	if d.Symbols == nil {
+		return
+	}
+	rewrites := map[symtab.Symbol]symtab.Symbol{}
+	for _, rw := range d.Symbols.Merge(&p.Symbols) {
+		rewrites[rw.From] = rw.To
+	}
+	move := func(s symtab.Symbol) symtab.Symbol {
+		if to, ok := rewrites[s]; ok {
+			return to
+		}
+		return s
+	}
+	for i, m := range p.Methods {
+		p.Methods[i].Name = move(m.Name)
+	}
+	for i, c := range p.Classes {
+		// unnamed classes are left as they are
+		if c.Name.ID != 0 {
+			p.Classes[i].Name = move(c.Name)
+		}
+	}
+	for i, x := range p.ExternalMethods {
+		p.ExternalMethods[i] = move(x)
+	}
+}
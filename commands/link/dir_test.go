@@ -0,0 +1,52 @@
+package link
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/storage"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+	"golang.org/x/exp/slices"
+)
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "prog")
+
+	// each package is built with a table of its own
+	for name, build := range map[string]func(*symtab.Symtab) *format.Package{
+		main:                       mainPackage,
+		filepath.Join(dir, "dep"):  depPackage,
+		filepath.Join(dir, "core"): corePackage,
+	} {
+		var syms symtab.Symtab
+		syms.SymbolID(name)
+		p := build(&syms)
+		p.Symbols = syms.Copy()
+
+		f, err := os.Create(name)
+		assert.Nil(t, err)
+		_, err = storage.Write(f, *p)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+	}
+
+	var syms symtab.Symtab
+	prog, err := Link(&syms, Dir{Main: main, Path: dir, Symbols: &syms})
+	assert.Nil(t, err)
+	assert.Equal(t, len(prog.Lines.Files), 2)
+
+	var ext []string
+	for _, x := range prog.ExternalMethods {
+		ext = append(ext, syms.SymbolName(x))
+	}
+	assert.Equal(t, ext[:2], []string{"test:result", "core:int_add"})
+	assert.True(t, slices.Contains(ext, "runtime:int_add"))
+
+	_, err = Dir{Main: main, Path: t.TempDir()}.LoadPackage("dep")
+	assert.True(t, errors.Is(err, ErrMissingPackage))
+}
@@ -2,9 +2,12 @@ package link
 
 import (
 	"errors"
+	"fmt"
 
+	"github.com/bobappleyard/cezanne/commands/diag"
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/format/verify"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 )
@@ -12,7 +15,7 @@ import (
 var (
 	ErrCircularImport    = errors.New("circular import")
 	ErrMissingPackage    = errors.New("missing package")
-	ErrMissingMainMethod = errors.New("missinng main method")
+	ErrMissingMainMethod = errors.New("missing main method")
 )
 
 type LinkerEnv interface {
@@ -21,20 +24,29 @@ type LinkerEnv interface {
 
 // Given a collection of packages keyed by path, create a program by starting
 // with the "main" package and taking the transitive closure of the import
-// relation.
+// relation. The program is verified before it is returned.
 func Link(syms *symtab.Symtab, env LinkerEnv) (*format.Program, error) {
 	l := &linker{
 		syms:    syms,
 		env:     env,
 		methods: map[symtab.Symbol]*method{syms.SymbolID("call"): {}},
 		imports: map[string]*importedPackage{},
+		consts:  map[format.Constant]int32{},
 	}
 	l.init()
-	err := l.importPackage("main")
-	if err != nil {
+	l.importPackage("main")
+	if err := l.diags.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := l.methods[syms.SymbolID("main")]; !ok {
+		l.diags.AddError(ErrMissingMainMethod)
+		return nil, l.diags.Err()
+	}
+	prog := l.complete()
+	if err := verify.Program(prog); err != nil {
 		return nil, err
 	}
-	return l.complete(), nil
+	return prog, nil
 }
 
 type linker struct {
@@ -43,6 +55,8 @@ type linker struct {
 	program format.Program
 	methods map[symtab.Symbol]*method
 	imports map[string]*importedPackage
+	consts  map[format.Constant]int32
+	diags   diag.List
 }
 
 type importedPackage struct {
@@ -83,6 +97,7 @@ func (l *linker) complete() *format.Program {
 	}
 	l.addMainInitCode()
 	l.determineOffsets()
+	l.program.Symbols = l.syms.Copy()
 	for i, c := range l.program.Classes {
 		if c.Kind == format.UserKind {
 			continue
@@ -92,29 +107,29 @@ func (l *linker) complete() *format.Program {
 	return &l.program
 }
 
-func (l *linker) importPackage(path string) error {
+// Problems with imports are recorded and the rest of the imports are still
+// processed, so that they can all be reported at once.
+func (l *linker) importPackage(path string) {
 	if p, ok := l.imports[path]; ok {
 		if p.global == -1 {
-			return ErrCircularImport
+			l.diags.AddError(fmt.Errorf("%s: %w", path, ErrCircularImport))
 		}
-		return nil
+		return
 	}
 
 	l.imports[path] = &importedPackage{order: len(l.imports), global: -1}
 
 	p, err := l.env.LoadPackage(path)
 	if err != nil {
-		return err
+		l.diags.AddError(err)
+		return
 	}
 
 	for _, q := range p.Imports {
 		if q == "." {
 			continue
 		}
-		err := l.importPackage(q)
-		if err != nil {
-			return err
-		}
+		l.importPackage(q)
 	}
 
 	global := l.program.GlobalCount
@@ -128,8 +143,6 @@ func (l *linker) importPackage(path string) error {
 
 	l.imports[path].global = global
 	l.imports[path].class = class
-
-	return nil
 }
 
 func (l *linker) appendPackage(p *format.Package, pkgGlob int32) {
@@ -137,9 +150,52 @@ func (l *linker) appendPackage(p *format.Package, pkgGlob int32) {
 	l.processBindings(p)
 	l.program.ExternalMethods = append(l.program.ExternalMethods, p.ExternalMethods...)
 	l.program.Classes = append(l.program.Classes, p.Classes...)
+	l.appendLines(p)
 	l.program.Code = append(l.program.Code, p.Code...)
 }
 
+// The package's line table is moved along with its code. The code that
+// follows it, whether from the linker or another package, starts out with no
+// source.
+func (l *linker) appendLines(p *format.Package) {
+	if len(p.Lines.Entries) == 0 {
+		return
+	}
+	files := make([]int32, len(p.Lines.Files))
+	for i, f := range p.Lines.Files {
+		files[i] = l.lineFile(f)
+	}
+	base := uint32(len(l.program.Code))
+	for _, e := range p.Lines.Entries {
+		if e.File >= 0 && int(e.File) < len(files) {
+			e.File = files[e.File]
+		} else {
+			e.File = -1
+		}
+		e.Offset += base
+		l.addLine(e)
+	}
+	l.addLine(format.LineEntry{Offset: base + uint32(len(p.Code)), File: -1})
+}
+
+func (l *linker) lineFile(name string) int32 {
+	for i, f := range l.program.Lines.Files {
+		if f == name {
+			return int32(i)
+		}
+	}
+	l.program.Lines.Files = append(l.program.Lines.Files, name)
+	return int32(len(l.program.Lines.Files) - 1)
+}
+
+func (l *linker) addLine(e format.LineEntry) {
+	entries := l.program.Lines.Entries
+	if n := len(entries); n != 0 && entries[n-1].Offset == e.Offset {
+		entries = entries[:n-1]
+	}
+	l.program.Lines.Entries = append(entries, e)
+}
+
 func (l *linker) addPackageEntry(packageClass format.ClassID) {
 	call := l.syms.SymbolID("call")
 	l.methods[call].impls = append(l.methods[call].impls, format.Implementation{
@@ -203,6 +259,9 @@ func (l *linker) processRelocations(p *format.Package, pkgGlob int32) {
 
 		case format.MethodRel:
 			rel.ID = int32(l.method(p.Methods[rel.ID].Name).id)
+
+		case format.ConstantRel:
+			rel.ID = l.constant(p.Constants[rel.ID])
 		}
 		writeInt32(p.Code[rel.Pos:], rel.ID)
 	}
@@ -216,11 +275,22 @@ func (l *linker) importGlobal(name string, pkgGlob int32) int32 {
 	return l.imports[name].global
 }
 
+// Packages that use the same literal share a constant.
+func (l *linker) constant(value format.Constant) int32 {
+	if id, ok := l.consts[value]; ok {
+		return id
+	}
+	id := int32(len(l.program.Constants))
+	l.program.Constants = append(l.program.Constants, value)
+	l.consts[value] = id
+	return id
+}
+
 func (l *linker) processBindings(p *format.Package) {
 	for _, impl := range p.Implementations {
 		var ep uint32
 		switch impl.Kind {
-		case format.StandardBinding:
+		case format.StandardBinding, format.HandlerBinding:
 			ep = impl.EntryPoint + uint32(len(l.program.Code))
 		case format.ExternalBinding:
 			ep = impl.EntryPoint + uint32(len(l.program.ExternalMethods))
@@ -252,7 +322,13 @@ func (l *linker) determineOffsets() {
 		slices.SortFunc(m.impls, func(l, r format.Implementation) bool {
 			return l.Class < r.Class
 		})
+		// a method that nothing implements is given an offset that takes
+		// every class outside of the table
 		if len(m.impls) == 0 {
+			methods[m.id] = format.Method{
+				Name:   n,
+				Offset: -int32(len(l.program.Classes)),
+			}
 			continue
 		}
 		offset := findOffset(space, m)
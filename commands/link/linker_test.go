@@ -1,11 +1,16 @@
 package link
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/bobappleyard/cezanne/commands/diag"
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/format/assembly"
+	"github.com/bobappleyard/cezanne/format/disasm"
 	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/format/verify"
 	"github.com/bobappleyard/cezanne/runtime/api"
 	"github.com/bobappleyard/cezanne/runtime/env"
 	"github.com/bobappleyard/cezanne/util/assert"
@@ -17,7 +22,7 @@ func (e mockLinkerEnv) LoadPackage(path string) (*format.Package, error) {
 	if p, ok := e[path]; ok {
 		return p, nil
 	}
-	return nil, ErrMissingPackage
+	return nil, fmt.Errorf("%s: %w", path, ErrMissingPackage)
 }
 
 func TestCircularImports(t *testing.T) {
@@ -35,7 +40,35 @@ func TestCircularImports(t *testing.T) {
 		},
 	})
 	assert.Nil(t, prog)
-	assert.Equal(t, err, ErrCircularImport)
+	assert.True(t, errors.Is(err, ErrCircularImport))
+	assert.Equal(t, err.Error(), "error: a: circular import")
+}
+
+func TestReportAllMissingPackages(t *testing.T) {
+	var syms symtab.Symtab
+
+	prog, err := Link(&syms, mockLinkerEnv{
+		"main": &format.Package{
+			Imports: []string{"a", "b"},
+		},
+	})
+	assert.Nil(t, prog)
+	assert.True(t, errors.Is(err, ErrMissingPackage))
+
+	var l diag.List
+	assert.True(t, errors.As(err, &l))
+	assert.Equal(t, len(l), 2)
+}
+
+func TestMissingMainMethod(t *testing.T) {
+	var syms symtab.Symtab
+
+	prog, err := Link(&syms, mockLinkerEnv{
+		"main": &format.Package{},
+	})
+	assert.Nil(t, prog)
+	assert.True(t, errors.Is(err, ErrMissingMainMethod))
+	assert.Equal(t, err.Error(), "error: missing main method")
 }
 
 func TestLink(t *testing.T) {
@@ -62,11 +95,36 @@ func TestLink(t *testing.T) {
 		p.Return(p.Process().Int(p.Process().AsInt(p.Arg(0)) + p.Process().AsInt(p.Arg(1))))
 	})
 
-	e.Run(&syms, prog)
+	assert.Nil(t, e.Run(&syms, prog))
 
 	assert.Equal(t, res, api.Object{Class: prog.CoreKinds[format.IntKind], Data: 10})
 }
 
+func TestLinkLines(t *testing.T) {
+	var syms symtab.Symtab
+
+	prog, err := Link(&syms, mockLinkerEnv{
+		"main": mainPackage(&syms),
+		"dep":  depPackage(&syms),
+		"core": corePackage(&syms),
+	})
+	assert.Nil(t, err)
+
+	// core, then dep, then main, after the linker's own entry code
+	assert.Equal(t, prog.Lines, format.LineTable{
+		Files: []string{"dep.cz", "main.cz"},
+		Entries: []format.LineEntry{
+			{Offset: 26, File: 0, Line: 1, Col: 1},
+			{Offset: 44, File: -1},
+			{Offset: 51, File: 1, Line: 2, Col: 3},
+			{Offset: 106, File: -1},
+		},
+	})
+
+	_, ok := prog.Lines.Lookup(106)
+	assert.False(t, ok)
+}
+
 func mainPackage(syms *symtab.Symtab) *format.Package {
 	var b assembly.Writer
 
@@ -78,6 +136,7 @@ func mainPackage(syms *symtab.Symtab) *format.Package {
 	b.Return()
 
 	b.ImplementMethod(pkg, b.Method(syms.SymbolID("main")))
+	b.SourcePos("main.cz", 2, 3)
 	b.GlobalLoad(b.Import("core"))
 	b.GlobalStore(core)
 	b.Natural(b.Fixed(2))
@@ -105,6 +164,7 @@ func depPackage(syms *symtab.Symtab) *format.Package {
 	b.Return()
 
 	b.ImplementMethod(pkg, b.Method(syms.SymbolID("add5")))
+	b.SourcePos("dep.cz", 1, 1)
 	b.Natural(b.Fixed(5))
 	b.Store(3)
 	b.GlobalLoad(b.Import("core"))
@@ -126,3 +186,101 @@ func corePackage(syms *symtab.Symtab) *format.Package {
 
 	return b.Package()
 }
+
+func TestLinkVerifies(t *testing.T) {
+	var syms symtab.Symtab
+	var b assembly.Writer
+
+	pkg := b.Class(0)
+	b.Create(pkg, 0)
+	b.Return()
+
+	// the fields are taken from registers that the method never sets
+	b.ImplementMethod(pkg, b.Method(syms.SymbolID("main")))
+	b.Create(b.Class(2), 0)
+	b.Return()
+
+	prog, err := Link(&syms, mockLinkerEnv{"main": b.Package()})
+	assert.Nil(t, prog)
+	assert.True(t, errors.Is(err, verify.ErrOutsideFrame))
+}
+
+func TestLinkConstants(t *testing.T) {
+	var syms symtab.Symtab
+
+	pkg := func(imports []string, strs ...string) *format.Package {
+		var b assembly.Writer
+		for _, imp := range imports {
+			b.Import(imp)
+		}
+		c := b.Class(0)
+		b.Create(c, 0)
+		b.Return()
+		b.ImplementMethod(c, b.Method(syms.SymbolID("main")))
+		for _, s := range strs {
+			b.Constant(b.String(s))
+		}
+		b.Return()
+		return b.Package()
+	}
+
+	prog, err := Link(&syms, mockLinkerEnv{
+		"main": pkg([]string{"dep"}, "b", "a"),
+		"dep":  pkg(nil, "a"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, prog.Constants, []format.Constant{
+		{Kind: format.StringKind, Value: "a"},
+		{Kind: format.StringKind, Value: "b"},
+	})
+
+	var consts []int32
+	code, err := disasm.Decode(prog.Code)
+	assert.Nil(t, err)
+	for _, i := range code {
+		if i.Op == format.ConstantOp {
+			consts = append(consts, i.Args[0])
+		}
+	}
+	assert.Equal(t, consts, []int32{0, 1, 0})
+}
+
+func TestLinkHandlers(t *testing.T) {
+	var syms symtab.Symtab
+
+	pkg := func(imports []string, n int) *format.Package {
+		var b assembly.Writer
+		for _, imp := range imports {
+			b.Import(imp)
+		}
+		c := b.Class(0)
+		b.Create(c, 0)
+		b.Return()
+		b.ImplementMethod(c, b.Method(syms.SymbolID("main")))
+		b.Return()
+		entry := b.Location()
+		b.ImplementHandlerAt(b.Class(0), b.Method(syms.SymbolID("oops")), entry)
+		entry.Define()
+		b.Natural(b.Fixed(n))
+		b.Return()
+		return b.Package()
+	}
+
+	prog, err := Link(&syms, mockLinkerEnv{
+		"main": pkg([]string{"dep"}, 1),
+		"dep":  pkg(nil, 2),
+	})
+	assert.Nil(t, err)
+
+	var found []int32
+	for _, impl := range prog.Implmentations {
+		if impl.Kind != format.HandlerBinding {
+			continue
+		}
+		code, err := disasm.Decode(prog.Code[impl.EntryPoint:])
+		assert.Nil(t, err)
+		assert.Equal(t, code[0].Op, format.NaturalOp)
+		found = append(found, code[0].Args[0])
+	}
+	assert.Equal(t, found, []int32{2, 1})
+}
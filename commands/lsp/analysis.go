@@ -0,0 +1,241 @@
+package lsp
+
+import (
+	"strings"
+
+	"github.com/bobappleyard/cezanne/commands/compile"
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/commands/compile/resolve"
+	"github.com/bobappleyard/cezanne/commands/compile/types"
+	"github.com/bobappleyard/cezanne/commands/diag"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
+
+// analysis is what the compiler front end makes of a single file. Each phase
+// only runs if the ones before it succeeded, as in the compiler.
+type analysis struct {
+	syms  *symtab.Symtab
+	pkg   ast.Package
+	diags diag.List
+
+	// nil unless the file type checked
+	types *types.Package
+}
+
+// Imports are given the same types as the compiler gives them, from the
+// compiled packages in a directory.
+func analyse(packages, file string, src []byte) *analysis {
+	a := &analysis{syms: &symtab.Symtab{}}
+
+	a.diags.AddError(parser.ParseNamedFile(a.syms, &a.pkg, file, src))
+	if a.diags.HasErrors() {
+		return a
+	}
+
+	a.diags.AddError(resolve.Package(a.syms, a.pkg))
+	if a.diags.HasErrors() {
+		return a
+	}
+
+	env := types.NewEnv(a.syms)
+	a.diags.AddError(compile.ImportTypes(env, a.syms, packages, a.pkg.Imports))
+	p, err := env.CheckPackage(a.pkg)
+	a.diags.AddError(err)
+	a.types = p
+
+	return a
+}
+
+// typeAt gives the type of the name at p, if it was recorded.
+func (a *analysis) typeAt(p ast.Pos) (types.Type, bool) {
+	if a == nil || a.types == nil {
+		return nil, false
+	}
+	t, ok := a.types.Positions[p]
+	return t, ok
+}
+
+// hover describes the name at p.
+func (a *analysis) hover(p ast.Pos, name string) (string, bool) {
+	t, ok := a.typeAt(p)
+	if !ok {
+		return "", false
+	}
+	return name + ": " + types.NewPrinter(nil).Type(t), true
+}
+
+type completion struct {
+	name, detail string
+}
+
+// completions lists the methods of the value named at p.
+func (a *analysis) completions(p ast.Pos) []completion {
+	t, ok := a.typeAt(p)
+	if !ok {
+		return nil
+	}
+	var res []completion
+	for _, m := range types.MethodsOf(t) {
+		sig := types.NewPrinter(nil).Shape(types.Shape{m})
+		res = append(res, completion{
+			name:   m.Name,
+			detail: strings.TrimSuffix(strings.TrimPrefix(sig, "{"), "}"),
+		})
+	}
+	return res
+}
+
+// methodDefinitions finds every method with the given name that objects in
+// the file define. Without knowing which object a method is invoked on, any of
+// them might be the one that is called.
+func (a *analysis) methodDefinitions(name string) []ast.Pos {
+	id := a.syms.SymbolID(name)
+	var res []ast.Pos
+	var walk func(x ast.Expr)
+	walkMethods := func(ms []ast.Method, objects bool) {
+		for _, m := range ms {
+			if objects && m.Name == id {
+				res = append(res, m.Pos)
+			}
+			walk(m.Body)
+		}
+	}
+	walk = func(x ast.Expr) {
+		switch x := x.(type) {
+		case ast.Let:
+			walk(x.Value)
+			walk(x.In)
+		case ast.Create:
+			walkMethods(x.Methods, true)
+		case ast.Invoke:
+			walk(x.Object)
+			for _, y := range x.Args {
+				walk(y)
+			}
+		case ast.Handle:
+			walk(x.In)
+			walkMethods(x.With, false)
+		case ast.Trigger:
+			for _, y := range x.Args {
+				walk(y)
+			}
+		case ast.Array:
+			for _, y := range x.Items {
+				walk(y)
+			}
+		}
+	}
+	walkMethods(a.pkg.Funcs, false)
+	return res
+}
+
+// definition finds where the name at p is bound. Arguments are bound by the
+// method that declares them.
+func (a *analysis) definition(p ast.Pos) (ast.Pos, bool) {
+	f := &definitionFinder{target: p, globals: map[symtab.Symbol]ast.Pos{}}
+	for _, imp := range a.pkg.Imports {
+		if imp.Pos == p {
+			return p, true
+		}
+		f.globals[imp.Name] = imp.Pos
+	}
+	for _, m := range a.pkg.Funcs {
+		f.globals[m.Name] = m.Pos
+	}
+	f.methods(nil, a.pkg.Funcs)
+	return f.found, f.ok
+}
+
+type definitionFinder struct {
+	target  ast.Pos
+	globals map[symtab.Symbol]ast.Pos
+	found   ast.Pos
+	ok      bool
+}
+
+// Local variables, innermost first.
+type binding struct {
+	name  symtab.Symbol
+	pos   ast.Pos
+	outer *binding
+}
+
+func (b *binding) bind(name symtab.Symbol, pos ast.Pos) *binding {
+	return &binding{name: name, pos: pos, outer: b}
+}
+
+func (b *binding) lookup(name symtab.Symbol) (ast.Pos, bool) {
+	for ; b != nil; b = b.outer {
+		if b.name == name {
+			return b.pos, true
+		}
+	}
+	return ast.Pos{}, false
+}
+
+func (f *definitionFinder) define(pos ast.Pos) {
+	f.found, f.ok = pos, true
+}
+
+func (f *definitionFinder) methods(b *binding, ms []ast.Method) {
+	for _, m := range ms {
+		if m.Pos == f.target {
+			f.define(m.Pos)
+			return
+		}
+		inner := b
+		for _, arg := range m.Args {
+			inner = inner.bind(arg, m.Pos)
+		}
+		f.expr(inner, m.Body)
+	}
+}
+
+func (f *definitionFinder) expr(b *binding, x ast.Expr) {
+	if f.ok {
+		return
+	}
+	switch x := x.(type) {
+	case ast.Ref:
+		if x.Pos != f.target {
+			return
+		}
+		if pos, ok := b.lookup(x.Name); ok {
+			f.define(pos)
+		} else if pos, ok := f.globals[x.Name]; ok {
+			f.define(pos)
+		}
+
+	case ast.Let:
+		if x.Pos == f.target {
+			f.define(x.Pos)
+			return
+		}
+		f.expr(b, x.Value)
+		f.expr(b.bind(x.Name, x.Pos), x.In)
+
+	case ast.Create:
+		f.methods(b, x.Methods)
+
+	case ast.Invoke:
+		f.expr(b, x.Object)
+		for _, y := range x.Args {
+			f.expr(b, y)
+		}
+
+	case ast.Handle:
+		f.expr(b, x.In)
+		f.methods(b, x.With)
+
+	case ast.Trigger:
+		for _, y := range x.Args {
+			f.expr(b, y)
+		}
+
+	case ast.Array:
+		for _, y := range x.Items {
+			f.expr(b, y)
+		}
+	}
+}
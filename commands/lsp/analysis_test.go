@@ -0,0 +1,146 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestDefinition(t *testing.T) {
+	d := newDocument(testURI, []byte(testSource))
+	a := analyse("", testURI, d.text)
+	assert.Nil(t, a.diags.Err())
+
+	for _, test := range []struct {
+		name      string
+		line, col int
+		def       ast.Pos
+		ok        bool
+	}{
+		{
+			name: "Import",
+			line: 13, col: 2,
+			def: ast.Pos{File: testURI, Line: 1, Col: 8},
+			ok:  true,
+		},
+		{
+			name: "Function",
+			line: 12, col: 10,
+			def: ast.Pos{File: testURI, Line: 3, Col: 6},
+			ok:  true,
+		},
+		{
+			name: "Let",
+			line: 6, col: 13,
+			def: ast.Pos{File: testURI, Line: 4, Col: 6},
+			ok:  true,
+		},
+		{
+			name: "Argument",
+			line: 4, col: 14,
+			def: ast.Pos{File: testURI, Line: 3, Col: 6},
+			ok:  true,
+		},
+		{
+			name: "NotAName",
+			line: 5, col: 2,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			def, ok := a.definition(ast.Pos{File: testURI, Line: test.line, Col: test.col})
+			assert.Equal(t, ok, test.ok)
+			assert.Equal(t, def, test.def)
+		})
+	}
+}
+
+func TestMethodDefinitions(t *testing.T) {
+	a := analyse("", testURI, []byte(`
+	func main() {
+		let a = object { get() { 1 } }
+		let b = object { get() { "b" } }
+		a.get()
+	}
+	`))
+	assert.Nil(t, a.diags.Err())
+	assert.Equal(t, a.methodDefinitions("get"), []ast.Pos{
+		{File: testURI, Line: 3, Col: 20},
+		{File: testURI, Line: 4, Col: 20},
+	})
+	assert.Equal(t, len(a.methodDefinitions("main")), 0)
+}
+
+func TestHover(t *testing.T) {
+	a := analyse("", testURI, []byte(testSource))
+
+	text, ok := a.hover(ast.Pos{File: testURI, Line: 4, Col: 6}, "start")
+	assert.True(t, ok)
+	assert.Equal(t, text, "start: Int")
+
+	text, ok = a.hover(ast.Pos{File: testURI, Line: 6, Col: 3}, "value")
+	assert.True(t, ok)
+	assert.Equal(t, text, "value: {call(): Int}")
+
+	_, ok = a.hover(ast.Pos{File: testURI, Line: 1, Col: 1}, "import")
+	assert.False(t, ok)
+}
+
+// The runtime package has the types that the compiler gives it, rather than
+// whatever its uses suggest.
+func TestImportRuntime(t *testing.T) {
+	a := analyse("", testURI, []byte(`import runtime
+
+func main() {
+	let s = runtime.set()
+	s.add(1)
+}
+`))
+	assert.Nil(t, a.diags.Err())
+	text, ok := a.hover(ast.Pos{File: testURI, Line: 4, Col: 6}, "s")
+	assert.True(t, ok)
+	assert.Equal(t, text, "s: Set[Int]")
+
+	a = analyse("", testURI, []byte(`import runtime
+
+func main() {
+	let s: Int = runtime.set()
+	s
+}
+`))
+	assert.Equal(t, a.diags.Error(), testURI+":4:6: error: main: s: expected Int, got Set[a]: wrong constructor")
+}
+
+func TestCompletionsOfVariable(t *testing.T) {
+	a := analyse("", testURI, []byte(`
+	func twice(f, x) {
+		f(f(x))
+	}
+	`))
+	assert.Nil(t, a.diags.Err())
+
+	cs := a.completions(ast.Pos{File: testURI, Line: 3, Col: 3})
+	assert.Equal(t, len(cs), 1)
+	assert.Equal(t, cs[0].name, "call")
+}
+
+func TestWordAndReceiver(t *testing.T) {
+	d := newDocument(testURI, []byte("foo.bar(x)\n  baz. qu"))
+
+	start, end := d.word(5)
+	assert.Equal(t, string(d.text[start:end]), "bar")
+
+	recv, ok := d.receiver(5)
+	assert.True(t, ok)
+	assert.Equal(t, recv, 0)
+
+	recv, ok = d.receiver(len(d.text))
+	assert.True(t, ok)
+	assert.Equal(t, string(d.text[recv:recv+3]), "baz")
+
+	_, ok = d.receiver(1)
+	assert.False(t, ok)
+
+	assert.Equal(t, d.position(13), position{Line: 1, Character: 2})
+	assert.Equal(t, d.offset(position{Line: 1, Character: 2}), 13)
+}
@@ -0,0 +1,180 @@
+package lsp
+
+import (
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+)
+
+// document is the text of an open file, as the client last sent it.
+type document struct {
+	uri   string
+	text  []byte
+	lines []int
+
+	current *analysis
+
+	// the most recent version of the document that type checked, which is
+	// used while the text is being edited into shape
+	checked *document
+}
+
+func newDocument(uri string, text []byte) *document {
+	d := &document{uri: uri, text: text, lines: []int{0}}
+	for i, c := range text {
+		if c == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	return d
+}
+
+// The protocol counts lines from 0 and characters in UTF-16 code units, where
+// the compiler counts lines from 1 and columns in bytes.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type span struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+func (d *document) lineEnd(line int) int {
+	if line+1 < len(d.lines) {
+		return d.lines[line+1] - 1
+	}
+	return len(d.text)
+}
+
+// offset finds the byte offset of a position, clamping it to the text.
+func (d *document) offset(p position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	at, end := d.lines[p.Line], d.lineEnd(p.Line)
+	for units := 0; at < end && units < p.Character; {
+		r, size := utf8.DecodeRune(d.text[at:])
+		units += len(utf16.Encode([]rune{r}))
+		at += size
+	}
+	return at
+}
+
+func (d *document) position(offset int) position {
+	line := 0
+	for line+1 < len(d.lines) && d.lines[line+1] <= offset {
+		line++
+	}
+	units := 0
+	for _, r := range string(d.text[d.lines[line]:offset]) {
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return position{Line: line, Character: units}
+}
+
+func (d *document) posOffset(p ast.Pos) int {
+	if p.Line < 1 || p.Line > len(d.lines) {
+		return 0
+	}
+	off := d.lines[p.Line-1] + p.Col - 1
+	if off > len(d.text) {
+		return len(d.text)
+	}
+	return off
+}
+
+func (d *document) pos(offset int) ast.Pos {
+	p := d.position(offset)
+	return ast.Pos{
+		File: d.uri,
+		Line: p.Line + 1,
+		Col:  offset - d.lines[p.Line] + 1,
+	}
+}
+
+// span gives the range covering the word that starts at p, or a single
+// character if there is no word there.
+func (d *document) span(p ast.Pos) span {
+	start := d.posOffset(p)
+	_, end := d.word(start)
+	if end == start && end < d.lineEnd(d.position(start).Line) {
+		end++
+	}
+	return span{Start: d.position(start), End: d.position(end)}
+}
+
+// typed finds the type information for the word between start and end. If
+// the document does not type check, the last version that did is used as long
+// as the word is still in the same place.
+func (d *document) typed(start, end int) (*analysis, ast.Pos, bool) {
+	c := d.checked
+	if c == nil {
+		return nil, ast.Pos{}, false
+	}
+	p := d.pos(start)
+	at := c.posOffset(p)
+	if c.pos(at) != p || at+end-start > len(c.text) {
+		return nil, ast.Pos{}, false
+	}
+	if string(c.text[at:at+end-start]) != string(d.text[start:end]) {
+		return nil, ast.Pos{}, false
+	}
+	return c.current, p, true
+}
+
+// word finds the identifier that includes, or ends at, offset. If there is no
+// such identifier then start and end are both offset.
+func (d *document) word(offset int) (start, end int) {
+	start, end = offset, offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRune(d.text[:start])
+		if !isIdent(r) {
+			break
+		}
+		start -= size
+	}
+	for end < len(d.text) {
+		r, size := utf8.DecodeRune(d.text[end:])
+		if !isIdent(r) {
+			break
+		}
+		end += size
+	}
+	return start, end
+}
+
+// receiver finds the identifier before the dot that precedes the word at
+// offset, for words that name methods. ok is false if the word does not follow
+// a dot.
+func (d *document) receiver(offset int) (start int, ok bool) {
+	start, _ = d.word(offset)
+	at := d.skipSpaceBack(start)
+	if at == 0 || d.text[at-1] != '.' {
+		return 0, false
+	}
+	at = d.skipSpaceBack(at - 1)
+	start, _ = d.word(at)
+	return start, start < at
+}
+
+func (d *document) skipSpaceBack(offset int) int {
+	for offset > 0 {
+		r, size := utf8.DecodeLastRune(d.text[:offset])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		offset -= size
+	}
+	return offset
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
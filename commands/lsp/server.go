@@ -0,0 +1,369 @@
+// Package lsp is a language server for Cezanne. It speaks the language server
+// protocol over standard input and output, and gives editors diagnostics,
+// definitions, types and method completions for the files they have open.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bobappleyard/cezanne/commands"
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/diag"
+)
+
+type Options struct {
+	// Directory containing compiled packages, named by import path, whose
+	// types imports are checked against.
+	Packages string `option:"p"`
+}
+
+var ErrNoShutdown = errors.New("exit before shutdown")
+
+func init() {
+	commands.Register("lsp", Serve)
+}
+
+// Serve runs a language server on standard input and output until the client
+// tells it to exit.
+func Serve(options Options, args []string) error {
+	s := NewServer(os.Stdin, os.Stdout)
+	s.packages = options.Packages
+	return s.Run()
+}
+
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	packages string
+
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: map[string]*document{},
+	}
+}
+
+// Run handles messages until the client sends exit or closes the connection.
+func (s *Server) Run() error {
+	for {
+		buf, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(buf, &req); err != nil {
+			if err := s.fail(nil, &responseError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+type handler func(s *Server, params json.RawMessage) (any, error)
+
+var handlers = map[string]handler{
+	"initialize":              (*Server).initialize,
+	"initialized":             ignore,
+	"shutdown":                (*Server).shutdownServer,
+	"textDocument/didOpen":    (*Server).didOpen,
+	"textDocument/didChange":  (*Server).didChange,
+	"textDocument/didClose":   (*Server).didClose,
+	"textDocument/definition": (*Server).definition,
+	"textDocument/hover":      (*Server).hover,
+	"textDocument/completion": (*Server).completion,
+}
+
+// Notifications have no ID, and are never answered, even when they fail.
+func (s *Server) handle(req request) error {
+	h := handlers[req.Method]
+	var res any
+	var err error
+	switch {
+	case h == nil:
+		err = &responseError{codeMethodNotFound, req.Method}
+	case !s.initialized && req.Method != "initialize":
+		err = &responseError{codeNotInitialized, "server not initialized"}
+	default:
+		res, err = h(s, req.Params)
+	}
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		var rerr *responseError
+		if !errors.As(err, &rerr) {
+			rerr = &responseError{codeInvalidParams, err.Error()}
+		}
+		return s.fail(req.ID, rerr)
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: req.ID, Result: res})
+}
+
+func (s *Server) fail(id *json.RawMessage, err *responseError) error {
+	return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: *err})
+}
+
+func (s *Server) notify(method string, params any) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+func ignore(s *Server, params json.RawMessage) (any, error) {
+	return nil, nil
+}
+
+type textDocument struct {
+	URI string `json:"uri"`
+}
+
+type documentPosition struct {
+	TextDocument textDocument `json:"textDocument"`
+	Position     position     `json:"position"`
+}
+
+type location struct {
+	URI   string `json:"uri"`
+	Range span   `json:"range"`
+}
+
+func (s *Server) initialize(params json.RawMessage) (any, error) {
+	s.initialized = true
+	return map[string]any{
+		"capabilities": map[string]any{
+			// the whole text is sent with each change
+			"textDocumentSync":   1,
+			"definitionProvider": true,
+			"hoverProvider":      true,
+			"completionProvider": map[string]any{
+				"triggerCharacters": []string{"."},
+			},
+		},
+		"serverInfo": map[string]any{
+			"name": "cz",
+		},
+	}, nil
+}
+
+func (s *Server) shutdownServer(params json.RawMessage) (any, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *Server) didOpen(params json.RawMessage) (any, error) {
+	var p struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+}
+
+func (s *Server) didChange(params json.RawMessage) (any, error) {
+	var p struct {
+		TextDocument   textDocument `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.ContentChanges) == 0 {
+		return nil, nil
+	}
+	return nil, s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+}
+
+func (s *Server) didClose(params json.RawMessage) (any, error) {
+	var p struct {
+		TextDocument textDocument `json:"textDocument"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	delete(s.docs, p.TextDocument.URI)
+	return nil, s.publish(p.TextDocument.URI, nil)
+}
+
+// update analyses the new text of a document and tells the client what is
+// wrong with it.
+func (s *Server) update(uri, text string) error {
+	d := newDocument(uri, []byte(text))
+	if old := s.docs[uri]; old != nil {
+		d.checked = old.checked
+	}
+	s.docs[uri] = d
+
+	a := analyse(s.packages, uri, d.text)
+	d.current = a
+	if a.types != nil {
+		d.checked = d
+	}
+
+	var res []any
+	for _, x := range a.diags {
+		if x.Span.Start.File != uri && x.Span.Start.File != "" {
+			continue
+		}
+		res = append(res, map[string]any{
+			"range":    d.diagnosticSpan(x.Span),
+			"severity": severity(x.Severity),
+			"source":   "cz",
+			"message":  x.Message,
+		})
+	}
+	return s.publish(uri, res)
+}
+
+func (s *Server) publish(uri string, diags []any) error {
+	if diags == nil {
+		diags = []any{}
+	}
+	return s.notify("textDocument/publishDiagnostics", map[string]any{
+		"uri":         uri,
+		"diagnostics": diags,
+	})
+}
+
+func (d *document) diagnosticSpan(sp diag.Span) span {
+	if sp.Start.Line == 0 {
+		return span{}
+	}
+	res := d.span(sp.Start)
+	if sp.End.Line != 0 {
+		res.End = d.position(d.posOffset(sp.End))
+	}
+	return res
+}
+
+func severity(s diag.Severity) int {
+	switch s {
+	case diag.Warning:
+		return 2
+	case diag.Note:
+		return 3
+	}
+	return 1
+}
+
+// lookup finds the document and offset that a request refers to.
+func (s *Server) lookup(params json.RawMessage) (*document, int, error) {
+	var p documentPosition
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, 0, err
+	}
+	d := s.docs[p.TextDocument.URI]
+	if d == nil {
+		return nil, 0, &responseError{codeInvalidParams, p.TextDocument.URI + ": document not open"}
+	}
+	return d, d.offset(p.Position), nil
+}
+
+func (s *Server) definition(params json.RawMessage) (any, error) {
+	d, offset, err := s.lookup(params)
+	if err != nil {
+		return nil, err
+	}
+	start, end := d.word(offset)
+	if start == end {
+		return nil, nil
+	}
+
+	var found []ast.Pos
+	if _, ok := d.receiver(start); ok {
+		found = d.current.methodDefinitions(string(d.text[start:end]))
+	} else if pos, ok := d.current.definition(d.pos(start)); ok {
+		found = append(found, pos)
+	}
+
+	res := []location{}
+	for _, pos := range found {
+		res = append(res, location{URI: d.uri, Range: d.span(pos)})
+	}
+	return res, nil
+}
+
+func (s *Server) hover(params json.RawMessage) (any, error) {
+	d, offset, err := s.lookup(params)
+	if err != nil {
+		return nil, err
+	}
+	start, end := d.word(offset)
+	if start == end {
+		return nil, nil
+	}
+	a, pos, ok := d.typed(start, end)
+	if !ok {
+		return nil, nil
+	}
+	text, ok := a.hover(pos, string(d.text[start:end]))
+	if !ok {
+		return nil, nil
+	}
+	return map[string]any{
+		"contents": map[string]any{
+			"kind":  "plaintext",
+			"value": text,
+		},
+		"range": span{Start: d.position(start), End: d.position(end)},
+	}, nil
+}
+
+// Completions are found using the last version of the document that type
+// checked, as the one being edited usually will not.
+func (s *Server) completion(params json.RawMessage) (any, error) {
+	d, offset, err := s.lookup(params)
+	if err != nil {
+		return nil, err
+	}
+	items := []any{}
+	recv, ok := d.receiver(offset)
+	if !ok {
+		return items, nil
+	}
+	_, end := d.word(recv)
+	a, pos, ok := d.typed(recv, end)
+	if !ok {
+		return items, nil
+	}
+	for _, c := range a.completions(pos) {
+		items = append(items, map[string]any{
+			"label":  c.name,
+			"kind":   2, // method
+			"detail": c.detail,
+		})
+	}
+	return items, nil
+}
@@ -0,0 +1,173 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+const testURI = "file:///test.cz"
+
+const testSource = `import io
+
+func counter(n: Int) {
+	let start = n
+	object {
+		value() { start }
+		next() { counter(start) }
+	}
+}
+
+func main() {
+	let c = counter(1)
+	io.print(c.value())
+}
+`
+
+type session struct {
+	in  bytes.Buffer
+	ids int
+}
+
+func (s *session) send(method string, params any) {
+	s.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *session) call(method string, params any) {
+	s.ids++
+	s.write(map[string]any{"jsonrpc": "2.0", "id": s.ids, "method": method, "params": params})
+}
+
+func (s *session) write(m any) {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(&s.in, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+}
+
+type reply struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func (s *session) run(t *testing.T) []reply {
+	var out bytes.Buffer
+	err := NewServer(&s.in, &out).Run()
+	assert.Nil(t, err)
+
+	var res []reply
+	r := bufio.NewReader(&out)
+	for {
+		buf, err := readMessage(r)
+		if err == io.EOF {
+			return res
+		}
+		assert.Nil(t, err)
+		var m reply
+		assert.Nil(t, json.Unmarshal(buf, &m))
+		res = append(res, m)
+	}
+}
+
+func at(line, char int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": testURI},
+		"position":     map[string]any{"line": line, "character": char},
+	}
+}
+
+func TestSession(t *testing.T) {
+	var s session
+	s.call("initialize", map[string]any{})
+	s.send("initialized", map[string]any{})
+	s.send("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": testURI, "text": testSource},
+	})
+	s.call("textDocument/definition", at(12, 13))
+	s.call("textDocument/hover", at(11, 5))
+	s.send("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": testURI},
+		"contentChanges": []any{map[string]any{"text": strings.Replace(testSource, "c.value()", "c.", 1)}},
+	})
+	s.call("textDocument/completion", at(12, 12))
+	s.call("shutdown", nil)
+	s.send("exit", nil)
+
+	res := s.run(t)
+	assert.Equal(t, len(res), 7)
+
+	assert.Equal(t, res[0].ID, 1)
+	var init struct {
+		Capabilities struct {
+			HoverProvider bool `json:"hoverProvider"`
+		} `json:"capabilities"`
+	}
+	assert.Nil(t, json.Unmarshal(res[0].Result, &init))
+	assert.True(t, init.Capabilities.HoverProvider)
+
+	assert.Equal(t, res[1].Method, "textDocument/publishDiagnostics")
+	assert.Equal(t, string(res[1].Params), `{"diagnostics":[],"uri":"file:///test.cz"}`)
+
+	assert.Equal(t, string(res[2].Result),
+		`[{"uri":"file:///test.cz","range":{"start":{"line":5,"character":2},"end":{"line":5,"character":7}}}]`)
+
+	var hover struct {
+		Contents struct {
+			Value string `json:"value"`
+		} `json:"contents"`
+	}
+	assert.Nil(t, json.Unmarshal(res[3].Result, &hover))
+	assert.Equal(t, hover.Contents.Value, "c: rec a. {next(): a, value(): Int}")
+
+	var diags struct {
+		Diagnostics []struct {
+			Range   span   `json:"range"`
+			Message string `json:"message"`
+		} `json:"diagnostics"`
+	}
+	assert.Nil(t, json.Unmarshal(res[4].Params, &diags))
+	assert.Equal(t, len(diags.Diagnostics), 1)
+	assert.Equal(t, diags.Diagnostics[0].Message, "unexpected token")
+	assert.Equal(t, diags.Diagnostics[0].Range.Start, position{Line: 12, Character: 12})
+
+	var items []struct {
+		Label string `json:"label"`
+	}
+	assert.Nil(t, json.Unmarshal(res[5].Result, &items))
+	assert.Equal(t, len(items), 2)
+	assert.Equal(t, items[0].Label, "next")
+	assert.Equal(t, items[1].Label, "value")
+
+	assert.Equal(t, string(res[6].Result), "null")
+}
+
+func TestNotInitialized(t *testing.T) {
+	var s session
+	s.call("textDocument/hover", at(0, 0))
+	s.send("initialized", map[string]any{})
+
+	res := s.run(t)
+	assert.Equal(t, len(res), 1)
+	assert.Equal(t, res[0].Error.Code, codeNotInitialized)
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	var s session
+	s.call("initialize", map[string]any{})
+	s.send("exit", nil)
+
+	var out bytes.Buffer
+	err := NewServer(&s.in, &out).Run()
+	assert.True(t, errors.Is(err, ErrNoShutdown))
+}
@@ -10,7 +10,9 @@ var ErrUnknownCommand = errors.New("unknown command")
 func Register[T any](name string, proc func(options T, args []string) error) {
 	registry[name] = func(ctx Context) error {
 		var options T
-		ctx.BindOptions(&options)
+		if err := ctx.BindOptions(&options); err != nil {
+			return err
+		}
 		return proc(options, ctx.Args())
 	}
 }
@@ -26,7 +28,7 @@ func Execute(context Context) error {
 type Context interface {
 	Name() string
 	Args() []string
-	BindOptions(options any)
+	BindOptions(options any) error
 }
 
 var registry = map[string]func(ctx Context) error{}
@@ -0,0 +1,105 @@
+// Package run provides a command that links and runs programs.
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bobappleyard/cezanne/commands"
+	"github.com/bobappleyard/cezanne/commands/link"
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/core"
+	"github.com/bobappleyard/cezanne/runtime/env"
+	"github.com/bobappleyard/cezanne/runtime/heap"
+	"github.com/bobappleyard/cezanne/runtime/trace"
+)
+
+type Options struct {
+	// Directory containing compiled packages, named by import path.
+	Packages string `option:"p"`
+
+	// Write the program's execution to a file, as one JSON event per line.
+	Trace string `option:"trace"`
+
+	// The most words that the heap may grow to. There is no limit by default.
+	MaxHeap int `option:"max-heap"`
+
+	// Write the objects that the program holds on to when it finishes to a
+	// file, as one JSON object on each line. By then only what is reachable
+	// from globals, constants and the result of main is left.
+	HeapProfile string `option:"heap-profile"`
+}
+
+var ErrOneFile = errors.New("expected one package file")
+
+func init() {
+	commands.Register("run", Run)
+}
+
+// Run links a main package with the packages that it imports, and runs the
+// program.
+func Run(options Options, files []string) error {
+	if len(files) != 1 {
+		return ErrOneFile
+	}
+	var syms symtab.Symtab
+	prog, err := link.Link(&syms, link.Dir{Main: files[0], Path: options.Packages, Symbols: &syms})
+	if err != nil {
+		return err
+	}
+	e := new(env.Env)
+	e.SetMaxHeapSize(options.MaxHeap)
+	core.Install(e)
+	if options.HeapProfile == "" {
+		return run(options, e, &syms, prog)
+	}
+	var profileErr error
+	e.SetExitHook(func(p *env.Process) {
+		profileErr = writeHeapProfile(options.HeapProfile, p)
+	})
+	if err := run(options, e, &syms, prog); err != nil {
+		return err
+	}
+	return profileErr
+}
+
+func writeHeapProfile(path string, p *env.Process) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := heap.WriteSnapshot(f, p); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func run(options Options, e *env.Env, syms *symtab.Symtab, prog *format.Program) error {
+	if options.Trace == "" {
+		return e.Run(syms, prog)
+	}
+
+	f, err := os.Create(options.Trace)
+	if err != nil {
+		return err
+	}
+
+	// the trace of a program that fails is the one most worth having
+	w := trace.NewWriter(f, syms, prog)
+	e.SetTracer(w)
+	err = e.Run(syms, prog)
+	traceErr := w.Flush()
+	if closeErr := f.Close(); traceErr == nil {
+		traceErr = closeErr
+	}
+	switch {
+	case err == nil:
+		return traceErr
+	case traceErr != nil:
+		return fmt.Errorf("%w (writing the trace: %v)", err, traceErr)
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package run
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/compile"
+	"github.com/bobappleyard/cezanne/runtime/env"
+	"github.com/bobappleyard/cezanne/runtime/memory"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestRunTrace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.cz")
+	pkg := filepath.Join(dir, "main")
+	out := filepath.Join(dir, "trace.jsonl")
+	err := os.WriteFile(src, []byte("func main() {\n\tid(3)\n}\n\nfunc id(x) {\n\tx\n}\n"), 0o644)
+	assert.Nil(t, err)
+	assert.Nil(t, compile.Compile(compile.Options{Output: pkg}, []string{src}))
+
+	assert.Nil(t, Run(Options{Trace: out}, []string{pkg}))
+
+	data, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	t.Log(string(data))
+	// the main package's class depends on what the runtime package defines
+	class := regexp.MustCompile(`"class":"c\d+",`)
+	var events []string
+	for _, l := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if strings.Contains(l, `"method":"main"`) || strings.Contains(l, `"method":"id"`) {
+			events = append(events, class.ReplaceAllString(l, ""))
+		}
+	}
+	assert.Equal(t, events, []string{
+		`{"event":"enter","method":"main"}`,
+		`{"event":"exit","method":"main"}`,
+		`{"event":"enter","method":"id"}`,
+		`{"event":"exit","method":"id"}`,
+	})
+}
+
+func TestRunTraceFailing(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.cz")
+	pkg := filepath.Join(dir, "main")
+	out := filepath.Join(dir, "trace.jsonl")
+	err := os.WriteFile(src, []byte("func main() {\n\tid(1).div(0)\n}\n\nfunc id(x) {\n\tx\n}\n"), 0o644)
+	assert.Nil(t, err)
+	assert.Nil(t, compile.Compile(compile.Options{Output: pkg}, []string{src}))
+
+	err = Run(Options{Trace: out}, []string{pkg})
+	assert.True(t, errors.Is(err, env.ErrUnhandledEffect))
+
+	// the trace is written up to where the program stopped
+	data, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(data), `"method":"id"`))
+}
+
+func TestRunOneFile(t *testing.T) {
+	err := Run(Options{}, nil)
+	assert.True(t, errors.Is(err, ErrOneFile))
+}
+
+func TestRunOutOfMemory(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.cz")
+	pkg := filepath.Join(dir, "main")
+	err := os.WriteFile(src, []byte("func main() {\n\tgrow([0])\n}\n\nfunc grow(xs) {\n\tgrow(xs.push(0))\n}\n"), 0o644)
+	assert.Nil(t, err)
+	assert.Nil(t, compile.Compile(compile.Options{Output: pkg}, []string{src}))
+
+	err = Run(Options{MaxHeap: 1 << 12}, []string{pkg})
+	assert.True(t, errors.Is(err, memory.ErrOutOfMemory))
+}
+
+func TestRunHeapProfile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.cz")
+	pkg := filepath.Join(dir, "main")
+	out := filepath.Join(dir, "heap.jsonl")
+	err := os.WriteFile(src, []byte("func main() {\n\t\"hello\".length()\n}\n"), 0o644)
+	assert.Nil(t, err)
+	assert.Nil(t, compile.Compile(compile.Options{Output: pkg}, []string{src}))
+
+	assert.Nil(t, Run(Options{HeapProfile: out}, []string{pkg}))
+
+	data, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	// constants are held by the program, so they are still there at the end
+	assert.True(t, strings.Contains(string(data), `{"id":0,"class":"String","words":2,"root":true}`))
+}
+
+func TestRunHeapProfileResult(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.cz")
+	pkg := filepath.Join(dir, "main")
+	out := filepath.Join(dir, "heap.jsonl")
+	err := os.WriteFile(src, []byte("func main() {\n\t[1, 2, 3]\n}\n"), 0o644)
+	assert.Nil(t, err)
+	assert.Nil(t, compile.Compile(compile.Options{Output: pkg}, []string{src}))
+
+	assert.Nil(t, Run(Options{HeapProfile: out}, []string{pkg}))
+
+	data, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	// the snapshot is taken while the result of main is still held
+	assert.True(t, strings.Contains(string(data), `"class":"array","words":3,"root":true}`))
+}
@@ -1,5 +1,26 @@
 package main
 
+import (
+	"fmt"
+	"os"
+
+	"github.com/bobappleyard/cezanne/commands"
+	_ "github.com/bobappleyard/cezanne/commands/asm"
+	_ "github.com/bobappleyard/cezanne/commands/compile"
+	_ "github.com/bobappleyard/cezanne/commands/debug"
+	_ "github.com/bobappleyard/cezanne/commands/disasm"
+	_ "github.com/bobappleyard/cezanne/commands/lsp"
+	_ "github.com/bobappleyard/cezanne/commands/run"
+)
+
 func main() {
-	
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: cz <command> [options] [arguments]")
+		os.Exit(2)
+	}
+	err := commands.Execute(commands.NewContext(os.Args[1], os.Args[2:]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cz %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
 }
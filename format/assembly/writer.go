@@ -1,6 +1,8 @@
 package assembly
 
 import (
+	"strconv"
+
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/format/symtab"
 )
@@ -14,6 +16,8 @@ type Writer struct {
 	bindings []format.Implementation
 	external []symtab.Symbol
 	imports  []string
+	lines    format.LineTable
+	consts   []format.Constant
 }
 
 type Value interface {
@@ -21,7 +25,7 @@ type Value interface {
 }
 
 func (b *Writer) Package() *format.Package {
-	return &format.Package{
+	p := &format.Package{
 		ExternalMethods: b.external,
 		Imports:         b.imports,
 		Classes:         b.classes,
@@ -29,7 +33,13 @@ func (b *Writer) Package() *format.Package {
 		Implementations: b.bindings,
 		Relocations:     b.rels,
 		Code:            b.code,
+		Lines:           b.lines,
+		Constants:       b.consts,
+	}
+	if b.syms != nil {
+		p.Symbols = b.syms.Copy()
 	}
+	return p
 }
 
 func New(syms *symtab.Symtab) *Writer {
@@ -84,6 +94,19 @@ func (b *Writer) Call(methodID *Method, base int) {
 	b.WriteByte(base)
 }
 
+func (b *Writer) Constant(id *Constant) {
+	b.WriteByte(format.ConstantOp)
+	id.write()
+}
+
+// Array creates an array of the given length from the registers starting at
+// base.
+func (b *Writer) Array(length, base int) {
+	b.WriteByte(format.ArrayOp)
+	b.writeInt(length)
+	b.WriteByte(base)
+}
+
 func (b *Writer) WriteByte(value int) {
 	b.code = append(b.code, byte(value))
 }
@@ -95,10 +118,40 @@ func (b *Writer) writeInt(value int) {
 	b.WriteByte(value >> 24)
 }
 
+// SourcePos records that the code written next was compiled from a position
+// in a source file.
+func (b *Writer) SourcePos(file string, line, col int) {
+	var fileID int
+	b.lines.Files, fileID = ensure(b.lines.Files, func(x string) bool {
+		return x == file
+	}, func() string {
+		return file
+	})
+	e := format.LineEntry{
+		Offset: uint32(len(b.code)),
+		File:   int32(fileID),
+		Line:   int32(line),
+		Col:    int32(col),
+	}
+
+	entries := b.lines.Entries
+	if n := len(entries); n != 0 {
+		last := entries[n-1]
+		if last.File == e.File && last.Line == e.Line && last.Col == e.Col {
+			return
+		}
+		if last.Offset == e.Offset {
+			entries = entries[:n-1]
+		}
+	}
+	b.lines.Entries = append(entries, e)
+}
+
 type Location struct {
-	b    *Writer
-	refs []int
-	def  int
+	b        *Writer
+	refs     []int
+	bindings []int
+	def      int
 }
 
 func (l *Location) write() {
@@ -118,7 +171,11 @@ func (l *Location) Define() {
 	for _, r := range l.refs {
 		l.b.rels[r].ID = int32(l.def)
 	}
+	for _, i := range l.bindings {
+		l.b.bindings[i].EntryPoint = uint32(l.def)
+	}
 	l.refs = nil
+	l.bindings = nil
 }
 
 func (b *Writer) Location() *Location {
@@ -154,6 +211,10 @@ func (b *Writer) Method(name symtab.Symbol) *Method {
 	}
 }
 
+func (m *Method) SetVisibility(v format.Visibility) {
+	m.b.methods[m.id].Visibility = v
+}
+
 type Class struct {
 	b  *Writer
 	id format.ClassID
@@ -181,6 +242,14 @@ func (c *Class) SetFields(count int) {
 	c.b.classes[c.id].Fieldc = uint32(count)
 }
 
+func (c *Class) SetKind(kind format.CoreKind) {
+	c.b.classes[c.id].Kind = kind
+}
+
+func (c *Class) SetName(name symtab.Symbol) {
+	c.b.classes[c.id].Name = name
+}
+
 type Global struct {
 	b    *Writer
 	kind format.RelocationKind
@@ -218,6 +287,44 @@ func (b *Writer) Import(name string) *Global {
 	}
 }
 
+type Constant struct {
+	b  *Writer
+	id int
+}
+
+func (c *Constant) write() {
+	c.b.rels = append(c.b.rels, format.Relocation{
+		Kind: format.ConstantRel,
+		ID:   int32(c.id),
+		Pos:  uint32(len(c.b.code)),
+	})
+	c.b.writeInt(0)
+}
+
+// String adds a string to the package's constant pool. Adding the same string
+// again gives the same constant.
+func (b *Writer) String(value string) *Constant {
+	return b.constant(format.Constant{Kind: format.StringKind, Value: value})
+}
+
+// Float adds a float to the package's constant pool.
+func (b *Writer) Float(value float64) *Constant {
+	return b.constant(format.Constant{Kind: format.FloatKind, Value: strconv.FormatFloat(value, 'g', -1, 64)})
+}
+
+func (b *Writer) constant(c format.Constant) *Constant {
+	var id int
+	b.consts, id = ensure(b.consts, func(x format.Constant) bool {
+		return x == c
+	}, func() format.Constant {
+		return c
+	})
+	return &Constant{
+		b:  b,
+		id: id,
+	}
+}
+
 type Fixed struct {
 	b     *Writer
 	value int
@@ -243,19 +350,48 @@ func (b *Writer) ImplementMethod(class *Class, method *Method) {
 	})
 }
 
-func (b *Writer) ImplementExternalMethod(class *Class, method *Method, ext symtab.Symbol) {
-	var entryPoint int
-	b.external, entryPoint = ensure(b.external, func(x symtab.Symbol) bool {
-		return x == ext
-	}, func() symtab.Symbol {
-		return ext
+// ImplementMethodAt binds a method to the code at a location, which may be
+// defined later.
+func (b *Writer) ImplementMethodAt(class *Class, method *Method, entry *Location) {
+	b.implementAt(format.StandardBinding, class, method, entry)
+}
+
+// ImplementHandlerAt is like ImplementMethodAt, but binds an effect handler.
+func (b *Writer) ImplementHandlerAt(class *Class, method *Method, entry *Location) {
+	b.implementAt(format.HandlerBinding, class, method, entry)
+}
+
+func (b *Writer) implementAt(kind format.ImplKind, class *Class, method *Method, entry *Location) {
+	if entry.def == 0 {
+		entry.bindings = append(entry.bindings, len(b.bindings))
+	}
+	b.bindings = append(b.bindings, format.Implementation{
+		Class:      class.id,
+		Method:     method.id,
+		Kind:       kind,
+		EntryPoint: uint32(entry.def),
 	})
+}
+
+func (b *Writer) ImplementExternalMethod(class *Class, method *Method, ext symtab.Symbol) {
 	b.bindings = append(b.bindings, format.Implementation{
 		Class:      class.id,
 		Method:     method.id,
 		Kind:       format.ExternalBinding,
-		EntryPoint: uint32(entryPoint),
+		EntryPoint: uint32(b.External(ext)),
+	})
+}
+
+// External declares an external method, giving its index in the package.
+// Declaring the same method again gives the same index.
+func (b *Writer) External(ext symtab.Symbol) int {
+	var id int
+	b.external, id = ensure(b.external, func(x symtab.Symbol) bool {
+		return x == ext
+	}, func() symtab.Symbol {
+		return ext
 	})
+	return id
 }
 
 func ensure[T any](xs []T, test func(x T) bool, cons func() T) ([]T, int) {
@@ -85,6 +85,31 @@ func TestImport(t *testing.T) {
 	})
 }
 
+func TestConstant(t *testing.T) {
+	var tab symtab.Symtab
+	b := New(&tab)
+
+	b.Constant(b.String("a"))
+	b.Constant(b.Float(1.5))
+	b.Constant(b.String("a"))
+
+	p := b.Package()
+	assert.Equal(t, p.Code, []byte{
+		format.ConstantOp, 0, 0, 0, 0,
+		format.ConstantOp, 0, 0, 0, 0,
+		format.ConstantOp, 0, 0, 0, 0,
+	})
+	assert.Equal(t, p.Constants, []format.Constant{
+		{Kind: format.StringKind, Value: "a"},
+		{Kind: format.FloatKind, Value: "1.5"},
+	})
+	assert.Equal(t, p.Relocations, []format.Relocation{
+		{Kind: format.ConstantRel, ID: 0, Pos: 1},
+		{Kind: format.ConstantRel, ID: 1, Pos: 6},
+		{Kind: format.ConstantRel, ID: 0, Pos: 11},
+	})
+}
+
 func TestClass(t *testing.T) {
 	var tab symtab.Symtab
 	b := New(&tab)
@@ -137,3 +162,72 @@ func TestBinding(t *testing.T) {
 	}})
 
 }
+
+func TestBindingAtLocation(t *testing.T) {
+	var tab symtab.Symtab
+	b := New(&tab)
+
+	pkg := b.Class(0)
+	entry := b.Location()
+	handler := b.Location()
+
+	b.ImplementMethodAt(pkg, b.Method(tab.SymbolID("main")), entry)
+	b.ImplementExternalMethod(pkg, b.Method(tab.SymbolID("print")), tab.SymbolID("io:print"))
+	b.Create(pkg, 0)
+	b.Return()
+	entry.Define()
+	b.Return()
+	handler.Define()
+	b.ImplementHandlerAt(pkg, b.Method(tab.SymbolID("fail")), handler)
+	b.Return()
+
+	p := b.Package()
+	assert.Equal(t, p.Implementations, []format.Implementation{
+		{Class: 0, Method: 0, Kind: format.StandardBinding, EntryPoint: 7},
+		{Class: 0, Method: 1, Kind: format.ExternalBinding, EntryPoint: 0},
+		{Class: 0, Method: 2, Kind: format.HandlerBinding, EntryPoint: 8},
+	})
+}
+
+func TestDeclarations(t *testing.T) {
+	var tab symtab.Symtab
+	b := New(&tab)
+
+	c := b.Class(0)
+	c.SetKind(format.TrueKind)
+	c.SetName(tab.SymbolID("True"))
+	b.Method(tab.SymbolID("secret")).SetVisibility(format.Private)
+	assert.Equal(t, b.External(tab.SymbolID("a")), 0)
+	assert.Equal(t, b.External(tab.SymbolID("b")), 1)
+	assert.Equal(t, b.External(tab.SymbolID("a")), 0)
+
+	p := b.Package()
+	assert.Equal(t, p.Classes, []format.Class{{Name: tab.SymbolID("True"), Kind: format.TrueKind}})
+	assert.Equal(t, p.Methods, []format.Method{{
+		Name:       tab.SymbolID("secret"),
+		Visibility: format.Private,
+	}})
+	assert.Equal(t, p.ExternalMethods, []symtab.Symbol{tab.SymbolID("a"), tab.SymbolID("b")})
+}
+
+func TestSourcePos(t *testing.T) {
+	var tab symtab.Symtab
+	b := New(&tab)
+
+	b.SourcePos("a.cz", 1, 1)
+	b.Load(2)
+	b.SourcePos("a.cz", 1, 1)
+	b.Load(3)
+	b.SourcePos("b.cz", 2, 1)
+	b.SourcePos("a.cz", 3, 4)
+	b.Return()
+
+	p := b.Package()
+	assert.Equal(t, p.Lines, format.LineTable{
+		Files: []string{"a.cz", "b.cz"},
+		Entries: []format.LineEntry{
+			{Offset: 0, File: 0, Line: 1, Col: 1},
+			{Offset: 4, File: 0, Line: 3, Col: 4},
+		},
+	})
+}
@@ -0,0 +1,128 @@
+// Package disasm decodes bytecode and describes packages and programs in a
+// form that people can read.
+package disasm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bobappleyard/cezanne/format"
+)
+
+var (
+	ErrUnknownOp = errors.New("unknown instruction")
+	ErrTruncated = errors.New("truncated instruction")
+)
+
+// OperandKind says what an instruction's operand refers to, and so how it is
+// encoded.
+type OperandKind int
+
+const (
+	_ OperandKind = iota
+
+	// a variable in the current frame
+	Register
+
+	// the frame offset at which a call's arguments, or an object's fields,
+	// start
+	Base
+
+	Int
+	Global
+	Class
+	Method
+
+	// a string literal in the package's constant pool
+	Constant
+)
+
+// Size is the number of bytes an operand of this kind takes up.
+func (k OperandKind) Size() int {
+	switch k {
+	case Register, Base:
+		return 1
+	}
+	return 4
+}
+
+type Op struct {
+	Name     string
+	Operands []OperandKind
+}
+
+var ops = map[byte]Op{
+	format.LoadOp:        {"LOAD", []OperandKind{Register}},
+	format.StoreOp:       {"STORE", []OperandKind{Register}},
+	format.NaturalOp:     {"NATURAL", []OperandKind{Int}},
+	format.GlobalLoadOp:  {"GLOBAL_LOAD", []OperandKind{Global}},
+	format.GlobalStoreOp: {"GLOBAL_STORE", []OperandKind{Global}},
+	format.CreateOp:      {"CREATE", []OperandKind{Class, Base}},
+	format.FieldOp:       {"FIELD", []OperandKind{Int}},
+	format.RetOp:         {"RETURN", nil},
+	format.CallOp:        {"CALL", []OperandKind{Method, Base}},
+	format.ConstantOp:    {"CONSTANT", []OperandKind{Constant}},
+	format.ArrayOp:       {"ARRAY", []OperandKind{Int, Base}},
+}
+
+// Lookup describes an opcode.
+func Lookup(op byte) (Op, bool) {
+	o, ok := ops[op]
+	return o, ok
+}
+
+// Instruction is an opcode along with its operands, which are given in the
+// order that they are encoded.
+type Instruction struct {
+	Pos  int
+	Op   byte
+	Args []int32
+}
+
+func (i Instruction) Size() int {
+	n := 1
+	for _, k := range ops[i.Op].Operands {
+		n += k.Size()
+	}
+	return n
+}
+
+// DecodeAt reads the instruction at pos.
+func DecodeAt(code []byte, pos int) (Instruction, error) {
+	if pos < 0 || pos >= len(code) {
+		return Instruction{}, fmt.Errorf("%d: %w", pos, ErrTruncated)
+	}
+	op, ok := ops[code[pos]]
+	if !ok {
+		return Instruction{}, fmt.Errorf("%d: %d: %w", pos, code[pos], ErrUnknownOp)
+	}
+	res := Instruction{Pos: pos, Op: code[pos]}
+	at := pos + 1
+	for _, k := range op.Operands {
+		if at+k.Size() > len(code) {
+			return Instruction{}, fmt.Errorf("%d: %s: %w", pos, op.Name, ErrTruncated)
+		}
+		var arg int32
+		for i := 0; i < k.Size(); i++ {
+			arg |= int32(code[at+i]) << (8 * i)
+		}
+		res.Args = append(res.Args, arg)
+		at += k.Size()
+	}
+	return res, nil
+}
+
+// Decode reads every instruction in code, which must consist only of whole
+// instructions.
+func Decode(code []byte) ([]Instruction, error) {
+	var res []Instruction
+	for pos := 0; pos < len(code); {
+		i, err := DecodeAt(code, pos)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, i)
+		pos += i.Size()
+	}
+	return res, nil
+}
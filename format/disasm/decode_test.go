@@ -0,0 +1,54 @@
+package disasm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestDecode(t *testing.T) {
+	is, err := Decode([]byte{
+		format.LoadOp, 3,
+		format.CallOp, 1, 1, 0, 0, 7,
+		format.NaturalOp, 0xff, 0xff, 0xff, 0xff,
+		format.RetOp,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, is, []Instruction{
+		{Pos: 0, Op: format.LoadOp, Args: []int32{3}},
+		{Pos: 2, Op: format.CallOp, Args: []int32{257, 7}},
+		{Pos: 8, Op: format.NaturalOp, Args: []int32{-1}},
+		{Pos: 13, Op: format.RetOp},
+	})
+	assert.Equal(t, is[1].Size(), 6)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		code []byte
+		err  error
+		msg  string
+	}{
+		{
+			name: "UnknownOp",
+			code: []byte{format.RetOp, 200},
+			err:  ErrUnknownOp,
+			msg:  "1: 200: unknown instruction",
+		},
+		{
+			name: "TruncatedOperand",
+			code: []byte{format.GlobalLoadOp, 1, 0},
+			err:  ErrTruncated,
+			msg:  "0: GLOBAL_LOAD: truncated instruction",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decode(test.code)
+			assert.True(t, errors.Is(err, test.err))
+			assert.Equal(t, err.Error(), test.msg)
+		})
+	}
+}
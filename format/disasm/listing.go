@@ -0,0 +1,287 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
+
+// Package writes a listing of a package. The tables come first, as
+// directives, followed by the code. Operands that the package relocates are
+// written symbolically: classes as c0, c1..., globals as g0, g1..., imports
+// by their path, methods by name and code addresses by label. Implementations
+// are labelled with their class and method, as in c1.match. Constants are
+// written as the strings that they stand for.
+func Package(w io.Writer, p *format.Package) error {
+	l := newLister(w, &p.Symbols, p.Methods, p.ExternalMethods)
+	l.imports = p.Imports
+	l.consts = p.Constants
+	for _, r := range p.Relocations {
+		l.rels[int(r.Pos)] = r
+		if r.Kind == format.CodeRel {
+			l.label(int(r.ID), codeLabel(int(r.ID)))
+		}
+	}
+
+	for _, imp := range p.Imports {
+		l.printf("import %s\n", strconv.Quote(imp))
+	}
+	l.externs()
+	for _, m := range p.Methods {
+		l.printf("method %s", l.syms.SymbolName(m.Name))
+		if m.Visibility == format.Private {
+			l.printf(" private")
+		}
+		l.printf("\n")
+	}
+	l.classes(p.Classes)
+	l.impls(p.Implementations)
+
+	return l.code(p.Code)
+}
+
+// Program writes a listing of a linked program, in the same form as Package.
+// As a program has no relocations, operands are only written symbolically
+// where their kind makes it clear what they refer to.
+func Program(w io.Writer, p *format.Program) error {
+	l := newLister(w, &p.Symbols, p.Methods, p.ExternalMethods)
+	l.consts = p.Constants
+
+	l.printf("globals %d\n", p.GlobalCount)
+	l.externs()
+	for _, m := range p.Methods {
+		l.printf("method %s offset=%d\n", l.syms.SymbolName(m.Name), m.Offset)
+	}
+	l.classes(p.Classes)
+	l.impls(p.Implmentations)
+
+	return l.code(p.Code)
+}
+
+var kindNames = map[format.CoreKind]string{
+	format.IntKind:    "int",
+	format.TrueKind:   "true",
+	format.FalseKind:  "false",
+	format.ArrayKind:  "array",
+	format.StringKind: "string",
+	format.FloatKind:  "float",
+	format.BigIntKind: "bigint",
+
+	format.ContinuationKind: "continuation",
+	format.MapKind:          "map",
+	format.SetKind:          "set",
+}
+
+type lister struct {
+	w       io.Writer
+	err     error
+	syms    *symtab.Symtab
+	methods []format.Method
+	extern  []symtab.Symbol
+	imports []string
+	consts  []format.Constant
+
+	// relocations by the position of the operand they apply to, and labels
+	// by the position that they mark
+	rels   map[int]format.Relocation
+	labels map[int][]string
+}
+
+func newLister(w io.Writer, syms *symtab.Symtab, methods []format.Method, extern []symtab.Symbol) *lister {
+	return &lister{
+		w:       w,
+		syms:    syms,
+		methods: methods,
+		extern:  extern,
+		rels:    map[int]format.Relocation{},
+		labels:  map[int][]string{},
+	}
+}
+
+func (l *lister) printf(format string, args ...any) {
+	if l.err != nil {
+		return
+	}
+	_, l.err = fmt.Fprintf(l.w, format, args...)
+}
+
+func (l *lister) label(pos int, name string) {
+	for _, n := range l.labels[pos] {
+		if n == name {
+			return
+		}
+	}
+	l.labels[pos] = append(l.labels[pos], name)
+}
+
+func codeLabel(pos int) string {
+	return fmt.Sprintf("L%d", pos)
+}
+
+func (l *lister) methodName(id int32) string {
+	if id < 0 || int(id) >= len(l.methods) {
+		return strconv.Itoa(int(id))
+	}
+	return l.syms.SymbolName(l.methods[id].Name)
+}
+
+func (l *lister) externName(id uint32) string {
+	if int(id) >= len(l.extern) {
+		return strconv.Itoa(int(id))
+	}
+	return strconv.Quote(l.syms.SymbolName(l.extern[id]))
+}
+
+func (l *lister) externs() {
+	for i := range l.extern {
+		l.printf("extern %s\n", l.externName(uint32(i)))
+	}
+}
+
+func (l *lister) classes(cs []format.Class) {
+	for i, c := range cs {
+		l.printf("class c%d fields=%d", i, c.Fieldc)
+		if k, ok := kindNames[c.Kind]; ok {
+			l.printf(" kind=%s", k)
+		}
+		if c.Name.ID != 0 {
+			l.printf(" // %s", l.syms.SymbolName(c.Name))
+		}
+		l.printf("\n")
+	}
+}
+
+// Programs lay their implementations out in a table with gaps in it, which
+// are left out.
+func (l *lister) impls(impls []format.Implementation) {
+	for _, impl := range impls {
+		if impl.Kind == 0 {
+			continue
+		}
+		l.printf("impl c%d %s ", impl.Class, l.methodName(int32(impl.Method)))
+		switch impl.Kind {
+		case format.ExternalBinding:
+			l.printf("extern %s\n", l.externName(impl.EntryPoint))
+			continue
+		case format.HandlerBinding:
+			l.printf("handler ")
+		}
+		name := fmt.Sprintf("c%d.%s", impl.Class, l.methodName(int32(impl.Method)))
+		l.label(int(impl.EntryPoint), name)
+		l.printf("%s\n", name)
+	}
+}
+
+// The code section has a line for each label and each instruction. The
+// instructions are indented, and followed by their offsets, which are lined up
+// with one another.
+func (l *lister) code(code []byte) error {
+	type line struct {
+		label string
+		text  string
+		pos   int
+	}
+	var lines []line
+	labels := func(pos int) {
+		names := l.labels[pos]
+		sort.Strings(names)
+		for _, n := range names {
+			lines = append(lines, line{label: n})
+		}
+	}
+
+	var err error
+	width := 0
+	for pos := 0; pos < len(code); {
+		labels(pos)
+		var i Instruction
+		i, err = DecodeAt(code, pos)
+		if err != nil {
+			break
+		}
+		text := l.instruction(i)
+		if len(text) > width {
+			width = len(text)
+		}
+		lines = append(lines, line{text: text, pos: pos})
+		pos += i.Size()
+	}
+	if err == nil {
+		labels(len(code))
+	}
+
+	l.printf("\n")
+	for _, ln := range lines {
+		if ln.label != "" {
+			l.printf("%s:\n", ln.label)
+			continue
+		}
+		l.printf("\t%-*s // %d\n", width, ln.text, ln.pos)
+	}
+	if l.err != nil {
+		return l.err
+	}
+	return err
+}
+
+// Floats are written so that they can be told apart from integers.
+func constant(c format.Constant) string {
+	if c.Kind == format.FloatKind {
+		if strings.ContainsAny(c.Value, ".eIN") {
+			return c.Value
+		}
+		return c.Value + ".0"
+	}
+	return strconv.Quote(c.Value)
+}
+
+func (l *lister) instruction(i Instruction) string {
+	op := ops[i.Op]
+	parts := []string{op.Name}
+	at := i.Pos + 1
+	for j, k := range op.Operands {
+		parts = append(parts, l.operand(k, at, i.Args[j]))
+		at += k.Size()
+	}
+	return strings.Join(parts, " ")
+}
+
+func (l *lister) operand(k OperandKind, pos int, value int32) string {
+	r, relocated := l.rels[pos]
+	if relocated {
+		value = r.ID
+	}
+	switch k {
+	case Base:
+		return fmt.Sprintf("base=%d", value)
+
+	case Int:
+		if relocated && r.Kind == format.CodeRel {
+			return codeLabel(int(value))
+		}
+
+	case Global:
+		if relocated && r.Kind == format.ImportRel && int(value) < len(l.imports) {
+			return strconv.Quote(l.imports[value])
+		}
+		return fmt.Sprintf("g%d", value)
+
+	case Class:
+		return fmt.Sprintf("c%d", value)
+
+	case Method:
+		return l.methodName(value)
+
+	case Constant:
+		if value >= 0 && int(value) < len(l.consts) {
+			return constant(l.consts[value])
+		}
+		return fmt.Sprintf("k%d", value)
+	}
+	return strconv.Itoa(int(value))
+}
@@ -0,0 +1,117 @@
+package disasm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/assembly"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func testPackage(syms *symtab.Symtab) *format.Package {
+	b := assembly.New(syms)
+
+	k := b.Location()
+	pkg := b.Class(0)
+	trueClass := b.Class(0)
+
+	b.Create(pkg, 0)
+	b.Return()
+
+	b.ImplementMethod(trueClass, b.Method(syms.SymbolID("match")))
+	b.Natural(k)
+	b.Store(3)
+	b.GlobalLoad(b.Import("io"))
+	b.Load(2)
+	b.Call(b.Method(syms.SymbolID("fold")), 7)
+	k.Define()
+	b.Return()
+
+	b.ImplementExternalMethod(pkg, b.Method(syms.SymbolID("lte")), syms.SymbolID("test:lte"))
+
+	p := b.Package()
+	p.Classes[1].Kind = format.TrueKind
+	return p
+}
+
+func TestPackage(t *testing.T) {
+	var syms symtab.Symtab
+	var buf bytes.Buffer
+
+	err := Package(&buf, testPackage(&syms))
+	assert.Nil(t, err)
+	assert.Equal(t, buf.String(), `import "io"
+extern "test:lte"
+method match
+method fold
+method lte
+class c0 fields=0
+class c1 fields=0 kind=true
+impl c1 match c1.match
+impl c0 lte extern "test:lte"
+
+	CREATE c0 base=0 // 0
+	RETURN           // 6
+c1.match:
+	NATURAL L27      // 7
+	STORE 3          // 12
+	GLOBAL_LOAD "io" // 14
+	LOAD 2           // 19
+	CALL fold base=7 // 21
+L27:
+	RETURN           // 27
+`)
+}
+
+func TestProgram(t *testing.T) {
+	var syms symtab.Symtab
+
+	p := &format.Program{
+		GlobalCount: 1,
+		Symbols:     syms,
+		Methods: []format.Method{
+			{Name: syms.SymbolID("call")},
+			{Name: syms.SymbolID("main"), Offset: 1},
+		},
+		Classes: []format.Class{{}, {Name: syms.SymbolID("progInit")}},
+		Implmentations: []format.Implementation{
+			{Class: 1, Method: 0, Kind: format.StandardBinding, EntryPoint: 12},
+			{},
+		},
+		Code: []byte{
+			format.CreateOp, 1, 0, 0, 0, 0,
+			format.CallOp, 0, 0, 0, 0, 0,
+			format.GlobalLoadOp, 0, 0, 0, 0,
+			format.CallOp, 1, 0, 0, 0, 0,
+		},
+	}
+	p.Symbols = syms.Copy()
+
+	var buf bytes.Buffer
+	err := Program(&buf, p)
+	assert.Nil(t, err)
+	assert.Equal(t, buf.String(), `globals 1
+method call offset=0
+method main offset=1
+class c0 fields=0
+class c1 fields=0 // progInit
+impl c1 call c1.call
+
+	CREATE c1 base=0 // 0
+	CALL call base=0 // 6
+c1.call:
+	GLOBAL_LOAD g0   // 12
+	CALL main base=0 // 17
+`)
+}
+
+func TestListingBadCode(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := Package(&buf, &format.Package{Code: []byte{format.RetOp, format.LoadOp}})
+	assert.True(t, errors.Is(err, ErrTruncated))
+	assert.Equal(t, buf.String(), "\n\tRETURN // 0\n")
+}
@@ -54,6 +54,11 @@ func (s *ReadState) calcRead() int64 {
 }
 
 func (s *ReadState) readValue(into reflect.Value) error {
+	if into.CanAddr() && into.Addr().CanInterface() {
+		if r, ok := into.Addr().Interface().(Reader); ok {
+			return r.ReadStorage(s)
+		}
+	}
 	switch into.Kind() {
 	case reflect.Bool:
 		var b byte
@@ -55,6 +55,12 @@ func (s *WriteState) Write(from any) error {
 }
 
 func (s *WriteState) writeValue(from reflect.Value) error {
+	// values within a structure may also know how to write themselves
+	if from.CanInterface() {
+		if w, ok := from.Interface().(Writer); ok {
+			return w.WriteStorage(s)
+		}
+	}
 	switch from.Kind() {
 	case reflect.Bool:
 		fmt.Println(s.at, from.Bool())
@@ -1,6 +1,11 @@
 package format
 
-import "github.com/bobappleyard/cezanne/format/symtab"
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bobappleyard/cezanne/format/symtab"
+)
 
 type ClassID int32
 type MethodID uint32
@@ -14,6 +19,10 @@ type Program struct {
 	Implmentations  []Implementation
 	Symbols         symtab.Symtab
 	Code            []byte
+	Lines           LineTable
+
+	// literals that are created when the program starts
+	Constants []Constant
 }
 
 type Package struct {
@@ -24,6 +33,61 @@ type Package struct {
 	Implementations []Implementation
 	Relocations     []Relocation
 	Code            []byte
+	Types           TypeInfo
+
+	// The names of the symbols that the package refers to. Linking does not
+	// need them, as packages are linked against a shared table, but they let
+	// tools describe a package on its own.
+	Symbols symtab.Symtab
+
+	Lines     LineTable
+	Constants []Constant
+}
+
+// Constant is a literal of a core kind, written as text: strings as
+// themselves, and floats as strconv.FormatFloat writes them.
+type Constant struct {
+	Kind  CoreKind
+	Value string
+}
+
+// LineTable maps code offsets back to the source that the code was compiled
+// from. Entries are in order of offset, and each one covers the code up to the
+// next. An entry whose file is -1 marks code that has no source.
+type LineTable struct {
+	Files   []string
+	Entries []LineEntry
+}
+
+type LineEntry struct {
+	Offset    uint32
+	File      int32
+	Line, Col int32
+}
+
+// Position is a place in a source file. Lines and columns count from 1.
+type Position struct {
+	File      string
+	Line, Col int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Lookup gives the source of the code at an offset.
+func (t *LineTable) Lookup(offset int) (Position, bool) {
+	i := sort.Search(len(t.Entries), func(i int) bool {
+		return int(t.Entries[i].Offset) > offset
+	})
+	if i == 0 {
+		return Position{}, false
+	}
+	e := t.Entries[i-1]
+	if e.File < 0 || int(e.File) >= len(t.Files) {
+		return Position{}, false
+	}
+	return Position{File: t.Files[e.File], Line: int(e.Line), Col: int(e.Col)}, true
 }
 
 type ImplKind int32
@@ -51,6 +115,11 @@ const (
 	FalseKind
 	ArrayKind
 	StringKind
+	FloatKind
+	BigIntKind
+	ContinuationKind
+	MapKind
+	SetKind
 
 	// not a kind, but can be used to init the kind list
 	AllKinds
@@ -85,6 +154,7 @@ const (
 	ClassRel
 	MethodRel
 	CodeRel
+	ConstantRel
 )
 
 type Relocation struct {
@@ -93,6 +163,52 @@ type Relocation struct {
 	Pos  uint32
 }
 
+// TypeInfo describes the type of a package's exports, so that packages that
+// import it can be checked against it. Types refer to each other, and to
+// constructors, by their index. A package with no types carries no type
+// information.
+type TypeInfo struct {
+	Exports      int32
+	Types        []Type
+	Constructors []TypeConstructor
+}
+
+type TypeKind int32
+
+const (
+	_ TypeKind = iota
+	VarType
+	NamedType
+	ObjectType
+	RecursiveType
+)
+
+// Type is one node of a type. What the fields mean depends on the kind:
+//
+//   - VarType: Methods are the constraint on the variable
+//   - NamedType: Cons is the constructor and Args are its arguments
+//   - ObjectType: Methods are the object's methods and Args its scope
+//   - RecursiveType: Args are the bound variable and the body
+type Type struct {
+	Kind    TypeKind
+	Cons    int32
+	Args    []int32
+	Methods []TypeMethod
+}
+
+// Names are stored as strings, so that type information can be read without
+// the symbol table it was written with.
+type TypeMethod struct {
+	Name         string
+	In, Out, Eff int32
+}
+
+type TypeConstructor struct {
+	Name    string
+	Args    []int32
+	Methods []TypeMethod
+}
+
 const (
 	LoadOp = iota
 	StoreOp
@@ -103,4 +219,6 @@ const (
 	FieldOp
 	RetOp
 	CallOp
+	ConstantOp
+	ArrayOp
 )
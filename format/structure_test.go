@@ -0,0 +1,35 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestLineTableLookup(t *testing.T) {
+	table := LineTable{
+		Files: []string{"a.cz"},
+		Entries: []LineEntry{
+			{Offset: 4, File: 0, Line: 1, Col: 1},
+			{Offset: 10, File: 0, Line: 2, Col: 5},
+			{Offset: 20, File: -1},
+		},
+	}
+	for _, test := range []struct {
+		offset int
+		pos    string
+	}{
+		{offset: 0},
+		{offset: 4, pos: "a.cz:1:1"},
+		{offset: 9, pos: "a.cz:1:1"},
+		{offset: 10, pos: "a.cz:2:5"},
+		{offset: 19, pos: "a.cz:2:5"},
+		{offset: 20},
+	} {
+		pos, ok := table.Lookup(test.offset)
+		assert.Equal(t, ok, test.pos != "")
+		if ok {
+			assert.Equal(t, pos.String(), test.pos)
+		}
+	}
+}
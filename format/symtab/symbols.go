@@ -36,7 +36,12 @@ func (t *Symtab) SymbolID(name string) Symbol {
 	return t.syms[idx]
 }
 
+// SymbolName gives the name of a symbol, or the empty string for a symbol that
+// is not in the table.
 func (t *Symtab) SymbolName(sym Symbol) string {
+	if int(sym.ID) >= len(t.data) {
+		return ""
+	}
 	end := sym.ID
 	for int(end) < len(t.data) && t.data[end] != 0 {
 		end++
@@ -47,6 +52,12 @@ func (t *Symtab) SymbolName(sym Symbol) string {
 	}))
 }
 
+// Copy gives a table with the same symbols as t, which can be added to
+// without affecting t.
+func (t *Symtab) Copy() Symtab {
+	return Symtab{data: append([]byte(nil), t.data...)}
+}
+
 func (t *Symtab) Merge(u *Symtab) []Rewrite {
 	t.buildSymTable()
 	u.buildSymTable()
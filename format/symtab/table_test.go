@@ -44,6 +44,11 @@ func TestSymbolName(t *testing.T) {
 	}
 }
 
+func TestUnknownSymbolName(t *testing.T) {
+	var tab Symtab
+	assert.Equal(t, tab.SymbolName(Symbol{ID: 3}), "")
+}
+
 func TestMerge(t *testing.T) {
 	var tab Symtab
 	tab.SymbolID("test")
@@ -100,3 +105,36 @@ func TestIO(t *testing.T) {
 	assert.Equal(t, rtab.data, tab.data)
 	assert.Equal(t, rtab.SymbolID("sym"), sym)
 }
+
+func TestNestedIO(t *testing.T) {
+	type file struct {
+		Name    string
+		Symbols Symtab
+	}
+	var f file
+	f.Name = "test"
+	sym := f.Symbols.SymbolID("sym")
+
+	var buf testBuf
+	_, err := storage.Write(&buf, f)
+	assert.Nil(t, err)
+
+	var g file
+	_, err = storage.Read(&buf, &g)
+	assert.Nil(t, err)
+	assert.Equal(t, g.Name, "test")
+	assert.Equal(t, g.Symbols.SymbolName(sym), "sym")
+}
+
+func TestCopy(t *testing.T) {
+	var tab Symtab
+	a := tab.SymbolID("a")
+
+	c := tab.Copy()
+	b := c.SymbolID("b")
+	tab.SymbolID("c")
+
+	assert.Equal(t, c.SymbolID("a"), a)
+	assert.Equal(t, c.SymbolName(b), "b")
+	assert.Equal(t, tab.SymbolName(b), "c")
+}
@@ -0,0 +1,399 @@
+// Package verify checks that linked programs can be run safely. The
+// interpreter trusts its bytecode, so anything that would index outside of
+// the program's tables, or send execution into the middle of an instruction,
+// is found here instead.
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/disasm"
+)
+
+var (
+	ErrOutOfRange       = errors.New("out of range")
+	ErrMidInstruction   = errors.New("jumps into the middle of an instruction")
+	ErrRunsOff          = errors.New("runs off the end of the code")
+	ErrOutsideFrame     = errors.New("outside the frame")
+	ErrDepthMismatch    = errors.New("frame depth does not match call base")
+	ErrBadImplementKind = errors.New("unknown implementation kind")
+)
+
+// Error is a problem with the code of a method, at some offset into the
+// program's code.
+type Error struct {
+	Method string
+	Pos    int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %d: %s", e.Method, e.Pos, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors are all of the problems found in a program, in code order.
+type Errors []*Error
+
+func (es Errors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any of the errors match target.
+func (es Errors) Is(target error) bool {
+	for _, e := range es {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Program checks the code that can be reached from the program's entry point
+// at offset 0 and from its implementations. Only reachable code is checked, as
+// the code may have data, such as string literals, placed within it.
+//
+// Calls end a run of instructions, as the callee returns to an address held in
+// its frame. Where that address is given directly, by a NATURAL that is
+// stored into the frame, it is checked too.
+//
+// If there are any problems the result is an Errors.
+func Program(p *format.Program) error {
+	v := check(p)
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Method is the code that is run for an implementation, as found by
+// verification. The code run when the program starts is a method of class -1.
+type Method struct {
+	Name   string
+	Class  format.ClassID
+	Method format.MethodID
+	Start  int
+
+	// the number of registers that the method uses
+	FrameSize int
+
+	// the offsets of the method's instructions, in order
+	Code []int
+}
+
+// Methods describes the code of a program that passes verification, in order
+// of where the methods start.
+func Methods(p *format.Program) ([]Method, error) {
+	v := check(p)
+	if len(v.errs) != 0 {
+		return nil, v.errs
+	}
+	res := make([]Method, len(v.bodies))
+	for i, b := range v.bodies {
+		code := make([]int, len(b.seen))
+		for j, in := range b.seen {
+			code[j] = in.Pos
+		}
+		sort.Ints(code)
+		res[i] = Method{
+			Name:      b.name,
+			Class:     b.class,
+			Method:    b.method,
+			Start:     b.start,
+			FrameSize: b.frameSize(),
+			Code:      code,
+		}
+	}
+	return res, nil
+}
+
+func check(p *format.Program) *verifier {
+	v := &verifier{
+		p:     p,
+		owner: make([]int, len(p.Code)),
+	}
+	for i := range v.owner {
+		v.owner[i] = -1
+	}
+
+	v.bodies = []*body{{name: "entry", class: -1}}
+	for i, impl := range p.Implmentations {
+		v.implementation(i, impl)
+	}
+	sort.SliceStable(v.bodies, func(i, j int) bool {
+		return v.bodies[i].start < v.bodies[j].start
+	})
+
+	// entry points are visited before the addresses that calls return to,
+	// so that any problems are reported against the former
+	for i, b := range v.bodies {
+		v.queue = append(v.queue, run{body: i, pos: b.start})
+	}
+	for len(v.queue) != 0 {
+		r := v.queue[0]
+		v.queue = v.queue[1:]
+		v.run(r)
+	}
+	for _, b := range v.bodies {
+		v.frame(b)
+	}
+
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		return v.errs[i].Pos < v.errs[j].Pos
+	})
+	return v
+}
+
+type verifier struct {
+	p      *format.Program
+	errs   Errors
+	bodies []*body
+	queue  []run
+
+	// the start of the instruction that each byte of code belongs to, or -1
+	owner []int
+}
+
+// body is the code that runs when a method is called, along with the
+// instructions found in it so far.
+type body struct {
+	name   string
+	class  format.ClassID
+	method format.MethodID
+	start  int
+	seen   []disasm.Instruction
+}
+
+// run is a sequence of instructions that ends in a call or a return.
+type run struct {
+	body int
+	pos  int
+}
+
+func (v *verifier) errorf(where string, pos int, format string, args ...any) {
+	v.errs = append(v.errs, &Error{Method: where, Pos: pos, Err: fmt.Errorf(format, args...)})
+}
+
+func (v *verifier) methodName(id format.MethodID) string {
+	if int(id) < len(v.p.Methods) {
+		if name := v.p.Symbols.SymbolName(v.p.Methods[id].Name); name != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("m%d", id)
+}
+
+func (v *verifier) implementation(i int, impl format.Implementation) {
+	// the implementation table has gaps in it
+	if impl.Kind == 0 {
+		return
+	}
+	name := fmt.Sprintf("c%d.%s", impl.Class, v.methodName(impl.Method))
+	where := fmt.Sprintf("implementation %d", i)
+	if impl.Class < 0 || int(impl.Class) >= len(v.p.Classes) {
+		v.errorf(where, int(impl.EntryPoint), "class %d: %w", impl.Class, ErrOutOfRange)
+	}
+	if int(impl.Method) >= len(v.p.Methods) {
+		v.errorf(where, int(impl.EntryPoint), "method %d: %w", impl.Method, ErrOutOfRange)
+	}
+	switch impl.Kind {
+	case format.StandardBinding, format.HandlerBinding:
+		if int(impl.EntryPoint) >= len(v.p.Code) {
+			v.errorf(name, int(impl.EntryPoint), "entry point: %w", ErrOutOfRange)
+			return
+		}
+		v.bodies = append(v.bodies, &body{
+			name:   name,
+			class:  impl.Class,
+			method: impl.Method,
+			start:  int(impl.EntryPoint),
+		})
+
+	case format.ExternalBinding:
+		if int(impl.EntryPoint) >= len(v.p.ExternalMethods) {
+			v.errorf(name, int(impl.EntryPoint), "external method: %w", ErrOutOfRange)
+		}
+
+	default:
+		v.errorf(where, int(impl.EntryPoint), "%d: %w", impl.Kind, ErrBadImplementKind)
+	}
+}
+
+// value is what is known about the result of an instruction.
+type value struct {
+	natural bool
+	n       int32
+}
+
+// run decodes instructions until a call or a return. Nothing is known about
+// the frame at the start of a run.
+func (v *verifier) run(r run) {
+	b := v.bodies[r.body]
+	pos := r.pos
+	var acc value
+	regs := map[int]value{}
+
+	for {
+		if pos >= len(v.p.Code) {
+			v.errorf(b.name, pos, "%w", ErrRunsOff)
+			return
+		}
+		if owner := v.owner[pos]; owner != -1 {
+			if owner != pos {
+				v.errorf(b.name, pos, "%w", ErrMidInstruction)
+			}
+			return
+		}
+		i, err := disasm.DecodeAt(v.p.Code, pos)
+		if errors.Is(err, disasm.ErrUnknownOp) {
+			v.errorf(b.name, pos, "%d: %w", v.p.Code[pos], disasm.ErrUnknownOp)
+			return
+		}
+		if err != nil {
+			v.errorf(b.name, pos, "%w", disasm.ErrTruncated)
+			return
+		}
+		if !v.claim(b.name, i) {
+			return
+		}
+		b.seen = append(b.seen, i)
+		v.operands(b.name, i)
+
+		switch i.Op {
+		case format.NaturalOp:
+			acc = value{natural: true, n: i.Args[0]}
+		case format.LoadOp, format.GlobalLoadOp, format.CreateOp, format.FieldOp, format.ConstantOp, format.ArrayOp:
+			acc = value{}
+		case format.StoreOp:
+			regs[int(i.Args[0])] = acc
+		case format.CallOp:
+			if ret, ok := v.call(b.name, i, regs); ok {
+				v.queue = append(v.queue, run{body: r.body, pos: ret})
+			}
+			return
+		case format.RetOp:
+			return
+		}
+		pos += i.Size()
+	}
+}
+
+// claim marks the bytes of an instruction as belonging to it. Instructions
+// may not overlap.
+func (v *verifier) claim(where string, i disasm.Instruction) bool {
+	for at := i.Pos + 1; at < i.Pos+i.Size() && at < len(v.owner); at++ {
+		if v.owner[at] != -1 {
+			v.errorf(where, v.owner[at], "%w", ErrMidInstruction)
+			return false
+		}
+	}
+	for at := i.Pos; at < i.Pos+i.Size(); at++ {
+		v.owner[at] = i.Pos
+	}
+	return true
+}
+
+func (v *verifier) operands(where string, i disasm.Instruction) {
+	op, _ := disasm.Lookup(i.Op)
+	for j, k := range op.Operands {
+		arg := i.Args[j]
+		var limit int
+		switch k {
+		case disasm.Global:
+			limit = int(v.p.GlobalCount)
+		case disasm.Class:
+			limit = len(v.p.Classes)
+		case disasm.Method:
+			limit = len(v.p.Methods)
+		case disasm.Constant:
+			limit = len(v.p.Constants)
+		case disasm.Int:
+			if i.Op == format.FieldOp && arg < 0 {
+				v.errorf(where, i.Pos, "%s: field %d: %w", op.Name, arg, ErrOutOfRange)
+			}
+			continue
+		default:
+			continue
+		}
+		if arg < 0 || int(arg) >= limit {
+			v.errorf(where, i.Pos, "%s: %d: %w", op.Name, arg, ErrOutOfRange)
+		}
+	}
+}
+
+// A call with a non-zero base sets up a new frame: the depth goes at the
+// base, and the address to return to after it.
+func (v *verifier) call(where string, i disasm.Instruction, regs map[int]value) (int, bool) {
+	base := int(i.Args[1])
+	if base == 0 {
+		return 0, false
+	}
+	if depth := regs[base]; depth.natural && int(depth.n) != base {
+		v.errorf(where, i.Pos, "CALL: depth %d, base %d: %w", depth.n, base, ErrDepthMismatch)
+	}
+	ret := regs[base+1]
+	if !ret.natural {
+		return 0, false
+	}
+	if ret.n < 0 || int(ret.n) >= len(v.p.Code) {
+		v.errorf(where, i.Pos, "CALL: return address %d: %w", ret.n, ErrOutOfRange)
+		return 0, false
+	}
+	return int(ret.n), true
+}
+
+// A method's frame is as large as the highest register it loads or stores.
+// Arguments count, as they are loaded.
+func (b *body) frameSize() int {
+	size := 0
+	for _, i := range b.seen {
+		switch i.Op {
+		case format.LoadOp, format.StoreOp:
+			if r := int(i.Args[0]) + 1; r > size {
+				size = r
+			}
+		}
+	}
+	return size
+}
+
+// frame checks that the instructions of a method only use registers within
+// its frame.
+func (v *verifier) frame(b *body) {
+	where := b.name
+	size := b.frameSize()
+	for _, i := range b.seen {
+		switch i.Op {
+		case format.CreateOp:
+			class, base := i.Args[0], int(i.Args[1])
+			if class < 0 || int(class) >= len(v.p.Classes) {
+				continue
+			}
+			if n := int(v.p.Classes[class].Fieldc); n != 0 && base+n > size {
+				v.errorf(where, i.Pos, "CREATE: fields %d-%d of a frame of %d: %w", base, base+n-1, size, ErrOutsideFrame)
+			}
+		case format.ArrayOp:
+			n, base := int(i.Args[0]), int(i.Args[1])
+			if n < 0 {
+				v.errorf(where, i.Pos, "ARRAY: length %d: %w", n, ErrOutOfRange)
+			} else if n != 0 && base+n > size {
+				v.errorf(where, i.Pos, "ARRAY: items %d-%d of a frame of %d: %w", base, base+n-1, size, ErrOutsideFrame)
+			}
+		case format.CallOp:
+			if base := int(i.Args[1]); base != 0 && base+2 > size {
+				v.errorf(where, i.Pos, "CALL: base %d of a frame of %d: %w", base, size, ErrOutsideFrame)
+			}
+		}
+	}
+}
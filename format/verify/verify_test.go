@@ -0,0 +1,220 @@
+package verify
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/disasm"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+// testProgram calls the main method of the object created from class 1,
+// which creates an object with a field and calls a method on it, before
+// returning the result. The code ends with some data.
+func testProgram(syms *symtab.Symtab) *format.Program {
+	return &format.Program{
+		GlobalCount: 1,
+		Classes:     []format.Class{{}, {}, {Fieldc: 1}},
+		Methods: []format.Method{
+			{Name: syms.SymbolID("main")},
+			{Name: syms.SymbolID("get")},
+		},
+		Implmentations: []format.Implementation{
+			{Class: 1, Method: 0, Kind: format.StandardBinding, EntryPoint: 12},
+			{},
+			{Class: 2, Method: 1, Kind: format.StandardBinding, EntryPoint: 52},
+		},
+		Symbols: syms.Copy(),
+		Code: []byte{
+			// 0: entry
+			format.CreateOp, 1, 0, 0, 0, 0,
+			format.CallOp, 0, 0, 0, 0, 0,
+
+			// 12: c1.main
+			format.NaturalOp, 4, 0, 0, 0,
+			format.StoreOp, 4,
+			format.CreateOp, 2, 0, 0, 0, 4,
+			format.StoreOp, 2,
+			format.NaturalOp, 2, 0, 0, 0,
+			format.StoreOp, 2,
+			format.NaturalOp, 47, 0, 0, 0,
+			format.StoreOp, 3,
+			format.CallOp, 1, 0, 0, 0, 2,
+			// 47: continuation
+			format.GlobalStoreOp, 0, 0, 0, 0,
+
+			// 52: c2.get
+			format.LoadOp, 2,
+			format.FieldOp, 0, 0, 0, 0,
+			format.RetOp,
+
+			// data
+			0xff, 0xff,
+		},
+	}
+}
+
+func TestValidProgram(t *testing.T) {
+	var syms symtab.Symtab
+	assert.Nil(t, Program(testProgram(&syms)))
+}
+
+func TestMethods(t *testing.T) {
+	var syms symtab.Symtab
+	ms, err := Methods(testProgram(&syms))
+	assert.Nil(t, err)
+	assert.Equal(t, ms, []Method{
+		{Name: "entry", Class: -1, Code: []int{0, 6}},
+		{
+			Name:      "c1.main",
+			Class:     1,
+			Method:    0,
+			Start:     12,
+			FrameSize: 5,
+			Code:      []int{12, 17, 19, 25, 27, 32, 34, 39, 41, 47},
+		},
+		{
+			Name:      "c2.get",
+			Class:     2,
+			Method:    1,
+			Start:     52,
+			FrameSize: 3,
+			Code:      []int{52, 54, 59},
+		},
+	})
+
+	p := testProgram(&syms)
+	p.Code[52] = 0xff
+	_, err = Methods(p)
+	assert.True(t, errors.Is(err, disasm.ErrUnknownOp))
+}
+
+func TestInvalidPrograms(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		change func(p *format.Program)
+		err    error
+		msg    string
+	}{
+		{
+			name: "Global",
+			change: func(p *format.Program) {
+				p.GlobalCount = 0
+			},
+			err: ErrOutOfRange,
+			msg: "c1.main: 47: GLOBAL_STORE: 0: out of range",
+		},
+		{
+			name: "Class",
+			change: func(p *format.Program) {
+				p.Code[1] = 7
+			},
+			err: ErrOutOfRange,
+			msg: "entry: 0: CREATE: 7: out of range",
+		},
+		{
+			name: "Method",
+			change: func(p *format.Program) {
+				p.Methods = p.Methods[:1]
+			},
+			err: ErrOutOfRange,
+			msg: "c1.main: 41: CALL: 1: out of range\n" +
+				"implementation 2: 52: method 1: out of range",
+		},
+		{
+			name: "Field",
+			change: func(p *format.Program) {
+				copy(p.Code[55:], []byte{0xff, 0xff, 0xff, 0xff})
+			},
+			err: ErrOutOfRange,
+			msg: "c2.get: 54: FIELD: field -1: out of range",
+		},
+		{
+			name: "Constant",
+			change: func(p *format.Program) {
+				p.Code[59] = format.ConstantOp
+				p.Code = append(p.Code[:60], 0, 0, 0, 0, format.RetOp)
+			},
+			err: ErrOutOfRange,
+			msg: "c2.get: 59: CONSTANT: 0: out of range",
+		},
+		{
+			name: "MidInstruction",
+			change: func(p *format.Program) {
+				p.Implmentations[2].EntryPoint = 42
+			},
+			err: ErrMidInstruction,
+			msg: "c2.get: 42: jumps into the middle of an instruction",
+		},
+		{
+			name: "ReturnAddress",
+			change: func(p *format.Program) {
+				p.Code[35] = 53
+			},
+			err: ErrMidInstruction,
+			msg: "c1.main: 53: jumps into the middle of an instruction",
+		},
+		{
+			name: "RunsOff",
+			change: func(p *format.Program) {
+				p.Code[59] = format.LoadOp
+				p.Code = p.Code[:61]
+			},
+			err: ErrRunsOff,
+			msg: "c2.get: 61: runs off the end of the code",
+		},
+		{
+			name: "UnknownOp",
+			change: func(p *format.Program) {
+				p.Code[59] = 0xff
+			},
+			err: disasm.ErrUnknownOp,
+			msg: "c2.get: 59: 255: unknown instruction",
+		},
+		{
+			name: "Fields",
+			change: func(p *format.Program) {
+				p.Classes[2].Fieldc = 2
+			},
+			err: ErrOutsideFrame,
+			msg: "c1.main: 19: CREATE: fields 4-5 of a frame of 5: outside the frame",
+		},
+		{
+			name: "Array",
+			change: func(p *format.Program) {
+				p.Code[19] = format.ArrayOp
+				p.Code[20] = 2
+			},
+			err: ErrOutsideFrame,
+			msg: "c1.main: 19: ARRAY: items 4-5 of a frame of 5: outside the frame",
+		},
+		{
+			name: "Depth",
+			change: func(p *format.Program) {
+				p.Code[28] = 3
+			},
+			err: ErrDepthMismatch,
+			msg: "c1.main: 41: CALL: depth 3, base 2: frame depth does not match call base",
+		},
+		{
+			name: "External",
+			change: func(p *format.Program) {
+				p.Implmentations[1] = format.Implementation{Class: 1, Method: 1, Kind: format.ExternalBinding}
+			},
+			err: ErrOutOfRange,
+			msg: "c1.get: 0: external method: out of range",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var syms symtab.Symtab
+			p := testProgram(&syms)
+			test.change(p)
+
+			err := Program(p)
+			assert.True(t, errors.Is(err, test.err))
+			assert.Equal(t, err.Error(), test.msg)
+		})
+	}
+}
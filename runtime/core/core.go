@@ -0,0 +1,479 @@
+// Package core is the runtime package that every program is linked with. It
+// defines the classes that ints, floats, booleans, strings, arrays, maps and
+// sets belong to, along with their methods, which are mostly implemented in
+// Go. It also provides the handle and trigger methods that effects are
+// compiled into, and the map and set methods that make empty collections.
+package core
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/assembly"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/env"
+)
+
+// Path is the import path of the runtime package.
+const Path = "runtime"
+
+type class struct {
+	kind   format.CoreKind
+	name   string
+	fields int
+}
+
+// The package object is the class of UserKind.
+var classes = []class{
+	{format.UserKind, "runtime", 0},
+	{format.IntKind, "Int", 0},
+	{format.FloatKind, "Float", 0},
+	{format.TrueKind, "True", 0},
+	{format.FalseKind, "False", 0},
+	{format.StringKind, "String", 0},
+	{format.ArrayKind, "Array", 0},
+	{format.BigIntKind, "BigInt", 0},
+	{format.ContinuationKind, "Continuation", 4},
+	{format.MapKind, "Map", 2},
+	{format.SetKind, "Set", 2},
+}
+
+type method struct {
+	kind format.CoreKind
+	name string
+	impl func(p *env.Thread, recv api.Object)
+}
+
+// The external methods are named after the kind of object they belong to, as
+// in runtime:int_add.
+var methods = []method{
+	{format.UserKind, "handle", handle},
+	{format.UserKind, "trigger", trigger},
+	{format.UserKind, "map", newMap},
+	{format.UserKind, "set", newSet},
+	{format.ContinuationKind, "resume", resume},
+
+	{format.IntKind, "add", intOp(addInt, (*big.Int).Add)},
+	{format.IntKind, "sub", intOp(subInt, (*big.Int).Sub)},
+	{format.IntKind, "mul", intOp(mulInt, (*big.Int).Mul)},
+	{format.IntKind, "div", divide(intOp(divInt, (*big.Int).Quo))},
+	{format.IntKind, "mod", divide(intOp(modInt, (*big.Int).Rem))},
+	{format.IntKind, "neg", intNeg},
+	{format.IntKind, "eq", intCmp(func(c int) bool { return c == 0 })},
+	{format.IntKind, "lt", intCmp(func(c int) bool { return c < 0 })},
+	{format.IntKind, "lte", intCmp(func(c int) bool { return c <= 0 })},
+	{format.IntKind, "gt", intCmp(func(c int) bool { return c > 0 })},
+	{format.IntKind, "gte", intCmp(func(c int) bool { return c >= 0 })},
+	{format.IntKind, "toFloat", intToFloat},
+	{format.IntKind, "toString", intToString},
+	{format.IntKind, "equals", objectEquals},
+	{format.IntKind, "hash", objectHash},
+
+	{format.FloatKind, "add", floatOp(func(a, b float64) float64 { return a + b })},
+	{format.FloatKind, "sub", floatOp(func(a, b float64) float64 { return a - b })},
+	{format.FloatKind, "mul", floatOp(func(a, b float64) float64 { return a * b })},
+	{format.FloatKind, "div", floatOp(func(a, b float64) float64 { return a / b })},
+	{format.FloatKind, "neg", floatNeg},
+	{format.FloatKind, "eq", floatCmp(func(a, b float64) bool { return a == b })},
+	{format.FloatKind, "lt", floatCmp(func(a, b float64) bool { return a < b })},
+	{format.FloatKind, "lte", floatCmp(func(a, b float64) bool { return a <= b })},
+	{format.FloatKind, "gt", floatCmp(func(a, b float64) bool { return a > b })},
+	{format.FloatKind, "gte", floatCmp(func(a, b float64) bool { return a >= b })},
+	{format.FloatKind, "toInt", floatToInt},
+	{format.FloatKind, "toString", floatToString},
+	{format.FloatKind, "equals", objectEquals},
+	{format.FloatKind, "hash", objectHash},
+
+	{format.TrueKind, "equals", objectEquals},
+	{format.TrueKind, "hash", objectHash},
+	{format.FalseKind, "equals", objectEquals},
+	{format.FalseKind, "hash", objectHash},
+
+	{format.StringKind, "length", stringLength},
+	{format.StringKind, "concat", stringConcat},
+	{format.StringKind, "slice", stringSlice},
+	{format.StringKind, "index", stringIndex},
+	{format.StringKind, "compare", stringCompare},
+	{format.StringKind, "eq", stringEq},
+	{format.StringKind, "split", stringSplit},
+	{format.StringKind, "toInt", stringToInt},
+	{format.StringKind, "toFloat", stringToFloat},
+	{format.StringKind, "equals", objectEquals},
+	{format.StringKind, "hash", objectHash},
+
+	{format.ArrayKind, "length", arrayLength},
+	{format.ArrayKind, "get", arrayGet},
+	{format.ArrayKind, "set", arraySet},
+	{format.ArrayKind, "push", arrayPush},
+	{format.ArrayKind, "slice", arraySlice},
+	{format.ArrayKind, "map", arrayMap},
+	{format.ArrayKind, "fold", arrayFold},
+	{format.ArrayKind, "equals", objectEquals},
+	{format.ArrayKind, "hash", objectHash},
+
+	{format.MapKind, "get", mapGet},
+	{format.MapKind, "has", collectionHas},
+	{format.MapKind, "put", mapPut},
+	{format.MapKind, "remove", collectionRemove},
+	{format.MapKind, "size", collectionSize},
+	{format.MapKind, "keys", mapKeys},
+	{format.MapKind, "values", mapValues},
+	{format.MapKind, "fold", mapFold},
+
+	{format.SetKind, "has", collectionHas},
+	{format.SetKind, "add", setAdd},
+	{format.SetKind, "remove", collectionRemove},
+	{format.SetKind, "size", collectionSize},
+	{format.SetKind, "items", setItems},
+	{format.SetKind, "fold", setFold},
+}
+
+// Big integers have the same methods as ints.
+func init() {
+	for _, m := range methods {
+		if m.kind == format.IntKind {
+			m.kind = format.BigIntKind
+			methods = append(methods, m)
+		}
+	}
+}
+
+var kindPrefixes = map[format.CoreKind]string{
+	format.UserKind:   "",
+	format.IntKind:    "int_",
+	format.FloatKind:  "float_",
+	format.BigIntKind: "bigint_",
+	format.StringKind: "string_",
+	format.ArrayKind:  "array_",
+	format.TrueKind:   "true_",
+	format.FalseKind:  "false_",
+	format.MapKind:    "map_",
+	format.SetKind:    "set_",
+
+	format.ContinuationKind: "continuation_",
+}
+
+func externalName(m method) string {
+	return Path + ":" + kindPrefixes[m.kind] + m.name
+}
+
+// Package builds the runtime package against a symbol table. Booleans have a
+// match method, written in bytecode, that calls true or false on its argument.
+func Package(syms *symtab.Symtab) *format.Package {
+	b := assembly.New(syms)
+
+	byKind := map[format.CoreKind]*assembly.Class{}
+	for _, c := range classes {
+		x := b.Class(c.fields)
+		x.SetKind(c.kind)
+		x.SetName(syms.SymbolID(c.name))
+		byKind[c.kind] = x
+	}
+
+	b.Create(byKind[format.UserKind], 0)
+	b.Return()
+
+	match := b.Method(syms.SymbolID("match"))
+	for _, c := range []struct {
+		kind   format.CoreKind
+		method string
+	}{
+		{format.TrueKind, "true"},
+		{format.FalseKind, "false"},
+	} {
+		b.ImplementMethod(byKind[c.kind], match)
+		b.Load(2)
+		b.Call(b.Method(syms.SymbolID(c.method)), 0)
+	}
+
+	for _, m := range methods {
+		b.ImplementExternalMethod(byKind[m.kind], b.Method(syms.SymbolID(m.name)), syms.SymbolID(externalName(m)))
+	}
+
+	return b.Package()
+}
+
+// Install adds the runtime package's external methods to an environment.
+func Install(e *env.Env) {
+	for _, m := range methods {
+		e.AddExternalMethod(externalName(m), m.impl)
+	}
+}
+
+// Floats follow IEEE 754, so dividing by zero gives an infinity rather than
+// failing.
+
+// floatArg reads an argument that should be a float, or triggers wrongType with
+// it.
+func floatArg(p *env.Thread, id int) (float64, bool) {
+	x := p.Arg(id)
+	if p.Process().Kind(x) != format.FloatKind {
+		p.Trigger("wrongType", x)
+		return 0, false
+	}
+	return p.Process().AsFloat(x), true
+}
+
+func floatOp(op func(a, b float64) float64) func(p *env.Thread, recv api.Object) {
+	return func(p *env.Thread, recv api.Object) {
+		b, ok := floatArg(p, 0)
+		if !ok {
+			return
+		}
+		a := p.Process().AsFloat(recv)
+		p.Return(p.Process().Float(op(a, b)))
+	}
+}
+
+func floatCmp(cmp func(a, b float64) bool) func(p *env.Thread, recv api.Object) {
+	return func(p *env.Thread, recv api.Object) {
+		b, ok := floatArg(p, 0)
+		if !ok {
+			return
+		}
+		a := p.Process().AsFloat(recv)
+		p.Return(p.Process().Bool(cmp(a, b)))
+	}
+}
+
+func floatNeg(p *env.Thread, recv api.Object) {
+	p.Return(p.Process().Float(-p.Process().AsFloat(recv)))
+}
+
+// toInt truncates towards zero. NaN and the infinities have no integer to
+// truncate to, and trigger outOfRange with the float.
+func floatToInt(p *env.Thread, recv api.Object) {
+	x := p.Process().AsFloat(recv)
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		p.Trigger("outOfRange", recv)
+		return
+	}
+	n, _ := big.NewFloat(x).Int(nil)
+	p.Return(p.Process().BigInt(n))
+}
+
+func floatToString(p *env.Thread, recv api.Object) {
+	p.Return(p.Process().String(strconv.FormatFloat(p.Process().AsFloat(recv), 'g', -1, 64)))
+}
+
+// Strings are sequences of bytes, which are usually UTF-8. Lengths and
+// positions count bytes. Positions outside of a string trigger outOfBounds, as
+// they do for arrays, and text that does not hold a number triggers
+// invalidNumber with the string when it is converted.
+
+// stringArg reads an argument that should be a string, or triggers wrongType
+// with it. Programs that type check only trigger this through imports whose
+// types are not known.
+func stringArg(p *env.Thread, id int) (string, bool) {
+	x := p.Arg(id)
+	if !p.Process().IsString(x) {
+		p.Trigger("wrongType", x)
+		return "", false
+	}
+	return p.Process().AsString(x), true
+}
+
+func stringLength(p *env.Thread, recv api.Object) {
+	p.Return(p.Process().Int(len(p.Process().AsString(recv))))
+}
+
+func stringConcat(p *env.Thread, recv api.Object) {
+	x, ok := stringArg(p, 0)
+	if !ok {
+		return
+	}
+	p.Return(p.Process().String(p.Process().AsString(recv) + x))
+}
+
+func stringSlice(p *env.Thread, recv api.Object) {
+	s := p.Process().AsString(recv)
+	start, ok := position(p, len(s), 0, true)
+	if !ok {
+		return
+	}
+	end, ok := position(p, len(s), 1, true)
+	if !ok {
+		return
+	}
+	if end < start {
+		p.Trigger("outOfBounds", p.Arg(1))
+		return
+	}
+	p.Return(p.Process().String(s[start:end]))
+}
+
+// index gives -1 when the string does not contain the argument.
+func stringIndex(p *env.Thread, recv api.Object) {
+	x, ok := stringArg(p, 0)
+	if !ok {
+		return
+	}
+	p.Return(p.Process().Int(strings.Index(p.Process().AsString(recv), x)))
+}
+
+func stringCompare(p *env.Thread, recv api.Object) {
+	x, ok := stringArg(p, 0)
+	if !ok {
+		return
+	}
+	p.Return(p.Process().Int(strings.Compare(p.Process().AsString(recv), x)))
+}
+
+func stringEq(p *env.Thread, recv api.Object) {
+	x := p.Arg(0)
+	p.Return(p.Process().Bool(p.Process().IsString(x) && p.Process().AsString(recv) == p.Process().AsString(x)))
+}
+
+func stringSplit(p *env.Thread, recv api.Object) {
+	sep, ok := stringArg(p, 0)
+	if !ok {
+		return
+	}
+	parts := strings.Split(p.Process().AsString(recv), sep)
+	items := make([]api.Object, len(parts))
+	defer p.PinAll(items)()
+	for i, part := range parts {
+		items[i] = p.Process().String(part)
+	}
+	p.Return(p.Process().Array(items))
+}
+
+func stringToInt(p *env.Thread, recv api.Object) {
+	x, ok := new(big.Int).SetString(p.Process().AsString(recv), 10)
+	if !ok {
+		p.Trigger("invalidNumber", recv)
+		return
+	}
+	p.Return(p.Process().BigInt(x))
+}
+
+func stringToFloat(p *env.Thread, recv api.Object) {
+	x, err := strconv.ParseFloat(p.Process().AsString(recv), 64)
+	if err != nil {
+		p.Trigger("invalidNumber", recv)
+		return
+	}
+	p.Return(p.Process().Float(x))
+}
+
+// handle(handlers, body) calls body() with the handlers installed, and
+// trigger(name, args) performs an effect.
+
+func handle(p *env.Thread, recv api.Object) {
+	p.Handle(p.Arg(0), p.Arg(1))
+}
+
+func trigger(p *env.Thread, recv api.Object) {
+	name, ok := stringArg(p, 0)
+	if !ok {
+		return
+	}
+	p.Trigger(name, p.Process().AsArray(p.Arg(1))...)
+}
+
+func resume(p *env.Thread, recv api.Object) {
+	p.Resume(recv, p.Arg(0))
+}
+
+// Arrays have a fixed length, and methods that change it give a new array.
+// Indexes outside of an array trigger outOfBounds with the index, and resuming
+// gives the method's result.
+
+// callBase is where the frames of callbacks start, above the registers of the
+// array methods that make them.
+const callBase = 8
+
+func arrayLength(p *env.Thread, recv api.Object) {
+	p.Return(p.Process().Int(p.Process().FieldCount(recv.Class)))
+}
+
+// index reads an argument as an index into an array, or triggers outOfBounds.
+// The end of the array is a valid index when inclusive is set.
+func index(p *env.Thread, recv api.Object, arg int, inclusive bool) (int, bool) {
+	return position(p, p.Process().FieldCount(recv.Class), arg, inclusive)
+}
+
+// position reads an argument as a position in something of length n, or
+// triggers outOfBounds.
+func position(p *env.Thread, n, arg int, inclusive bool) (int, bool) {
+	x := p.Arg(arg)
+	if inclusive {
+		n++
+	}
+	if p.Process().Kind(x) != format.IntKind {
+		p.Trigger("outOfBounds", x)
+		return 0, false
+	}
+	i := p.Process().AsInt(x)
+	if i < 0 || i >= n {
+		p.Trigger("outOfBounds", x)
+		return 0, false
+	}
+	return i, true
+}
+
+func arrayGet(p *env.Thread, recv api.Object) {
+	i, ok := index(p, recv, 0, false)
+	if !ok {
+		return
+	}
+	p.Return(p.Process().Field(recv, i))
+}
+
+// set gives the array, so that calls can be chained.
+func arraySet(p *env.Thread, recv api.Object) {
+	i, ok := index(p, recv, 0, false)
+	if !ok {
+		return
+	}
+	p.Process().SetField(recv, i, p.Arg(1))
+	p.Return(recv)
+}
+
+func arrayPush(p *env.Thread, recv api.Object) {
+	items := append(p.Process().AsArray(recv), p.Arg(0))
+	p.Return(p.Process().Array(items))
+}
+
+func arraySlice(p *env.Thread, recv api.Object) {
+	start, ok := index(p, recv, 0, true)
+	if !ok {
+		return
+	}
+	end, ok := index(p, recv, 1, true)
+	if !ok {
+		return
+	}
+	if end < start {
+		p.Trigger("outOfBounds", p.Arg(1))
+		return
+	}
+	p.Return(p.Process().Array(p.Process().AsArray(recv)[start:end]))
+}
+
+func arrayMap(p *env.Thread, recv api.Object) {
+	f := p.Arg(0)
+	items := p.Process().AsArray(recv)
+	defer p.PinAll(items)()
+	defer p.Pin(&f)()
+	for i := range items {
+		items[i] = p.Call(callBase, f, "call", items[i])
+	}
+	p.Return(p.Process().Array(items))
+}
+
+// fold calls f(acc, x) for each item in turn, starting with init.
+func arrayFold(p *env.Thread, recv api.Object) {
+	acc, f := p.Arg(0), p.Arg(1)
+	items := p.Process().AsArray(recv)
+	defer p.PinAll(items)()
+	defer p.Pin(&f)()
+	for i := range items {
+		acc = p.Call(callBase, f, "call", acc, items[i])
+	}
+	p.Return(acc)
+}
@@ -0,0 +1,519 @@
+package core_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/commands/compile/ast"
+	"github.com/bobappleyard/cezanne/commands/compile/backend"
+	"github.com/bobappleyard/cezanne/commands/compile/parser"
+	"github.com/bobappleyard/cezanne/commands/link"
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/assembly"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/core"
+	"github.com/bobappleyard/cezanne/runtime/env"
+	"github.com/bobappleyard/cezanne/util/assert"
+	"github.com/bobappleyard/cezanne/util/must"
+)
+
+type testLinkerEnv map[string]*format.Package
+
+// LoadPackage implements link.LinkerEnv
+func (e testLinkerEnv) LoadPackage(path string) (*format.Package, error) {
+	pkg := e[path]
+	if pkg == nil {
+		return nil, fmt.Errorf("%s: %w", path, link.ErrMissingPackage)
+	}
+	return pkg, nil
+}
+
+func testPkg(syms *symtab.Symtab) *format.Package {
+	b := assembly.New(syms)
+	pkg := b.Class(0)
+	b.Create(pkg, 0)
+	b.Return()
+	for _, name := range []string{"result", "items", "depth"} {
+		b.ImplementExternalMethod(pkg, b.Method(syms.SymbolID(name)), syms.SymbolID("test:"+name))
+	}
+	return b.Package()
+}
+
+// runtimeTest gives the body of a function, which can use the functions in
+// testdata/helpers.cz, and what it returns.
+type runtimeTest struct {
+	name string
+	in   string
+	out  string
+}
+
+func runTests(t *testing.T, tests []runtimeTest) {
+	helpers := must.Be(os.ReadFile("testdata/helpers.cz"))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := "import runtime\nimport test\n\nfunc main() {\n\ttest.result(value())\n}\n\n" +
+				"func value() {\n\t" + test.in + "\n}\n\n" + string(helpers)
+
+			// collecting at every allocation moves objects out from under any
+			// reference that the runtime has lost track of
+			for _, stress := range []bool{false, true} {
+				e := new(env.Env)
+				e.SetGCStress(stress)
+				results, err := runSource(t, e, "main.cz", []byte(src))
+				assert.Nil(t, err)
+				assert.Equal(t, results, []string{test.out})
+			}
+		})
+	}
+}
+
+func TestInts(t *testing.T) {
+	runTests(t, []runtimeTest{
+		{name: "Recursion", in: "fac(5)", out: "120"},
+		{name: "Div", in: "17.div(5)", out: "3"},
+		{name: "Mod", in: "17.mod(5)", out: "2"},
+		{name: "Neg", in: "17.neg()", out: "-17"},
+		{name: "Gt", in: "17.gt(5)", out: "true"},
+		{name: "Eq", in: "17.eq(5)", out: "false"},
+		{name: "ToString", in: "17.toString()", out: "17"},
+	})
+}
+
+func TestBigInts(t *testing.T) {
+	runTests(t, []runtimeTest{
+		{name: "Overflow", in: "fac(30)", out: "265252859812191058636308480000000"},
+		{name: "Neg", in: "fac(30).neg()", out: "-265252859812191058636308480000000"},
+		{name: "Shrink", in: "fac(30).sub(fac(30))", out: "0"},
+		{name: "Gt", in: "fac(30).gt(17)", out: "true"},
+		{name: "MulDiv", in: "fac(30).mul(fac(30)).div(fac(30)).eq(fac(30))", out: "true"},
+		{name: "DivToInt", in: "fac(30).div(fac(29)).add(1)", out: "31"},
+		{name: "Mod", in: "fac(30).mod(7).toString()", out: "0"},
+		{name: "Parse", in: `"12345678901234567890123".toInt()`, out: "12345678901234567890123"},
+	})
+}
+
+func TestFloats(t *testing.T) {
+	runTests(t, []runtimeTest{
+		{name: "FromInt", in: "2.5.mul(17.toFloat())", out: "42.5"},
+		{name: "Lt", in: "2.5.div(2.0).lt(1.5)", out: "true"},
+		{name: "ToInt", in: "2.5.neg().toInt()", out: "-2"},
+		{name: "Parse", in: `"1e3".toFloat().add(0.5)`, out: "1000.5"},
+		{name: "BigToInt", in: "1e30.toInt().toFloat()", out: "1e+30"},
+	})
+}
+
+func TestStrings(t *testing.T) {
+	runTests(t, []runtimeTest{
+		{name: "Literal", in: `"héllo"`, out: "héllo"},
+		{name: "Length", in: `"a,b,c".length()`, out: "5"},
+		{name: "Concat", in: `"a,b,c".concat("!")`, out: "a,b,c!"},
+		{name: "Slice", in: `"a,b,c".slice(2, 3)`, out: "b"},
+		{name: "Index", in: `"a,b,c".index("c")`, out: "4"},
+		{name: "Compare", in: `"a,b,c".compare("b")`, out: "-1"},
+		{name: "Eq", in: `"a,b,c".eq("a,b,c")`, out: "true"},
+		{name: "Split", in: `"a,b,c".split(",").get(2)`, out: "c"},
+		{name: "ToInt", in: `"42".toInt().add(1)`, out: "43"},
+	})
+}
+
+func TestArrays(t *testing.T) {
+	runTests(t, []runtimeTest{
+		{name: "FromGo", in: "test.items().length()", out: "2"},
+		{name: "FromGoGet", in: "test.items().get(1)", out: "5"},
+		{name: "Push", in: "[1, 2, 3].push(4).length()", out: "4"},
+		{
+			name: "Map",
+			in:   "[1, 2, 3].map(object {\n\t\tcall(v) { v.mul(2) }\n\t}).get(2)",
+			out:  "6",
+		},
+		{
+			name: "Fold",
+			in:   "[1, 2, 3].fold(0, object {\n\t\tcall(acc, v) { acc.add(v) }\n\t})",
+			out:  "6",
+		},
+		{name: "Slice", in: "[1, 2, 3].slice(1, 3).get(0)", out: "2"},
+		{
+			name: "OutOfBounds",
+			in:   "handle [1, 2, 3].get(7) {\n\t\toutOfBounds(i) { i.neg() }\n\t}",
+			out:  "-7",
+		},
+		{
+			name: "OutOfBoundsResumed",
+			in:   "handle [1, 2, 3].get(7).add(1) {\n\t\toutOfBounds(i) { context.resume(5) }\n\t}",
+			out:  "6",
+		},
+		{
+			name: "OutOfBoundsInCallback",
+			in: "let a = [1, 2, 3]\n\thandle a.fold(0, object {\n\t\tcall(acc, v) { acc.add(a.get(v)) }\n\t}) {\n" +
+				"\t\toutOfBounds(i) { i }\n\t}",
+			out: "3",
+		},
+	})
+}
+
+func TestCollections(t *testing.T) {
+	runTests(t, []runtimeTest{
+		{name: "ArrayEquals", in: "[1, 2].equals([1, 2])", out: "true"},
+		{name: "StringHash", in: `"ab".hash().eq("ab".hash())`, out: "true"},
+		{name: "Size", in: "fill(runtime.map(), 200).size()", out: "200"},
+		{name: "Get", in: "fill(runtime.map(), 200).get(17)", out: "289"},
+		{
+			name: "Values",
+			in:   "fill(runtime.map(), 200).values().fold(0, object {\n\t\tcall(acc, v) { acc.add(v) }\n\t})",
+			out:  "2686700",
+		},
+		{name: "Remove", in: "drain(fill(runtime.map(), 200), 200).size()", out: "100"},
+		{name: "Removed", in: "drain(fill(runtime.map(), 200), 200).has(18)", out: "false"},
+		{name: "Kept", in: "drain(fill(runtime.map(), 200), 200).has(17)", out: "true"},
+		{
+			name: "MissingKey",
+			in:   "handle drain(fill(runtime.map(), 200), 200).get(18) {\n\t\tmissingKey(k) { k.neg() }\n\t}",
+			out:  "-18",
+		},
+		{
+			name: "Persistent",
+			in:   "let m = fill(runtime.map(), 200)\n\tlet d = drain(m, 200)\n\tm.size()",
+			out:  "200",
+		},
+		{name: "SetOfArrays", in: "runtime.set().add([1, 2]).add([1, 2]).add([2, 1]).size()", out: "2"},
+		{name: "SetHasArray", in: "runtime.set().add([1, 2]).add([2, 1]).has([2, 1])", out: "true"},
+		{
+			name: "CollidingSize",
+			in:   "runtime.set().add(key(1)).add(key(2)).add(key(3)).remove(key(2)).size()",
+			out:  "2",
+		},
+		{
+			name: "CollidingHas",
+			in:   "runtime.set().add(key(1)).add(key(2)).add(key(3)).remove(key(2)).has(key(3))",
+			out:  "true",
+		},
+		{
+			name: "CollidingRemoved",
+			in:   "runtime.set().add(key(1)).add(key(2)).add(key(3)).remove(key(2)).has(key(2))",
+			out:  "false",
+		},
+		{
+			name: "CollidingEmptied",
+			in:   "runtime.set().add(key(1)).add(key(3)).remove(key(1)).remove(key(3)).items().length()",
+			out:  "0",
+		},
+	})
+}
+
+// Objects without equals and hash methods are only equal to themselves.
+func TestIdentities(t *testing.T) {
+	runTests(t, []runtimeTest{
+		{
+			name: "SameObject",
+			in:   "let o = object {\n\t\tid() { 1 }\n\t}\n\truntime.map().put(o, 5).get(o)",
+			out:  "5",
+		},
+		{
+			name: "OtherObject",
+			in:   "let o = object {\n\t\tid() { 1 }\n\t}\n\truntime.set().add(o).has(object {\n\t\tid() { 1 }\n\t})",
+			out:  "false",
+		},
+		{
+			name: "SameMap",
+			in:   "let inner = runtime.map().put(1, 2)\n\truntime.map().put(inner, 3).put(runtime.map().put(1, 2), 4).get(inner)",
+			out:  "3",
+		},
+		{
+			name: "OtherMap",
+			in:   "let inner = runtime.map().put(1, 2)\n\truntime.map().put(inner, 3).put(runtime.map().put(1, 2), 4).size()",
+			out:  "2",
+		},
+		{name: "NaN", in: "runtime.set().add(0.0.div(0.0)).has(0.0.div(0.0))", out: "true"},
+		{name: "NegativeZero", in: "runtime.set().add(0.0).has(0.0.neg())", out: "false"},
+	})
+}
+
+func TestFailures(t *testing.T) {
+	runTests(t, []runtimeTest{
+		{
+			name: "DivisionByZero",
+			in:   "handle 17.div(0) {\n\t\tdivisionByZero(n) { n }\n\t}",
+			out:  "17",
+		},
+		{
+			name: "DivisionByZeroResumed",
+			in:   "handle 1.mod(0).add(1) {\n\t\tdivisionByZero(n) { context.resume(5) }\n\t}",
+			out:  "6",
+		},
+		{
+			name: "BigDivisionByZero",
+			in:   "handle fac(30).div(0) {\n\t\tdivisionByZero(n) { n.neg() }\n\t}",
+			out:  "-265252859812191058636308480000000",
+		},
+		{
+			name: "InvalidInt",
+			in:   "handle \"abc\".toInt() {\n\t\tinvalidNumber(s) { s.length() }\n\t}",
+			out:  "3",
+		},
+		{
+			name: "InvalidFloatResumed",
+			in:   "handle \"1.5x\".toFloat() {\n\t\tinvalidNumber(s) { context.resume(2.5) }\n\t}",
+			out:  "2.5",
+		},
+		{
+			name: "StringOutOfBounds",
+			in:   "handle \"abc\".slice(2, 5) {\n\t\toutOfBounds(i) { i.toString() }\n\t}",
+			out:  "5",
+		},
+		{
+			name: "StringOutOfBoundsResumed",
+			in:   "handle \"abc\".slice(2, 1) {\n\t\toutOfBounds(i) { context.resume(\"none\") }\n\t}",
+			out:  "none",
+		},
+		{
+			name: "InfinityToInt",
+			in:   "handle 1.0.div(0.0).toInt() {\n\t\toutOfRange(x) { context.resume(7) }\n\t}",
+			out:  "7",
+		},
+		{
+			name: "NaNToInt",
+			in:   "handle 0.0.div(0.0).neg().toInt().toString() {\n\t\toutOfRange(x) { x.toString() }\n\t}",
+			out:  "NaN",
+		},
+	})
+}
+
+// Effects that the runtime triggers stop the program with an error when
+// nothing handles them.
+func TestUnhandledEffects(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "DivisionByZero",
+			in:   "test.result(1.div(0))",
+			out:  "main.cz:4:16: divisionByZero: unhandled effect",
+		},
+		{
+			name: "BigDivisionByZero",
+			in:   `test.result("100000000000000000000".toInt().mod(0))`,
+			out:  "main.cz:4:46: divisionByZero: unhandled effect",
+		},
+		{
+			name: "InvalidNumber",
+			in:   `test.result("abc".toInt())`,
+			out:  "main.cz:4:20: invalidNumber: unhandled effect",
+		},
+		{
+			name: "StringOutOfBounds",
+			in:   `test.result("abc".slice(1, 4))`,
+			out:  "main.cz:4:20: outOfBounds: unhandled effect",
+		},
+		{
+			name: "FloatOutOfRange",
+			in:   "test.result(1.0.neg().div(0.0).toInt())",
+			out:  "main.cz:4:33: outOfRange: unhandled effect",
+		},
+		{
+			// only possible without type checking
+			name: "WrongType",
+			in:   `test.result("abc".concat(1))`,
+			out:  "main.cz:4:20: wrongType: unhandled effect",
+		},
+		{
+			name: "IntWrongType",
+			in:   `test.result(1.add("x"))`,
+			out:  "main.cz:4:16: wrongType: unhandled effect",
+		},
+		{
+			name: "BigIntWrongType",
+			in:   `test.result("100000000000000000000".toInt().lt("x"))`,
+			out:  "main.cz:4:46: wrongType: unhandled effect",
+		},
+		{
+			name: "FloatWrongType",
+			in:   `test.result(1.0.add("x"))`,
+			out:  "main.cz:4:18: wrongType: unhandled effect",
+		},
+		{
+			name: "FloatCmpWrongType",
+			in:   "test.result(1.0.lt(1))",
+			out:  "main.cz:4:18: wrongType: unhandled effect",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			src := "import test\n\nfunc main() {\n\t" + test.in + "\n}\n"
+			_, err := runSource(t, new(env.Env), "main.cz", []byte(src))
+			assert.True(t, errors.Is(err, env.ErrUnhandledEffect))
+			assert.Equal(t, err.Error(), test.out)
+		})
+	}
+}
+
+type callTracer struct {
+	calls []env.Event
+	errs  []string
+}
+
+// Trace checks that every method exits in the reverse of the order it was
+// entered in.
+func (c *callTracer) Trace(e env.Event) {
+	switch e.Kind {
+	case env.EnterEvent, env.TriggerEvent:
+		c.calls = append(c.calls, e)
+	case env.ExitEvent, env.HandleEvent:
+		n := len(c.calls)
+		if n == 0 || c.calls[n-1].Method != e.Method || c.calls[n-1].Class != e.Class {
+			c.errs = append(c.errs, fmt.Sprintf("unexpected exit %v", e))
+			return
+		}
+		c.calls = c.calls[:n-1]
+	}
+}
+
+// The frames that an effect unwinds exit, whether or not it is resumed, and
+// enter again when it is.
+func TestTraceEffects(t *testing.T) {
+	src := `import test
+
+func main() {
+	test.result(handle f() {
+		divisionByZero(n) { n }
+	})
+	test.result(handle f() {
+		divisionByZero(n) { context.resume(n.add(4)) }
+	})
+}
+
+func f() {
+	g().add(1)
+}
+
+func g() {
+	h().add(1)
+}
+
+func h() {
+	1.div(0).add(1)
+}
+`
+	var c callTracer
+	e := new(env.Env)
+	e.SetTracer(&c)
+	results, err := runSource(t, e, "main.cz", []byte(src))
+	assert.Nil(t, err)
+	assert.Equal(t, results, []string{"1", "8"})
+	assert.Equal(t, c.errs, []string(nil))
+	assert.Equal(t, len(c.calls), 0)
+}
+
+// Objects that are only equal to themselves are spread through the trie by
+// their fields, rather than all colliding seven levels down.
+func TestIdentityHash(t *testing.T) {
+	src := `import runtime
+import test
+
+func main() {
+	test.result(test.depth(fill(runtime.set(), 64)))
+}
+
+func fill(s, n) {
+	n.eq(0).match(object {
+		true() { s }
+		false() {
+			fill(s.add(object { id() { n } }), n.sub(1))
+		}
+	})
+}
+`
+	results, err := runSource(t, new(env.Env), "main.cz", []byte(src))
+	assert.Nil(t, err)
+	assert.Equal(t, results, []string{"3"})
+}
+
+func runSource(t *testing.T, e *env.Env, name string, src []byte) ([]string, error) {
+	var syms symtab.Symtab
+	var pt ast.Package
+	assert.Nil(t, parser.ParseNamedFile(&syms, &pt, name, src))
+	pkg, err := backend.BuildPackage(&syms, pt)
+	assert.Nil(t, err)
+	pkg.Imports = append(pkg.Imports, core.Path)
+
+	prog, err := link.Link(&syms, testLinkerEnv{
+		"main":    pkg,
+		"test":    testPkg(&syms),
+		"runtime": core.Package(&syms),
+	})
+	assert.Nil(t, err)
+
+	core.Install(e)
+
+	var results []string
+	e.AddExternalMethod("test:result", func(p *env.Thread, recv api.Object) {
+		x := p.Arg(0)
+		switch x.Class {
+		case prog.CoreKinds[format.IntKind]:
+			results = append(results, fmt.Sprint(p.Process().AsInt(x)))
+		case prog.CoreKinds[format.BigIntKind]:
+			results = append(results, p.Process().AsBigInt(x).String())
+		case prog.CoreKinds[format.FloatKind]:
+			results = append(results, fmt.Sprint(p.Process().AsFloat(x)))
+		case prog.CoreKinds[format.StringKind]:
+			results = append(results, p.Process().AsString(x))
+		default:
+			results = append(results, fmt.Sprint(p.Process().AsBool(x)))
+		}
+		p.Return(x)
+	})
+	e.AddExternalMethod("test:items", func(p *env.Thread, recv api.Object) {
+		p.Return(p.Process().Array([]api.Object{p.Process().Int(4), p.Process().Int(5)}))
+	})
+
+	// how many levels there are in the trie of a map or set
+	var depth func(p *env.Process, node api.Object) int
+	depth = func(p *env.Process, node api.Object) int {
+		res := 0
+		for _, x := range p.AsArray(node) {
+			if p.Kind(x) == format.ArrayKind {
+				if d := depth(p, x); d > res {
+					res = d
+				}
+			}
+		}
+		return res + 1
+	}
+	e.AddExternalMethod("test:depth", func(p *env.Thread, recv api.Object) {
+		p.Return(p.Process().Int(depth(p.Process(), p.Process().Field(p.Arg(0), 0))))
+	})
+
+	err = e.Run(&syms, prog)
+	return results, err
+}
+
+func TestPackage(t *testing.T) {
+	var syms symtab.Symtab
+	p := core.Package(&syms)
+
+	var kinds []format.CoreKind
+	for _, c := range p.Classes {
+		kinds = append(kinds, c.Kind)
+	}
+	assert.Equal(t, kinds, []format.CoreKind{
+		format.UserKind,
+		format.IntKind,
+		format.FloatKind,
+		format.TrueKind,
+		format.FalseKind,
+		format.StringKind,
+		format.ArrayKind,
+		format.BigIntKind,
+		format.ContinuationKind,
+		format.MapKind,
+		format.SetKind,
+	})
+
+	for _, x := range p.ExternalMethods {
+		assert.True(t, strings.HasPrefix(syms.SymbolName(x), core.Path+":"))
+	}
+}
@@ -0,0 +1,483 @@
+package core
+
+import (
+	"hash/fnv"
+	"math/bits"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/env"
+)
+
+// Maps and sets are hash array mapped tries, whose nodes are arrays. A node
+// starts with two bitmaps, of the entries that it holds and of the nodes below
+// it, followed by a key and a value for each entry and then the nodes. Hashes
+// run out after seven levels, so keys whose hashes are the same end up in a
+// collision node, which holds only keys and values. Sets are maps whose values
+// are their keys.
+//
+// Updates copy the path down to the change and share the rest, so maps and
+// sets never change once they have been made.
+//
+// Comparing and hashing keys may call methods, which may collect, so the
+// nodes and keys held while doing so are pinned.
+
+const (
+	levelBits = 5
+	levelMask = 1<<levelBits - 1
+	hashBits  = 32
+)
+
+type trie struct {
+	p *env.Thread
+}
+
+func (t trie) array(items ...api.Object) api.Object {
+	return t.p.Process().Array(items)
+}
+
+func (t trie) items(node api.Object) []api.Object {
+	return t.p.Process().AsArray(node)
+}
+
+func (t trie) bitmaps(items []api.Object) (data, nodes uint32) {
+	return uint32(t.p.Process().AsInt(items[0])), uint32(t.p.Process().AsInt(items[1]))
+}
+
+func (t trie) setBitmaps(items []api.Object, data, nodes uint32) {
+	items[0] = t.p.Process().Int(int(data))
+	items[1] = t.p.Process().Int(int(nodes))
+}
+
+func (t trie) empty() api.Object {
+	return t.array(t.p.Process().Int(0), t.p.Process().Int(0))
+}
+
+func levelBit(h uint32, shift int) uint32 {
+	return 1 << (h >> shift & levelMask)
+}
+
+// dataIndex is where the key of an entry goes, and nodeIndex where a node goes.
+func dataIndex(data, bit uint32) int {
+	return 2 + 2*bits.OnesCount32(data&(bit-1))
+}
+
+func nodeIndex(data, nodes, bit uint32) int {
+	return 2 + 2*bits.OnesCount32(data) + bits.OnesCount32(nodes&(bit-1))
+}
+
+func insertAt(items []api.Object, i int, xs ...api.Object) []api.Object {
+	res := make([]api.Object, 0, len(items)+len(xs))
+	res = append(res, items[:i]...)
+	res = append(res, xs...)
+	return append(res, items[i:]...)
+}
+
+func removeAt(items []api.Object, i, n int) []api.Object {
+	return append(items[:i], items[i+n:]...)
+}
+
+func (t trie) find(node, k api.Object, h uint32, shift int) (api.Object, bool) {
+	items := t.items(node)
+	defer t.p.PinAll(items)()
+	defer t.p.Pin(&k)()
+	if shift >= hashBits {
+		for i := 0; i < len(items); i += 2 {
+			if equal(t.p, items[i], k) {
+				return items[i+1], true
+			}
+		}
+		return api.Object{}, false
+	}
+	data, nodes := t.bitmaps(items)
+	bit := levelBit(h, shift)
+	switch {
+	case data&bit != 0:
+		i := dataIndex(data, bit)
+		if equal(t.p, items[i], k) {
+			return items[i+1], true
+		}
+	case nodes&bit != 0:
+		return t.find(items[nodeIndex(data, nodes, bit)], k, h, shift+levelBits)
+	}
+	return api.Object{}, false
+}
+
+// insert gives the node with k set to v, and whether k is new to it.
+func (t trie) insert(node, k, v api.Object, h uint32, shift int) (api.Object, bool) {
+	items := t.items(node)
+	defer t.p.PinAll(items)()
+	defer t.p.Pin(&k, &v)()
+	if shift >= hashBits {
+		for i := 0; i < len(items); i += 2 {
+			if equal(t.p, items[i], k) {
+				items[i+1] = v
+				return t.array(items...), false
+			}
+		}
+		return t.array(append(items, k, v)...), true
+	}
+	data, nodes := t.bitmaps(items)
+	bit := levelBit(h, shift)
+	switch {
+	case data&bit != 0:
+		i := dataIndex(data, bit)
+		if equal(t.p, items[i], k) {
+			items[i+1] = v
+			return t.array(items...), false
+		}
+		// the entry already here moves down into a node along with the new one
+		hi := hash(t.p, items[i])
+		sub := t.pair(items[i], items[i+1], hi, k, v, h, shift+levelBits)
+		items = removeAt(items, i, 2)
+		data ^= bit
+		nodes |= bit
+		items = insertAt(items, nodeIndex(data, nodes, bit), sub)
+
+	case nodes&bit != 0:
+		j := nodeIndex(data, nodes, bit)
+		sub, added := t.insert(items[j], k, v, h, shift+levelBits)
+		items[j] = sub
+		return t.array(items...), added
+
+	default:
+		data |= bit
+		items = insertAt(items, dataIndex(data, bit), k, v)
+	}
+	t.setBitmaps(items, data, nodes)
+	return t.array(items...), true
+}
+
+// pair makes a node holding two entries with different keys.
+func (t trie) pair(k1, v1 api.Object, h1 uint32, k2, v2 api.Object, h2 uint32, shift int) api.Object {
+	if shift >= hashBits {
+		return t.array(k1, v1, k2, v2)
+	}
+	b1, b2 := levelBit(h1, shift), levelBit(h2, shift)
+	if b1 == b2 {
+		sub := t.pair(k1, v1, h1, k2, v2, h2, shift+levelBits)
+		items := []api.Object{{}, {}, sub}
+		t.setBitmaps(items, 0, b1)
+		return t.array(items...)
+	}
+	if b2 < b1 {
+		k1, v1, k2, v2 = k2, v2, k1, v1
+	}
+	items := []api.Object{{}, {}, k1, v1, k2, v2}
+	t.setBitmaps(items, b1|b2, 0)
+	return t.array(items...)
+}
+
+// remove gives the node without k, and whether k was in it. Nodes left with a
+// single entry are folded into the node above them, so that a map has the same
+// shape however it was made.
+func (t trie) remove(node, k api.Object, h uint32, shift int) (api.Object, bool) {
+	items := t.items(node)
+	defer t.p.PinAll(items)()
+	defer t.p.Pin(&node, &k)()
+	if shift >= hashBits {
+		for i := 0; i < len(items); i += 2 {
+			if equal(t.p, items[i], k) {
+				return t.array(removeAt(items, i, 2)...), true
+			}
+		}
+		return node, false
+	}
+	data, nodes := t.bitmaps(items)
+	bit := levelBit(h, shift)
+	switch {
+	case data&bit != 0:
+		i := dataIndex(data, bit)
+		if !equal(t.p, items[i], k) {
+			return node, false
+		}
+		items = removeAt(items, i, 2)
+		data ^= bit
+
+	case nodes&bit != 0:
+		j := nodeIndex(data, nodes, bit)
+		sub, removed := t.remove(items[j], k, h, shift+levelBits)
+		if !removed {
+			return node, false
+		}
+		sk, sv, single := t.single(sub, shift+levelBits)
+		if !single {
+			items[j] = sub
+			break
+		}
+		items = removeAt(items, j, 1)
+		nodes ^= bit
+		data |= bit
+		items = insertAt(items, dataIndex(data, bit), sk, sv)
+
+	default:
+		return node, false
+	}
+	t.setBitmaps(items, data, nodes)
+	return t.array(items...), true
+}
+
+// single gives the entry of a node that holds only one.
+func (t trie) single(node api.Object, shift int) (k, v api.Object, ok bool) {
+	items := t.items(node)
+	if shift >= hashBits {
+		if len(items) != 2 {
+			return k, v, false
+		}
+		return items[0], items[1], true
+	}
+	data, nodes := t.bitmaps(items)
+	if nodes != 0 || bits.OnesCount32(data) != 1 {
+		return k, v, false
+	}
+	return items[2], items[3], true
+}
+
+// entries gives the keys and values held in a node and the nodes below it.
+func (t trie) entries(node api.Object, shift int, keys, values []api.Object) ([]api.Object, []api.Object) {
+	items := t.items(node)
+	if shift >= hashBits {
+		for i := 0; i < len(items); i += 2 {
+			keys, values = append(keys, items[i]), append(values, items[i+1])
+		}
+		return keys, values
+	}
+	data, _ := t.bitmaps(items)
+	end := 2 + 2*bits.OnesCount32(data)
+	for i := 2; i < end; i += 2 {
+		keys, values = append(keys, items[i]), append(values, items[i+1])
+	}
+	for _, sub := range items[end:] {
+		keys, values = t.entries(sub, shift+levelBits, keys, values)
+	}
+	return keys, values
+}
+
+// Objects of the core kinds are compared and hashed directly. Any other object
+// is asked through its equals and hash methods, if it has them, and otherwise
+// is only equal to itself. Such objects move when they are collected, so they
+// cannot be hashed by where they are. Their fields never change, though, so
+// they are hashed by their class and by the fields that can be hashed without
+// following a reference to another object that could change or move: numbers,
+// strings and booleans. Objects of one class that only differ in other fields
+// collide, and finding one of n of them takes O(n) comparisons.
+//
+// Floats are the same when their bits are, unlike with eq: NaN can be found
+// again once it has been put in a map, and 0.0 and -0.0 are different keys.
+
+func equal(p *env.Thread, a, b api.Object) bool {
+	proc := p.Process()
+	kind := proc.Kind(a)
+	if kind == format.UserKind && proc.Implements(a, "equals") {
+		return proc.AsBool(p.Call(callBase, a, "equals", b))
+	}
+	if kind != proc.Kind(b) {
+		return false
+	}
+	switch kind {
+	case format.IntKind:
+		return a.Data == b.Data
+	case format.BigIntKind:
+		return proc.AsBigInt(a).Cmp(proc.AsBigInt(b)) == 0
+	case format.StringKind:
+		return proc.AsString(a) == proc.AsString(b)
+	case format.TrueKind, format.FalseKind:
+		return true
+	case format.ArrayKind:
+		xs, ys := proc.AsArray(a), proc.AsArray(b)
+		if len(xs) != len(ys) {
+			return false
+		}
+		defer p.PinAll(xs)()
+		defer p.PinAll(ys)()
+		for i, x := range xs {
+			if !equal(p, x, ys[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func hash(p *env.Thread, x api.Object) uint32 {
+	proc := p.Process()
+	switch proc.Kind(x) {
+	case format.IntKind, format.FloatKind:
+		return mix(uint64(x.Data))
+	case format.BigIntKind:
+		h := fnv.New32a()
+		h.Write([]byte(proc.AsBigInt(x).String()))
+		return h.Sum32()
+	case format.StringKind:
+		h := fnv.New32a()
+		h.Write([]byte(proc.AsString(x)))
+		return h.Sum32()
+	case format.TrueKind:
+		return 1231
+	case format.FalseKind:
+		return 1237
+	case format.ArrayKind:
+		var h uint32 = 1
+		items := proc.AsArray(x)
+		defer p.PinAll(items)()
+		for i := range items {
+			h = 31*h + hash(p, items[i])
+		}
+		return h
+	}
+	if proc.Kind(x) == format.UserKind && proc.Implements(x, "hash") {
+		return uint32(proc.AsInt(p.Call(callBase, x, "hash")))
+	}
+	return identityHash(p, x)
+}
+
+func identityHash(p *env.Thread, x api.Object) uint32 {
+	proc := p.Process()
+	h := mix(uint64(x.Class))
+	if proc.Kind(x) != format.UserKind {
+		return h
+	}
+	for i := 0; i < proc.FieldCount(x.Class); i++ {
+		f := proc.Field(x, i)
+		switch proc.Kind(f) {
+		case format.IntKind, format.FloatKind, format.BigIntKind, format.StringKind, format.TrueKind, format.FalseKind:
+			h = 31*h + hash(p, f)
+		default:
+			h = 31*h + mix(uint64(f.Class))
+		}
+	}
+	return h
+}
+
+// mix spreads the bits of an int, so that small ints do not all fall into the
+// same part of a trie.
+func mix(x uint64) uint32 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return uint32(x)
+}
+
+func objectEquals(p *env.Thread, recv api.Object) {
+	p.Return(p.Process().Bool(equal(p, recv, p.Arg(0))))
+}
+
+func objectHash(p *env.Thread, recv api.Object) {
+	p.Return(p.Process().Int(int(hash(p, recv))))
+}
+
+// A map or set holds the root of its trie and the number of entries in it.
+
+func newMap(p *env.Thread, recv api.Object) {
+	proc := p.Process()
+	p.Return(proc.Create(proc.KindClass(format.MapKind), trie{p}.empty(), proc.Int(0)))
+}
+
+func newSet(p *env.Thread, recv api.Object) {
+	proc := p.Process()
+	p.Return(proc.Create(proc.KindClass(format.SetKind), trie{p}.empty(), proc.Int(0)))
+}
+
+func lookup(p *env.Thread, recv, k api.Object) (api.Object, bool) {
+	defer p.Pin(&recv, &k)()
+	h := hash(p, k)
+	return trie{p}.find(p.Process().Field(recv, 0), k, h, 0)
+}
+
+func update(p *env.Thread, recv, k, v api.Object) {
+	proc := p.Process()
+	defer p.Pin(&recv, &k, &v)()
+	h := hash(p, k)
+	root, added := trie{p}.insert(proc.Field(recv, 0), k, v, h, 0)
+	size := proc.AsInt(proc.Field(recv, 1))
+	if added {
+		size++
+	}
+	p.Return(proc.Create(recv.Class, root, proc.Int(size)))
+}
+
+func collectionRemove(p *env.Thread, recv api.Object) {
+	proc := p.Process()
+	k := p.Arg(0)
+	defer p.Pin(&recv, &k)()
+	h := hash(p, k)
+	root, removed := trie{p}.remove(proc.Field(recv, 0), k, h, 0)
+	if !removed {
+		p.Return(recv)
+		return
+	}
+	p.Return(proc.Create(recv.Class, root, proc.Int(proc.AsInt(proc.Field(recv, 1))-1)))
+}
+
+func collectionHas(p *env.Thread, recv api.Object) {
+	_, ok := lookup(p, recv, p.Arg(0))
+	p.Return(p.Process().Bool(ok))
+}
+
+func collectionSize(p *env.Thread, recv api.Object) {
+	p.Return(p.Process().Field(recv, 1))
+}
+
+func entries(p *env.Thread, recv api.Object) (keys, values []api.Object) {
+	return trie{p}.entries(p.Process().Field(recv, 0), 0, nil, nil)
+}
+
+// Looking up a key that is not in a map triggers missingKey with the key, and
+// resuming gives the method's result.
+func mapGet(p *env.Thread, recv api.Object) {
+	v, ok := lookup(p, recv, p.Arg(0))
+	if !ok {
+		p.Trigger("missingKey", p.Arg(0))
+		return
+	}
+	p.Return(v)
+}
+
+func mapPut(p *env.Thread, recv api.Object) {
+	update(p, recv, p.Arg(0), p.Arg(1))
+}
+
+func mapKeys(p *env.Thread, recv api.Object) {
+	keys, _ := entries(p, recv)
+	p.Return(p.Process().Array(keys))
+}
+
+func mapValues(p *env.Thread, recv api.Object) {
+	_, values := entries(p, recv)
+	p.Return(p.Process().Array(values))
+}
+
+// fold calls f(acc, k, v) for each entry, in no particular order.
+func mapFold(p *env.Thread, recv api.Object) {
+	acc, f := p.Arg(0), p.Arg(1)
+	keys, values := entries(p, recv)
+	defer p.PinAll(keys)()
+	defer p.PinAll(values)()
+	defer p.Pin(&f)()
+	for i := range keys {
+		acc = p.Call(callBase, f, "call", acc, keys[i], values[i])
+	}
+	p.Return(acc)
+}
+
+func setAdd(p *env.Thread, recv api.Object) {
+	update(p, recv, p.Arg(0), p.Arg(0))
+}
+
+func setItems(p *env.Thread, recv api.Object) {
+	keys, _ := entries(p, recv)
+	p.Return(p.Process().Array(keys))
+}
+
+// fold calls f(acc, x) for each item, in no particular order.
+func setFold(p *env.Thread, recv api.Object) {
+	acc, f := p.Arg(0), p.Arg(1)
+	keys, _ := entries(p, recv)
+	defer p.PinAll(keys)()
+	defer p.Pin(&f)()
+	for i := range keys {
+		acc = p.Call(callBase, f, "call", acc, keys[i])
+	}
+	p.Return(acc)
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/env"
+)
+
+// Integers are ints for as long as they fit, and big integers otherwise. Each
+// operation is tried on ints first, and only falls back to math/big when one
+// of its operands is big or the result would overflow. The process gives back
+// an int for any result that fits in one.
+
+// intArgs triggers wrongType with the first of xs that is not an integer.
+func intArgs(p *env.Thread, xs ...api.Object) bool {
+	for _, x := range xs {
+		if k := p.Process().Kind(x); k != format.IntKind && k != format.BigIntKind {
+			p.Trigger("wrongType", x)
+			return false
+		}
+	}
+	return true
+}
+
+func intOp(small func(a, b int) (int, bool), large func(z, a, b *big.Int) *big.Int) func(p *env.Thread, recv api.Object) {
+	return func(p *env.Thread, recv api.Object) {
+		proc, arg := p.Process(), p.Arg(0)
+		if !intArgs(p, recv, arg) {
+			return
+		}
+		if proc.Kind(recv) == format.IntKind && proc.Kind(arg) == format.IntKind {
+			if c, ok := small(proc.AsInt(recv), proc.AsInt(arg)); ok {
+				p.Return(proc.Int(c))
+				return
+			}
+		}
+		p.Return(proc.BigInt(large(new(big.Int), proc.AsBigInt(recv), proc.AsBigInt(arg))))
+	}
+}
+
+// intCmp tests the result of comparing the receiver to the argument.
+func intCmp(test func(c int) bool) func(p *env.Thread, recv api.Object) {
+	return func(p *env.Thread, recv api.Object) {
+		proc, arg := p.Process(), p.Arg(0)
+		if !intArgs(p, recv, arg) {
+			return
+		}
+		var c int
+		if proc.Kind(recv) == format.IntKind && proc.Kind(arg) == format.IntKind {
+			a, b := proc.AsInt(recv), proc.AsInt(arg)
+			switch {
+			case a < b:
+				c = -1
+			case a > b:
+				c = 1
+			}
+		} else {
+			c = proc.AsBigInt(recv).Cmp(proc.AsBigInt(arg))
+		}
+		p.Return(proc.Bool(test(c)))
+	}
+}
+
+func addInt(a, b int) (int, bool) {
+	c := a + b
+	return c, (b >= 0) == (c >= a)
+}
+
+func subInt(a, b int) (int, bool) {
+	c := a - b
+	return c, (b >= 0) == (c <= a)
+}
+
+func mulInt(a, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	if a == -1 && b == math.MinInt || b == -1 && a == math.MinInt {
+		return 0, false
+	}
+	c := a * b
+	return c, c/b == a
+}
+
+// Division truncates towards zero, for big integers as well as ints. Dividing
+// by zero triggers divisionByZero with the receiver, and resuming gives the
+// method's result.
+
+func divide(op func(p *env.Thread, recv api.Object)) func(p *env.Thread, recv api.Object) {
+	return func(p *env.Thread, recv api.Object) {
+		// zero always fits in an int
+		proc, arg := p.Process(), p.Arg(0)
+		if proc.Kind(arg) == format.IntKind && proc.AsInt(arg) == 0 {
+			p.Trigger("divisionByZero", recv)
+			return
+		}
+		op(p, recv)
+	}
+}
+
+func divInt(a, b int) (int, bool) {
+	if a == math.MinInt && b == -1 {
+		return 0, false
+	}
+	return a / b, true
+}
+
+func modInt(a, b int) (int, bool) {
+	return a % b, true
+}
+
+func intNeg(p *env.Thread, recv api.Object) {
+	proc := p.Process()
+	if !intArgs(p, recv) {
+		return
+	}
+	if proc.Kind(recv) == format.IntKind && proc.AsInt(recv) != math.MinInt {
+		p.Return(proc.Int(-proc.AsInt(recv)))
+		return
+	}
+	p.Return(proc.BigInt(new(big.Int).Neg(proc.AsBigInt(recv))))
+}
+
+func intToFloat(p *env.Thread, recv api.Object) {
+	if !intArgs(p, recv) {
+		return
+	}
+	x, _ := new(big.Float).SetInt(p.Process().AsBigInt(recv)).Float64()
+	p.Return(p.Process().Float(x))
+}
+
+func intToString(p *env.Thread, recv api.Object) {
+	if !intArgs(p, recv) {
+		return
+	}
+	p.Return(p.Process().String(p.Process().AsBigInt(recv).String()))
+}
@@ -1,21 +1,39 @@
 package env
 
 import (
-	"unicode/utf8"
+	"math"
+	"math/big"
 
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/runtime/api"
-	"github.com/bobappleyard/cezanne/util/slices"
 )
 
+// Kind gives the core kind of an object's class. Objects of user-defined
+// classes are of UserKind.
+func (p *Process) Kind(x api.Object) format.CoreKind {
+	if x.Class < 0 {
+		return format.ArrayKind
+	}
+	return p.classes[x.Class].Kind
+}
+
+// KindClass gives the class that objects of a core kind belong to.
+func (p *Process) KindClass(kind format.CoreKind) format.ClassID {
+	return p.kinds[kind]
+}
+
 func (p *Process) Create(class format.ClassID, fields ...api.Object) api.Object {
-	return p.memory.Alloc(class, fields)
+	return p.alloc(class, fields)
 }
 
 func (p *Process) Field(x api.Object, id int) api.Object {
 	return p.memory.Get(x, id)
 }
 
+func (p *Process) SetField(x api.Object, id int, value api.Object) {
+	p.memory.Set(x, id, value)
+}
+
 func (p *Process) Int(x int) api.Object {
 	return api.Object{
 		Class: p.kinds[format.IntKind],
@@ -27,6 +45,51 @@ func (p *Process) AsInt(x api.Object) int {
 	return int(x.Data)
 }
 
+// Float creates a float, which is held in the object itself rather than on the
+// heap. This relies on objects having 64 bits of data.
+func (p *Process) Float(x float64) api.Object {
+	return api.Object{
+		Class: p.kinds[format.FloatKind],
+		Data:  api.Ref(math.Float64bits(x)),
+	}
+}
+
+func (p *Process) AsFloat(x api.Object) float64 {
+	return math.Float64frombits(uint64(x.Data))
+}
+
+// BigInt creates an integer of any size. Integers that fit in an int are made
+// by Int, and larger ones are held on the heap as a sign byte followed by
+// their magnitude.
+func (p *Process) BigInt(x *big.Int) api.Object {
+	if x.IsInt64() {
+		if v := x.Int64(); v >= math.MinInt && v <= math.MaxInt {
+			return p.Int(int(v))
+		}
+	}
+	sign := byte(0)
+	if x.Sign() < 0 {
+		sign = 1
+	}
+	return p.allocBytes(p.kinds[format.BigIntKind], append([]byte{sign}, x.Bytes()...))
+}
+
+// AsBigInt reads an integer of either representation.
+func (p *Process) AsBigInt(x api.Object) *big.Int {
+	switch p.Kind(x) {
+	case format.IntKind:
+		return big.NewInt(int64(p.AsInt(x)))
+	case format.BigIntKind:
+		data := p.memory.Bytes(x)
+		res := new(big.Int).SetBytes(data[1:])
+		if data[0] == 1 {
+			res.Neg(res)
+		}
+		return res
+	}
+	panic("expected an integer")
+}
+
 func (p *Process) Bool(x bool) api.Object {
 	if x {
 		return api.Object{Class: p.kinds[format.TrueKind]}
@@ -39,7 +102,7 @@ func (p *Process) AsBool(x api.Object) bool {
 }
 
 func (p *Process) Array(items []api.Object) api.Object {
-	return p.memory.Alloc(format.ClassID(-len(items)-1), items)
+	return p.alloc(format.ClassID(-len(items)-1), items)
 }
 
 func (p *Process) AsArray(x api.Object) []api.Object {
@@ -51,21 +114,16 @@ func (p *Process) AsArray(x api.Object) []api.Object {
 	return items
 }
 
+// String creates a string, which holds its bytes directly rather than as an
+// array of objects.
 func (p *Process) String(s string) api.Object {
-	var runes []api.Object
-
-	pos := 0
-	for pos < len(s) {
-		c, size := utf8.DecodeRuneInString(s[pos:])
-		runes = append(runes, p.Int(int(c)))
-		pos += size
-	}
+	return p.allocBytes(p.kinds[format.StringKind], []byte(s))
+}
 
-	return p.Create(p.kinds[format.StringKind], p.Array(runes))
+func (p *Process) IsString(x api.Object) bool {
+	return p.Kind(x) == format.StringKind
 }
 
 func (p *Process) AsString(x api.Object) string {
-	return string(slices.Map(p.AsArray(p.Field(x, 0)), func(x api.Object) rune {
-		return rune(p.AsInt(x))
-	}))
+	return string(p.memory.Bytes(x))
 }
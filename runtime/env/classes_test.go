@@ -1,9 +1,12 @@
 package env
 
 import (
+	"math"
+	"math/big"
 	"testing"
 
 	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
 	"github.com/bobappleyard/cezanne/runtime/api"
 	"github.com/bobappleyard/cezanne/util/assert"
 )
@@ -12,26 +15,98 @@ func TestArray(t *testing.T) {
 	e := newTestProc()
 	e.classes = make([]format.Class, 1)
 	a := e.Array([]api.Object{e.Int(1), e.Int(2)})
-	t.Log(a)
 	e.globals = []api.Object{a}
-	t.Log(e.memory)
 	e.memory.Collect()
-	t.Log(e.memory)
+	t.Log(e.HeapStats())
 	assert.Equal(t, e.AsArray(a), []api.Object{e.Int(1), e.Int(2)})
 }
 
+func TestFloat(t *testing.T) {
+	e := newTestProc()
+	e.kinds[format.FloatKind] = 3
+
+	for _, x := range []float64{0, -1.5, 1e300, math.Inf(-1)} {
+		f := e.Float(x)
+		assert.Equal(t, f.Class, 3)
+		assert.Equal(t, e.AsFloat(f), x)
+	}
+}
+
 func TestString(t *testing.T) {
 	e := newTestProc()
 	e.classes = []format.Class{
 		{Name: e.syms.SymbolID("Int"), Fieldc: 0},
-		{Name: e.syms.SymbolID("String"), Fieldc: 1},
+		{Name: e.syms.SymbolID("String"), Kind: format.StringKind},
 	}
 	e.kinds = []format.ClassID{
 		format.IntKind:    0,
 		format.StringKind: 1,
 	}
 
-	s := e.String("hello")
+	s := e.String("hello, world")
+	e.globals = []api.Object{s}
+	e.memory.Collect()
+
+	assert.True(t, e.IsString(e.globals[0]))
+	assert.False(t, e.IsString(e.Int(1)))
+	assert.Equal(t, e.AsString(e.globals[0]), "hello, world")
+}
+
+func TestBigInt(t *testing.T) {
+	e := newTestProc()
+	e.classes = []format.Class{
+		{Name: e.syms.SymbolID("Int"), Kind: format.IntKind},
+		{Name: e.syms.SymbolID("BigInt"), Kind: format.BigIntKind},
+	}
+	e.kinds = []format.ClassID{
+		format.IntKind:    0,
+		format.BigIntKind: 1,
+	}
+
+	small := e.BigInt(big.NewInt(-42))
+	assert.Equal(t, e.Kind(small), format.IntKind)
+	assert.Equal(t, e.AsInt(small), -42)
+
+	x, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	e.globals = []api.Object{e.BigInt(x)}
+	e.memory.Collect()
+
+	assert.Equal(t, e.Kind(e.globals[0]), format.BigIntKind)
+	assert.Equal(t, e.AsBigInt(e.globals[0]), x)
+	assert.Equal(t, e.AsBigInt(small), big.NewInt(-42))
+}
+
+func TestConstants(t *testing.T) {
+	var syms symtab.Symtab
+	prog := &format.Program{
+		Classes: []format.Class{
+			{},
+			{Name: syms.SymbolID("String"), Kind: format.StringKind},
+		},
+		CoreKinds: []format.ClassID{format.StringKind: 1},
+		Methods:   []format.Method{{Name: syms.SymbolID("result")}},
+		Implmentations: []format.Implementation{
+			{},
+			{Class: 1, Method: 0, Kind: format.ExternalBinding},
+		},
+		ExternalMethods: []symtab.Symbol{syms.SymbolID("test:result")},
+		Code: []byte{
+			format.ConstantOp, 1, 0, 0, 0,
+			format.CallOp, 0, 0, 0, 0, 0,
+		},
+		Constants: []format.Constant{
+			{Kind: format.StringKind, Value: "first"},
+			{Kind: format.StringKind, Value: "second"},
+		},
+	}
+
+	e := new(Env)
 
-	assert.Equal(t, e.AsString(s), "hello")
+	var res string
+	e.AddExternalMethod("test:result", func(p *Thread, recv api.Object) {
+		res = p.Process().AsString(recv)
+		p.Return(recv)
+	})
+	assert.Nil(t, e.Run(&syms, prog))
+	assert.Equal(t, res, "second")
 }
@@ -1,76 +1,151 @@
 package env
 
-// func (p *Process) installHandlers(handlers api.Object, next int) {
-// 	// shift the activation frame up
-// 	p.data[p.frame+2] = Int(AsInt(p.data[p.frame]) + 2)
-// 	p.data[p.frame+3] = p.data[p.frame+1]
-
-// 	// install the handler context
-// 	p.data[p.frame] = Int(p.frame - next)
-// 	p.data[p.frame+1] = handlers
-
-// 	// set context registers
-// 	p.context = p.frame
-// 	p.frame += 2
-// }
-
-// func (p *Process) EnterContext(handlers api.Object, body api.Object) {
-// 	p.installHandlers(handlers, p.context)
-
-// 	// enter body
-// 	p.value = body
-// 	p.callMethod(p.env.callMethodID)
-// }
-
-// func (p *Process) TriggerEffect(id format.MethodID, argProvider api.Object) {
-// 	for ctx := p.context; ; ctx -= AsInt(p.data[ctx]) {
-// 		handlers := p.data[ctx+1]
-// 		method := p.getMethod(handlers, id)
-// 		if method == nil {
-// 			continue
-// 		}
-
-// 		// establish fake handler context
-// 		p.installHandlers(p.env.memory.Alloc(api.Ref(emptyClass)), ctx)
-
-// 		// enter handler
-// 		p.data[p.frame+2] = handlers
-// 		p.value = argProvider
-// 		p.callMethod(p.env.callMethodID)
-// 		break
-// 	}
-// }
-
-// func (p *Process) FastAbortHandler(value api.Object) {
-// 	p.context -= AsInt(p.data[p.context])
-// 	p.frame = p.context + 2
-// 	p.Return(value)
-// }
-
-// func (p *Process) FastResumeHandler(value api.Object) {
-// 	p.Return(value)
-// }
-
-// func (p *Process) ReifyHandlerContext(body api.Object) {
-// 	fmt.Println(p.context)
-
-// 	ctx := AsInt(p.data[p.context])
-// 	data := make([]api.Object, ctx)
-// 	copy(data, p.data[p.context-ctx+4:])
-
-// 	fmt.Println(data)
-
-// 	p.frame -= ctx
-// 	p.data[p.frame-2] = Int(AsInt(p.data[p.frame]) - 2)
-// 	p.data[p.frame-1] = p.data[p.frame+1]
-// 	p.frame -= 2
-
-// 	p.data[p.frame+2] = &contextObject{data: data}
-
-// 	p.value = body
-// 	p.callMethod(p.env.callMethodID)
-// }
-
-// func (p *Process) SlowResumeHandler(ctx, value api.Object) {
-
-// }
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/runtime/api"
+)
+
+// ErrUnhandledEffect stops a program that triggers an effect with no handler
+// for it.
+var ErrUnhandledEffect = errors.New("unhandled effect")
+
+// ErrStackOverflow stops a program whose stack has no room for what it needs.
+var ErrStackOverflow = errors.New("stack overflow")
+
+// A handler context sits on the stack just below the frame of the handle
+// expression that installed it. It holds the distance back to the enclosing
+// context, followed by the handlers. Stack links are relative, so the part of
+// the stack above a context can be saved and restored somewhere else.
+
+// Handle calls the body's call method with the handlers installed. It takes
+// over the frame of the method that calls it.
+func (p *Thread) Handle(handlers, body api.Object) {
+	f := p.frame
+	depth, ret := p.data[f], p.data[f+1]
+
+	p.data[f] = p.process.Int(f - p.context)
+	p.data[f+1] = handlers
+	p.context = f
+
+	p.frame = f + 2
+	p.frameEnd -= 2
+	if p.frameEnd < 1 {
+		p.frameEnd = 1
+	}
+	p.data[p.frame] = p.process.Int(p.process.AsInt(depth) + 2)
+	p.data[p.frame+1] = ret
+
+	p.TailCall(body, p.process.mustMethodID("call"))
+}
+
+// Trigger performs an effect, which is handled by the innermost handlers that
+// implement it. The stack is unwound to the handle expression, which returns
+// what the handler does. The handler is given a continuation to resume the
+// stack with, followed by the arguments.
+func (p *Thread) Trigger(name string, args ...api.Object) {
+	m, ctx := p.findHandler(name)
+	if ctx == 0 {
+		err := fmt.Errorf("%s: %w", name, ErrUnhandledEffect)
+		if pos, ok := p.SourcePos(); ok {
+			err = fmt.Errorf("%s: %w", pos, err)
+		}
+		panic(err)
+	}
+	defer p.PinAll(args)()
+
+	// the frames above the handle expression's are discarded
+	discarded := 0
+	for f := p.frame; f > ctx+2; f -= p.process.AsInt(p.data[f]) {
+		discarded++
+	}
+	calls := p.process.Int(0)
+	if p.process.tracer != nil {
+		calls = p.saveCalls(discarded + 1)
+		for i := 0; i < discarded; i++ {
+			p.traceExit()
+		}
+	}
+	defer p.Pin(&calls)()
+
+	// the stack is saved up to the return address of the triggering method
+	saved := p.process.Array(p.data[ctx : p.frame+2])
+	k := p.process.Create(p.process.kinds[format.ContinuationKind],
+		saved,
+		p.process.Int(p.context-ctx),
+		p.process.Int(p.frame-ctx),
+		calls,
+	)
+
+	// the handle expression's frame is kept, for the handler to return from
+	p.clear(ctx + 4)
+	p.context = ctx - p.process.AsInt(p.data[ctx])
+	p.frame = ctx + 2
+	p.frameEnd = 1
+	p.TailCall(p.data[ctx+1], m, append([]api.Object{k}, args...)...)
+}
+
+// Resume restores the stack saved by a continuation in place of the method
+// that calls it, so that the handle expression returns to that method's
+// caller. The triggering method returns the value.
+//
+// Calls from Go cannot be restored, so neither can continuations that were
+// captured inside of them.
+func (p *Thread) Resume(k, value api.Object) {
+	saved := p.process.AsArray(p.process.Field(k, 0))
+	ctx := p.process.AsInt(p.process.Field(k, 1))
+	frame := p.process.AsInt(p.process.Field(k, 2))
+
+	for f := frame; f > 2; f -= p.process.AsInt(saved[f]) {
+		if p.process.AsInt(saved[f+1]) == -1 {
+			panic("unable to resume across a call from Go")
+		}
+	}
+
+	f := p.frame
+	if f+len(saved) > len(p.data) {
+		err := ErrStackOverflow
+		if pos, ok := p.SourcePos(); ok {
+			err = fmt.Errorf("%s: %w", pos, err)
+		}
+		panic(err)
+	}
+	depth, ret := p.data[f], p.data[f+1]
+	copy(p.data[f:], saved)
+
+	// the methods that were running when the effect was triggered carry on
+	// in place of this one
+	if p.process.tracer != nil {
+		p.traceExit()
+		p.restoreCalls(p.process.Field(k, 3))
+	}
+
+	p.data[f] = p.process.Int(f - p.context)
+	p.data[f+2] = p.process.Int(p.process.AsInt(depth) + 2)
+	p.data[f+3] = ret
+
+	p.context = f + ctx
+	p.frame = f + frame
+	p.Return(value)
+}
+
+func (p *Thread) findHandler(name string) (format.MethodID, int) {
+	m, ok := p.process.MethodID(name)
+	if !ok {
+		return 0, 0
+	}
+	offset := p.process.methods[m].Offset
+	for ctx := p.context; ctx > 0; {
+		if p.getMethod(p.data[ctx+1], offset) != nil {
+			return m, ctx
+		}
+		next := p.process.AsInt(p.data[ctx])
+		if next <= 0 {
+			break
+		}
+		ctx -= next
+	}
+	return 0, 0
+}
@@ -1,303 +1,173 @@
 package env
 
-/*
-func TestEnterContext(t *testing.T) {
-	e := &Env{
-		code: []byte{
-			createOp, 0, 0, 0, 0, 0,
-			storeOp, 2,
-			createOp, 1, 0, 0, 0, 0,
-			storeOp, 3,
-			globalOp, 0, 0, 0, 0,
-			callOp, 0, 0, 0, 0, 0,
-
-			// expression to be handled
-			100: naturalOp, 2, 0, 0, 0,
-			retOp,
-		},
-		classes: make([]format.Class, 3),
-		globals: []Object{
-			&standardObject{classID: 2},
-		},
-		methods: []format.Binding{
-			{ClassID: -1},
-			{ClassID: 1, Start: 100},
-			{ClassID: 2, Start: -1},
-		},
-		extern: []func(*Process){
-			func(p *Process) {
-				p.EnterContext(p.Arg(0), p.Arg(1))
-			},
-		},
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/memory"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+// newEffectProc makes a process with a body class, whose call method runs body,
+// and a handler class, whose oops method runs handler.
+func newEffectProc(body, handler func(p *Thread, recv api.Object)) *Process {
+	e := newTestProc()
+	e.memory = memory.NewArena(e, 256)
+	e.classes = []format.Class{
+		{Kind: format.IntKind},
+		{},
+		{},
+		{Kind: format.ContinuationKind, Fieldc: 4},
 	}
-
-	p := &Process{env: e}
-
-	p.run()
-	assert.Equal(t, Int(2), p.value)
-}
-
-func TestTriggerEffect(t *testing.T) {
-	e := &Env{
-		code: []byte{
-			createOp, 0, 0, 0, 0, 0,
-			storeOp, 2,
-			createOp, 1, 0, 0, 0, 0,
-			storeOp, 3,
-			globalOp, 0, 0, 0, 0,
-			callOp, 0, 0, 0, 0, 0,
-
-			// expression to be handled
-			100: createOp, 5, 0, 0, 0, 0,
-			storeOp, 2,
-			globalOp, 0, 0, 0, 0,
-			callOp, 1, 0, 0, 0, 0,
-
-			// trigger
-			150: loadOp, 2,
-			callOp, 4, 0, 0, 0, 0,
-
-			// handler
-			200: naturalOp, 2, 0, 0, 0,
-			retOp,
-		},
-		classes: make([]format.Class, 6),
-		globals: []Object{
-			&standardObject{classID: 2},
-		},
-		methods: []format.Binding{
-			{ClassID: -1},
-			{ClassID: 1, Start: 100},
-			{ClassID: 2, Start: -1},
-			{ClassID: 2, Start: -2},
-			{ClassID: 0, Start: 200},
-			{ClassID: 5, Start: 150},
-		},
-		extern: []func(*Process){
-			func(p *Process) {
-				p.EnterContext(p.Arg(0), p.Arg(1))
-			},
-			func(p *Process) {
-				p.TriggerEffect(4, p.Arg(0))
-			},
-		},
+	e.kinds[format.ContinuationKind] = 3
+	e.methods = []format.Method{
+		{Name: e.syms.SymbolID("call"), Offset: 0},
+		{Name: e.syms.SymbolID("oops"), Offset: 1},
 	}
-
-	p := &Process{env: e}
-
-	p.run()
-	assert.Equal(t, Int(2), p.value)
-}
-
-func TestFastAbort(t *testing.T) {
-	e := &Env{
-		code: []byte{
-			createOp, 0, 0, 0, 0, 0,
-			storeOp, 2,
-			createOp, 1, 0, 0, 0, 0,
-			storeOp, 3,
-			globalOp, 0, 0, 0, 0,
-			callOp, 0, 0, 0, 0, 0,
-
-			// expression to be handled
-			100: naturalOp, 2, 0, 0, 0,
-			storeOp, 2,
-			naturalOp, 127, 0, 0, 0,
-			storeOp, 3,
-			createOp, 3, 0, 0, 0, 0,
-			callOp, 2, 0, 0, 0, 2,
-			127: naturalOp, 10, 0, 0, 0,
-			retOp,
-
-			// method called by expression
-			150: createOp, 7, 0, 0, 0, 0,
-			storeOp, 2,
-			globalOp, 0, 0, 0, 0,
-			callOp, 1, 0, 0, 0, 0,
-
-			// trigger
-			175: loadOp, 2,
-			callOp, 4, 0, 0, 0, 0,
-
-			// handler
-			200: naturalOp, 2, 0, 0, 0,
-			storeOp, 2,
-			globalOp, 0, 0, 0, 0,
-			callOp, 4, 0, 0, 0, 0,
-		},
-		classes: make([]format.Class, 8),
-		globals: []Object{
-			&standardObject{classID: 2},
-		},
-		methods: []format.Binding{
-			{ClassID: -1},
-			{ClassID: 1, Start: 100},
-			{ClassID: 2, Start: -1},
-			{ClassID: 2, Start: -2},
-			{ClassID: 0, Start: 200},
-			{ClassID: 3, Start: 150},
-			{ClassID: 2, Start: -3},
-			{ClassID: 7, Start: 175},
-		},
-		extern: []func(*Process){
-			func(p *Process) {
-				p.EnterContext(p.Arg(0), p.Arg(1))
-			},
-			func(p *Process) {
-				p.TriggerEffect(4, p.Arg(0))
-			},
-			func(p *Process) {
-				p.FastAbortHandler(p.Arg(0))
-			},
-		},
+	e.bindings = []format.Implementation{
+		{Class: -1},
+		{Class: 1, Method: 0, Kind: format.ExternalBinding, EntryPoint: 0},
+		{Class: -1},
+		{Class: 2, Method: 1, Kind: format.ExternalBinding, EntryPoint: 1},
 	}
+	e.extern = []func(p *Thread, recv api.Object){body, handler}
+	return e
+}
 
-	p := &Process{env: e}
-
-	p.run()
-	assert.Equal(t, Int(2), p.value)
+func runHandled(e *Process) *Thread {
+	p := &Thread{process: e}
+	p.data[2] = e.Int(0)
+	p.data[3] = e.Int(-1)
+	p.frame = 2
+	p.Handle(e.Create(2), e.Create(1))
+	return p
 }
 
-func TestFastResume(t *testing.T) {
-	e := &Env{
-		code: []byte{
-			createOp, 0, 0, 0, 0, 0,
-			storeOp, 2,
-			createOp, 1, 0, 0, 0, 0,
-			storeOp, 3,
-			globalOp, 0, 0, 0, 0,
-			callOp, 0, 0, 0, 0, 0,
+func TestHandleReturns(t *testing.T) {
+	e := newEffectProc(func(p *Thread, recv api.Object) {
+		p.Return(p.process.Int(3))
+	}, nil)
 
-			// expression to be handled
-			100: naturalOp, 2, 0, 0, 0,
-			storeOp, 2,
-			naturalOp, 127, 0, 0, 0,
-			storeOp, 3,
-			createOp, 3, 0, 0, 0, 0,
-			callOp, 2, 0, 0, 0, 2,
-			127: naturalOp, 10, 0, 0, 0,
-			retOp,
+	p := runHandled(e)
 
-			// method called by expression
-			150: createOp, 7, 0, 0, 0, 0,
-			storeOp, 2,
-			globalOp, 0, 0, 0, 0,
-			callOp, 1, 0, 0, 0, 0,
+	assert.Equal(t, p.value, e.Int(3))
+	assert.Equal(t, p.codePos, -1)
+	assert.Equal(t, p.frame, 2)
+	assert.Equal(t, p.context, 0)
+}
 
-			// trigger
-			175: loadOp, 2,
-			callOp, 4, 0, 0, 0, 0,
+func TestTriggerAborts(t *testing.T) {
+	e := newEffectProc(func(p *Thread, recv api.Object) {
+		assert.Equal(t, len(p.Handlers()), 1)
+		p.Trigger("oops", p.process.Int(4))
+	}, func(p *Thread, recv api.Object) {
+		assert.Equal(t, p.process.Kind(p.Arg(0)), format.ContinuationKind)
+		p.Return(p.process.Int(p.process.AsInt(p.Arg(1)) * 10))
+	})
 
-			// handler
-			200: naturalOp, 2, 0, 0, 0,
-			storeOp, 2,
-			globalOp, 0, 0, 0, 0,
-			callOp, 4, 0, 0, 0, 0,
-		},
-		classes: make([]format.Class, 8),
-		globals: []Object{
-			&standardObject{classID: 2},
-		},
-		methods: []format.Binding{
-			{ClassID: -1},
-			{ClassID: 1, Start: 100},
-			{ClassID: 2, Start: -1},
-			{ClassID: 2, Start: -2},
-			{ClassID: 0, Start: 200},
-			{ClassID: 3, Start: 150},
-			{ClassID: 2, Start: -3},
-			{ClassID: 7, Start: 175},
-		},
-		extern: []func(*Process){
-			func(p *Process) {
-				p.EnterContext(p.Arg(0), p.Arg(1))
-			},
-			func(p *Process) {
-				p.TriggerEffect(4, p.Arg(0))
-			},
-			func(p *Process) {
-				p.FastResumeHandler(p.Arg(0))
-			},
-		},
-	}
+	p := runHandled(e)
 
-	p := &Process{env: e}
-
-	p.run()
-	assert.Equal(t, Int(10), p.value)
+	assert.Equal(t, p.value, e.Int(40))
+	assert.Equal(t, p.codePos, -1)
+	assert.Equal(t, p.context, 0)
 }
 
-func TestReifyContext(t *testing.T) {
-	e := &Env{
-		code: []byte{
-			createOp, 0, 0, 0, 0, 0,
-			storeOp, 2,
-			createOp, 1, 0, 0, 0, 0,
-			storeOp, 3,
-			globalOp, 0, 0, 0, 0,
-			callOp, 0, 0, 0, 0, 0,
+func TestResume(t *testing.T) {
+	e := newEffectProc(func(p *Thread, recv api.Object) {
+		p.Trigger("oops", p.process.Int(4))
+	}, func(p *Thread, recv api.Object) {
+		assert.Equal(t, p.context, 0)
+		p.Resume(p.Arg(0), p.process.Int(5))
+	})
 
-			// expression to be handled
-			100: naturalOp, 2, 0, 0, 0,
-			storeOp, 2,
-			naturalOp, 127, 0, 0, 0,
-			storeOp, 3,
-			createOp, 3, 0, 0, 0, 0,
-			callOp, 2, 0, 0, 0, 2,
-			127: naturalOp, 10, 0, 0, 0,
-			retOp,
+	p := runHandled(e)
 
-			// method called by expression
-			150: createOp, 7, 0, 0, 0, 0,
-			storeOp, 2,
-			globalOp, 0, 0, 0, 0,
-			callOp, 1, 0, 0, 0, 0,
+	assert.Equal(t, p.value, e.Int(5))
+	assert.Equal(t, p.codePos, -1)
+	assert.Equal(t, p.context, 0)
+}
 
-			// trigger
-			175: loadOp, 2,
-			callOp, 4, 0, 0, 0, 0,
+func TestUnhandled(t *testing.T) {
+	e := newEffectProc(nil, nil)
+	p := &Thread{process: e}
 
-			// handler
-			200: createOp, 8, 0, 0, 0, 0,
-			storeOp, 2,
-			globalOp, 0, 0, 0, 0,
-			callOp, 4, 0, 0, 0, 0,
+	defer func() {
+		err, _ := recover().(error)
+		assert.True(t, errors.Is(err, ErrUnhandledEffect))
+		assert.Equal(t, err.Error(), "oops: unhandled effect")
+	}()
+	p.Trigger("oops")
+}
 
-			// handler body
-			250: loadOp, 2,
-			retOp,
-		},
-		classes: make([]format.Class, 9),
-		globals: []Object{
-			&standardObject{classID: 2},
-		},
-		methods: []format.Binding{
-			{ClassID: -1},
-			{ClassID: 1, Start: 100},
-			{ClassID: 2, Start: -1},
-			{ClassID: 2, Start: -2},
-			{ClassID: 0, Start: 200},
-			{ClassID: 3, Start: 150},
-			{ClassID: 2, Start: -3},
-			{ClassID: 7, Start: 175},
-			{ClassID: 8, Start: 250},
-		},
-		extern: []func(*Process){
-			func(p *Process) {
-				p.EnterContext(p.Arg(0), p.Arg(1))
-			},
-			func(p *Process) {
-				p.TriggerEffect(4, p.Arg(0))
-			},
-			func(p *Process) {
-				p.ReifyHandlerContext(p.Arg(0))
-			},
-		},
-	}
+func TestCallUnwound(t *testing.T) {
+	var calls int
+	e := newEffectProc(func(p *Thread, recv api.Object) {
+		calls++
+		if calls == 1 {
+			// the second call triggers, so the first never gets a result
+			p.Call(8, recv, "call")
+			t.Error("call not unwound")
+		}
+		p.Trigger("oops")
+	}, func(p *Thread, recv api.Object) {
+		p.Return(p.process.Int(7))
+	})
+	p := &Thread{process: e}
+	p.data[2] = e.Int(0)
+	p.data[3] = e.Int(-1)
+	p.frame = 2
+	p.codePos = -1
+
+	// the handler returns to the end of the program, so the call from Go is
+	// abandoned for the loop running the handle expression
+	func() {
+		defer func() {
+			_, ok := recover().(unwound)
+			assert.True(t, ok)
+		}()
+		p.Handle(e.Create(2), e.Create(1))
+	}()
+
+	assert.Equal(t, calls, 2)
+	assert.Equal(t, p.value, e.Int(7))
+	assert.Equal(t, p.frame, 2)
+}
 
-	p := &Process{env: e}
+func TestResumeAcrossCall(t *testing.T) {
+	var calls int
+	e := newEffectProc(func(p *Thread, recv api.Object) {
+		calls++
+		if calls == 1 {
+			p.Call(8, recv, "call")
+		}
+		p.Trigger("oops")
+	}, func(p *Thread, recv api.Object) {
+		p.Resume(p.Arg(0), p.process.Int(7))
+	})
+
+	defer func() {
+		assert.Equal(t, recover(), any("unable to resume across a call from Go"))
+	}()
+	runHandled(e)
+	t.Error("resumed")
+}
 
-	p.run()
-	assert.Equal(t, Int(10), p.value)
+func TestResumeOverflow(t *testing.T) {
+	e := newEffectProc(func(p *Thread, recv api.Object) {
+		p.Trigger("oops")
+	}, func(p *Thread, recv api.Object) {
+		k := p.Arg(0)
+		// too close to the end of the stack for the saved frames to fit
+		p.frame = len(p.data) - 2
+		p.Resume(k, p.process.Int(7))
+	})
+
+	defer func() {
+		err, _ := recover().(error)
+		assert.True(t, errors.Is(err, ErrStackOverflow))
+	}()
+	runHandled(e)
+	t.Error("resumed")
 }
-*/
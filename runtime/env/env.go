@@ -1,8 +1,12 @@
 package env
 
 import (
+	"errors"
+	"strconv"
+
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/format/verify"
 	"github.com/bobappleyard/cezanne/runtime/api"
 	"github.com/bobappleyard/cezanne/runtime/memory"
 	"github.com/bobappleyard/cezanne/util/slices"
@@ -11,10 +15,26 @@ import (
 type Env struct {
 	externalMethods map[string]func(p *Thread, recv api.Object)
 	heapSize        int
+	maxHeapSize     int
+	nurserySize     int
+	gcStress        bool
+	exitHook        func(p *Process)
+	verify          bool
+	stepHook        func(t *Thread) bool
+	tracer          Tracer
 }
 
-func (e *Env) Run(syms *symtab.Symtab, prog *format.Program) {
+// Run runs a program. If the environment verifies programs, one that fails
+// verification is not run. A program that runs out of memory is stopped, and
+// gives an error wrapping memory.ErrOutOfMemory.
+func (e *Env) Run(syms *symtab.Symtab, prog *format.Program) (err error) {
+	if e.verify {
+		if err := verify.Program(prog); err != nil {
+			return err
+		}
+	}
 	p := &Process{
+		syms:    syms,
 		globals: make([]api.Object, prog.GlobalCount),
 		extern: slices.Map(prog.ExternalMethods, func(n symtab.Symbol) func(p *Thread, recv api.Object) {
 			return e.externalMethods[syms.SymbolName(n)]
@@ -24,15 +44,111 @@ func (e *Env) Run(syms *symtab.Symtab, prog *format.Program) {
 		bindings: prog.Implmentations,
 		methods:  prog.Methods,
 		code:     prog.Code,
+		lines:    prog.Lines,
+		stepHook: e.stepHook,
+		exitHook: e.exitHook,
+		tracer:   e.tracer,
+	}
+	size := e.heapSize
+	if size == 0 {
+		size = DefaultHeapSize
+	}
+	if e.maxHeapSize != 0 && size > e.maxHeapSize {
+		size = e.maxHeapSize
+	}
+	p.memory = memory.NewArena(p, size)
+	policy := memory.DefaultPolicy
+	policy.MaxSize = e.maxHeapSize
+	policy.NurserySize = e.nurserySize
+	if policy.NurserySize == 0 {
+		policy.NurserySize = DefaultNurserySize
+	}
+	policy.Stress = e.gcStress
+	p.memory.SetPolicy(policy)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = runError(r)
+		}
+	}()
+	// creating a constant may collect the ones before it
+	for _, c := range prog.Constants {
+		p.consts = append(p.consts, p.constant(c))
 	}
-	p.memory = memory.NewArena(p, e.heapSize)
 	p.Run()
+	return nil
 }
 
+// runError gives the error that stopped the program, when the heap or the
+// stack was exhausted or an effect went unhandled, and panics again with
+// anything else.
+func runError(r any) error {
+	err, ok := r.(error)
+	if ok && (errors.Is(err, memory.ErrOutOfMemory) || errors.Is(err, ErrUnhandledEffect) || errors.Is(err, ErrStackOverflow)) {
+		return err
+	}
+	panic(r)
+}
+
+func (p *Process) constant(c format.Constant) api.Object {
+	if c.Kind == format.FloatKind {
+		x, _ := strconv.ParseFloat(c.Value, 64)
+		return p.Float(x)
+	}
+	return p.String(c.Value)
+}
+
+// DefaultHeapSize is the number of words in the heap of a program, unless
+// another size is set.
+const DefaultHeapSize = 1 << 16
+
 func (e *Env) SetHeapSize(size int) {
 	e.heapSize = size
 }
 
+// SetMaxHeapSize limits the number of words that the heap, nursery included,
+// may grow to. A size of 0, the default, sets no limit.
+func (e *Env) SetMaxHeapSize(size int) {
+	e.maxHeapSize = size
+}
+
+// DefaultNurserySize is the number of words that new objects are made in
+// before they are collected, unless another size is set.
+const DefaultNurserySize = 1 << 12
+
+// SetNurserySize sets the number of words that new objects are made in before
+// they are collected. A size of 0 uses DefaultNurserySize.
+func (e *Env) SetNurserySize(size int) {
+	e.nurserySize = size
+}
+
+// SetGCStress makes programs collect the whole heap every time that they
+// allocate. This is slow, but shows up objects that the runtime has lost track
+// of much sooner than they would otherwise be noticed.
+func (e *Env) SetGCStress(stress bool) {
+	e.gcStress = stress
+}
+
+// SetVerify controls whether programs are verified before they are run.
+// Programs from the linker have already been verified, but programs loaded
+// from elsewhere may not have been.
+func (e *Env) SetVerify(verify bool) {
+	e.verify = verify
+}
+
+// SetStepHook installs a function that is called before each instruction that
+// a program runs. If it returns false the thread stops. A nil hook removes it.
+func (e *Env) SetStepHook(hook func(t *Thread) bool) {
+	e.stepHook = hook
+}
+
+// SetExitHook installs a function that is called when a program finishes, with
+// the process as the program left it, including the result of main. Programs
+// that fail do not call it.
+func (e *Env) SetExitHook(hook func(p *Process)) {
+	e.exitHook = hook
+}
+
 func (e *Env) AddExternalMethod(name string, impl func(p *Thread, recv api.Object)) {
 	if e.externalMethods == nil {
 		e.externalMethods = map[string]func(p *Thread, recv api.Object){}
@@ -0,0 +1,96 @@
+package env
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/format/verify"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestRunVerifies(t *testing.T) {
+	var syms symtab.Symtab
+	prog := &format.Program{
+		Code: []byte{format.GlobalLoadOp, 0, 0, 0, 0, format.RetOp},
+	}
+
+	e := new(Env)
+	e.SetVerify(true)
+	err := e.Run(&syms, prog)
+	assert.True(t, errors.Is(err, verify.ErrOutOfRange))
+}
+
+func TestRunReportsSourcePos(t *testing.T) {
+	var syms symtab.Symtab
+	prog := &format.Program{
+		Classes:   []format.Class{{}},
+		CoreKinds: make([]format.ClassID, format.AllKinds),
+		Methods:   []format.Method{{Name: syms.SymbolID("missing")}},
+		Code: []byte{
+			format.CreateOp, 0, 0, 0, 0, 0,
+			format.CallOp, 0, 0, 0, 0, 0,
+		},
+		Lines: format.LineTable{
+			Files:   []string{"a.cz"},
+			Entries: []format.LineEntry{{Offset: 6, File: 0, Line: 3, Col: 5}},
+		},
+	}
+
+	e := new(Env)
+	e.SetHeapSize(32)
+
+	var msg any
+	func() {
+		defer func() { msg = recover() }()
+		e.Run(&syms, prog)
+	}()
+	assert.Equal(t, msg, any("a.cz:3:5: unable to call method missing"))
+}
+
+func TestStepHook(t *testing.T) {
+	var syms symtab.Symtab
+	prog := &format.Program{
+		Classes:   []format.Class{{}},
+		CoreKinds: make([]format.ClassID, format.AllKinds),
+		Methods:   []format.Method{{Name: syms.SymbolID("get")}},
+		Implmentations: []format.Implementation{
+			{Class: 0, Method: 0, Kind: format.StandardBinding, EntryPoint: 27},
+		},
+		Code: []byte{
+			format.NaturalOp, 2, 0, 0, 0,
+			format.StoreOp, 2,
+			format.NaturalOp, 26, 0, 0, 0,
+			format.StoreOp, 3,
+			format.CreateOp, 0, 0, 0, 0, 0,
+			format.CallOp, 0, 0, 0, 0, 2,
+			format.RetOp,
+
+			format.NaturalOp, 7, 0, 0, 0,
+			format.RetOp,
+		},
+	}
+
+	e := new(Env)
+	e.SetHeapSize(32)
+
+	var visited []int
+	var frames []Frame
+	var recv api.Object
+	e.SetStepHook(func(t *Thread) bool {
+		visited = append(visited, t.CodePos())
+		if t.CodePos() == 27 {
+			frames = t.Frames()
+			recv = t.Value()
+		}
+		// stop before the method returns
+		return t.CodePos() != 32
+	})
+	assert.Nil(t, e.Run(&syms, prog))
+
+	assert.Equal(t, visited, []int{0, 5, 7, 12, 14, 20, 27, 32})
+	assert.Equal(t, frames, []Frame{{Base: 4, CodePos: 27}, {Base: 2, CodePos: 26}})
+	assert.Equal(t, recv.Class, format.ClassID(0))
+}
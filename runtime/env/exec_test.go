@@ -196,3 +196,61 @@ func TestRun(t *testing.T) {
 
 	assert.Equal(t, e.Int(3), p.value)
 }
+
+func TestMarkRoots(t *testing.T) {
+	e := newTestProc()
+	e.classes = []format.Class{{}}
+	p := e.newThread()
+	defer e.endThread(p)
+
+	p.frame = 2
+	p.frameEnd = 2
+	p.data[4] = e.Array([]api.Object{e.Int(1)})
+	p.value = e.Array([]api.Object{e.Int(2)})
+	x := e.Array([]api.Object{e.Int(3)})
+	unpin := p.Pin(&x)
+	// registers past the end of the running frame are not looked at
+	stale := api.Object{Class: -2, Data: 1000}
+	p.data[5] = stale
+
+	e.memory.Collect()
+	e.Array([]api.Object{e.Int(4)})
+	e.memory.Collect()
+
+	assert.Equal(t, e.Field(p.data[4], 0), e.Int(1))
+	assert.Equal(t, e.Field(p.value, 0), e.Int(2))
+	assert.Equal(t, e.Field(x, 0), e.Int(3))
+	assert.Equal(t, p.data[5], stale)
+	unpin()
+	assert.Equal(t, len(p.pinned), 0)
+}
+
+func TestCallMovesFrameEnd(t *testing.T) {
+	e := newTestProc()
+	e.code = []byte{
+		format.CallOp, 0, 0, 0, 0, 4,
+		format.RetOp,
+	}
+	e.bindings = []format.Implementation{
+		{EntryPoint: 6},
+	}
+	e.classes = []format.Class{{}}
+	e.methods = []format.Method{{}}
+	p := &Thread{process: e}
+	p.frame = 2
+	p.frameEnd = 7
+	p.data[6] = e.Int(4)
+	p.data[7] = e.Int(5)
+	p.data[9] = e.Int(1)
+
+	p.step()
+	assert.Equal(t, p.frame, 6)
+	assert.Equal(t, p.frameEnd, 3)
+
+	// returning clears the registers of the frame
+	p.step()
+	assert.Equal(t, p.frame, 2)
+	assert.Equal(t, p.frameEnd, 4)
+	assert.Equal(t, p.data[7], api.Object{})
+	assert.Equal(t, p.data[9], api.Object{})
+}
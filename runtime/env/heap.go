@@ -0,0 +1,54 @@
+package env
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/memory"
+)
+
+// HeapStats describes a process's heap. LiveWords and Objects count what can
+// be reached from the roots, with objects counted by the name of their class.
+// Collections and BytesCopied give the work that the collector has done so far.
+type HeapStats struct {
+	LiveWords   int
+	Objects     map[string]int
+	Collections int
+	BytesCopied int
+}
+
+// HeapStats walks the heap, which takes time in proportion to the number of
+// objects that are live.
+func (p *Process) HeapStats() HeapStats {
+	s := HeapStats{Objects: map[string]int{}}
+	p.WalkHeap(func(n memory.Node) {
+		s.LiveWords += n.Words
+		s.Objects[p.ClassName(n.Object.Class)]++
+	})
+	m := p.memory.Stats()
+	s.Collections = m.Collections
+	s.BytesCopied = m.Copied * int(unsafe.Sizeof(api.Object{}))
+	return s
+}
+
+// WalkHeap visits each object that the process can reach.
+func (p *Process) WalkHeap(visit func(n memory.Node)) {
+	p.memory.Walk(visit)
+}
+
+// ClassName gives the name of a class. Arrays have no class of their own, and
+// most classes created by the compiler have no name, so they are known by
+// their ID.
+func (p *Process) ClassName(id format.ClassID) string {
+	if id < 0 {
+		return "array"
+	}
+	if int(id) < len(p.classes) {
+		if name := p.classes[id].Name; name.ID != 0 {
+			return p.syms.SymbolName(name)
+		}
+	}
+	return fmt.Sprintf("c%d", id)
+}
@@ -0,0 +1,27 @@
+package env
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestHeapStats(t *testing.T) {
+	e := newTestProc()
+	e.syms.SymbolID("")
+	e.classes = []format.Class{{}, {Name: e.syms.SymbolID("Pair"), Fieldc: 2}, {Fieldc: 1}}
+	pair := e.Create(1, e.Array([]api.Object{e.Int(1)}), e.Create(2, e.Int(2)))
+	e.globals = []api.Object{pair, e.Create(1, pair, pair)}
+	e.Create(2, e.Int(3))
+	e.memory.Collect()
+
+	assert.Equal(t, e.HeapStats(), HeapStats{
+		LiveWords:   6,
+		Objects:     map[string]int{"Pair": 2, "array": 1, "c2": 1},
+		Collections: 1,
+		BytesCopied: 6 * int(unsafe.Sizeof(api.Object{})),
+	})
+}
@@ -0,0 +1,31 @@
+package env
+
+import "github.com/bobappleyard/cezanne/runtime/api"
+
+// Collections move objects, and only update the references that the process
+// knows about. Methods written in Go that hold on to objects across calls and
+// allocations pin the variables that they are held in, so that those are
+// updated too. Each function that Pin and PinAll give unpins what was pinned,
+// along with anything pinned since, and is usually deferred.
+//
+// A variable must not be pinned more than once at a time.
+
+// Pin keeps variables holding objects up to date.
+func (p *Thread) Pin(xs ...*api.Object) func() {
+	n := len(p.pinned)
+	p.pinned = append(p.pinned, xs...)
+	return func() {
+		p.pinned = p.pinned[:n]
+	}
+}
+
+// PinAll keeps the items of a slice up to date.
+func (p *Thread) PinAll(xs []api.Object) func() {
+	n := len(p.pinned)
+	for i := range xs {
+		p.pinned = append(p.pinned, &xs[i])
+	}
+	return func() {
+		p.pinned = p.pinned[:n]
+	}
+}
@@ -1,7 +1,7 @@
 package env
 
 import (
-	"fmt"
+	"errors"
 
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/format/symtab"
@@ -13,30 +13,91 @@ type Process struct {
 	syms     *symtab.Symtab
 	extern   []func(p *Thread, recv api.Object)
 	globals  []api.Object
+	consts   []api.Object
 	classes  []format.Class
 	kinds    []format.ClassID
 	bindings []format.Implementation
 	methods  []format.Method
 	code     []byte
+	lines    format.LineTable
 	memory   *memory.Arena
-	threads  []Thread
+	stepHook func(t *Thread) bool
+	exitHook func(p *Process)
+	threads  []*Thread
+	tracer   Tracer
+
+	// method IDs by name, for calls made from Go
+	methodIDs map[string]format.MethodID
 }
 
 func (e *Process) Run() {
-	p := &Thread{
-		process: e,
-	}
+	p := e.newThread()
+	defer e.endThread(p)
 	p.run()
+	// the thread is still marked, so the program's result is still live
+	if e.exitHook != nil {
+		e.exitHook(e)
+	}
+}
+
+// The process marks the stacks of its threads, so every thread that runs
+// code must belong to it.
+func (e *Process) newThread() *Thread {
+	p := &Thread{process: e}
+	e.threads = append(e.threads, p)
+	return p
+}
+
+func (e *Process) endThread(p *Thread) {
+	for i, q := range e.threads {
+		if q == p {
+			e.threads = append(e.threads[:i], e.threads[i+1:]...)
+			return
+		}
+	}
+}
+
+// MethodID finds a method by name. Methods that no package mentions are not
+// found.
+func (e *Process) MethodID(name string) (format.MethodID, bool) {
+	if e.methodIDs == nil {
+		e.methodIDs = map[string]format.MethodID{}
+		for i, m := range e.methods {
+			e.methodIDs[e.syms.SymbolName(m.Name)] = format.MethodID(i)
+		}
+	}
+	id, ok := e.methodIDs[name]
+	return id, ok
+}
+
+// Implements reports whether x has a method with the given name.
+func (e *Process) Implements(x api.Object, name string) bool {
+	id, ok := e.MethodID(name)
+	return ok && e.getMethod(x, e.methods[id].Offset) != nil
+}
+
+func (e *Process) mustMethodID(name string) format.MethodID {
+	id, ok := e.MethodID(name)
+	if !ok {
+		panic("unable to call method " + name)
+	}
+	return id
 }
 
 func (p *Thread) Process() *Process {
 	return p.process
 }
 
+// Strings and big integers hold their bytes directly, whatever their class
+// says.
 func (e *Process) FieldCount(class format.ClassID) int {
 	if class < 0 {
 		return -int(class) - 1
 	}
+	switch e.classes[class].Kind {
+	case format.StringKind, format.BigIntKind:
+		return memory.Bytes
+	}
 	return int(e.classes[class].Fieldc)
 }
 
@@ -44,21 +105,51 @@ func (e *Process) MarkRoots(c *memory.Collection) {
 	for i, x := range e.globals {
 		e.globals[i] = c.Copy(x)
 	}
+	for i, x := range e.consts {
+		e.consts[i] = c.Copy(x)
+	}
 	for _, p := range e.threads {
-		for i := 0; i < p.frame+p.frameEnd; i++ {
-			p.data[i] = c.Copy(p.data[i])
-		}
+		p.markRoots(c)
 	}
 }
 
+// Each frame starts where the one that called it ends, so the frames on the
+// stack run from the start of the thread's data to the end of the running
+// frame. Registers above that are cleared as frames return or are unwound, so
+// no stale objects are found there when later frames use them.
+func (p *Thread) markRoots(c *memory.Collection) {
+	p.value = c.Copy(p.value)
+	end := p.frame + p.frameEnd + 1
+	if end > len(p.data) {
+		end = len(p.data)
+	}
+	for i := 0; i < end; i++ {
+		p.data[i] = c.Copy(p.data[i])
+	}
+	for _, x := range p.pinned {
+		*x = c.Copy(*x)
+	}
+}
+
+// StackSize is the number of registers that each thread has for its frames.
+const StackSize = 1024
+
 type Thread struct {
-	process  *Process
-	frame    int
+	process *Process
+	frame   int
+	context int
+	codePos int
+	value   api.Object
+	data    [StackSize]api.Object
+
+	// the last register of the running frame that holds anything
 	frameEnd int
-	context  int
-	codePos  int
-	value    api.Object
-	data     [1024]api.Object
+
+	// objects held by methods written in Go
+	pinned []*api.Object
+
+	// the methods that have been entered and not yet exited, when tracing
+	calls []Event
 }
 
 func (p *Thread) run() {
@@ -67,18 +158,135 @@ func (p *Thread) run() {
 	p.data[3] = p.process.Int(-1)
 
 	p.frame = 2
+	p.frameEnd = 1
+
+	defer func() {
+		if r := recover(); r != nil && r != errStopped {
+			panic(r)
+		}
+	}()
+	p.runFrom(0, 0)
+}
+
+// errStopped unwinds a thread that its step hook has stopped.
+var errStopped = errors.New("stopped")
+
+// unwound abandons a call from Go after an effect has been handled outside of
+// it. The handler carries on in the loop that is running the handler's frame.
+type unwound struct{}
 
+// runFrom runs the thread until the frame at base returns to the caller's
+// frame.
+func (p *Thread) runFrom(base, caller int) {
+	for !p.runSteps(base, caller) {
+	}
+}
+
+// runSteps gives false when a call from Go inside of it has been unwound, and
+// it needs to carry on.
+func (p *Thread) runSteps(base, caller int) (done bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(unwound); !ok || p.frame < base {
+				panic(r)
+			}
+		}
+	}()
 	for p.codePos != -1 {
+		if p.frame < base {
+			panic(unwound{})
+		}
+		if hook := p.process.stepHook; hook != nil && !hook(p) {
+			panic(errStopped)
+		}
 		p.step()
 	}
+	// a handler outside of the call has returned to the end of the program
+	if p.frame < caller {
+		panic(unwound{})
+	}
+	return true
 }
 
-const inDebug = false
+// Call calls a method from Go and gives its result. The method's frame starts
+// at base, which must be above the registers of the method making the call.
+func (p *Thread) Call(base int, object api.Object, name string, args ...api.Object) api.Object {
+	caller := p.frame
+	f := caller + base
+	p.data[f] = p.process.Int(base)
+	p.data[f+1] = p.process.Int(-1)
+	codePos := p.codePos
+	p.frame = f
+	p.frameEnd = 1
+	p.setArgs(object, args)
+	p.callMethod(p.process.methods[p.process.mustMethodID(name)], false)
+	p.runFrom(f, caller)
+	p.codePos = codePos
+	return p.value
+}
+
+// CodePos is the offset of the next instruction that the thread will run.
+func (p *Thread) CodePos() int {
+	return p.codePos
+}
 
-func (p *Thread) debug(op string, args ...any) {
-	if inDebug {
-		fmt.Println(append([]any{p.codePos, op}, args...)...)
+// Value is the result of the last instruction. When a method has just been
+// entered, it is the object that the method was called on.
+func (p *Thread) Value() api.Object {
+	return p.value
+}
+
+// Frame is a method activation on a thread's stack. Base is where the frame's
+// registers start in the thread's data, and CodePos is where the frame will
+// carry on from: the next instruction for the running frame, and the return
+// address for the others.
+type Frame struct {
+	Base    int
+	CodePos int
+}
+
+// Frame gives the running frame.
+func (p *Thread) Frame() Frame {
+	return Frame{Base: p.frame, CodePos: p.codePos}
+}
+
+// Frames gives the thread's stack, starting with the running frame.
+func (p *Thread) Frames() []Frame {
+	res := []Frame{p.Frame()}
+	for f := p.frame; ; {
+		depth := p.process.AsInt(p.data[f])
+		if depth <= 0 {
+			break
+		}
+		ret := p.process.AsInt(p.data[f+1])
+		f -= depth
+		res = append(res, Frame{Base: f, CodePos: ret})
+	}
+	return res
+}
+
+// Registers gives the first n registers of a frame.
+func (p *Thread) Registers(f Frame, n int) []api.Object {
+	end := f.Base + n
+	if end > len(p.data) {
+		end = len(p.data)
+	}
+	return append([]api.Object(nil), p.data[f.Base:end]...)
+}
+
+// Handlers gives the handler objects of the thread's handler contexts,
+// starting with the innermost.
+func (p *Thread) Handlers() []api.Object {
+	var res []api.Object
+	for ctx := p.context; ctx > 0; {
+		res = append(res, p.data[ctx+1])
+		next := p.process.AsInt(p.data[ctx])
+		if next <= 0 {
+			break
+		}
+		ctx -= next
 	}
+	return res
 }
 
 func (p *Thread) step() {
@@ -86,15 +294,11 @@ func (p *Thread) step() {
 	case format.LoadOp:
 		varID := p.readByte()
 
-		p.debug("LOAD", varID)
-
 		p.value = p.data[p.frame+varID]
 
 	case format.StoreOp:
 		varID := p.readByte()
 
-		p.debug("STORE", varID)
-
 		if varID > p.frameEnd {
 			p.frameEnd = varID
 		}
@@ -103,42 +307,30 @@ func (p *Thread) step() {
 	case format.NaturalOp:
 		value := p.readInt()
 
-		p.debug("NATURAL", value)
-
 		p.value = p.process.Int(value)
 
 	case format.GlobalLoadOp:
 		globalID := p.readInt()
 
-		p.debug("GLOBAL_LOAD", globalID)
-
 		p.value = p.process.globals[globalID]
 
 	case format.GlobalStoreOp:
 		globalID := p.readInt()
 
-		p.debug("GLOBAL_STORE", globalID)
-
 		p.process.globals[globalID] = p.value
 
 	case format.CreateOp:
 		classID := format.ClassID(p.readInt())
 		base := p.readByte()
 
-		p.debug("CREATE", classID, base)
-
-		p.value = p.process.memory.Alloc(classID, p.data[p.frame+base:])
+		p.value = p.process.alloc(classID, p.data[p.frame+base:])
 
 	case format.FieldOp:
 		field := p.readInt()
 
-		p.debug("FIELD", field)
-
 		p.value = p.process.Field(p.value, field)
 
 	case format.RetOp:
-		p.debug("RETURN")
-
 		p.ret()
 
 	case format.CallOp:
@@ -146,10 +338,20 @@ func (p *Thread) step() {
 		base := p.readByte()
 		m := p.process.methods[methodId]
 
-		p.debug("CALL", methodId, base, m.Name)
-
 		p.frame += base
-		p.callMethod(m)
+		p.frameEnd -= base
+		p.callMethod(m, base == 0)
+
+	case format.ConstantOp:
+		constID := p.readInt()
+
+		p.value = p.process.consts[constID]
+
+	case format.ArrayOp:
+		length := p.readInt()
+		base := p.readByte()
+
+		p.value = p.process.Array(p.data[p.frame+base : p.frame+base+length])
 	}
 }
 
@@ -162,12 +364,22 @@ func (p *Thread) Return(x api.Object) {
 	p.ret()
 }
 
+// TailCall calls a method in place of the one that is running. The object goes
+// in the register after the arguments, where the method expects to find this.
 func (p *Thread) TailCall(object api.Object, method format.MethodID, args ...api.Object) {
+	p.setArgs(object, args)
+	p.callMethod(p.process.methods[method], true)
+}
+
+func (p *Thread) setArgs(object api.Object, args []api.Object) {
 	for i, x := range args {
 		p.data[p.frame+i+2] = x
 	}
+	p.data[p.frame+len(args)+2] = object
+	if end := len(args) + 2; end > p.frameEnd {
+		p.frameEnd = end
+	}
 	p.value = object
-	p.callMethod(p.process.methods[method])
 }
 
 func (p *Thread) readByte() int {
@@ -194,32 +406,70 @@ func (p *Thread) ret() {
 		p.context -= p.process.AsInt(p.data[p.context])
 	}
 
+	if p.process.tracer != nil {
+		p.traceExit()
+	}
+
+	p.clear(p.frame)
 	p.frame -= depth
 	p.frameEnd = depth
 	p.codePos = codePos
 }
 
-func (p *Thread) callMethod(m format.Method) {
+// clear empties the registers of the running frame from a position in the
+// thread's data.
+func (p *Thread) clear(from int) {
+	end := p.frame + p.frameEnd + 1
+	if end > len(p.data) {
+		end = len(p.data)
+	}
+	for i := from; i < end; i++ {
+		p.data[i] = api.Object{}
+	}
+}
+
+// A tail call takes the place of the method that makes it.
+func (p *Thread) callMethod(m format.Method, tail bool) {
 	impl := p.getMethod(p.value, m.Offset)
+	if p.process.tracer != nil && impl != nil {
+		if tail {
+			p.traceExit()
+		}
+		p.traceEnter(impl, m)
+	}
 	if impl == nil {
-		panic("unable to call method " + p.process.syms.SymbolName(m.Name))
+		msg := "unable to call method " + p.process.syms.SymbolName(m.Name)
+		if pos, ok := p.SourcePos(); ok {
+			msg = pos.String() + ": " + msg
+		}
+		panic(msg)
 	}
 	p.enterMethod(impl)
 }
 
+// SourcePos gives the position in the source of the instruction that the
+// thread last read.
+func (p *Thread) SourcePos() (format.Position, bool) {
+	return p.process.lines.Lookup(p.codePos - 1)
+}
+
 func (p *Thread) getMethod(object api.Object, offset int32) *format.Implementation {
+	return p.process.getMethod(object, offset)
+}
+
+func (e *Process) getMethod(object api.Object, offset int32) *format.Implementation {
 	cid := int(object.Class)
 	if cid < 0 {
-		cid = int(p.process.kinds[format.ArrayKind])
+		cid = int(e.kinds[format.ArrayKind])
 	}
 	idx := cid + int(offset)
-	if idx < 0 || idx >= len(p.process.bindings) {
+	if idx < 0 || idx >= len(e.bindings) {
 		return nil
 	}
-	if p.process.bindings[idx].Class != object.Class {
+	if e.bindings[idx].Class != format.ClassID(cid) {
 		return nil
 	}
-	return &p.process.bindings[idx]
+	return &e.bindings[idx]
 }
 
 func (p *Thread) enterMethod(method *format.Implementation) {
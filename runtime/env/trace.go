@@ -0,0 +1,137 @@
+package env
+
+import (
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/api"
+)
+
+// Tracer receives events from a running program. When no tracer is installed
+// the events are not created.
+type Tracer interface {
+	Trace(e Event)
+}
+
+type EventKind int
+
+const (
+	_ EventKind = iota
+
+	// a method has been called on an object of a class, or has finished
+	EnterEvent
+	ExitEvent
+
+	// an object has been created, with a number of fields, or of bytes for
+	// strings and big integers
+	AllocEvent
+
+	// the garbage collector has started, or finished with a number of words
+	// still live
+	CollectStartEvent
+	CollectEndEvent
+
+	// a call has been handled by an implementation bound as a handler, or
+	// that handler has finished
+	TriggerEvent
+	HandleEvent
+)
+
+type Event struct {
+	Kind   EventKind
+	Class  format.ClassID
+	Method symtab.Symbol
+	Size   int
+}
+
+// SetTracer installs a tracer for the programs that the environment runs. A
+// nil tracer removes it.
+func (e *Env) SetTracer(t Tracer) {
+	e.tracer = t
+}
+
+func (p *Thread) traceEnter(impl *format.Implementation, m format.Method) {
+	e := Event{Kind: EnterEvent, Class: p.value.Class, Method: m.Name}
+	if impl.Kind == format.HandlerBinding {
+		e.Kind = TriggerEvent
+	}
+	p.calls = append(p.calls, e)
+	p.process.tracer.Trace(e)
+}
+
+// Code that is run other than through a method, such as the code at the start
+// of the program, has nothing to exit.
+func (p *Thread) traceExit() {
+	n := len(p.calls)
+	if n == 0 {
+		return
+	}
+	e := p.calls[n-1]
+	p.calls = p.calls[:n-1]
+	if e.Kind == TriggerEvent {
+		e.Kind = HandleEvent
+	} else {
+		e.Kind = ExitEvent
+	}
+	p.process.tracer.Trace(e)
+}
+
+// saveCalls gives the methods that the top n frames are running, as an array
+// of ints, for restoreCalls to enter again.
+func (p *Thread) saveCalls(n int) api.Object {
+	if n > len(p.calls) {
+		n = len(p.calls)
+	}
+	var items []api.Object
+	for _, e := range p.calls[len(p.calls)-n:] {
+		items = append(items,
+			p.process.Int(int(e.Kind)),
+			p.process.Int(int(e.Class)),
+			p.process.Int(int(e.Method.ID)),
+		)
+	}
+	return p.process.Array(items)
+}
+
+func (p *Thread) restoreCalls(saved api.Object) {
+	if p.process.Kind(saved) != format.ArrayKind {
+		return
+	}
+	items := p.process.AsArray(saved)
+	for i := 0; i+2 < len(items); i += 3 {
+		e := Event{
+			Kind:   EventKind(p.process.AsInt(items[i])),
+			Class:  format.ClassID(p.process.AsInt(items[i+1])),
+			Method: symtab.Symbol{ID: uint32(p.process.AsInt(items[i+2]))},
+		}
+		p.calls = append(p.calls, e)
+		p.process.tracer.Trace(e)
+	}
+}
+
+func (p *Process) alloc(class format.ClassID, fields []api.Object) api.Object {
+	if p.tracer != nil {
+		p.tracer.Trace(Event{Kind: AllocEvent, Class: class, Size: p.FieldCount(class)})
+	}
+	return p.memory.Alloc(class, fields)
+}
+
+func (p *Process) allocBytes(class format.ClassID, data []byte) api.Object {
+	if p.tracer != nil {
+		p.tracer.Trace(Event{Kind: AllocEvent, Class: class, Size: len(data)})
+	}
+	return p.memory.AllocBytes(class, data)
+}
+
+// CollectStart implements memory.Observer
+func (p *Process) CollectStart() {
+	if p.tracer != nil {
+		p.tracer.Trace(Event{Kind: CollectStartEvent})
+	}
+}
+
+// CollectEnd implements memory.Observer
+func (p *Process) CollectEnd(live int) {
+	if p.tracer != nil {
+		p.tracer.Trace(Event{Kind: CollectEndEvent, Size: live})
+	}
+}
@@ -0,0 +1,75 @@
+package env
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+type eventLog []Event
+
+func (l *eventLog) Trace(e Event) {
+	*l = append(*l, e)
+}
+
+func TestTracer(t *testing.T) {
+	var syms symtab.Symtab
+	get := syms.SymbolID("get")
+	prog := &format.Program{
+		Classes:   []format.Class{{}, {Fieldc: 1}},
+		CoreKinds: make([]format.ClassID, format.AllKinds),
+		Methods:   []format.Method{{Name: get}},
+		Implmentations: []format.Implementation{
+			{},
+			{Class: 1, Method: 0, Kind: format.StandardBinding, EntryPoint: 27},
+		},
+		Code: []byte{
+			format.NaturalOp, 2, 0, 0, 0,
+			format.StoreOp, 2,
+			format.NaturalOp, 26, 0, 0, 0,
+			format.StoreOp, 3,
+			format.CreateOp, 1, 0, 0, 0, 2,
+			format.CallOp, 0, 0, 0, 0, 2,
+			format.RetOp,
+
+			format.NaturalOp, 7, 0, 0, 0,
+			format.RetOp,
+		},
+	}
+
+	var log eventLog
+	e := new(Env)
+	e.SetHeapSize(32)
+	e.SetTracer(&log)
+	assert.Nil(t, e.Run(&syms, prog))
+
+	assert.Equal(t, log, eventLog{
+		{Kind: AllocEvent, Class: 1, Size: 1},
+		{Kind: EnterEvent, Class: 1, Method: get},
+		{Kind: ExitEvent, Class: 1, Method: get},
+	})
+}
+
+func TestTraceHandlers(t *testing.T) {
+	var syms symtab.Symtab
+	p := newTestProc()
+	p.syms = &syms
+	p.methods = []format.Method{{Name: syms.SymbolID("Write")}}
+	p.bindings = []format.Implementation{{Class: 0, Method: 0, Kind: format.HandlerBinding, EntryPoint: 5}}
+	var log eventLog
+	p.tracer = &log
+
+	th := &Thread{process: p}
+	th.callMethod(p.methods[0], false)
+	th.data[0] = p.Int(0)
+	th.data[1] = p.Int(-1)
+	th.ret()
+
+	assert.Equal(t, th.codePos, -1)
+	assert.Equal(t, log, eventLog{
+		{Kind: TriggerEvent, Method: syms.SymbolID("Write")},
+		{Kind: HandleEvent, Method: syms.SymbolID("Write")},
+	})
+}
@@ -0,0 +1,68 @@
+// Package heap writes snapshots of the heaps of programs as JSON, with one
+// object on each line, so that what a program holds on to can be looked at
+// offline.
+package heap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/memory"
+)
+
+// Heap is implemented by env.Process.
+type Heap interface {
+	WalkHeap(visit func(n memory.Node))
+	ClassName(id format.ClassID) string
+	FieldCount(id format.ClassID) int
+}
+
+// Objects are numbered in the order that they are found, starting with the
+// roots. Fields that refer to other objects are written as edges, and the
+// rest are left out.
+type record struct {
+	ID     int    `json:"id"`
+	Class  string `json:"class"`
+	Words  int    `json:"words"`
+	Root   bool   `json:"root,omitempty"`
+	Fields []edge `json:"fields,omitempty"`
+}
+
+type edge struct {
+	Field int `json:"field"`
+	To    int `json:"to"`
+}
+
+// WriteSnapshot writes out every object that can be reached in a heap.
+func WriteSnapshot(w io.Writer, h Heap) error {
+	var nodes []memory.Node
+	ids := map[api.Ref]int{}
+	h.WalkHeap(func(n memory.Node) {
+		ids[n.Object.Data] = len(nodes)
+		nodes = append(nodes, n)
+	})
+
+	b := bufio.NewWriter(w)
+	enc := json.NewEncoder(b)
+	for i, n := range nodes {
+		r := record{
+			ID:    i,
+			Class: h.ClassName(n.Object.Class),
+			Words: n.Words,
+			Root:  n.Root,
+		}
+		for j, x := range n.Fields {
+			if h.FieldCount(x.Class) == 0 {
+				continue
+			}
+			r.Fields = append(r.Fields, edge{Field: j, To: ids[x.Data]})
+		}
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return b.Flush()
+}
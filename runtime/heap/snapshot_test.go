@@ -0,0 +1,45 @@
+package heap
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/runtime/memory"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+// testHeap has classes whose IDs are their field counts.
+type testHeap []memory.Node
+
+func (h testHeap) WalkHeap(visit func(n memory.Node)) {
+	for _, n := range h {
+		visit(n)
+	}
+}
+
+func (testHeap) ClassName(id format.ClassID) string {
+	return fmt.Sprintf("c%d", id)
+}
+
+func (testHeap) FieldCount(id format.ClassID) int {
+	return int(id)
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	pair := api.Object{Class: 2, Data: 10}
+	box := api.Object{Class: 1, Data: 20}
+	h := testHeap{
+		{Object: pair, Words: 2, Fields: []api.Object{{Data: 4}, box}, Root: true},
+		{Object: box, Words: 1, Fields: []api.Object{pair}},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteSnapshot(&buf, h))
+
+	assert.Equal(t, buf.String(), `{"id":0,"class":"c2","words":2,"root":true,"fields":[{"field":1,"to":1}]}
+{"id":1,"class":"c1","words":1,"fields":[{"field":0,"to":0}]}
+`)
+}
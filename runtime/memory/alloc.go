@@ -1,109 +1,478 @@
 package memory
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"math/bits"
 
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/runtime/api"
 )
 
+// An arena has two generations. New objects go into the nursery, which is
+// collected on its own when it fills up, and objects that survive are promoted
+// to the old generation. The old generation is a pair of semispaces, which are
+// only collected when promotion would overflow them.
+//
+// References to objects in the nursery have the young bit set. Old objects
+// that have been given references to young ones are kept in a remembered set,
+// so that collecting the nursery does not mean looking through the whole of
+// the old generation.
 type Arena struct {
 	env         Env
+	policy      Policy
 	front, back []api.Object
 	allocated   api.Ref
+
+	nursery    []api.Object
+	youngAlloc api.Ref
+	remembered []api.Ref
+
+	// the fields of the object being made, while making it collects
+	fields []api.Object
+
+	stats Stats
+
+	// the semispaces never shrink below the size that they started at
+	minSize int
+}
+
+// ErrOutOfMemory is raised, as a panic, by an allocation that cannot be made
+// without growing the heap past its maximum size.
+var ErrOutOfMemory = errors.New("out of memory")
+
+// Policy controls how the semispaces are sized. After a collection they double
+// when more than Grow of them is live, and halve when less than Shrink is.
+// They also grow when an object does not fit even after a collection. MaxSize
+// limits the words in a semispace and the nursery together, and a MaxSize of 0
+// leaves them to grow without limit.
+//
+// Objects of up to NurserySize words start in the nursery, and larger ones
+// go straight into the old generation. A NurserySize of 0 puts everything in
+// the old generation.
+//
+// Stress collects both generations at every allocation, so that references
+// that the environment has not marked are found quickly rather than
+// occasionally.
+type Policy struct {
+	MaxSize      int
+	Grow, Shrink float64
+	NurserySize  int
+	Stress       bool
 }
 
+var DefaultPolicy = Policy{Grow: 0.5, Shrink: 0.125}
+
 type Collection struct {
 	arena  *Arena
 	copied api.Ref
+
+	// only the nursery is being collected
+	minor bool
+
+	// the heap is being walked rather than collected
+	walk *walk
 }
 
+// Stats counts the work that an arena's collections have done. Copied is in
+// words.
+type Stats struct {
+	Collections int
+	Copied      int
+}
+
+// Env describes the objects in an arena, and finds the objects that are in
+// use. FieldCount gives Bytes for classes whose objects hold bytes rather than
+// fields.
 type Env interface {
 	FieldCount(class format.ClassID) int
 	MarkRoots(c *Collection)
 }
 
+// Bytes is the field count of classes whose objects are byte strings. These
+// are stored as a header word, giving their length, followed by the bytes
+// packed into as many words as they need.
+const Bytes = -1
+
+const wordBytes = bits.UintSize / 8
+
+const young = api.Ref(1) << (bits.UintSize - 1)
+
+// Observer is told when collections start and finish, along with how many
+// words are live in the old generation afterwards. Environments that implement
+// it are told about collections of either generation.
+type Observer interface {
+	CollectStart()
+	CollectEnd(live int)
+}
+
 func NewArena(env Env, size int) *Arena {
 	return &Arena{
-		env:   env,
-		front: make([]api.Object, size),
-		back:  make([]api.Object, size),
+		env:     env,
+		policy:  DefaultPolicy,
+		front:   make([]api.Object, size),
+		back:    make([]api.Object, size),
+		minSize: size,
 	}
 }
 
-func (a *Arena) Alloc(class format.ClassID, fields []api.Object) api.Object {
-	res, ok := a.tryAlloc(class, fields)
-	if ok {
-		return res
+// SetPolicy changes how the heap is managed. The nursery is only sized before
+// anything has been allocated in it.
+func (a *Arena) SetPolicy(p Policy) {
+	a.policy = p
+	if a.youngAlloc == 0 {
+		a.nursery = make([]api.Object, p.NurserySize)
 	}
-	a.Collect()
-	res, ok = a.tryAlloc(class, fields)
-	if ok {
-		return res
+	if size := a.limit(len(a.front)); size < len(a.front) && a.allocated == 0 {
+		a.front = make([]api.Object, size)
+		a.back = make([]api.Object, size)
+		a.minSize = size
 	}
-	panic("out of memory")
 }
 
-func (a *Arena) tryAlloc(class format.ClassID, fields []api.Object) (api.Object, bool) {
-	fieldCount := api.Ref(a.env.FieldCount(class))
+// limit gives the largest that the semispaces may be, up to a size, leaving
+// room in MaxSize for the nursery.
+func (a *Arena) limit(size int) int {
+	if a.policy.MaxSize == 0 {
+		return size
+	}
+	max := a.policy.MaxSize - len(a.nursery)
+	if max < 0 {
+		max = 0
+	}
+	if size > max {
+		return max
+	}
+	return size
+}
+
+// Stats gives the work done by the arena's collections so far.
+func (a *Arena) Stats() Stats {
+	return a.stats
+}
+
+// Size is the number of words in each semispace.
+func (a *Arena) Size() int {
+	return len(a.front)
+}
+
+func (a *Arena) Alloc(class format.ClassID, fields []api.Object) api.Object {
+	fieldCount := a.env.FieldCount(class)
 	if fieldCount == 0 {
 		return api.Object{
 			Class: class,
-		}, true
+		}
+	}
+	return a.alloc(class, fieldCount, fields)
+}
+
+// AllocBytes creates a byte string. The class must have a field count of
+// Bytes.
+func (a *Arena) AllocBytes(class format.ClassID, data []byte) api.Object {
+	words := packBytes(data)
+	return a.alloc(class, len(words), words)
+}
+
+func (a *Arena) alloc(class format.ClassID, words int, data []api.Object) api.Object {
+	if a.policy.Stress {
+		data = a.collectFor(class, words, data, a.Collect)
+	}
+	if words <= len(a.nursery) {
+		if int(a.youngAlloc)+words > len(a.nursery) {
+			data = a.collectFor(class, words, data, a.collectYoung)
+		}
+		return a.placeYoung(class, words, data)
+	}
+	res, ok := a.place(class, words, data)
+	if !ok {
+		data = a.collectFor(class, words, data, func() { a.makeRoom(words) })
+		res, _ = a.place(class, words, data)
+	}
+	// the fields of an object made in the old generation may be young
+	if a.env.FieldCount(class) != Bytes {
+		for i := 0; i < words; i++ {
+			if a.isYoung(a.front[res.Data+api.Ref(i)]) {
+				a.remembered = append(a.remembered, res.Data+api.Ref(i))
+			}
+		}
+	}
+	return res
+}
+
+// collectFor collects while making an object, and gives its fields. They may
+// hold the only references to their objects, so they are kept up to date.
+func (a *Arena) collectFor(class format.ClassID, words int, data []api.Object, collect func()) []api.Object {
+	if a.env.FieldCount(class) == Bytes {
+		collect()
+		return data
 	}
-	if a.allocated+fieldCount > api.Ref(len(a.front)) {
+	if len(data) > words {
+		data = data[:words]
+	}
+	a.fields = append([]api.Object(nil), data...)
+	collect()
+	data, a.fields = a.fields, nil
+	return data
+}
+
+// makeRoom collects, and then grows the heap if there is still not room for
+// an object of a number of words.
+func (a *Arena) makeRoom(words int) {
+	a.Collect()
+	need := int(a.allocated) + words
+	if need <= len(a.front) {
+		return
+	}
+	size := len(a.front)
+	if size == 0 {
+		size = 1
+	}
+	for size < need {
+		size *= 2
+	}
+	size = a.limit(size)
+	if need > size {
+		panic(fmt.Errorf("%d words: %w", need, ErrOutOfMemory))
+	}
+	a.resize(size)
+}
+
+// resize changes the size of the semispaces. References are offsets into the
+// heap, so the live objects stay where they are, and the semispaces cannot get
+// smaller than them.
+func (a *Arena) resize(size int) {
+	if size < int(a.allocated) {
+		panic(fmt.Errorf("%d words: %w", a.allocated, ErrOutOfMemory))
+	}
+	front := make([]api.Object, size)
+	copy(front, a.front[:a.allocated])
+	a.front = front
+	a.back = make([]api.Object, size)
+}
+
+// Bytes gives a copy of the contents of a byte string.
+func (a *Arena) Bytes(object api.Object) []byte {
+	space, at := a.words(object)
+	n := int(space[at].Data)
+	res := make([]byte, n)
+	for i := range res {
+		w := space[at+1+api.Ref(i/wordBytes)].Data
+		res[i] = byte(w >> (8 * (i % wordBytes)))
+	}
+	return res
+}
+
+// ByteWords is the number of words taken up by a byte string of length n.
+func ByteWords(n int) int {
+	return 1 + (n+wordBytes-1)/wordBytes
+}
+
+func packBytes(data []byte) []api.Object {
+	words := make([]api.Object, ByteWords(len(data)))
+	words[0] = api.Object{Class: bytesHeader, Data: api.Ref(len(data))}
+	for i, b := range data {
+		words[1+i/wordBytes].Data |= api.Ref(b) << (8 * (i % wordBytes))
+	}
+	return words
+}
+
+// place puts an object of a number of words at the end of the old
+// generation, starting with as many of the words given as will fit.
+func (a *Arena) place(class format.ClassID, words int, data []api.Object) (api.Object, bool) {
+	size := api.Ref(words)
+	if a.allocated+size > api.Ref(len(a.front)) {
 		return api.Object{}, false
 	}
 	res := api.Object{
 		Class: class,
 		Data:  a.allocated,
 	}
-	copy(a.front[a.allocated:a.allocated+fieldCount], fields)
-	a.allocated += fieldCount
+	copy(a.front[a.allocated:a.allocated+size], data)
+	a.allocated += size
 	return res, true
 }
 
+func (a *Arena) placeYoung(class format.ClassID, words int, data []api.Object) api.Object {
+	size := api.Ref(words)
+	res := api.Object{
+		Class: class,
+		Data:  a.youngAlloc | young,
+	}
+	copy(a.nursery[a.youngAlloc:a.youngAlloc+size], data)
+	a.youngAlloc += size
+	return res
+}
+
+// words gives the space that an object is in and where it starts.
+func (a *Arena) words(object api.Object) ([]api.Object, api.Ref) {
+	if object.Data&young != 0 {
+		return a.nursery, object.Data &^ young
+	}
+	return a.front, object.Data
+}
+
+// isYoung tells whether x refers to an object in the nursery. Objects without
+// fields keep other things in their data, so they are never young.
+func (a *Arena) isYoung(x api.Object) bool {
+	return x.Data&young != 0 && a.env.FieldCount(x.Class) != 0
+}
+
 func (a *Arena) Get(object api.Object, field int) api.Object {
-	return a.front[object.Data+api.Ref(field)]
+	space, at := a.words(object)
+	return space[at+api.Ref(field)]
 }
 
+// Set is the write barrier: old objects that are given young ones are
+// remembered.
 func (a *Arena) Set(object api.Object, field int, value api.Object) {
-	a.front[object.Data+api.Ref(field)] = value
+	space, at := a.words(object)
+	at += api.Ref(field)
+	space[at] = value
+	if object.Data&young == 0 && a.isYoung(value) {
+		a.remembered = append(a.remembered, at)
+	}
 }
 
+// Collect collects both generations, leaving the nursery empty.
 func (a *Arena) Collect() {
+	o, observed := a.env.(Observer)
+	if observed {
+		o.CollectStart()
+	}
+	// the young objects may all be live, and need room in the old generation,
+	// as far as the heap may grow
+	if need := int(a.allocated + a.youngAlloc); need > len(a.back) {
+		size := len(a.back)
+		if size < a.minSize {
+			size = a.minSize
+		}
+		if size == 0 {
+			size = 1
+		}
+		for size < need {
+			size *= 2
+		}
+		a.back = make([]api.Object, a.limit(size))
+	}
+	a.stats.Collections++
 	c := &Collection{arena: a}
 	a.front, a.back = a.back, a.front
 	a.allocated = 0
 	a.env.MarkRoots(c)
+	c.markFields()
 	c.collect()
+	if len(a.back) < len(a.front) {
+		a.back = make([]api.Object, len(a.front))
+	}
+	a.youngAlloc = 0
+	a.remembered = a.remembered[:0]
+	a.adjust()
+	if observed {
+		o.CollectEnd(int(a.allocated))
+	}
+}
+
+// collectYoung promotes the live objects in the nursery. If the old
+// generation might not have room for them, both generations are collected
+// instead.
+func (a *Arena) collectYoung() {
+	if int(a.allocated+a.youngAlloc) > len(a.front) {
+		a.Collect()
+		return
+	}
+	o, observed := a.env.(Observer)
+	if observed {
+		o.CollectStart()
+	}
+	a.stats.Collections++
+	c := &Collection{arena: a, copied: a.allocated, minor: true}
+	a.env.MarkRoots(c)
+	c.markFields()
+	for _, at := range a.remembered {
+		a.front[at] = c.Copy(a.front[at])
+	}
+	c.collect()
+	a.youngAlloc = 0
+	a.remembered = a.remembered[:0]
+	if observed {
+		o.CollectEnd(int(a.allocated))
+	}
+}
+
+// adjust sizes the semispaces according to how much of them is live.
+func (a *Arena) adjust() {
+	size, live := len(a.front), float64(a.allocated)
+	switch {
+	case live > a.policy.Grow*float64(size):
+		size = a.limit(size * 2)
+	case live < a.policy.Shrink*float64(size) && size/2 >= a.minSize:
+		size /= 2
+	}
+	if size != len(a.front) {
+		a.resize(size)
+	}
 }
 
+func (c *Collection) markFields() {
+	for i, x := range c.arena.fields {
+		c.arena.fields[i] = c.Copy(x)
+	}
+}
+
+// The contents of byte strings are not objects, and so are skipped.
 func (c *Collection) collect() {
 	for c.copied < c.arena.allocated {
-		c.arena.front[c.copied] = c.Copy(c.arena.front[c.copied])
+		x := c.arena.front[c.copied]
+		if x.Class == bytesHeader {
+			c.copied += api.Ref(ByteWords(int(x.Data)))
+			continue
+		}
+		c.arena.front[c.copied] = c.Copy(x)
 		c.copied++
 	}
 }
 
-const reloc = format.ClassID(math.MaxInt32)
+// These classes mark words in the heap: the first word of an object that has
+// been moved, and the header of a byte string.
+const (
+	reloc       = format.ClassID(math.MaxInt32)
+	bytesHeader = format.ClassID(math.MaxInt32 - 1)
+)
 
+// Copy moves an object into the old generation, if it has not been already,
+// and gives its new reference. Collecting the nursery leaves old objects
+// where they are.
 func (c *Collection) Copy(old api.Object) api.Object {
 	fields := c.arena.env.FieldCount(old.Class)
 	if fields == 0 {
 		return old
 	}
-	if c.arena.back[old.Data].Class == reloc {
+	if c.walk != nil {
+		c.walk.find(c.arena, old)
+		return old
+	}
+	from, at := c.arena.back, old.Data
+	if at&young != 0 {
+		from, at = c.arena.nursery, at&^young
+	} else if c.minor {
+		return old
+	}
+	if from[at].Class == reloc {
 		return api.Object{
 			Class: old.Class,
-			Data:  c.arena.back[old.Data].Data,
+			Data:  from[at].Data,
 		}
 	}
-	new, ok := c.arena.tryAlloc(old.Class, c.arena.back[old.Data:old.Data+api.Ref(fields)])
+	if fields == Bytes {
+		fields = ByteWords(int(from[at].Data))
+	}
+	new, ok := c.arena.place(old.Class, fields, from[at:at+api.Ref(fields)])
 	if !ok {
-		panic("out of memory")
+		panic(fmt.Errorf("%d words: %w", int(c.arena.allocated)+fields, ErrOutOfMemory))
 	}
-	c.arena.back[old.Data] = api.Object{
+	c.arena.stats.Copied += fields
+	from[at] = api.Object{
 		Class: reloc,
 		Data:  new.Data,
 	}
@@ -1,7 +1,9 @@
 package memory
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/bobappleyard/cezanne/format"
 	"github.com/bobappleyard/cezanne/runtime/api"
@@ -34,3 +36,291 @@ func TestAlloc(t *testing.T) {
 	assert.Equal(t, a.Get(e.root, 0), api.Object{Data: 1})
 	assert.Equal(t, a.Get(a.Get(e.root, 1), 0), e.root)
 }
+
+type observedEnv struct {
+	testEnv
+	events []string
+	live   int
+}
+
+func (e *observedEnv) CollectStart() {
+	e.events = append(e.events, "start")
+}
+
+func (e *observedEnv) CollectEnd(live int) {
+	e.events = append(e.events, "end")
+	e.live = live
+}
+
+func TestObserveCollection(t *testing.T) {
+	e := &observedEnv{}
+	a := NewArena(e, 8)
+
+	e.root = a.Alloc(3, nil)
+	a.Alloc(4, nil)
+	a.Alloc(2, nil)
+
+	assert.Equal(t, e.events, []string{"start", "end"})
+	assert.Equal(t, e.live, 3)
+}
+
+func TestBytes(t *testing.T) {
+	e := &testEnv{}
+	a := NewArena(e, 16)
+
+	s := a.AllocBytes(Bytes, []byte("hello, world"))
+	a.Alloc(3, nil)
+	e.root = a.Alloc(2, []api.Object{s, {Data: 7}})
+	a.Collect()
+
+	assert.Equal(t, a.allocated, api.Ref(ByteWords(12)+2))
+	assert.Equal(t, a.Get(e.root, 1), api.Object{Data: 7})
+	assert.Equal(t, string(a.Bytes(a.Get(e.root, 0))), "hello, world")
+	assert.Equal(t, a.Bytes(a.AllocBytes(Bytes, nil)), []byte{})
+}
+
+func TestGrow(t *testing.T) {
+	e := &testEnv{}
+	a := NewArena(e, 8)
+
+	// nothing is live, but the object is bigger than the heap
+	x := a.Alloc(12, []api.Object{{Data: 3}})
+	assert.Equal(t, a.Size(), 16)
+	assert.Equal(t, a.Get(x, 0), api.Object{Data: 3})
+
+	// more than half of the heap is live after this collection
+	e.root = x
+	a.Alloc(6, nil)
+	assert.Equal(t, a.Size(), 32)
+	assert.Equal(t, a.Get(e.root, 0), api.Object{Data: 3})
+}
+
+func TestShrink(t *testing.T) {
+	e := &testEnv{}
+	a := NewArena(e, 4)
+	a.Alloc(30, nil)
+	assert.Equal(t, a.Size(), 32)
+
+	// the heap halves at each collection that leaves less than an eighth of it
+	// live
+	e.root = a.Alloc(1, []api.Object{{Data: 5}})
+	for i := 0; i < 4; i++ {
+		a.Collect()
+	}
+	assert.Equal(t, a.Size(), 8)
+	assert.Equal(t, a.Get(e.root, 0), api.Object{Data: 5})
+}
+
+func TestMaxSize(t *testing.T) {
+	e := &testEnv{}
+	a := NewArena(e, 8)
+	a.SetPolicy(Policy{MaxSize: 16, Grow: 0.5, Shrink: 0.125})
+
+	e.root = a.Alloc(12, nil)
+	assert.Equal(t, a.Size(), 16)
+
+	defer func() {
+		err, _ := recover().(error)
+		assert.True(t, errors.Is(err, ErrOutOfMemory))
+		assert.Equal(t, err.Error(), "18 words: out of memory")
+	}()
+	a.Alloc(6, nil)
+}
+
+func newGenerational(e Env, nursery int) *Arena {
+	a := NewArena(e, 16)
+	p := DefaultPolicy
+	p.NurserySize = nursery
+	a.SetPolicy(p)
+	return a
+}
+
+func TestPromote(t *testing.T) {
+	e := &observedEnv{}
+	a := newGenerational(e, 4)
+
+	e.root = a.Alloc(2, []api.Object{{Data: 1}})
+	a.Alloc(2, nil)
+	assert.Equal(t, a.allocated, 0)
+
+	// the nursery is full, so the live object in it moves to the old generation
+	a.Alloc(2, nil)
+	assert.Equal(t, e.events, []string{"start", "end"})
+	assert.Equal(t, e.live, 2)
+	assert.Equal(t, e.root.Data&young, 0)
+	assert.Equal(t, a.youngAlloc, 2)
+	assert.Equal(t, a.Get(e.root, 0), api.Object{Data: 1})
+}
+
+func TestWriteBarrier(t *testing.T) {
+	e := &testEnv{}
+	a := newGenerational(e, 4)
+
+	e.root = a.Alloc(2, nil)
+	a.Alloc(3, nil)
+	assert.Equal(t, e.root.Data&young, 0)
+
+	// only the old object refers to the young one
+	a.Set(e.root, 1, a.Alloc(1, []api.Object{{Data: 9}}))
+	assert.Equal(t, len(a.remembered), 1)
+	a.Alloc(3, nil)
+
+	x := a.Get(e.root, 1)
+	assert.Equal(t, x.Data&young, 0)
+	assert.Equal(t, a.Get(x, 0), api.Object{Data: 9})
+	assert.Equal(t, len(a.remembered), 0)
+	assert.Equal(t, a.allocated, 3)
+}
+
+func TestPretenure(t *testing.T) {
+	e := &testEnv{}
+	a := newGenerational(e, 4)
+
+	x := a.Alloc(1, []api.Object{{Data: 4}})
+	e.root = a.Alloc(6, []api.Object{x})
+	assert.Equal(t, e.root.Data&young, 0)
+	assert.Equal(t, a.remembered, []api.Ref{0})
+
+	a.Alloc(4, nil)
+	assert.Equal(t, a.Get(a.Get(e.root, 0), 0), api.Object{Data: 4})
+	assert.Equal(t, a.allocated, 7)
+}
+
+func TestCollectBoth(t *testing.T) {
+	e := &testEnv{}
+	a := newGenerational(e, 4)
+
+	e.root = a.Alloc(2, []api.Object{a.Alloc(1, []api.Object{{Data: 6}})})
+	a.Collect()
+
+	assert.Equal(t, a.youngAlloc, 0)
+	assert.Equal(t, a.allocated, 3)
+	assert.Equal(t, a.Get(a.Get(e.root, 0), 0), api.Object{Data: 6})
+}
+
+// pauseEnv keeps a long list alive while a program makes short-lived objects,
+// and times the collections.
+type pauseEnv struct {
+	testEnv
+	start time.Time
+	max   time.Duration
+	total time.Duration
+	count int
+}
+
+func (e *pauseEnv) CollectStart() {
+	e.start = time.Now()
+}
+
+func (e *pauseEnv) CollectEnd(live int) {
+	d := time.Since(e.start)
+	if d > e.max {
+		e.max = d
+	}
+	e.total += d
+	e.count++
+}
+
+func BenchmarkPause(b *testing.B) {
+	for _, bench := range []struct {
+		name    string
+		nursery int
+	}{
+		{"semispace", 0},
+		{"generational", 1 << 12},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			e := &pauseEnv{}
+			a := newGenerational(e, bench.nursery)
+			for i := 0; i < 1<<16; i++ {
+				e.root = a.Alloc(2, []api.Object{{Data: api.Ref(i)}, e.root})
+			}
+			e.max, e.total, e.count = 0, 0, 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 64; j++ {
+					a.Alloc(2, []api.Object{{Data: api.Ref(j)}, e.root})
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(e.max.Nanoseconds()), "max-pause-ns")
+			if e.count != 0 {
+				b.ReportMetric(float64(e.total.Nanoseconds()/int64(e.count)), "mean-pause-ns")
+			}
+		})
+	}
+}
+
+func TestAllocKeepsFields(t *testing.T) {
+	e := &testEnv{}
+	a := NewArena(e, 4)
+
+	// x is only referred to by the object being made, which collects
+	x := a.Alloc(1, []api.Object{{Data: 5}})
+	a.Alloc(2, nil)
+	e.root = a.Alloc(2, []api.Object{x})
+
+	assert.Equal(t, a.allocated, 3)
+	assert.Equal(t, a.Get(a.Get(e.root, 0), 0), api.Object{Data: 5})
+}
+
+func TestStress(t *testing.T) {
+	e := &observedEnv{}
+	a := NewArena(e, 16)
+	p := DefaultPolicy
+	p.NurserySize = 4
+	p.Stress = true
+	a.SetPolicy(p)
+
+	e.root = a.Alloc(1, []api.Object{{Data: 2}})
+	a.Alloc(1, nil)
+	a.Alloc(1, nil)
+
+	assert.Equal(t, len(e.events), 6)
+	assert.Equal(t, e.live, 1)
+	assert.Equal(t, a.Get(e.root, 0), api.Object{Data: 2})
+}
+
+func TestMaxSizeWithNursery(t *testing.T) {
+	e := &testEnv{}
+	a := NewArena(e, 8)
+	a.SetPolicy(Policy{MaxSize: 16, Grow: 0.5, Shrink: 0.125, NurserySize: 4})
+
+	defer func() {
+		err, _ := recover().(error)
+		assert.True(t, errors.Is(err, ErrOutOfMemory))
+		assert.True(t, a.Size() <= 16)
+	}()
+	// a list that only ever gets longer
+	for i := 0; i < 20; i++ {
+		e.root = a.Alloc(2, []api.Object{{Data: api.Ref(i)}, e.root})
+	}
+	t.Error("list fits in the heap")
+}
+
+func TestMaxSizeCountsNursery(t *testing.T) {
+	e := &testEnv{}
+	a := NewArena(e, 16)
+	a.SetPolicy(Policy{MaxSize: 16, Grow: 0.5, Shrink: 0.125, NurserySize: 8})
+	assert.Equal(t, a.Size(), 8)
+
+	defer func() {
+		err, _ := recover().(error)
+		assert.True(t, errors.Is(err, ErrOutOfMemory))
+		assert.Equal(t, a.Size(), 8)
+	}()
+	for i := 0; i < 20; i++ {
+		e.root = a.Alloc(2, []api.Object{{Data: api.Ref(i)}, e.root})
+	}
+	t.Error("list fits in the heap")
+}
+
+func TestCollectEmptyArena(t *testing.T) {
+	e := &testEnv{}
+	a := NewArena(e, 0)
+	a.SetPolicy(Policy{Grow: 0.5, Shrink: 0.125, NurserySize: 4})
+
+	e.root = a.Alloc(1, []api.Object{{Data: 3}})
+	a.Collect()
+	assert.Equal(t, a.Get(e.root, 0), api.Object{Data: 3})
+}
@@ -0,0 +1,51 @@
+package memory
+
+import "github.com/bobappleyard/cezanne/runtime/api"
+
+// Node is an object found by walking the heap. Roots are the objects that the
+// environment marks, rather than those found in the fields of other objects.
+// Byte strings have no fields.
+type Node struct {
+	Object api.Object
+	Words  int
+	Fields []api.Object
+	Root   bool
+}
+
+type walk struct {
+	seen  map[api.Ref]bool
+	nodes []Node
+	roots bool
+}
+
+// Walk visits each object that can be reached from the roots once, starting
+// with the roots. Nothing is moved.
+func (a *Arena) Walk(visit func(n Node)) {
+	w := &walk{seen: map[api.Ref]bool{}, roots: true}
+	c := &Collection{arena: a, walk: w}
+	a.env.MarkRoots(c)
+	w.roots = false
+	for i := 0; i < len(w.nodes); i++ {
+		n := w.nodes[i]
+		for _, x := range n.Fields {
+			c.Copy(x)
+		}
+		visit(n)
+	}
+}
+
+func (w *walk) find(a *Arena, x api.Object) {
+	if w.seen[x.Data] {
+		return
+	}
+	w.seen[x.Data] = true
+	n := Node{Object: x, Root: w.roots}
+	space, at := a.words(x)
+	if words := a.env.FieldCount(x.Class); words == Bytes {
+		n.Words = ByteWords(int(space[at].Data))
+	} else {
+		n.Words = words
+		n.Fields = append([]api.Object(nil), space[at:at+api.Ref(words)]...)
+	}
+	w.nodes = append(w.nodes, n)
+}
@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/cezanne/runtime/api"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestWalk(t *testing.T) {
+	e := &testEnv{}
+	a := newGenerational(e, 8)
+
+	s := a.AllocBytes(Bytes, []byte("hi"))
+	x := a.Alloc(1, []api.Object{s})
+	e.root = a.Alloc(2, []api.Object{x, {Data: 3}})
+	a.Set(e.root, 1, s)
+	a.Set(x, 0, e.root)
+	a.Alloc(3, nil)
+
+	var nodes []Node
+	a.Walk(func(n Node) {
+		nodes = append(nodes, n)
+	})
+
+	assert.Equal(t, nodes, []Node{
+		{Object: e.root, Words: 2, Fields: []api.Object{x, s}, Root: true},
+		{Object: x, Words: 1, Fields: []api.Object{e.root}},
+		{Object: s, Words: ByteWords(2)},
+	})
+}
+
+func TestStats(t *testing.T) {
+	e := &testEnv{}
+	a := newGenerational(e, 4)
+
+	e.root = a.Alloc(3, nil)
+	a.Alloc(2, nil)
+	a.Collect()
+
+	// the object is copied when it is promoted, and again by the full collection
+	assert.Equal(t, a.Stats(), Stats{Collections: 2, Copied: 6})
+}
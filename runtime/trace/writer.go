@@ -0,0 +1,111 @@
+// Package trace writes out the events of running programs as JSON, with one
+// event on each line.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/env"
+)
+
+// Writer is a tracer that writes events as they happen. Writing stops at the
+// first error, which is given by Flush.
+type Writer struct {
+	w    *bufio.Writer
+	enc  *json.Encoder
+	syms *symtab.Symtab
+	prog *format.Program
+	err  error
+}
+
+type record struct {
+	Event  string `json:"event"`
+	Class  string `json:"class,omitempty"`
+	Method string `json:"method,omitempty"`
+	Fields *int   `json:"fields,omitempty"`
+	Bytes  *int   `json:"bytes,omitempty"`
+	Live   *int   `json:"live,omitempty"`
+}
+
+var eventNames = map[env.EventKind]string{
+	env.EnterEvent:        "enter",
+	env.ExitEvent:         "exit",
+	env.AllocEvent:        "alloc",
+	env.CollectStartEvent: "gc_start",
+	env.CollectEndEvent:   "gc_end",
+	env.TriggerEvent:      "trigger",
+	env.HandleEvent:       "handle",
+}
+
+// NewWriter creates a tracer for a program. Classes and methods are written
+// by name.
+func NewWriter(w io.Writer, syms *symtab.Symtab, prog *format.Program) *Writer {
+	b := bufio.NewWriter(w)
+	return &Writer{
+		w:    b,
+		enc:  json.NewEncoder(b),
+		syms: syms,
+		prog: prog,
+	}
+}
+
+// Trace implements env.Tracer
+func (w *Writer) Trace(e env.Event) {
+	if w.err != nil {
+		return
+	}
+	r := record{Event: eventNames[e.Kind]}
+	switch e.Kind {
+	case env.EnterEvent, env.ExitEvent, env.TriggerEvent, env.HandleEvent:
+		r.Class = w.className(e.Class)
+		r.Method = w.syms.SymbolName(e.Method)
+	case env.AllocEvent:
+		r.Class = w.className(e.Class)
+		if w.holdsBytes(e.Class) {
+			r.Bytes = &e.Size
+		} else {
+			r.Fields = &e.Size
+		}
+	case env.CollectEndEvent:
+		r.Live = &e.Size
+	}
+	w.err = w.enc.Encode(r)
+}
+
+// Flush writes out any events that have been buffered.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+func (w *Writer) holdsBytes(id format.ClassID) bool {
+	if id < 0 || int(id) >= len(w.prog.Classes) {
+		return false
+	}
+	switch w.prog.Classes[id].Kind {
+	case format.StringKind, format.BigIntKind:
+		return true
+	}
+	return false
+}
+
+// Arrays have no class of their own. Most classes created by the compiler have
+// no name, so they are known by their ID.
+func (w *Writer) className(id format.ClassID) string {
+	if id < 0 {
+		return "array"
+	}
+	if int(id) < len(w.prog.Classes) {
+		if name := w.prog.Classes[id].Name; name.ID != 0 {
+			return w.syms.SymbolName(name)
+		}
+	}
+	return fmt.Sprintf("c%d", id)
+}
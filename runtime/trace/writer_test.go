@@ -0,0 +1,60 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/cezanne/format"
+	"github.com/bobappleyard/cezanne/format/symtab"
+	"github.com/bobappleyard/cezanne/runtime/env"
+	"github.com/bobappleyard/cezanne/util/assert"
+)
+
+func TestWriter(t *testing.T) {
+	var syms symtab.Symtab
+	syms.SymbolID("")
+	prog := &format.Program{
+		Classes: []format.Class{{}, {Name: syms.SymbolID("Pair"), Fieldc: 2}, {Name: syms.SymbolID("String"), Kind: format.StringKind}},
+	}
+	get := syms.SymbolID("get")
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, &syms, prog)
+	for _, e := range []env.Event{
+		{Kind: env.AllocEvent, Class: 1, Size: 2},
+		{Kind: env.AllocEvent, Class: -3, Size: 2},
+		{Kind: env.AllocEvent, Class: 2, Size: 5},
+		{Kind: env.EnterEvent, Class: 1, Method: get},
+		{Kind: env.CollectStartEvent},
+		{Kind: env.CollectEndEvent, Size: 0},
+		{Kind: env.ExitEvent, Class: 0, Method: get},
+	} {
+		w.Trace(e)
+	}
+	assert.Nil(t, w.Flush())
+
+	assert.Equal(t, buf.String(), `{"event":"alloc","class":"Pair","fields":2}
+{"event":"alloc","class":"array","fields":2}
+{"event":"alloc","class":"String","bytes":5}
+{"event":"enter","class":"Pair","method":"get"}
+{"event":"gc_start"}
+{"event":"gc_end","live":0}
+{"event":"exit","class":"c0","method":"get"}
+`)
+}
+
+type failingWriter struct{}
+
+var errFailed = errors.New("failed")
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errFailed
+}
+
+func TestWriterError(t *testing.T) {
+	var syms symtab.Symtab
+	w := NewWriter(failingWriter{}, &syms, &format.Program{})
+	w.Trace(env.Event{Kind: env.CollectStartEvent})
+	assert.True(t, errors.Is(w.Flush(), errFailed))
+}